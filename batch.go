@@ -2,60 +2,171 @@ package CouloyDB
 
 import (
 	"encoding/binary"
+	"sync"
+	"time"
+
 	"github.com/Kirov7/CouloyDB/data"
 	"github.com/Kirov7/CouloyDB/public"
-	"sync"
 )
 
 // WriteBatch Atomic operation writeBatch
+// The writes are kept in order and applied together when the batch is committed. A batch doesn't take part
+// in the conflict detection of the txns, it is applied between their commits like a write outside a txn.
+// The records of the batch are staged by a txn which is not tracked by the oracle and bound by no txn limit,
+// the commits are only locked while they are staged if a write of the batch depends on the db.
+// The deletes and the expirations of the keys which don't exist are ignored
 type WriteBatch struct {
-	options      WriteBatchOptions
-	mu           *sync.Mutex
-	db           *DB
-	pendingWrite map[string]*data.LogRecord // Temporary storage data
+	options WriteBatchOptions
+	mu      *sync.Mutex
+	db      *DB
+	ops     []batchOp // Temporary storage data
+}
+
+// batchOp is a write of the batch
+type batchOp struct {
+	// apply stages the write in the txn of the commit, it returns the event to notify the watchers of,
+	// nil if the write changes nothing
+	apply func(txn *Txn) (*watchEvent, error)
+	// readsDB is true if the records of the write depend on the db
+	readsDB bool
 }
 
 func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 	return &WriteBatch{
-		options:      opts,
-		mu:           new(sync.Mutex),
-		db:           db,
-		pendingWrite: make(map[string]*data.LogRecord),
+		options: opts,
+		mu:      new(sync.Mutex),
+		db:      db,
+		ops:     make([]batchOp, 0),
 	}
 }
 
 func (wb *WriteBatch) Put(key []byte, value []byte) error {
-	if len(key) == 0 {
-		return public.ErrKeyIsEmpty
-	}
-	wb.mu.Lock()
-	defer wb.mu.Unlock()
+	return wb.PutWithExpiration(key, value, 0)
+}
 
-	// Temporarily to deposit logRecord to memory
-	logRecord := &data.LogRecord{Key: key, Value: value}
-	wb.pendingWrite[string(key)] = logRecord
-	return nil
+// PutWithExpiration puts the string to expire after the duration from the commit
+func (wb *WriteBatch) PutWithExpiration(key []byte, value []byte, duration time.Duration) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return wb.add(false, func(txn *Txn) (*watchEvent, error) {
+		var expiration int64
+		if duration != 0 {
+			expiration = time.Now().Add(duration).UnixNano()
+		}
+		return &watchEvent{key: string(key), value: value, eventType: PutEvent}, txn.set(key, value, expiration)
+	})
 }
 
 func (wb *WriteBatch) Del(key []byte) error {
 	if len(key) == 0 {
 		return public.ErrKeyIsEmpty
 	}
-	wb.mu.Lock()
-	defer wb.mu.Unlock()
+	return wb.add(true, func(txn *Txn) (*watchEvent, error) {
+		if _, err := txn.Get(key); err == public.ErrKeyNotFound {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return &watchEvent{key: string(key), eventType: DelEvent}, txn.Del(key)
+	})
+}
 
-	recordPos := wb.db.index.getStrIndex().Get(key)
-	if recordPos == nil {
-		if wb.pendingWrite[string(key)] != nil {
-			delete(wb.pendingWrite, string(key))
+func (wb *WriteBatch) HSet(key, field, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if err := checkKey(field); err != nil {
+		return err
+	}
+	return wb.add(false, func(txn *Txn) (*watchEvent, error) {
+		return &watchEvent{key: string(key), eventType: PutEvent}, txn.HSet(key, field, value)
+	})
+}
+
+func (wb *WriteBatch) HDel(key, field []byte) error {
+	return wb.add(true, func(txn *Txn) (*watchEvent, error) {
+		return ignoreNotFound(&watchEvent{key: string(key), eventType: DelEvent}, txn.HDel(key, field))
+	})
+}
+
+func (wb *WriteBatch) SAdd(key []byte, members ...[]byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	for _, member := range members {
+		if err := checkKey(member); err != nil {
+			return err
 		}
-		return nil
 	}
+	return wb.add(false, func(txn *Txn) (*watchEvent, error) {
+		return &watchEvent{key: string(key), eventType: PutEvent}, txn.SAdd(key, members...)
+	})
+}
+
+func (wb *WriteBatch) SRem(key []byte, members ...[]byte) error {
+	return wb.add(true, func(txn *Txn) (*watchEvent, error) {
+		var event *watchEvent
+		for _, member := range members {
+			removed, err := ignoreNotFound(&watchEvent{key: string(key), eventType: DelEvent}, txn.SRem(key, member))
+			if err != nil {
+				return nil, err
+			}
+			if removed != nil {
+				event = removed
+			}
+		}
+		return event, nil
+	})
+}
+
+func (wb *WriteBatch) LPush(key []byte, values [][]byte) error {
+	return wb.push(key, values, true)
+}
 
-	// Temporarily to deposit logRecord to memory
-	logRecord := &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
-	wb.pendingWrite[string(key)] = logRecord
+func (wb *WriteBatch) RPush(key []byte, values [][]byte) error {
+	return wb.push(key, values, false)
+}
 
+func (wb *WriteBatch) push(key []byte, values [][]byte, isLeft bool) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return wb.add(true, func(txn *Txn) (*watchEvent, error) {
+		return &watchEvent{key: string(key), eventType: PutEvent}, txn.push(key, values, isLeft)
+	})
+}
+
+// Expire sets the key to expire after the duration from the commit, whatever the data type it is stored as
+func (wb *WriteBatch) Expire(key []byte, duration time.Duration) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return wb.add(true, func(txn *Txn) (*watchEvent, error) {
+		return ignoreNotFound(&watchEvent{key: string(key), eventType: PutEvent}, txn.Expire(key, duration))
+	})
+}
+
+// Persist removes the expiration of the key, whatever the data type it is stored as
+func (wb *WriteBatch) Persist(key []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return wb.add(true, func(txn *Txn) (*watchEvent, error) {
+		return ignoreNotFound(&watchEvent{key: string(key), eventType: PutEvent}, txn.Persist(key))
+	})
+}
+
+// add keeps the write, it returns ErrExceedMaxBatchNum if the batch has MaxBatchNum writes already
+func (wb *WriteBatch) add(readsDB bool, apply func(txn *Txn) (*watchEvent, error)) error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	if wb.options.MaxBatchNum > 0 && len(wb.ops) >= int(wb.options.MaxBatchNum) {
+		return public.ErrExceedMaxBatchNum
+	}
+	// Temporarily to deposit the write to memory
+	wb.ops = append(wb.ops, batchOp{apply: apply, readsDB: readsDB})
 	return nil
 }
 
@@ -63,58 +174,107 @@ func (wb *WriteBatch) Commit() error {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
-	if len(wb.pendingWrite) == 0 {
+	if len(wb.ops) == 0 {
 		return nil
 	}
 
-	// get the latest txId
-	txId := wb.db.GetTxId()
-
-	positions := make(map[string]*data.LogPos)
-
-	// do appendLogRecord and append it in temp slice
-	for _, record := range wb.pendingWrite {
-		recordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   encodeKeyWithTxId(record.Key, txId),
-			Value: record.Value,
-			Type:  record.Type,
-		})
-		if err != nil {
-			return err
+	readsDB := false
+	for _, op := range wb.ops {
+		readsDB = readsDB || op.readsDB
+	}
+	o := wb.db.oracle
+	// the writes depending on the db are staged with the commits locked, the others are not
+	if readsDB {
+		o.mu.Lock()
+	}
+	txn := newTxn(false, wb.db, ReadCommitted)
+	txn.startTs = wb.db.GetTxId()
+	txn.unlimited = true
+	events, err := wb.stage(txn)
+	if err != nil {
+		if readsDB {
+			o.mu.Unlock()
 		}
-		positions[string(record.Key)] = recordPos
+		wb.abort(txn)
+		return err
 	}
+	if !readsDB {
+		o.mu.Lock()
+	}
+	// no txn commits while the batch is applied
+	defer o.mu.Unlock()
 
-	//wb.db.strIndex.Put(record.Key, recordPos)
+	if err := txn.dropEmptiedExpires(); err != nil {
+		wb.abort(txn)
+		return err
+	}
 	// write fin mark
+	txn.commitTs = wb.db.GetTxId()
 	finishRecord := &data.LogRecord{
-		Key:  encodeKeyWithTxId(public.TX_COMMIT_KEY, txId),
-		Type: data.LogRecordTxnCommit,
+		Key:   encodeKeyWithTxId(public.TX_COMMIT_KEY, txn.startTs),
+		Value: encodeCommitTs(txn.commitTs),
+		Type:  data.LogRecordTxnCommit,
 	}
-	if _, err := wb.db.appendLogRecord(finishRecord); err != nil {
+	if _, err := wb.db.appendLogRecordWithLock(finishRecord); err != nil {
+		wb.abort(txn)
 		return err
 	}
 
+	// the batch is committed once its mark is written, the indexes are updated even if the sync fails
+	var syncErr error
 	if wb.options.SyncWrites {
-		if err := wb.db.Sync(); err != nil {
-			return err
-		}
+		syncErr = wb.db.Sync()
 	}
 
 	// update mem memTable
-	for _, record := range wb.pendingWrite {
-		pos := positions[string(record.Key)]
-		if record.Type == data.LogRecordNormal {
-			wb.db.index.getStrIndex().Put(record.Key, pos)
+	o.publishBatch(txn)
+	indexErr := txn.updateIndexes()
+	for _, event := range events {
+		wb.db.Notify(event.key, event.value, event.eventType)
+	}
+
+	// concat pendingWrite
+	wb.ops = make([]batchOp, 0)
+	txn.publishChange()
+	if indexErr != nil {
+		return indexErr
+	}
+	return syncErr
+}
+
+// stage writes the records of the batch in order and returns the events of the writes
+func (wb *WriteBatch) stage(txn *Txn) ([]*watchEvent, error) {
+	events := make([]*watchEvent, 0, len(wb.ops))
+	for _, op := range wb.ops {
+		event, err := op.apply(txn)
+		if err != nil {
+			return nil, err
 		}
-		if record.Type == data.LogRecordDeleted {
-			wb.db.index.getStrIndex().Del(record.Key)
+		if event != nil {
+			events = append(events, event)
 		}
 	}
+	return events, nil
+}
 
-	// concat pendingWrite
-	wb.pendingWrite = make(map[string]*data.LogRecord)
-	return nil
+// abort ends the records staged by the batch with a rollback mark, so they are dropped when the log is replayed
+func (wb *WriteBatch) abort(txn *Txn) {
+	logRecord := &data.LogRecord{
+		Key:  encodeKeyWithTxId(public.TX_ROLLBACK_KEY, txn.startTs),
+		Type: data.LogRecordTxnRollback,
+	}
+	_, _ = wb.db.appendLogRecordWithLock(logRecord)
+}
+
+// ignoreNotFound returns no event and no error if the key to change was not found
+func ignoreNotFound(event *watchEvent, err error) (*watchEvent, error) {
+	if err == public.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
 }
 
 func encodeKeyWithTxId(key []byte, txId int64) []byte {
@@ -0,0 +1,171 @@
+package CouloyDB
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBatch_Commit(t *testing.T) {
+	options := DefaultOptions()
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("old"), []byte("v")))
+	wb := db.NewWriteBatch(DefaultBatchOptions())
+	assert.Nil(t, wb.Put([]byte("str"), []byte("v1")))
+	assert.Nil(t, wb.PutWithExpiration([]byte("tmp"), []byte("v"), time.Hour))
+	assert.Nil(t, wb.Del([]byte("old")))
+	assert.Nil(t, wb.Del([]byte("missing")))
+	assert.Nil(t, wb.HSet([]byte("hash"), []byte("f1"), []byte("v1")))
+	assert.Nil(t, wb.HSet([]byte("hash"), []byte("f2"), []byte("v2")))
+	assert.Nil(t, wb.HDel([]byte("hash"), []byte("f2")))
+	assert.Nil(t, wb.SAdd([]byte("set"), []byte("m1"), []byte("m2")))
+	assert.Nil(t, wb.SRem([]byte("set"), []byte("m2"), []byte("m3")))
+	assert.Nil(t, wb.RPush([]byte("list"), [][]byte{[]byte("e2"), []byte("e3")}))
+	assert.Nil(t, wb.LPush([]byte("list"), [][]byte{[]byte("e1")}))
+	assert.Nil(t, wb.Expire([]byte("hash"), time.Hour))
+
+	// nothing is visible before the commit
+	_, err = db.Get([]byte("str"))
+	assert.Equal(t, public.ErrKeyNotFound, err)
+	assert.Nil(t, wb.Commit())
+
+	check := func() {
+		value, err := db.Get([]byte("str"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), value)
+		_, err = db.Get([]byte("old"))
+		assert.Equal(t, public.ErrKeyNotFound, err)
+		ttl, err := db.TTL([]byte("tmp"))
+		assert.Nil(t, err)
+		assert.Greater(t, ttl, time.Minute)
+		ttl, err = db.TTL([]byte("hash"))
+		assert.Nil(t, err)
+		assert.Greater(t, ttl, time.Minute)
+
+		assert.Nil(t, db.SerialTransaction(true, func(txn *Txn) error {
+			fields, values, err := txn.HGetAll([]byte("hash"))
+			assert.Nil(t, err)
+			assert.Equal(t, [][]byte{[]byte("f1")}, fields)
+			assert.Equal(t, [][]byte{[]byte("v1")}, values)
+			members, err := txn.SMembers([]byte("set"))
+			assert.Nil(t, err)
+			assert.Equal(t, [][]byte{[]byte("m1")}, members)
+			elements, err := txn.LRange([]byte("list"), 0, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, [][]byte{[]byte("e1"), []byte("e2"), []byte("e3")}, elements)
+			return nil
+		}))
+	}
+	check()
+
+	// the batch is replayed from the log
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+	check()
+}
+
+func TestWriteBatch_Watch(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	strCh := db.Watch(ctx, "str")
+	hashCh := db.Watch(ctx, "hash")
+
+	wb := db.NewWriteBatch(DefaultBatchOptions())
+	assert.Nil(t, wb.Put([]byte("str"), []byte("v1")))
+	assert.Nil(t, wb.Del([]byte("str")))
+	assert.Nil(t, wb.HSet([]byte("hash"), []byte("f"), []byte("v")))
+	assert.Nil(t, wb.Commit())
+
+	for _, expected := range []*watchEvent{
+		{key: "str", value: []byte("v1"), eventType: PutEvent},
+		{key: "str", eventType: DelEvent},
+	} {
+		select {
+		case event := <-strCh:
+			assert.Equal(t, expected, event)
+		case <-time.After(time.Second):
+			t.Fatal("the event of the batch is not received")
+		}
+	}
+	select {
+	case event := <-hashCh:
+		assert.Equal(t, &watchEvent{key: "hash", eventType: PutEvent}, event)
+	case <-time.After(time.Second):
+		t.Fatal("the event of the batch is not received")
+	}
+}
+
+func TestWriteBatch_MaxBatchNum(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	opts := DefaultBatchOptions()
+	opts.MaxBatchNum = 2
+	wb := db.NewWriteBatch(opts)
+	assert.Nil(t, wb.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, wb.HSet([]byte("hash"), []byte("f"), []byte("v")))
+	assert.Equal(t, public.ErrExceedMaxBatchNum, wb.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, wb.Commit())
+
+	// the batch is empty again after the commit
+	assert.Nil(t, wb.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, wb.Commit())
+	value, err := db.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), value)
+}
+
+func TestWriteBatch_Failed(t *testing.T) {
+	options := DefaultOptions()
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("k0"), []byte("v0")))
+	writer := &failingWriter{IOManager: db.activityFile.Writer}
+	db.activityFile.Writer = writer
+
+	// the second record fails, the first one is ended by a rollback mark
+	wb := db.NewWriteBatch(DefaultBatchOptions())
+	assert.Nil(t, wb.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, wb.Put([]byte("k2"), []byte("v2")))
+	writer.skip, writer.failures = 1, 1
+	assert.NotNil(t, wb.Commit())
+	db.activityFile.Writer = writer.IOManager
+
+	check := func() {
+		for _, key := range []string{"k1", "k2"} {
+			_, err := db.Get([]byte(key))
+			assert.Equal(t, public.ErrKeyNotFound, err)
+		}
+	}
+	check()
+	// a batch applied with no active txn is not kept for the conflict checks
+	wb = db.NewWriteBatch(DefaultBatchOptions())
+	assert.Nil(t, wb.Put([]byte("k3"), []byte("v3")))
+	assert.Nil(t, wb.Commit())
+	db.oracle.txnsMu.Lock()
+	assert.Len(t, db.oracle.committedTxns, 0)
+	db.oracle.txnsMu.Unlock()
+
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+	check()
+}
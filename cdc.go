@@ -0,0 +1,610 @@
+package CouloyDB
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/public/ds"
+)
+
+// Every commit of a txn or a write batch and every write outside them is a change. When the change stream
+// is enabled, a change is appended to the change file once it is applied to the indexes. The commits and the
+// writes outside the txns hold the lock of the oracle, so the changes are appended in the order of their commitTs
+// and a subscriber can resume from the commitTs of the last change it handled.
+//
+// The change is appended after the commit, which does not fail if the change does not. The commit mark of a txn
+// keeps its commitTs and the writes outside the txns keep it as their version, so the changes committed after
+// the last one in the change file are rebuilt from the log when the db is opened. A change stream which failed
+// to append a change is closed until then
+
+// Change is the mutations committed together
+type Change struct {
+	// TxId is the id of the txn or the write batch, NO_TX_ID for a write outside them
+	TxId     int64
+	CommitTs int64
+	// the mutations in the order they were written, a record written several times is only the last time
+	Mutations []Mutation
+}
+
+// Mutation is a record written by a change
+type Mutation struct {
+	DataType data.DataType
+	Deleted  bool
+	// Range is true if the strings from Key to Value were deleted, like the range tombstone
+	Range bool
+	Key   []byte
+	// Sub is the field of a hash, the member of a set or a zset, the chunk of a bitmap, the sub key of a stream,
+	// the seq of a list element or the data type of an expiration, it is empty for the other data types
+	Sub        []byte
+	Value      []byte
+	Expiration int64
+}
+
+const (
+	// changeFileSize is the size a change file is full at, the retention removes whole change files
+	changeFileSize int64 = 16 * 1024 * 1024
+	// changeIndexSpacing is the number of bytes of changes between two entries of the index of a change file
+	changeIndexSpacing int64 = 4096
+)
+
+// changeFeed keeps the change files and the subscribers
+type changeFeed struct {
+	mu      *sync.Mutex
+	dirPath string
+	// the oldest first, the changes are appended to the last one
+	files []*changeFile
+	// the size a change file is full at, and the most bytes of changes kept, zero if they are all kept
+	fileSize    int64
+	maxBytes    int64
+	syncWrites  bool
+	subscribers map[*ds.EventQueue]struct{}
+	// the commitTs of the last change in the change file
+	lastCommitTs int64
+	// the commitTs of the last change removed by the retention, the changes after an earlier one are incomplete
+	droppedTs int64
+	// the failure to append a change, the later changes are not appended
+	err error
+}
+
+// changeFile is a change file with a sparse index of the commitTs of its changes
+type changeFile struct {
+	file *data.DataFile
+	// the commitTs and the offset of a change about every changeIndexSpacing bytes, the first change included
+	index        []changeIndexEntry
+	lastCommitTs int64
+}
+
+type changeIndexEntry struct {
+	commitTs int64
+	offset   int64
+}
+
+func openChangeFeed(opt Options) (*changeFeed, error) {
+	// the single change file of the older versions is the first one
+	legacy := filepath.Join(opt.DirPath, public.ChangeFileName)
+	if _, err := os.Stat(legacy); err == nil {
+		if err := os.Rename(legacy, data.GetChangeFileName(opt.DirPath, 0)); err != nil {
+			return nil, err
+		}
+	}
+
+	dirEntries, err := os.ReadDir(opt.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	var fileIds []int
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), public.ChangeFileNameSuffix) {
+			fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), public.ChangeFileNameSuffix))
+			if err != nil {
+				return nil, errors.New("the data dir maybe contaminated or damaged")
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	if len(fileIds) == 0 {
+		fileIds = append(fileIds, 0)
+	}
+	sort.Ints(fileIds)
+
+	f := &changeFeed{
+		mu:          &sync.Mutex{},
+		dirPath:     opt.DirPath,
+		fileSize:    changeFileSize,
+		maxBytes:    opt.MaxChangeBytes,
+		syncWrites:  opt.SyncWrites,
+		subscribers: make(map[*ds.EventQueue]struct{}),
+	}
+	// a few files at least are kept, so the retention removes a part of the changes at a time
+	if f.maxBytes > 0 && f.maxBytes/4 < f.fileSize {
+		f.fileSize = f.maxBytes/4 + 1
+	}
+	for _, fid := range fileIds {
+		cf, err := openChangeFile(opt.DirPath, uint32(fid))
+		if err != nil {
+			return nil, err
+		}
+		f.files = append(f.files, cf)
+		if cf.lastCommitTs > f.lastCommitTs {
+			f.lastCommitTs = cf.lastCommitTs
+		}
+	}
+	// the change files before the first one were removed by the retention
+	if first := f.files[0]; first.file.FileId > 0 {
+		if len(first.index) > 0 {
+			f.droppedTs = first.index[0].commitTs - 1
+		} else {
+			f.droppedTs = f.lastCommitTs
+		}
+	}
+	return f, nil
+}
+
+// openChangeFile opens the change file and builds its index, a change partly appended before a crash is cut off
+func openChangeFile(dirPath string, fileId uint32) (*changeFile, error) {
+	file, err := data.OpenChangeFile(dirPath, fileId)
+	if err != nil {
+		return nil, err
+	}
+	cf := &changeFile{file: file}
+	var offset int64
+	for offset < file.WriteOff {
+		logRecord, size, err := file.ReadLogRecord(offset)
+		if err != nil {
+			break
+		}
+		cf.add(int64(binary.BigEndian.Uint64(logRecord.Key)), offset)
+		offset += size
+	}
+	file.WriteOff = offset
+	return cf, nil
+}
+
+// add records the change appended at the offset
+func (cf *changeFile) add(commitTs, offset int64) {
+	if n := len(cf.index); n == 0 || offset-cf.index[n-1].offset >= changeIndexSpacing {
+		cf.index = append(cf.index, changeIndexEntry{commitTs: commitTs, offset: offset})
+	}
+	cf.lastCommitTs = commitTs
+}
+
+// seek returns the offset of a change at or before the first change committed after from
+func (cf *changeFile) seek(from int64) int64 {
+	i := sort.Search(len(cf.index), func(i int) bool {
+		return cf.index[i].commitTs > from
+	})
+	if i == 0 {
+		return 0
+	}
+	return cf.index[i-1].offset
+}
+
+// Subscribe returns the changes committed after the commitTs from in the order of their commitTs, the channel
+// is closed when the context is done or the db is closed. The changes committed before the subscription are
+// read from the change files, starting at the first change after from. It returns ErrChangesDropped if some of
+// the changes after from were removed by the retention, and the channel is closed if the retention removes
+// the changes before the subscriber reads them
+func (db *DB) Subscribe(ctx context.Context, from int64) (<-chan *Change, error) {
+	f := db.feed
+	if f == nil {
+		return nil, public.ErrChangeStreamDisabled
+	}
+
+	// the changes appended after the ends of the files are sent by the queue
+	type part struct {
+		file        *data.DataFile
+		offset, end int64
+	}
+	f.mu.Lock()
+	if f.err != nil {
+		f.mu.Unlock()
+		return nil, f.err
+	}
+	if from < f.droppedTs {
+		f.mu.Unlock()
+		return nil, public.ErrChangesDropped
+	}
+	queue := ds.NewEventQueue()
+	f.subscribers[queue] = struct{}{}
+	start := 0
+	for i, cf := range f.files {
+		if len(cf.index) > 0 && cf.index[0].commitTs <= from {
+			start = i
+		}
+	}
+	parts := make([]part, 0, len(f.files)-start)
+	for i, cf := range f.files[start:] {
+		p := part{file: cf.file, end: cf.file.WriteOff}
+		if i == 0 {
+			p.offset = cf.seek(from)
+		}
+		parts = append(parts, p)
+	}
+	f.mu.Unlock()
+
+	changeCh := make(chan *Change, 128)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			f.unsubscribe(queue)
+		case <-done:
+		}
+	}()
+	go func() {
+		defer close(changeCh)
+		defer close(done)
+		defer f.unsubscribe(queue)
+
+		send := func(change *Change) bool {
+			if change.CommitTs <= from {
+				return true
+			}
+			select {
+			case changeCh <- change:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, p := range parts {
+			for offset := p.offset; offset < p.end; {
+				logRecord, size, err := f.read(p.file, offset)
+				if err != nil {
+					return
+				}
+				if !send(decodeChange(logRecord.Value)) {
+					return
+				}
+				offset += size
+			}
+		}
+		for {
+			change, ok := queue.Read().(*Change)
+			if !ok || !send(change) {
+				return
+			}
+		}
+	}()
+	return changeCh, nil
+}
+
+func (f *changeFeed) unsubscribe(queue *ds.EventQueue) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subscribers, queue)
+	queue.Close()
+}
+
+// publish appends the change to the change file and sends it to the subscribers
+func (f *changeFeed) publish(change *Change) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	// the files are closed with the db
+	if len(f.files) == 0 {
+		return os.ErrClosed
+	}
+
+	cf := f.files[len(f.files)-1]
+	if cf.file.WriteOff >= f.fileSize {
+		if err := f.rotate(); err != nil {
+			f.failLocked(err)
+			return err
+		}
+		cf = f.files[len(f.files)-1]
+	}
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(change.CommitTs))
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: key, Value: encodeChange(change)})
+	offset := cf.file.WriteOff
+	if err := cf.file.Write(encRecord); err != nil {
+		f.failLocked(err)
+		return err
+	}
+	if f.syncWrites {
+		if err := cf.file.Sync(); err != nil {
+			f.failLocked(err)
+			return err
+		}
+	}
+	cf.add(change.CommitTs, offset)
+	f.lastCommitTs = change.CommitTs
+
+	for queue := range f.subscribers {
+		queue.Write(change)
+	}
+	return nil
+}
+
+// read reads the change at the offset of the change file, it fails once the file is removed by the retention
+// or the feed is closed
+func (f *changeFeed) read(file *data.DataFile, offset int64) (*data.LogRecord, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.files) == 0 || file.FileId < f.files[0].file.FileId {
+		return nil, 0, public.ErrChangesDropped
+	}
+	return file.ReadLogRecord(offset)
+}
+
+// rotate starts a new change file and removes the oldest ones beyond the retention, the lock must be held
+func (f *changeFeed) rotate() error {
+	last := f.files[len(f.files)-1]
+	if err := last.file.Sync(); err != nil {
+		return err
+	}
+	file, err := data.OpenChangeFile(f.dirPath, last.file.FileId+1)
+	if err != nil {
+		return err
+	}
+	f.files = append(f.files, &changeFile{file: file})
+	if f.maxBytes == 0 {
+		return nil
+	}
+
+	var size int64
+	for _, cf := range f.files {
+		size += cf.file.WriteOff
+	}
+	// the last full file is kept, its last commitTs is where the changes are recovered from at the open
+	for size > f.maxBytes && len(f.files) > 2 {
+		oldest := f.files[0]
+		if err := oldest.file.Close(); err != nil {
+			return err
+		}
+		if err := os.Remove(data.GetChangeFileName(f.dirPath, oldest.file.FileId)); err != nil {
+			return err
+		}
+		size -= oldest.file.WriteOff
+		f.droppedTs = oldest.lastCommitTs
+		f.files = f.files[1:]
+	}
+	return nil
+}
+
+// fail stops appending the changes, the subscribers are closed so they don't miss the changes silently
+func (f *changeFeed) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failLocked(err)
+}
+
+// failed returns the error which stopped the feed, nil if it is working
+func (f *changeFeed) failed() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func (f *changeFeed) failLocked(err error) {
+	if f.err != nil {
+		return
+	}
+	f.err = err
+	for queue := range f.subscribers {
+		queue.Close()
+	}
+	f.subscribers = make(map[*ds.EventQueue]struct{})
+}
+
+func (f *changeFeed) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for queue := range f.subscribers {
+		queue.Close()
+	}
+	f.subscribers = make(map[*ds.EventQueue]struct{})
+	files := f.files
+	f.files = nil
+	for _, cf := range files {
+		if err := cf.file.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockStrWrite takes the locks of a string write outside the txns, no txn commits or begins during the write
+func (db *DB) lockStrWrite() {
+	db.oracle.mu.Lock()
+	db.getIndexLockByType(data.String).Lock()
+}
+
+func (db *DB) unlockStrWrite() {
+	db.getIndexLockByType(data.String).Unlock()
+	db.oracle.mu.Unlock()
+}
+
+// publishChange publishes the change if the change stream is enabled, the change is committed already
+// so a failure is only logged
+func (db *DB) publishChange(change *Change) {
+	if db.feed == nil || len(change.Mutations) == 0 {
+		return
+	}
+	if err := db.feed.publish(change); err != nil {
+		log.Printf("failed to publish the change %d: %v", change.CommitTs, err)
+	}
+}
+
+// publishChange publishes the change committed by the txn if the change stream is enabled
+func (txn *Txn) publishChange() {
+	if txn.db.feed == nil {
+		return
+	}
+
+	positions := make([]*data.LogPos, 0)
+	addPendingWrites := func(pendingWrites map[string]*pendingWrite) {
+		for _, pw := range pendingWrites {
+			positions = append(positions, pw.LogPos)
+		}
+	}
+	addNestedPendingWrites := func(pendingWrites map[string]map[string]*pendingWrite) {
+		for _, subWrites := range pendingWrites {
+			addPendingWrites(subWrites)
+		}
+	}
+	addPendingWrites(txn.strPendingWrites)
+	addNestedPendingWrites(txn.hashPendingWrites)
+	addNestedPendingWrites(txn.setPendingWrites)
+	addNestedPendingWrites(txn.zsetPendingWrites)
+	addNestedPendingWrites(txn.bitmapPendingWrites)
+	addPendingWrites(txn.hllPendingWrites)
+	addNestedPendingWrites(txn.streamPendingWrites)
+	addPendingWrites(txn.expirePendingWrites)
+	addPendingWrites(txn.listMetaPendingWrites)
+	addNestedPendingWrites(txn.listDataPendingWrites)
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].Fid != positions[j].Fid {
+			return positions[i].Fid < positions[j].Fid
+		}
+		return positions[i].Offset < positions[j].Offset
+	})
+
+	change := &Change{TxId: txn.startTs, CommitTs: txn.commitTs, Mutations: make([]Mutation, 0, len(positions))}
+	for _, pos := range positions {
+		logRecord, err := txn.db.readLogRecord(pos)
+		if err != nil {
+			log.Printf("failed to publish the change %d: %v", change.CommitTs, err)
+			txn.db.feed.fail(err)
+			return
+		}
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		change.Mutations = append(change.Mutations, mutationOf(realKey, logRecord))
+	}
+	txn.db.publishChange(change)
+}
+
+// recoverChanges publishes the changes of the records committed together which are missing from the change file,
+// the records are replayed from the log in the order they were written
+func (db *DB) recoverChanges(txId, commitTs int64, records []*data.TxRecord) {
+	if db.feed == nil || commitTs <= db.feed.lastCommitTs {
+		return
+	}
+	// a record written several times is only the last time
+	last := make(map[string]int, len(records))
+	for i, txRecord := range records {
+		last[string(encodeExpireKey(txRecord.Record.DataType, txRecord.Record.Key))] = i
+	}
+	change := &Change{TxId: txId, CommitTs: commitTs, Mutations: make([]Mutation, 0, len(last))}
+	for i, txRecord := range records {
+		if last[string(encodeExpireKey(txRecord.Record.DataType, txRecord.Record.Key))] == i {
+			change.Mutations = append(change.Mutations, mutationOf(txRecord.Record.Key, txRecord.Record))
+		}
+	}
+	db.publishChange(change)
+}
+
+// mutationOf returns the mutation written by the record, whose key is the real key
+func mutationOf(realKey []byte, logRecord *data.LogRecord) Mutation {
+	m := Mutation{
+		DataType:   logRecord.DataType,
+		Deleted:    logRecord.Type == data.LogRecordDeleted || logRecord.Type == data.LogRecordRangeDeleted,
+		Range:      logRecord.Type == data.LogRecordRangeDeleted,
+		Key:        realKey,
+		Expiration: logRecord.Expiration,
+	}
+	if !m.Deleted || m.Range {
+		m.Value = logRecord.Value
+	}
+	switch logRecord.DataType {
+	case data.Hash:
+		m.Key, m.Sub = decodeFieldKey(realKey)
+	case data.Set, data.ZSet, data.Bitmap, data.Stream:
+		m.Key, m.Sub = decodeMemberKey(realKey)
+	case data.List:
+		m.Key, m.Sub = listIndexKey(realKey)
+	case data.Expire:
+		typ, key := decodeExpireKey(realKey)
+		m.Key, m.Sub = key, []byte{byte(typ)}
+	}
+	return m
+}
+
+// encodeChange encodes the change as txId | commitTs | the number of the mutations | mutations,
+// a mutation is dataType | flags | key | sub | value | expiration with the sizes before the bytes,
+// the flags are mutationDeleted and mutationRange
+func encodeChange(change *Change) []byte {
+	buf := make([]byte, 0, 64)
+	buf = binary.AppendVarint(buf, change.TxId)
+	buf = binary.AppendVarint(buf, change.CommitTs)
+	buf = binary.AppendUvarint(buf, uint64(len(change.Mutations)))
+	for _, m := range change.Mutations {
+		flags := byte(0)
+		if m.Deleted {
+			flags |= mutationDeleted
+		}
+		if m.Range {
+			flags |= mutationRange
+		}
+		buf = append(buf, byte(m.DataType), flags)
+		for _, b := range [][]byte{m.Key, m.Sub, m.Value} {
+			buf = binary.AppendUvarint(buf, uint64(len(b)))
+			buf = append(buf, b...)
+		}
+		buf = binary.AppendVarint(buf, m.Expiration)
+	}
+	return buf
+}
+
+// encodeCommitTs encodes the commitTs kept by the commit mark of a txn
+func encodeCommitTs(commitTs int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(commitTs))
+	return buf
+}
+
+// decodeCommitTs decodes the commitTs of a commit mark, zero if the mark does not keep it
+func decodeCommitTs(buf []byte) int64 {
+	if len(buf) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(buf))
+}
+
+const (
+	mutationDeleted byte = 1 << iota
+	mutationRange
+)
+
+func decodeChange(buf []byte) *Change {
+	var n int
+	change := &Change{}
+	change.TxId, n = binary.Varint(buf)
+	buf = buf[n:]
+	change.CommitTs, n = binary.Varint(buf)
+	buf = buf[n:]
+	count, n := binary.Uvarint(buf)
+	buf = buf[n:]
+
+	change.Mutations = make([]Mutation, count)
+	for i := range change.Mutations {
+		m := &change.Mutations[i]
+		m.DataType, m.Deleted, m.Range = data.DataType(buf[0]), buf[1]&mutationDeleted != 0, buf[1]&mutationRange != 0
+		buf = buf[2:]
+		for _, b := range []*[]byte{&m.Key, &m.Sub, &m.Value} {
+			size, n := binary.Uvarint(buf)
+			if size > 0 {
+				*b = buf[n : n+int(size)]
+			}
+			buf = buf[n+int(size):]
+		}
+		m.Expiration, n = binary.Varint(buf)
+		buf = buf[n:]
+	}
+	return change
+}
@@ -0,0 +1,293 @@
+package CouloyDB
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/public/utils/bytex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Subscribe(t *testing.T) {
+	options := DefaultOptions()
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+
+	// the change stream is disabled by default
+	_, err = db.Subscribe(context.Background(), 0)
+	assert.Equal(t, public.ErrChangeStreamDisabled, err)
+	assert.Nil(t, db.Close())
+
+	options.EnableChangeStream = true
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer func() { destroyCouloyDB(db) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changeCh, err := db.Subscribe(ctx, 0)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	txn, err := db.Begin(TxnOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Set([]byte("k2"), []byte("v2")))
+	assert.Nil(t, txn.HSet([]byte("h"), []byte("f"), []byte("v")))
+	assert.Nil(t, txn.Commit())
+	wb := db.NewWriteBatch(DefaultBatchOptions())
+	assert.Nil(t, wb.Put([]byte("k3"), []byte("v3")))
+	assert.Nil(t, wb.Del([]byte("k1")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Del([]byte("k2")))
+	assert.Nil(t, db.DeletePrefix([]byte("k")))
+
+	expected := [][]Mutation{
+		{{DataType: data.String, Key: []byte("k1"), Value: []byte("v1")}},
+		{
+			{DataType: data.String, Key: []byte("k2"), Value: []byte("v2")},
+			{DataType: data.Hash, Key: []byte("h"), Sub: []byte("f"), Value: []byte("v")},
+		},
+		{
+			{DataType: data.String, Key: []byte("k3"), Value: []byte("v3")},
+			{DataType: data.String, Deleted: true, Key: []byte("k1")},
+		},
+		{{DataType: data.String, Deleted: true, Key: []byte("k2")}},
+		// a range of strings is deleted by a single mutation
+		{{DataType: data.String, Deleted: true, Range: true, Key: []byte("k"), Value: []byte("l")}},
+	}
+	changes := make([]*Change, 0, len(expected))
+	for range expected {
+		select {
+		case change := <-changeCh:
+			changes = append(changes, change)
+		case <-ctx.Done():
+			assert.Fail(t, "Context canceled before receiving all changes")
+			return
+		}
+	}
+	for i, change := range changes {
+		assert.Equal(t, expected[i], change.Mutations)
+		if i > 0 {
+			assert.Greater(t, change.CommitTs, changes[i-1].CommitTs)
+		}
+	}
+	assert.Equal(t, public.NO_TX_ID, changes[0].TxId)
+	assert.NotEqual(t, public.NO_TX_ID, changes[1].TxId)
+
+	// a subscriber resumes from a commitTs after a restart
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	changeCh, err = db.Subscribe(ctx, changes[1].CommitTs)
+	assert.Nil(t, err)
+	for _, want := range changes[2:] {
+		select {
+		case change := <-changeCh:
+			assert.Equal(t, want, change)
+		case <-ctx.Done():
+			assert.Fail(t, "Context canceled before receiving all changes")
+			return
+		}
+	}
+
+	// the channel is closed when the context is done
+	subCtx, subCancel := context.WithCancel(context.Background())
+	changeCh, err = db.Subscribe(subCtx, changes[len(changes)-1].CommitTs)
+	assert.Nil(t, err)
+	subCancel()
+	select {
+	case _, ok := <-changeCh:
+		assert.False(t, ok)
+	case <-ctx.Done():
+		assert.Fail(t, "Context canceled before the channel was closed")
+	}
+}
+
+func TestDB_Subscribe_Recover(t *testing.T) {
+	options := DefaultOptions()
+	options.EnableChangeStream = true
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer func() { destroyCouloyDB(db) }()
+
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	// the changes are lost as in a crash after the commits, the commits still succeed
+	db.feed.fail(errors.New("injected"))
+	assert.Nil(t, db.Put([]byte("k2"), []byte("v2")))
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		if err := txn.Set([]byte("k3"), []byte("v3")); err != nil {
+			return err
+		}
+		return txn.HSet([]byte("h"), []byte("f"), []byte("v"))
+	})
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultBatchOptions())
+	assert.Nil(t, wb.Del([]byte("k1")))
+	assert.Nil(t, wb.Commit())
+
+	_, err = db.Subscribe(context.Background(), 0)
+	assert.NotNil(t, err)
+
+	// the missing changes are rebuilt from the log when the db is opened
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("k4"), []byte("v4")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changeCh, err := db.Subscribe(ctx, 0)
+	assert.Nil(t, err)
+
+	expected := [][]Mutation{
+		{{DataType: data.String, Key: []byte("k1"), Value: []byte("v1")}},
+		{{DataType: data.String, Key: []byte("k2"), Value: []byte("v2")}},
+		{
+			{DataType: data.String, Key: []byte("k3"), Value: []byte("v3")},
+			{DataType: data.Hash, Key: []byte("h"), Sub: []byte("f"), Value: []byte("v")},
+		},
+		{{DataType: data.String, Deleted: true, Key: []byte("k1")}},
+		{{DataType: data.String, Key: []byte("k4"), Value: []byte("v4")}},
+	}
+	var last int64
+	for _, want := range expected {
+		select {
+		case change := <-changeCh:
+			assert.Equal(t, want, change.Mutations)
+			assert.Greater(t, change.CommitTs, last)
+			last = change.CommitTs
+		case <-ctx.Done():
+			assert.Fail(t, "Context canceled before receiving all changes")
+			return
+		}
+	}
+}
+
+func TestDB_Subscribe_Merge(t *testing.T) {
+	options := DefaultOptions()
+	options.DataFileSize = 32 * 1024
+	options.SyncWrites = false
+	options.EnableChangeStream = true
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer func() { destroyCouloyDB(db) }()
+
+	n := 300
+	for round := 0; round < 3; round++ {
+		for i := 0; i < n; i++ {
+			assert.Nil(t, db.Put(bytex.GetTestKey(i), append(bytex.GetTestKey(i), byte(round))))
+		}
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	// the change file is not replaced by the merge and the merged records are not published again
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	value, err := db.Get(bytex.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, append(bytex.GetTestKey(0), byte(2)), value)
+	assert.Nil(t, db.Put([]byte("last"), []byte("v")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changeCh, err := db.Subscribe(ctx, 0)
+	assert.Nil(t, err)
+	for i := 0; i <= 3*n; i++ {
+		select {
+		case change := <-changeCh:
+			if i < 3*n {
+				assert.Equal(t, bytex.GetTestKey(i%n), change.Mutations[0].Key)
+			} else {
+				assert.Equal(t, []byte("last"), change.Mutations[0].Key)
+			}
+		case <-ctx.Done():
+			assert.Fail(t, "Context canceled before receiving all changes")
+			return
+		}
+	}
+}
+
+func TestDB_Subscribe_Retention(t *testing.T) {
+	options := DefaultOptions()
+	options.SyncWrites = false
+	options.EnableChangeStream = true
+	options.MaxChangeBytes = 64 * 1024
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+
+	n := 5000
+	var commitTs []int64
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	changeCh, err := db.Subscribe(ctx, 0)
+	assert.Nil(t, err)
+	for i := 0; i < n; i++ {
+		assert.Nil(t, db.Put(bytex.GetTestKey(i), bytex.RandomBytes(64)))
+		select {
+		case change := <-changeCh:
+			commitTs = append(commitTs, change.CommitTs)
+		case <-ctx.Done():
+			assert.Fail(t, "Context canceled before receiving all changes")
+			return
+		}
+	}
+	assert.Nil(t, db.Close())
+
+	// the oldest change files were removed
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer func() { destroyCouloyDB(db) }()
+	var size int64
+	for _, cf := range db.feed.files {
+		size += cf.file.WriteOff
+	}
+	assert.Greater(t, len(db.feed.files), 1)
+	assert.Greater(t, db.feed.files[0].file.FileId, uint32(0))
+	assert.LessOrEqual(t, size, options.MaxChangeBytes)
+	assert.Equal(t, commitTs[n-1], db.feed.lastCommitTs)
+
+	_, err = db.Subscribe(context.Background(), 0)
+	assert.Equal(t, public.ErrChangesDropped, err)
+	_, err = db.Subscribe(context.Background(), db.feed.droppedTs-1)
+	assert.Equal(t, public.ErrChangesDropped, err)
+
+	// a subscriber starts at the change after from, in the middle of a change file
+	for _, i := range []int{n - 1, n - 2, n - 100, n - 300} {
+		if commitTs[i] < db.feed.droppedTs {
+			continue
+		}
+		changeCh, err := db.Subscribe(ctx, commitTs[i])
+		assert.Nil(t, err)
+		for j := i + 1; j < n; j++ {
+			select {
+			case change := <-changeCh:
+				assert.Equal(t, commitTs[j], change.CommitTs)
+				assert.Equal(t, bytex.GetTestKey(j), change.Mutations[0].Key)
+			case <-ctx.Done():
+				assert.Fail(t, "Context canceled before receiving all changes")
+				return
+			}
+		}
+	}
+}
+
+func TestChangeFile_Seek(t *testing.T) {
+	cf := &changeFile{}
+	for i := int64(0); i < 100; i++ {
+		cf.add(10*(i+1), i*1024)
+	}
+	// an entry about every changeIndexSpacing bytes
+	assert.Equal(t, 25, len(cf.index))
+	assert.Equal(t, int64(1000), cf.lastCommitTs)
+
+	assert.Equal(t, int64(0), cf.seek(0))
+	assert.Equal(t, int64(0), cf.seek(10))
+	assert.Equal(t, int64(0), cf.seek(49))
+	assert.Equal(t, int64(4*1024), cf.seek(50))
+	assert.Equal(t, int64(96*1024), cf.seek(1000))
+}
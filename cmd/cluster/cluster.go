@@ -84,6 +84,10 @@ var clusterCmd = &cobra.Command{
 			kuloyOpts.StandaloneOpt.IndexType = meta.Btree
 		case "art":
 			kuloyOpts.StandaloneOpt.IndexType = meta.ART
+		case "bptree":
+			kuloyOpts.StandaloneOpt.IndexType = meta.BPlusTree
+		case "compact":
+			kuloyOpts.StandaloneOpt.IndexType = meta.CompactBtree
 		}
 
 		resp.SetupEngine(kuloyOpts, true)
@@ -118,7 +122,7 @@ func kuloyServerStart(addr string) {
 		}
 	}()
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	// SIGINT User sends INTR character (Ctrl + C) to trigger kill -2
 	// End of SIGTERM program (can be captured, blocked, or ignored)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -145,7 +149,7 @@ func init() {
 	cmdSelf = clusterCmd.Flags().Int64P("self", "s", 0, "Local Index in the cluster (optional)")
 
 	clusterCmd.Flags().StringVarP(&cmdDirPath, "dpath", "d", "./datafile", "Directory Path where data logs are stored [default at ./datafile]")
-	clusterCmd.Flags().StringVarP(&cmdIndexType, "itype", "t", "btree", "Type of memory index (hashmap/btree/art)")
+	clusterCmd.Flags().StringVarP(&cmdIndexType, "itype", "t", "btree", "Type of memory index (hashmap/btree/art/bptree/compact)")
 	cmdMergeInterval = clusterCmd.Flags().Int64P("minterval", "", 3600, "merge frequently interval (unit: second) [default 8 hours]")
 	cmdDataFileSize = clusterCmd.Flags().Int64P("dfsize", "", 268435456, "Maximum byte size per datafile (unit: Byte) [default 256MB]")
 	cmdSyncWrites = clusterCmd.Flags().BoolP("sync", "", false, "Whether to enable write synchronization (true/false)")
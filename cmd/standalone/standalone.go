@@ -95,6 +95,10 @@ var standaloneCmd = &cobra.Command{
 			kuloyOpts.StandaloneOpt.IndexType = meta.Btree
 		case "art":
 			kuloyOpts.StandaloneOpt.IndexType = meta.ART
+		case "bptree":
+			kuloyOpts.StandaloneOpt.IndexType = meta.BPlusTree
+		case "compact":
+			kuloyOpts.StandaloneOpt.IndexType = meta.CompactBtree
 		}
 
 		resp.SetupEngine(kuloyOpts, false)
@@ -127,7 +131,7 @@ func kuloyServerStart(addr string) {
 		}
 	}()
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	// SIGINT User sends INTR character (Ctrl + C) to trigger kill -2
 	// End of SIGTERM program (can be captured, blocked, or ignored)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -153,7 +157,7 @@ func init() {
 	standaloneCmd.Flags().StringVarP(&cmdPort, "port", "p", ":9736", "Address of the host on the network (For example 192.168.1.151:9736) [default 0.0.0.0:9736]")
 
 	standaloneCmd.Flags().StringVarP(&cmdDirPath, "dpath", "d", "./datafile", "Directory Path where data logs are stored [default at ./datafile]")
-	standaloneCmd.Flags().StringVarP(&cmdIndexType, "itype", "t", "btree", "Type of memory index (hashmap/btree/art)")
+	standaloneCmd.Flags().StringVarP(&cmdIndexType, "itype", "t", "btree", "Type of memory index (hashmap/btree/art/bptree/compact)")
 	cmdMergeInterval = standaloneCmd.Flags().Int64P("minterval", "", 3600, "merge frequently interval (unit: second) [default 8 hours]")
 	cmdDataFileSize = standaloneCmd.Flags().Int64P("dfsize", "", 268435456, "Maximum byte size per datafile (unit: Byte) [default 256MB]")
 	cmdSyncWrites = standaloneCmd.Flags().BoolP("sync", "", false, "Whether to enable write synchronization (true/false)")
@@ -34,6 +34,18 @@ func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0)
 }
 
+// OpenChangeFile Open the change file, the records are appended to its end
+func OpenChangeFile(dirPath string, fileId uint32) (*DataFile, error) {
+	df, err := newDataFile(GetChangeFileName(dirPath, fileId), fileId)
+	if err != nil {
+		return nil, err
+	}
+	if df.WriteOff, err = df.Writer.Size(); err != nil {
+		return nil, err
+	}
+	return df, nil
+}
+
 // OpenTxIDFile Open new datafile
 //func OpenTxIDFile(dirPath string) (*DataFile, error) {
 //	fileName := filepath.Join(dirPath, public.TxIDFileName)
@@ -44,6 +56,10 @@ func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+public.DataFileNameSuffix)
 }
 
+func GetChangeFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+public.ChangeFileNameSuffix)
+}
+
 func newDataFile(fileName string, fileId uint32) (*DataFile, error) {
 	writer, err := driver.NewIOManager(fileName)
 	if err != nil {
@@ -89,7 +105,7 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	keySize, valueSize := int64(header.KeySize), int64(header.ValueSize)
 	var recordSize = headerSize + keySize + valueSize
 
-	logRecord := &LogRecord{Type: header.RecordType, DataType: header.DataType, Expiration: header.Expiration}
+	logRecord := &LogRecord{Type: header.RecordType, DataType: header.DataType, Expiration: header.Expiration, Version: header.Version}
 	// read the real k-v
 	if keySize > 0 || valueSize > 0 {
 		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
@@ -13,6 +13,12 @@ const (
 	LogRecordTxnCommit
 	LogRecordTxnRollback
 	LogRecordTxnBegin
+	// LogRecordTxnSavepoint marks a savepoint of the txn, its value is the name of the savepoint
+	LogRecordTxnSavepoint
+	// LogRecordTxnRollbackTo discards the records of the txn after the savepoint named by its value
+	LogRecordTxnRollbackTo
+	// LogRecordRangeDeleted deletes the strings from its key to its value, an empty value has no upper bound
+	LogRecordRangeDeleted
 )
 
 type DataType uint8
@@ -23,14 +29,24 @@ const (
 	List
 	ListMeta
 	Set
+	ZSet
+	Bitmap
+	HyperLogLog
+	Stream
+	// Expire is the expiration of a key of another data type
+	Expire
 )
 
 const (
-	// crc type keySize ValueSize
-	// 4 + 1 + 1 + 5 + 5 + 10 = 26
-	maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64 + 6
+	// crc type dataType keySize ValueSize expiration version
+	// 4 + 1 + 1 + 5 + 5 + 10 + 10 = 36
+	maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64*2 + 6
 )
 
+// versionedFlag is set in the type byte of the records with a version, the version follows the expiration.
+// The records written before the versions were added have no version
+const versionedFlag byte = 1 << 7
+
 type LogRecordHeader struct {
 	crc        uint32
 	RecordType LogRecordType
@@ -38,6 +54,7 @@ type LogRecordHeader struct {
 	KeySize    uint32
 	ValueSize  uint32
 	Expiration int64
+	Version    int64
 }
 
 type LogRecord struct {
@@ -46,6 +63,7 @@ type LogRecord struct {
 	Type       LogRecordType
 	DataType   DataType
 	Expiration int64
+	Version    int64 // the version of a string, zero if it has none
 }
 
 // LogPos The location of the data on the disk
@@ -66,6 +84,10 @@ func EncodeLogRecord(log *LogRecord) ([]byte, int64) {
 	index += binary.PutVarint(header[index:], int64(len(log.Key)))
 	index += binary.PutVarint(header[index:], int64(len(log.Value)))
 	index += binary.PutVarint(header[index:], log.Expiration)
+	if log.Version != 0 {
+		header[4] |= versionedFlag
+		index += binary.PutVarint(header[index:], log.Version)
+	}
 
 	var size = index + len(log.Key) + len(log.Value)
 	encBytes := make([]byte, size)
@@ -90,7 +112,7 @@ func DecodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 
 	header := &LogRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		RecordType: buf[4],
+		RecordType: buf[4] &^ versionedFlag,
 		DataType:   DataType(buf[5]),
 	}
 
@@ -110,6 +132,12 @@ func DecodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	header.Expiration = expiration
 	index += n
 
+	if buf[4]&versionedFlag != 0 {
+		version, n := binary.Varint(buf[index:])
+		header.Version = version
+		index += n
+	}
+
 	return header, int64(index)
 }
 
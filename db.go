@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"sort"
@@ -37,8 +38,10 @@ type DB struct {
 	mergeDone    chan error
 	L            *lua.LState
 	oracle       *oracle
+	locks        *lockManager
 	ttl          *ttl
 	wm           *watcherManager
+	feed         *changeFeed
 }
 
 func NewCouloyDB(opt Options) (*DB, error) {
@@ -61,14 +64,25 @@ func NewCouloyDB(opt Options) (*DB, error) {
 		return nil, public.ErrDirOccupied
 	}
 
+	strIndex, err := openStrIndex(opt)
+	if err != nil {
+		return nil, err
+	}
+
 	// Init DB
 	db := &DB{
 		options: opt,
 		oldFile: make(map[uint32]*data.DataFile),
 		index: &index{
-			hashIndex: make(map[string]meta.MemTable),
-			setIndex:  make(map[string]meta.MemTable),
-			strIndex:  meta.NewMemTable(opt.IndexType),
+			hashIndex:   make(map[string]meta.MemTable),
+			setIndex:    make(map[string]meta.MemTable),
+			zsetIndex:   make(map[string]*zset),
+			bitmapIndex: make(map[string]meta.MemTable),
+			hllIndex:    meta.NewMemTable(opt.IndexType),
+			streamIndex: make(map[string]*stream),
+			expireIndex: meta.NewMemTable(opt.IndexType),
+			strIndex:    strIndex,
+			strHistory:  make(map[string][]*strVersion),
 			listIndex: listIndex{
 				metaIndex: meta.NewMemTable(opt.IndexType),
 				dataIndex: make(map[string]meta.MemTable),
@@ -80,15 +94,26 @@ func NewCouloyDB(opt Options) (*DB, error) {
 		mergeDone:  make(chan error),
 		flock:      fl,
 		wm:         newWatcherManager(),
+		locks:      newLockManager(),
 	}
 
 	db.indexLocks[data.String] = &sync.RWMutex{}
 	db.indexLocks[data.Hash] = &sync.RWMutex{}
+	db.indexLocks[data.List] = &sync.RWMutex{}
 	db.indexLocks[data.Set] = &sync.RWMutex{}
+	db.indexLocks[data.ZSet] = &sync.RWMutex{}
+	db.indexLocks[data.Bitmap] = &sync.RWMutex{}
+	db.indexLocks[data.HyperLogLog] = &sync.RWMutex{}
+	db.indexLocks[data.Stream] = &sync.RWMutex{}
+	db.indexLocks[data.Expire] = &sync.RWMutex{}
 
-	db.ttl = newTTL(func(key string) error {
-		return db.Del([]byte(key))
-	})
+	db.ttl = newTTL(db.expireJob)
+
+	if opt.EnableChangeStream {
+		if db.feed, err = openChangeFeed(opt); err != nil {
+			return nil, err
+		}
+	}
 
 	if opt.EnableLuaInterpreter {
 		db.initLuaInterpreter()
@@ -105,6 +130,10 @@ func NewCouloyDB(opt Options) (*DB, error) {
 		return nil, err
 	}
 
+	if err := db.migrateLegacyLists(); err != nil {
+		return nil, err
+	}
+
 	go db.mergeWorker()
 
 	go db.ttl.start()
@@ -127,17 +156,26 @@ func (db *DB) put(key, value []byte, duration time.Duration) error {
 		return err
 	}
 
-	db.getIndexLockByType(data.String).Lock()
-	defer db.getIndexLockByType(data.String).Unlock()
+	db.lockStrWrite()
+	defer db.unlockStrWrite()
 
 	var expiration int64
 	if duration != 0 {
 		expiration = time.Now().Add(duration).UnixNano()
-		db.ttl.add(ds.NewJob(string(key), time.Unix(0, expiration)))
+	}
+	_, err := db.putString(key, value, expiration)
+	return err
+}
+
+// putString writes the string with a new version and returns the version, the expiration is a unix time
+// in nanoseconds or zero if the string does not expire. The string lock must be held
+func (db *DB) putString(key, value []byte, expiration int64) (int64, error) {
+	if expiration != 0 {
+		db.ttl.add(ds.NewJob(string(encodeExpireKey(data.String, key)), time.Unix(0, expiration)))
 	} else {
 		// If it is a key without an expiration time set
 		// you may need to remove the previously set expiration time
-		db.ttl.del(string(key))
+		db.ttl.del(string(encodeExpireKey(data.String, key)))
 	}
 
 	logRecord := &data.LogRecord{
@@ -146,19 +184,29 @@ func (db *DB) put(key, value []byte, duration time.Duration) error {
 		Type:       data.LogRecordNormal,
 		DataType:   data.String,
 		Expiration: expiration,
+		Version:    db.GetTxId(),
 	}
 
 	pos, err := db.appendLogRecordWithLock(logRecord)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	db.Notify(string(key), value, PutEvent)
 
+	db.publishStrWrite(logRecord.Version, key)
 	if ok := db.index.getStrIndex().Put(key, pos); !ok {
-		return public.ErrUpdateIndexFailed
-	}
-	return nil
+		return 0, db.index.strIndexUpdateErr()
+	}
+	db.addStrVersion(key, logRecord.Version, pos, false)
+	db.publishChange(&Change{
+		TxId:     public.NO_TX_ID,
+		CommitTs: logRecord.Version,
+		Mutations: []Mutation{
+			{DataType: data.String, Key: key, Value: value, Expiration: expiration},
+		},
+	})
+	return logRecord.Version, nil
 }
 
 func (db *DB) Get(key []byte) ([]byte, error) {
@@ -169,13 +217,16 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	db.getIndexLockByType(data.String).RLock()
 	defer db.getIndexLockByType(data.String).RUnlock()
 
-	if db.ttl.isExpired(string(key)) {
+	if db.ttl.isExpired(string(encodeExpireKey(data.String, key))) {
 		// if the key is expired, just return and don't delete the key now
 		return nil, public.ErrKeyNotFound
 	}
 
 	pos := db.index.getStrIndex().Get(key)
 	if pos == nil {
+		if err := db.index.strIndexErr(); err != nil {
+			return nil, err
+		}
 		return nil, public.ErrKeyNotFound
 	}
 
@@ -188,32 +239,45 @@ func (db *DB) Del(key []byte) error {
 	}
 	// Check if exist in memory memTable
 
-	db.getIndexLockByType(data.String).Lock()
-	defer db.getIndexLockByType(data.String).Unlock()
+	db.lockStrWrite()
+	defer db.unlockStrWrite()
 
+	return db.delString(key)
+}
+
+// delString deletes the string, the string lock must be held
+func (db *DB) delString(key []byte) error {
 	if pos := db.index.getStrIndex().Get(key); pos == nil {
-		return nil
+		return db.index.strIndexErr()
 	}
 
-	db.ttl.del(string(key))
+	db.ttl.del(string(encodeExpireKey(data.String, key)))
 
 	// Build deleted tags LogRecord
 	logRecord := &data.LogRecord{
-		Key:  encodeKeyWithTxId(key, public.NO_TX_ID),
-		Type: data.LogRecordDeleted,
+		Key:     encodeKeyWithTxId(key, public.NO_TX_ID),
+		Type:    data.LogRecordDeleted,
+		Version: db.GetTxId(),
 	}
 
-	_, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecordWithLock(logRecord)
 	if err != nil {
 		return err
 	}
 
 	db.Notify(string(key), nil, DelEvent)
 
+	db.publishStrWrite(logRecord.Version, key)
 	// Delete key in memory memTable
 	if ok := db.index.getStrIndex().Del(key); !ok {
-		return public.ErrUpdateIndexFailed
+		return db.index.strIndexUpdateErr()
 	}
+	db.addStrVersion(key, logRecord.Version, pos, true)
+	db.publishChange(&Change{
+		TxId:      public.NO_TX_ID,
+		CommitTs:  logRecord.Version,
+		Mutations: []Mutation{{DataType: data.String, Deleted: true, Key: key}},
+	})
 	return nil
 }
 
@@ -237,6 +301,16 @@ func (db *DB) Size() int {
 	return db.index.getStrIndex().Count()
 }
 
+// IndexMemoryUsage reports the memory taken by the in-memory indexes,
+// the bytes are estimated unless the index type is meta.CompactBtree
+func (db *DB) IndexMemoryUsage() IndexMemoryUsage {
+	for _, typ := range []data.DataType{data.String, data.Hash, data.List, data.Set, data.ZSet, data.Bitmap, data.HyperLogLog, data.Stream} {
+		db.getIndexLockByType(typ).RLock()
+		defer db.getIndexLockByType(typ).RUnlock()
+	}
+	return db.index.memoryUsage()
+}
+
 // ListKeys get all the key and return
 func (db *DB) ListKeys() [][]byte {
 	db.getIndexLockByType(data.String).RLock()
@@ -251,6 +325,61 @@ func (db *DB) ListKeys() [][]byte {
 	return keys
 }
 
+// RandomKeys returns limit random keys, a key may be returned more than once
+func (db *DB) RandomKeys(limit int) [][]byte {
+	db.getIndexLockByType(data.String).RLock()
+	defer db.getIndexLockByType(data.String).RUnlock()
+	count := db.index.getStrIndex().Count()
+	if limit <= 0 || count == 0 {
+		return [][]byte{}
+	}
+
+	// pick the indexes first so that the keys are collected in one pass
+	picks := make([]int, limit)
+	for i := range picks {
+		picks[i] = rand.Intn(count)
+	}
+	sort.Ints(picks)
+	keys := make([][]byte, 0, limit)
+	iterator := db.index.getStrIndex().Iterator(false)
+	defer iterator.Close()
+	var idx int
+	for iterator.Rewind(); iterator.Valid() && len(keys) < limit; iterator.Next() {
+		for len(keys) < limit && picks[len(keys)] == idx {
+			keys = append(keys, iterator.Key())
+		}
+		idx++
+	}
+	rand.Shuffle(len(keys), func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+	return keys
+}
+
+// RandomDistinctKeys returns up to limit random keys which are different from each other
+func (db *DB) RandomDistinctKeys(limit int) [][]byte {
+	db.getIndexLockByType(data.String).RLock()
+	defer db.getIndexLockByType(data.String).RUnlock()
+	if limit <= 0 || db.index.getStrIndex().Count() == 0 {
+		return [][]byte{}
+	}
+
+	// reservoir sampling, every key is kept with the same probability
+	keys := make([][]byte, 0, limit)
+	iterator := db.index.getStrIndex().Iterator(false)
+	defer iterator.Close()
+	var idx int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if len(keys) < limit {
+			keys = append(keys, iterator.Key())
+		} else if j := rand.Intn(idx + 1); j < limit {
+			keys[j] = iterator.Key()
+		}
+		idx++
+	}
+	return keys
+}
+
 // Fold gets all the keys and executes the function passed in by the user.
 // Terminates the traversal when the function returns false
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
@@ -279,6 +408,9 @@ func (db *DB) Clear() error {
 	if err != nil {
 		return err
 	}
+	if err := os.MkdirAll(db.options.DirPath, os.ModePerm); err != nil {
+		return err
+	}
 
 	fl := flock.New(filepath.Join(db.options.DirPath, public.FileLockName))
 	if getLock, err := fl.TryLock(); err != nil {
@@ -286,8 +418,15 @@ func (db *DB) Clear() error {
 	} else if !getLock {
 		return public.ErrDirOccupied
 	}
+	db.flock = fl
 
-	db.index.strIndex = meta.NewMemTable(db.options.IndexType)
+	// a persistent string index is reopened in the new directory
+	strIndex, err := openStrIndex(db.options)
+	if err != nil {
+		return err
+	}
+	db.index.strIndex = strIndex
+	db.index.strHistory = make(map[string][]*strVersion)
 	db.index.hashIndex = make(map[string]meta.MemTable)
 	return nil
 }
@@ -302,6 +441,10 @@ func (db *DB) Close() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if err := db.closeStrIndex(); err != nil {
+		return err
+	}
+
 	if db.activityFile == nil {
 		return nil
 	}
@@ -319,9 +462,46 @@ func (db *DB) Close() error {
 	db.ttl.stop()
 
 	db.wm.stop()
+
+	if db.feed != nil {
+		return db.feed.close()
+	}
 	return nil
 }
 
+// closeStrIndex closes a persistent string index, with the end of the log as its checkpoint
+func (db *DB) closeStrIndex() error {
+	idx, ok := db.index.getStrIndex().(meta.PersistentMemTable)
+	if !ok {
+		return nil
+	}
+	checkpoint := &meta.Checkpoint{
+		Standalone: db.strIndexOnly(),
+		TxId:       atomic.LoadInt64(&db.oracle.txId),
+	}
+	if db.activityFile != nil {
+		checkpoint.Pos.Fid = db.activityFile.FileId
+		checkpoint.Pos.Offset = db.activityFile.WriteOff
+	}
+	return idx.Close(checkpoint)
+}
+
+// strIndexOnly returns whether the string index holds the whole state rebuilt from the log,
+// a restart doesn't have to read the records before its checkpoint then
+func (db *DB) strIndexOnly() bool {
+	i := db.index
+	if len(i.hashIndex) != 0 || len(i.setIndex) != 0 || len(i.zsetIndex) != 0 || len(i.bitmapIndex) != 0 ||
+		len(i.streamIndex) != 0 || len(i.listIndex.dataIndex) != 0 || i.listIndex.metaIndex.Count() != 0 ||
+		i.hllIndex.Count() != 0 || i.expireIndex.Count() != 0 {
+		return false
+	}
+	// the expirations of the strings and their old versions are only kept in the log
+	if !db.ttl.empty() || db.historyRetained() {
+		return false
+	}
+	return db.feed == nil || db.feed.failed() == nil
+}
+
 func (db *DB) Sync() error {
 	if db.activityFile == nil {
 		return nil
@@ -436,6 +616,15 @@ func checkOptions(opt *Options) error {
 	if opt.DataFileSize < 64 {
 		opt.DataFileSize = 64
 	}
+	if opt.RetainVersions < 0 || opt.RetainDuration < 0 {
+		return errors.New("RetainVersions and RetainDuration can not be negative")
+	}
+	if opt.MaxTxnRecords < 0 || opt.MaxTxnBytes < 0 || opt.MaxTxnLifetime < 0 {
+		return errors.New("MaxTxnRecords, MaxTxnBytes and MaxTxnLifetime can not be negative")
+	}
+	if opt.MaxChangeBytes < 0 {
+		return errors.New("MaxChangeBytes can not be negative")
+	}
 	return nil
 }
 
@@ -472,19 +661,34 @@ func (db *DB) loadDataFile() error {
 		}
 	}
 
+	// a persistent string index closed cleanly is complete up to its checkpoint,
+	// otherwise it is rebuilt from the hint file and the log
+	var checkpoint *meta.Checkpoint
+	if idx, ok := db.index.getStrIndex().(meta.PersistentMemTable); ok {
+		if cp, ok := idx.Checkpoint(); ok {
+			checkpoint = cp
+		} else if err := idx.Reset(); err != nil {
+			return err
+		}
+	}
+
 	// load index from hint file first
-	if err := db.loadIndexFromHintFile(); err != nil {
-		return err
+	if checkpoint == nil {
+		if err := db.loadIndexFromHintFile(); err != nil {
+			return err
+		}
 	}
 
 	// loadIndex
-	if err := db.loadIndex(fileIds); err != nil {
+	if err := db.loadIndex(fileIds, checkpoint); err != nil {
 		return err
 	}
-	return nil
+	return db.index.strIndexErr()
 }
 
-func (db *DB) loadIndex(fids []int) error {
+// loadIndex replays the log, string records before the checkpoint are already in the string index.
+// The replay starts at the checkpoint if nothing but the string index needs the records before it
+func (db *DB) loadIndex(fids []int, checkpoint *meta.Checkpoint) error {
 	if len(fids) == 0 {
 		return nil
 	}
@@ -506,17 +710,57 @@ func (db *DB) loadIndex(fids []int) error {
 		deleteLessThan(fids, nonMergeFileId)
 	}
 
+	// the ttl job key to the expiration
 	expirations := make(map[string]int64)
+	// the greatest version of the strings
+	var maxVersion int64
+
+	indexed := func(pos *data.LogPos) bool {
+		return checkpoint != nil && (pos.Fid < checkpoint.Pos.Fid ||
+			pos.Fid == checkpoint.Pos.Fid && pos.Offset < checkpoint.Pos.Offset)
+	}
+
+	// the file and the offset the replay starts at
+	start, startOffset := 0, int64(0)
+	if checkpoint != nil && checkpoint.Standalone && !db.historyRetained() {
+		for i, fid := range fids {
+			if uint32(fid) == checkpoint.Pos.Fid {
+				start, startOffset = i, checkpoint.Pos.Offset
+			}
+		}
+	}
 
 	updateIndex := func(key []byte, log *data.LogRecord, pos *data.LogPos) {
 		switch log.DataType {
 		case data.String:
+			if log.Version > maxVersion {
+				maxVersion = log.Version
+			}
+			if log.Type == data.LogRecordRangeDeleted {
+				for from := key; from != nil; {
+					var keys [][]byte
+					keys, from = db.strKeysInRange(from, log.Value, deleteRangeChunk)
+					for _, k := range keys {
+						db.addStrVersion(k, log.Version, pos, true)
+						delete(expirations, string(encodeExpireKey(data.String, k)))
+						if !indexed(pos) {
+							db.index.getStrIndex().Del(k)
+						}
+					}
+				}
+				return
+			}
+			db.addStrVersion(key, log.Version, pos, log.Type == data.LogRecordDeleted)
 			if log.Type == data.LogRecordDeleted {
-				delete(expirations, string(key))
-				db.index.getStrIndex().Del(key)
+				delete(expirations, string(encodeExpireKey(data.String, key)))
+				if !indexed(pos) {
+					db.index.getStrIndex().Del(key)
+				}
 			} else {
-				expirations[string(key)] = log.Expiration
-				db.index.getStrIndex().Put(key, pos)
+				expirations[string(encodeExpireKey(data.String, key))] = log.Expiration
+				if !indexed(pos) {
+					db.index.getStrIndex().Put(key, pos)
+				}
 			}
 		case data.Hash:
 			realKey, field := decodeFieldKey(log.Key)
@@ -530,12 +774,14 @@ func (db *DB) loadIndex(fids []int) error {
 			}
 			if log.Type == data.LogRecordDeleted {
 				idx.Del(field)
+				if idx.Count() == 0 {
+					db.index.delHashIndex(string(realKey))
+				}
 			} else {
 				idx.Put(field, pos)
 			}
 		case data.List:
-			realKey, seq, _, _ := decodeListKey(log.Key)
-			seqBuf, _ := seq.GobEncode()
+			realKey, seqBuf := listIndexKey(log.Key)
 			var (
 				idx meta.MemTable
 				ok  bool
@@ -546,6 +792,9 @@ func (db *DB) loadIndex(fids []int) error {
 			}
 			if log.Type == data.LogRecordDeleted {
 				idx.Del(seqBuf)
+				if idx.Count() == 0 {
+					db.index.delListDataIndex(string(realKey))
+				}
 			} else {
 				idx.Put(seqBuf, pos)
 			}
@@ -565,11 +814,64 @@ func (db *DB) loadIndex(fids []int) error {
 				db.index.setSetIndex(string(realKey), meta.NewMemTable(db.options.IndexType))
 				idx, _ = db.index.getSetIndex(string(realKey))
 			}
-			hashKey := hashMemberKey(realKey, member)
 			if log.Type == data.LogRecordDeleted {
-				idx.Del(hashKey)
+				idx.Del(member)
+				if idx.Count() == 0 {
+					db.index.delSetIndex(string(realKey))
+				}
+			} else {
+				idx.Put(member, pos)
+			}
+		case data.ZSet:
+			realKey, member := decodeMemberKey(log.Key)
+			zs, ok := db.index.getZSetIndex(string(realKey))
+			if !ok {
+				zs = newZSet(db.options.IndexType)
+				db.index.setZSetIndex(string(realKey), zs)
+			}
+			if log.Type == data.LogRecordDeleted {
+				zs.members.Del(member)
+				zs.scores.Remove(string(member))
+			} else {
+				zs.members.Put(member, pos)
+				zs.scores.Add(string(member), decodeScore(log.Value))
+			}
+		case data.Bitmap:
+			realKey, chunk := decodeMemberKey(log.Key)
+			idx, ok := db.index.getBitmapIndex(string(realKey))
+			if !ok {
+				idx = meta.NewMemTable(db.options.IndexType)
+				db.index.setBitmapIndex(string(realKey), idx)
+			}
+			if log.Type == data.LogRecordDeleted {
+				idx.Del(chunk)
 			} else {
-				idx.Put(hashKey, pos)
+				idx.Put(chunk, pos)
+			}
+		case data.HyperLogLog:
+			if log.Type == data.LogRecordDeleted {
+				db.index.getHLLIndex().Del(key)
+			} else {
+				db.index.getHLLIndex().Put(key, pos)
+			}
+		case data.Stream:
+			realKey, sub := decodeMemberKey(log.Key)
+			s, ok := db.index.getStreamIndex(string(realKey))
+			if !ok {
+				s = newStream()
+				db.index.setStreamIndex(string(realKey), s)
+			}
+			s.apply(sub, log.Type, log.Value, pos)
+			if s.empty() {
+				db.index.delStreamIndex(string(realKey))
+			}
+		case data.Expire:
+			if log.Type == data.LogRecordDeleted {
+				delete(expirations, string(key))
+				db.index.getExpireIndex().Del(key)
+			} else {
+				expirations[string(key)] = log.Expiration
+				db.index.getExpireIndex().Put(key, pos)
 			}
 		}
 	}
@@ -577,10 +879,12 @@ func (db *DB) loadIndex(fids []int) error {
 	// a map to store the Record data in tx temporarily
 	// txId -> recordList
 	txRecords := make(map[int64][]*data.TxRecord)
+	// txId -> savepoint name -> the number of the records before the savepoint
+	savepoints := make(map[int64]map[string]int)
 
 	// Iterate through all the file ids and process the records in the file
-	for i, fid := range fids {
-		var fileId = uint32(fid)
+	for i := start; i < len(fids); i++ {
+		var fileId = uint32(fids[i])
 		var dataFile *data.DataFile
 		if fileId == db.activityFile.FileId {
 			dataFile = db.activityFile
@@ -588,6 +892,9 @@ func (db *DB) loadIndex(fids []int) error {
 			dataFile = db.oldFile[fileId]
 		}
 		var offset int64
+		if i == start {
+			offset = startOffset
+		}
 		for {
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
@@ -603,19 +910,45 @@ func (db *DB) loadIndex(fids []int) error {
 			realKey, txId := parseLogRecordKey(logRecord.Key)
 			if txId == public.NO_TX_ID {
 				// if not in tx, update memIndex directly
+				logRecord.Key = realKey
 				updateIndex(realKey, logRecord, logRecordPos)
+				// the version of a string written outside the txns is its commitTs
+				if logRecord.DataType == data.String {
+					db.recoverChanges(public.NO_TX_ID, logRecord.Version, []*data.TxRecord{{Record: logRecord, Pos: logRecordPos}})
+				}
 			} else {
 
 				if logRecord.Type == data.LogRecordTxnBegin {
 					// if "begin" do nothing
 				} else if logRecord.Type == data.LogRecordTxnCommit {
 					// if the tx has finished, update to memIndex
+					commitTs := decodeCommitTs(logRecord.Value)
+					if commitTs > maxVersion {
+						maxVersion = commitTs
+					}
 					for _, txRecord := range txRecords[txId] {
+						// the strings of the txn are versioned by its commitTs in the history
+						if txRecord.Record.DataType == data.String && commitTs != 0 {
+							txRecord.Record.Version = commitTs
+						}
 						updateIndex(txRecord.Record.Key, txRecord.Record, txRecord.Pos)
 					}
+					db.recoverChanges(txId, commitTs, txRecords[txId])
 					delete(txRecords, txId)
+					delete(savepoints, txId)
 				} else if logRecord.Type == data.LogRecordTxnRollback {
 					delete(txRecords, txId)
+					delete(savepoints, txId)
+				} else if logRecord.Type == data.LogRecordTxnSavepoint {
+					if _, ok := savepoints[txId]; !ok {
+						savepoints[txId] = make(map[string]int)
+					}
+					savepoints[txId][string(logRecord.Value)] = len(txRecords[txId])
+				} else if logRecord.Type == data.LogRecordTxnRollbackTo {
+					// discard the records after the savepoint
+					if n, ok := savepoints[txId][string(logRecord.Value)]; ok {
+						txRecords[txId] = txRecords[txId][:n]
+					}
 				} else {
 					//
 					logRecord.Key = realKey
@@ -636,14 +969,26 @@ func (db *DB) loadIndex(fids []int) error {
 		}
 	}
 
+	// the versions and the commitTs are taken from the txn ids, which must stay greater than the ones
+	// in the log and in the change file
+	if db.feed != nil && db.feed.lastCommitTs > maxVersion {
+		maxVersion = db.feed.lastCommitTs
+	}
+	if checkpoint != nil && checkpoint.TxId > maxVersion {
+		maxVersion = checkpoint.TxId
+	}
+	if maxVersion >= db.oracle.txId {
+		db.oracle.txId = maxVersion
+	}
+
 	// update ttl according to the current memtable
-	for key, expiration := range expirations {
+	for jobKey, expiration := range expirations {
 		if expiration != 0 {
 			exp := time.Unix(0, expiration)
 			if exp.After(time.Now()) {
-				db.ttl.add(ds.NewJob(key, exp))
+				db.ttl.add(ds.NewJob(jobKey, exp))
 			} else {
-				err := db.Del([]byte(key))
+				err := db.expireJob(jobKey)
 				if err != nil {
 					return err
 				}
@@ -674,6 +1019,18 @@ func (db *DB) loadIndex(fids []int) error {
 // }
 
 func (db *DB) getLogRecordByPos(pos *data.LogPos) (*data.LogRecord, error) {
+	logRecord, err := db.readLogRecord(pos)
+	if err != nil {
+		return nil, err
+	}
+	if logRecord.Type == data.LogRecordDeleted {
+		return nil, public.ErrKeyNotFound
+	}
+	return logRecord, nil
+}
+
+// readLogRecord reads the record at the position, the deletes included
+func (db *DB) readLogRecord(pos *data.LogPos) (*data.LogRecord, error) {
 	var dataFile *data.DataFile
 	if db.activityFile.FileId == pos.Fid {
 		dataFile = db.activityFile
@@ -685,13 +1042,7 @@ func (db *DB) getLogRecordByPos(pos *data.LogPos) (*data.LogRecord, error) {
 	}
 
 	logRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
-	if err != nil {
-		return nil, err
-	}
-	if logRecord.Type == data.LogRecordDeleted {
-		return nil, public.ErrKeyNotFound
-	}
-	return logRecord, nil
+	return logRecord, err
 }
 
 func (db *DB) getValueByPos(pos *data.LogPos) ([]byte, error) {
@@ -713,10 +1064,6 @@ func (db *DB) GetTxId() int64 {
 	return db.oracle.GetTxId()
 }
 
-func (db *DB) Persist(key []byte) {
-	db.ttl.del(string(key))
-}
-
 func (db *DB) Watch(ctx context.Context, key string) <-chan *watchEvent {
 	return db.wm.watch(ctx, key)
 }
@@ -737,8 +1084,20 @@ func (db *DB) getIndexLockByType(typ data.DataType) *sync.RWMutex {
 		return db.indexLocks[data.String]
 	case data.Hash:
 		return db.indexLocks[data.Hash]
+	case data.List, data.ListMeta:
+		return db.indexLocks[data.List]
 	case data.Set:
 		return db.indexLocks[data.Set]
+	case data.ZSet:
+		return db.indexLocks[data.ZSet]
+	case data.Bitmap:
+		return db.indexLocks[data.Bitmap]
+	case data.HyperLogLog:
+		return db.indexLocks[data.HyperLogLog]
+	case data.Stream:
+		return db.indexLocks[data.Stream]
+	case data.Expire:
+		return db.indexLocks[data.Expire]
 	}
 	return nil
 }
@@ -750,6 +1109,10 @@ func checkKey(key []byte) error {
 	if len(key) == 1 && (key[0] < 32 || key[0] == 127) {
 		return public.ErrKeyIsControlChar
 	}
+	// any string index may be rebuilt as a B+tree, so the keys fit in its pages whatever the index type is
+	if len(key) > meta.MaxKeySize {
+		return public.ErrKeyTooLarge
+	}
 	return nil
 }
 
@@ -1,10 +1,14 @@
 package CouloyDB
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/meta"
 	"github.com/Kirov7/CouloyDB/public"
 	"github.com/Kirov7/CouloyDB/public/utils/bytex"
 	"github.com/Kirov7/CouloyDB/public/utils/wait"
@@ -299,3 +303,323 @@ func TestDB_Fold(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, count, 2)
 }
+
+func TestDB_RandomKeys(t *testing.T) {
+	couloyDB, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, couloyDB)
+	defer destroyCouloyDB(couloyDB)
+
+	assert.Len(t, couloyDB.RandomKeys(3), 0)
+	assert.Len(t, couloyDB.RandomDistinctKeys(3), 0)
+
+	var n = 10
+	for i := 0; i < n; i++ {
+		err = couloyDB.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+		assert.Nil(t, err)
+	}
+
+	// the keys may repeat, so more keys than the db holds can be sampled
+	keys := couloyDB.RandomKeys(3 * n)
+	assert.Len(t, keys, 3*n)
+	for _, key := range keys {
+		assert.True(t, bytes.HasPrefix(key, []byte("key")))
+	}
+
+	distinct := make(map[string]bool)
+	for _, key := range couloyDB.RandomDistinctKeys(4) {
+		distinct[string(key)] = true
+	}
+	assert.Len(t, distinct, 4)
+	assert.Len(t, couloyDB.RandomDistinctKeys(3*n), n)
+}
+
+func TestDB_BPlusTree_Reboot(t *testing.T) {
+	options := DefaultOptions()
+	options.SyncWrites = false
+	options.SetIndexType(meta.BPlusTree)
+	couloyDB, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, couloyDB)
+
+	// enough keys to split the leaves and the root several times
+	n := 20000
+	for i := 0; i < n; i++ {
+		err := couloyDB.Put(bytex.GetTestKey(i), bytex.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < n; i += 2 {
+		err := couloyDB.Del(bytex.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, n/2, couloyDB.Size())
+
+	// the string index is loaded from its page file, only the records after the checkpoint are replayed
+	err = couloyDB.Close()
+	assert.Nil(t, err)
+	couloyDB, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, couloyDB)
+	defer destroyCouloyDB(couloyDB)
+
+	err = couloyDB.Put(bytex.GetTestKey(n), bytex.GetTestKey(n))
+	assert.Nil(t, err)
+	assert.Equal(t, n/2+1, couloyDB.Size())
+
+	for i := 0; i < n; i++ {
+		value, err := couloyDB.Get(bytex.GetTestKey(i))
+		if i%2 == 0 {
+			assert.Equal(t, public.ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, bytex.GetTestKey(i), value)
+		}
+	}
+
+	keys := couloyDB.ListKeys()
+	assert.Equal(t, n/2+1, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.Equal(t, -1, bytes.Compare(keys[i-1], keys[i]))
+	}
+
+	iterator := couloyDB.NewIterator(IteratorOptions{Reverse: true})
+	iterator.Seek(bytex.GetTestKey(n - 2))
+	assert.True(t, iterator.Valid())
+	assert.Equal(t, bytex.GetTestKey(n-3), iterator.Key())
+	iterator.Close()
+}
+
+func TestDB_BPlusTree_Checkpoint(t *testing.T) {
+	options := DefaultOptions()
+	options.SetIndexType(meta.BPlusTree)
+	couloyDB, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, couloyDB)
+
+	checkpoint := func() *meta.Checkpoint {
+		cp, ok := couloyDB.index.getStrIndex().(meta.PersistentMemTable).Checkpoint()
+		assert.True(t, ok)
+		return cp
+	}
+	reopen := func() {
+		assert.Nil(t, couloyDB.Close())
+		couloyDB, err = NewCouloyDB(options)
+		assert.Nil(t, err)
+		assert.NotNil(t, couloyDB)
+	}
+
+	n := 100
+	for i := 0; i < n; i++ {
+		assert.Nil(t, couloyDB.Put(bytex.GetTestKey(i), bytex.GetTestKey(i)))
+	}
+	lastTxId := couloyDB.GetTxId()
+
+	// the other data types and the expirations of the strings are only rebuilt from the whole log
+	err = couloyDB.SerialTransaction(false, func(txn *Txn) error {
+		return txn.HSet([]byte("hash"), []byte("field"), []byte("value"))
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, couloyDB.PutWithExpiration([]byte("ttl"), []byte("value"), time.Hour))
+	reopen()
+	assert.False(t, checkpoint().Standalone)
+	var value []byte
+	err = couloyDB.SerialTransaction(true, func(txn *Txn) error {
+		value, err = txn.HGet([]byte("hash"), []byte("field"))
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	ttl, err := couloyDB.TTL([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.Greater(t, ttl, time.Minute)
+
+	// with nothing but the string index, the log before the checkpoint is not read at all
+	err = couloyDB.SerialTransaction(false, func(txn *Txn) error {
+		return txn.HDel([]byte("hash"), []byte("field"))
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, couloyDB.Del([]byte("ttl")))
+	reopen()
+	cp := checkpoint()
+	assert.True(t, cp.Standalone)
+	assert.Greater(t, cp.TxId, lastTxId)
+	assert.GreaterOrEqual(t, couloyDB.GetTxId(), cp.TxId)
+
+	// damage the first record, only the replay of the whole log would read it
+	dataFile, err := data.OpenDataFile(options.DirPath, 0)
+	assert.Nil(t, err)
+	_, size, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Close())
+	file, err := os.OpenFile(data.GetDataFileName(options.DirPath, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte{0xff}, size-1)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	reopen()
+	defer destroyCouloyDB(couloyDB)
+	assert.True(t, checkpoint().Standalone)
+	assert.Equal(t, n, couloyDB.Size())
+	for i := 1; i < n; i++ {
+		value, err := couloyDB.Get(bytex.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, bytex.GetTestKey(i), value)
+	}
+	assert.Nil(t, couloyDB.Put([]byte("after"), []byte("value")))
+	value, err = couloyDB.Get([]byte("after"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestDB_BPlusTree_Clear(t *testing.T) {
+	options := DefaultOptions()
+	options.SetIndexType(meta.BPlusTree)
+	couloyDB, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, couloyDB)
+	assert.Nil(t, couloyDB.Put([]byte("key"), []byte("value")))
+
+	// the string index is still a B+tree after the clear
+	assert.Nil(t, couloyDB.Clear())
+	_, ok := couloyDB.index.getStrIndex().(*meta.BPTree)
+	assert.True(t, ok)
+	assert.Equal(t, 0, couloyDB.Size())
+
+	assert.Nil(t, couloyDB.closeStrIndex())
+	assert.Nil(t, couloyDB.flock.Unlock())
+	assert.Nil(t, os.RemoveAll(options.DirPath))
+}
+
+func TestDB_BPlusTree_KeyTooLarge(t *testing.T) {
+	options := DefaultOptions()
+	options.SetIndexType(meta.BPlusTree)
+	couloyDB, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, couloyDB)
+	defer destroyCouloyDB(couloyDB)
+
+	// a key the B+tree can not hold is rejected before it is written
+	key := bytes.Repeat([]byte{'k'}, meta.MaxKeySize+1)
+	assert.Equal(t, public.ErrKeyTooLarge, couloyDB.Put(key, []byte("value")))
+	err = couloyDB.SerialTransaction(false, func(txn *Txn) error {
+		return txn.Set(key, []byte("value"))
+	})
+	assert.Equal(t, public.ErrKeyTooLarge, err)
+
+	key = bytes.Repeat([]byte{'k'}, meta.MaxKeySize)
+	err = couloyDB.SerialTransaction(false, func(txn *Txn) error {
+		return txn.Set(key, []byte("value"))
+	})
+	assert.Nil(t, err)
+	value, err := couloyDB.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestDB_CompactIndex(t *testing.T) {
+	options := DefaultOptions()
+	options.SyncWrites = false
+	options.SetIndexType(meta.CompactBtree)
+	couloyDB, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, couloyDB)
+	defer destroyCouloyDB(couloyDB)
+
+	// long keys, so the deletes leave enough garbage in the arenas to trigger a compaction
+	key := func(i int) []byte {
+		return append(bytex.GetTestKey(i), bytes.Repeat([]byte{'k'}, 128)...)
+	}
+	n := 20000
+	for i := 0; i < n; i++ {
+		err := couloyDB.Put(key(i), bytex.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < n; i++ {
+		if i%4 != 0 {
+			err := couloyDB.Del(key(i))
+			assert.Nil(t, err)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		value, err := couloyDB.Get(key(i))
+		if i%4 != 0 {
+			assert.Equal(t, public.ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, bytex.GetTestKey(i), value)
+		}
+	}
+
+	keys := couloyDB.ListKeys()
+	assert.Equal(t, n/4, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.Equal(t, -1, bytes.Compare(keys[i-1], keys[i]))
+	}
+
+	err = couloyDB.SerialTransaction(false, func(txn *Txn) error {
+		return txn.HSet(bytex.GetTestKey(0), bytex.GetTestKey(1), bytex.GetTestKey(2))
+	})
+	assert.Nil(t, err)
+
+	usage := couloyDB.IndexMemoryUsage()
+	assert.Equal(t, n/4, usage.String.Keys)
+	assert.Equal(t, int64(n/4*len(key(0))), usage.String.KeyBytes)
+	assert.Equal(t, 1, usage.Hash.Keys)
+	assert.Equal(t, n/4+1, usage.Total().Keys)
+	// the deleted keys were compacted away
+	assert.Less(t, usage.String.Bytes, int64(n*len(key(0))))
+}
+
+func TestDB_Merge_Reboot(t *testing.T) {
+	options := DefaultOptions()
+	options.DataFileSize = 32 * 1024
+	options.SyncWrites = false
+	couloyDB, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, couloyDB)
+
+	// overwrite the keys several times so that the old values spread over many files
+	n := 500
+	for round := 0; round < 3; round++ {
+		for i := 0; i < n; i++ {
+			value := append(bytex.GetTestKey(i), byte(round))
+			err := couloyDB.Put(bytex.GetTestKey(i), value)
+			assert.Nil(t, err)
+		}
+		err = couloyDB.SerialTransaction(false, func(txn *Txn) error {
+			return txn.HSet([]byte("hash"), []byte("field"), []byte{byte(round)})
+		})
+		assert.Nil(t, err)
+	}
+	for i := 0; i < n; i += 2 {
+		assert.Nil(t, couloyDB.Del(bytex.GetTestKey(i)))
+	}
+
+	assert.Nil(t, couloyDB.Merge())
+	assert.Nil(t, couloyDB.Close())
+	couloyDB, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(couloyDB)
+
+	// the replaced files are removed and the merged records are not loaded as strings
+	assert.Equal(t, n/2, couloyDB.Size())
+	for i := 0; i < n; i++ {
+		value, err := couloyDB.Get(bytex.GetTestKey(i))
+		if i%2 == 0 {
+			assert.Equal(t, public.ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, append(bytex.GetTestKey(i), 2), value)
+		}
+	}
+	err = couloyDB.SerialTransaction(true, func(txn *Txn) error {
+		value, err := txn.HGet([]byte("hash"), []byte("field"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{2}, value)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Len(t, couloyDB.index.hashIndex, 1)
+}
@@ -0,0 +1,115 @@
+package CouloyDB
+
+import (
+	"bytes"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/public"
+)
+
+// A range of strings is deleted by a single range tombstone, whose key is the start of the range and whose
+// value is the end. The strings in the range are removed from the index when the tombstone is written or
+// replayed, so merge drops their records. The tombstone itself is only kept by merge as a retained delete.
+//
+// The strings are removed from the index in chunks of deleteRangeChunk keys, the string lock is released
+// between the chunks so the reads go on. The writes and the new txns wait until the whole range is removed,
+// so only the reads outside the txns and the txns which are not serializable may see a part of the range
+
+// deleteRangeChunk is the number of strings removed from the index at a time by a range tombstone
+const deleteRangeChunk = 1024
+
+// DeleteRange deletes the strings whose keys are in [start, end), a nil end deletes up to the last key
+func (db *DB) DeleteRange(start, end []byte) error {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+
+	db.oracle.mu.Lock()
+	defer db.oracle.mu.Unlock()
+	lock := db.getIndexLockByType(data.String)
+	lock.Lock()
+
+	keys, next := db.strKeysInRange(start, end, deleteRangeChunk)
+	if len(keys) == 0 {
+		lock.Unlock()
+		return nil
+	}
+
+	logRecord := &data.LogRecord{
+		Key:      encodeKeyWithTxId(start, public.NO_TX_ID),
+		Value:    end,
+		Type:     data.LogRecordRangeDeleted,
+		DataType: data.String,
+		Version:  db.GetTxId(),
+	}
+	pos, err := db.appendLogRecordWithLock(logRecord)
+	if err != nil {
+		lock.Unlock()
+		return err
+	}
+
+	for {
+		db.publishStrWrite(logRecord.Version, keys...)
+		for _, key := range keys {
+			db.ttl.del(string(encodeExpireKey(data.String, key)))
+			if ok := db.index.getStrIndex().Del(key); !ok {
+				lock.Unlock()
+				return db.index.strIndexUpdateErr()
+			}
+			db.addStrVersion(key, logRecord.Version, pos, true)
+			db.Notify(string(key), nil, DelEvent)
+		}
+		lock.Unlock()
+		if next == nil {
+			break
+		}
+		lock.Lock()
+		keys, next = db.strKeysInRange(next, end, deleteRangeChunk)
+	}
+
+	db.publishChange(&Change{
+		TxId:      public.NO_TX_ID,
+		CommitTs:  logRecord.Version,
+		Mutations: []Mutation{{DataType: data.String, Deleted: true, Range: true, Key: start, Value: end}},
+	})
+	return nil
+}
+
+// DeletePrefix deletes the strings whose keys start with the prefix
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return public.ErrKeyIsEmpty
+	}
+	return db.DeleteRange(prefix, prefixEnd(prefix))
+}
+
+// prefixEnd returns the least key greater than all the keys with the prefix, nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// strKeysInRange returns up to limit keys of the strings in [start, end), and the key the rest of the range
+// starts at or nil if there are no more. The string lock must be held
+func (db *DB) strKeysInRange(start, end []byte, limit int) ([][]byte, []byte) {
+	keys := make([][]byte, 0)
+	var next []byte
+	ascendFrom(db.index.getStrIndex(), start, func(key []byte, _ *data.LogPos) bool {
+		if len(end) > 0 && bytes.Compare(key, end) >= 0 {
+			return false
+		}
+		if len(keys) == limit {
+			next = append([]byte(nil), key...)
+			return false
+		}
+		keys = append(keys, append([]byte(nil), key...))
+		return true
+	})
+	return keys, next
+}
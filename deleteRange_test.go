@@ -0,0 +1,127 @@
+package CouloyDB
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/public/utils/bytex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_DeleteRange(t *testing.T) {
+	options := DefaultOptions()
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, key := range []string{"a", "b1", "b2", "c", "d"} {
+		assert.Nil(t, db.Put([]byte(key), []byte(key)))
+	}
+	assert.Nil(t, db.PutWithExpiration([]byte("b3"), []byte("b3"), time.Hour))
+	assert.Nil(t, db.DeleteRange([]byte("b"), []byte("c")))
+	assert.Nil(t, db.DeleteRange([]byte("d"), nil))
+	// an empty range deletes nothing
+	assert.Nil(t, db.DeleteRange([]byte("c"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("b2"), []byte("new")))
+
+	check := func() {
+		for key, want := range map[string][]byte{"a": []byte("a"), "b1": nil, "b2": []byte("new"), "b3": nil, "c": []byte("c"), "d": nil} {
+			value, err := db.Get([]byte(key))
+			if want == nil {
+				assert.Equal(t, public.ErrKeyNotFound, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, want, value)
+			}
+		}
+	}
+	check()
+
+	// the tombstones are replayed when the db is opened
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	check()
+
+	// merge drops the deleted strings
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+	check()
+	assert.Equal(t, 3, db.Size())
+}
+
+func TestDB_DeletePrefix(t *testing.T) {
+	options := DefaultOptions()
+	options.RetainVersions = 2
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, key := range []string{"tenant:1:a", "tenant:1:b", "tenant:12:a", "tenant:2:a"} {
+		assert.Nil(t, db.Put([]byte(key), []byte(key)))
+	}
+	ts := time.Now().UnixNano()
+	assert.Nil(t, db.DeletePrefix([]byte("tenant:1:")))
+	assert.Equal(t, public.ErrKeyIsEmpty, db.DeletePrefix(nil))
+
+	// merge keeps the tombstone as a retained delete
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+
+	assert.Equal(t, [][]byte{[]byte("tenant:12:a"), []byte("tenant:2:a")}, db.ListKeys())
+	// the deleted strings are kept in the history
+	value, err := db.GetAt([]byte("tenant:1:a"), ts)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("tenant:1:a"), value)
+	history, err := db.History([]byte("tenant:1:b"))
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+	assert.True(t, history[1].Deleted)
+
+	assert.Equal(t, []byte{'a', 'c'}, prefixEnd([]byte("ab")))
+	assert.Equal(t, []byte{'b'}, prefixEnd([]byte{'a', 0xff}))
+	assert.Nil(t, prefixEnd([]byte{0xff}))
+}
+
+func TestDB_DeleteRange_Chunks(t *testing.T) {
+	options := DefaultOptions()
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// more strings than a chunk, around both ends of the range
+	n := 3*deleteRangeChunk + 10
+	for i := 0; i < n; i++ {
+		assert.Nil(t, db.Put(bytex.GetTestKey(i), bytex.GetTestKey(i)))
+	}
+	start, end := bytex.GetTestKey(5), bytex.GetTestKey(n-5)
+	assert.Nil(t, db.DeleteRange(start, end))
+
+	check := func() {
+		assert.Equal(t, 10, db.Size())
+		for i := 0; i < n; i++ {
+			value, err := db.Get(bytex.GetTestKey(i))
+			if i >= 5 && i < n-5 {
+				assert.Equal(t, public.ErrKeyNotFound, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, bytex.GetTestKey(i), value)
+			}
+		}
+	}
+	check()
+
+	// the tombstone is replayed in chunks as well
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+	check()
+}
@@ -0,0 +1,483 @@
+package CouloyDB
+
+import (
+	"time"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/meta"
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/public/ds"
+)
+
+// The expiration of a string is kept in the header of its record. The expiration of a collection is a record
+// of the Expire data type, whose key is the data type followed by the key, so the collections of different
+// types under the same key expire on their own. The ttl jobs are keyed the same way
+
+// collectionTypes are the data types which expire by an Expire record
+var collectionTypes = []data.DataType{
+	data.Hash, data.List, data.Set, data.ZSet, data.Bitmap, data.HyperLogLog, data.Stream,
+}
+
+// NoExpiration is the ttl of a key which does not expire
+const NoExpiration time.Duration = -1
+
+// Expire sets the key to expire after the duration, whatever the data type it is stored as.
+// It returns ErrKeyNotFound if the key does not exist
+func (db *DB) Expire(key []byte, duration time.Duration) error {
+	return db.ExpireAt(key, time.Now().Add(duration))
+}
+
+// ExpireAt sets the key to expire at the time, it returns ErrKeyNotFound if the key does not exist
+func (db *DB) ExpireAt(key []byte, at time.Time) error {
+	return db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.ExpireAt(key, at)
+	})
+}
+
+// TTL returns the remaining time to live of the key, or NoExpiration if the key does not expire
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	var ttl time.Duration
+	err := db.SerialTransaction(true, func(txn *Txn) error {
+		var err error
+		ttl, err = txn.TTL(key)
+		return err
+	})
+	return ttl, err
+}
+
+// Persist removes the expiration of the key, it returns ErrKeyNotFound if the key does not exist
+func (db *DB) Persist(key []byte) error {
+	return db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.Persist(key)
+	})
+}
+
+// Expire sets the key to expire after the duration, whatever the data type it is stored as
+func (txn *Txn) Expire(key []byte, duration time.Duration) error {
+	return txn.ExpireAt(key, time.Now().Add(duration))
+}
+
+// ExpireAt sets the key to expire at the time, whatever the data type it is stored as
+func (txn *Txn) ExpireAt(key []byte, at time.Time) error {
+	if txn.readOnly {
+		return public.ErrUpdateInReadOnlyTxn
+	}
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	types, err := txn.keyTypes(key)
+	if err != nil {
+		return err
+	}
+	if len(types) == 0 {
+		return public.ErrKeyNotFound
+	}
+	for _, typ := range types {
+		if err := txn.setExpiration(typ, key, at.UnixNano()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TTL returns the remaining time to live of the key, or NoExpiration if the key does not expire.
+// If the key is stored as several data types, the earliest expiration is returned
+func (txn *Txn) TTL(key []byte) (time.Duration, error) {
+	types, err := txn.keyTypes(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(types) == 0 {
+		return 0, public.ErrKeyNotFound
+	}
+
+	ttl := NoExpiration
+	now := time.Now().UnixNano()
+	for _, typ := range types {
+		expiration, err := txn.getExpiration(typ, key)
+		if err != nil {
+			return 0, err
+		}
+		if expiration == 0 {
+			continue
+		}
+		// the key may have expired without being removed yet
+		remaining := time.Duration(expiration - now)
+		if remaining < 0 {
+			remaining = 0
+		}
+		if ttl == NoExpiration || remaining < ttl {
+			ttl = remaining
+		}
+	}
+	return ttl, nil
+}
+
+// Persist removes the expiration of the key, whatever the data type it is stored as
+func (txn *Txn) Persist(key []byte) error {
+	if txn.readOnly {
+		return public.ErrUpdateInReadOnlyTxn
+	}
+
+	types, err := txn.keyTypes(key)
+	if err != nil {
+		return err
+	}
+	if len(types) == 0 {
+		return public.ErrKeyNotFound
+	}
+	for _, typ := range types {
+		expiration, err := txn.getExpiration(typ, key)
+		if err != nil {
+			return err
+		}
+		if expiration == 0 {
+			continue
+		}
+		if err := txn.setExpiration(typ, key, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyTypes returns the data types the key is stored as
+func (txn *Txn) keyTypes(key []byte) ([]data.DataType, error) {
+	types := make([]data.DataType, 0)
+	if _, err := txn.Get(key); err == nil {
+		types = append(types, data.String)
+	} else if err != public.ErrKeyNotFound {
+		return nil, err
+	}
+	for _, typ := range collectionTypes {
+		exist, err := txn.collectionExists(typ, key)
+		if err != nil {
+			return nil, err
+		}
+		if exist {
+			types = append(types, typ)
+		}
+	}
+	return types, nil
+}
+
+// getExpiration returns the expiration of the key stored as the data type, zero if it does not expire
+func (txn *Txn) getExpiration(typ data.DataType, key []byte) (int64, error) {
+	if typ == data.String {
+		expiration, _, err := txn.getStrExpiration(key)
+		return expiration, err
+	}
+	expiration, _, err := txn.getExpire(typ, key)
+	return expiration, err
+}
+
+// setExpiration sets the expiration of the key stored as the data type, zero removes the expiration
+func (txn *Txn) setExpiration(typ data.DataType, key []byte, expiration int64) error {
+	if typ == data.String {
+		value, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		return txn.set(key, value, expiration)
+	}
+	if expiration == 0 {
+		return txn.delExpire(typ, key)
+	}
+	return txn.putExpire(typ, key, expiration)
+}
+
+// getStrExpiration returns the expiration of the string as seen by the txn and whether the string exists
+func (txn *Txn) getStrExpiration(key []byte) (int64, bool, error) {
+	if pw, ok := txn.strPendingWrites[string(key)]; ok {
+		if pw.typ == data.LogRecordDeleted {
+			return 0, false, nil
+		}
+		return pw.expiration, true, nil
+	}
+
+	pos := txn.committedPos(data.String, key, "", func() *data.LogPos {
+		return txn.db.index.getStrIndex().Get(key)
+	})
+	if pos == nil {
+		return 0, false, nil
+	}
+	logRecord, err := txn.db.getLogRecordByPos(pos)
+	if err != nil {
+		return 0, false, err
+	}
+	return logRecord.Expiration, true, nil
+}
+
+// expireJob deletes the expired key of the ttl job
+func (db *DB) expireJob(jobKey string) error {
+	typ, key := decodeExpireKey([]byte(jobKey))
+	if typ == data.String {
+		return db.Del(key)
+	}
+	return db.SerialTransaction(false, func(txn *Txn) error {
+		// the expiration may have been changed since the job was popped
+		expiration, ok, err := txn.getExpire(typ, key)
+		if err != nil || !ok || expiration > time.Now().UnixNano() {
+			return err
+		}
+		return txn.dropKey(typ, key)
+	})
+}
+
+// collectionExists returns whether the key is stored as the collection type
+func (txn *Txn) collectionExists(typ data.DataType, key []byte) (bool, error) {
+	switch typ {
+	case data.Hash:
+		return len(txn.hashFields(key)) > 0, nil
+	case data.List:
+		return txn.listLen(key) > 0, nil
+	case data.Set:
+		return len(txn.setMembers(key)) > 0, nil
+	case data.ZSet:
+		_, err := txn.ZCard(key)
+		if err == public.ErrKeyNotFound {
+			return false, nil
+		}
+		return err == nil, err
+	case data.Bitmap:
+		chunkIdxs, err := txn.bitmapChunkIndexes(key)
+		return len(chunkIdxs) > 0, err
+	case data.HyperLogLog:
+		_, err := txn.getHLL(key)
+		if err == public.ErrKeyNotFound {
+			return false, nil
+		}
+		return err == nil, err
+	case data.Stream:
+		_, exist, err := txn.getStreamLastID(key)
+		return exist, err
+	}
+	return false, nil
+}
+
+// dropKey deletes the collection stored under the key and its expiration
+func (txn *Txn) dropKey(typ data.DataType, key []byte) error {
+	switch typ {
+	case data.Hash:
+		for field := range txn.hashFields(key) {
+			if err := txn.HDel(key, []byte(field)); err != nil {
+				return err
+			}
+		}
+	case data.List:
+		elements, err := txn.listElements(key)
+		if err != nil {
+			return err
+		}
+		removed := make(map[int]bool, len(elements))
+		for i := range elements {
+			removed[i] = true
+		}
+		if err := txn.removeListElements(key, elements, removed); err != nil {
+			return err
+		}
+	case data.Set:
+		for member := range txn.setMembers(key) {
+			if err := txn.appendSetMember(key, []byte(member), data.LogRecordDeleted); err != nil {
+				return err
+			}
+		}
+	case data.ZSet:
+		members, err := txn.ZRange(key, 0, -1)
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			if err := txn.ZRem(key, member.Member); err != nil {
+				return err
+			}
+		}
+	case data.Bitmap:
+		chunkIdxs, err := txn.bitmapChunkIndexes(key)
+		if err != nil {
+			return err
+		}
+		for _, chunkIdx := range chunkIdxs {
+			if err := txn.putBitmapChunk(key, chunkIdx, nil); err != nil {
+				return err
+			}
+		}
+	case data.HyperLogLog:
+		if _, err := txn.getHLL(key); err == nil {
+			if err := txn.delHLL(key); err != nil {
+				return err
+			}
+		}
+	case data.Stream:
+		if err := txn.delStream(key); err != nil {
+			return err
+		}
+	}
+	return txn.delExpire(typ, key)
+}
+
+// getExpire returns the expiration of the collection as seen by the txn and whether it has one
+func (txn *Txn) getExpire(typ data.DataType, key []byte) (int64, bool, error) {
+	expireKey := encodeExpireKey(typ, key)
+	var pos *data.LogPos
+	if pw, ok := txn.expirePendingWrites[string(expireKey)]; ok {
+		if pw.typ == data.LogRecordDeleted {
+			return 0, false, nil
+		}
+		pos = pw.LogPos
+	} else {
+		pos = txn.committedPos(data.Expire, expireKey, "", func() *data.LogPos {
+			return txn.db.index.getExpireIndex().Get(expireKey)
+		})
+		if pos == nil {
+			return 0, false, nil
+		}
+	}
+
+	logRecord, err := txn.db.getLogRecordByPos(pos)
+	if err != nil {
+		return 0, false, err
+	}
+	return logRecord.Expiration, true, nil
+}
+
+func (txn *Txn) putExpire(typ data.DataType, key []byte, expiration int64) error {
+	return txn.appendExpire(typ, key, expiration, data.LogRecordNormal)
+}
+
+func (txn *Txn) delExpire(typ data.DataType, key []byte) error {
+	if _, ok, err := txn.getExpire(typ, key); err != nil || !ok {
+		return err
+	}
+	return txn.appendExpire(typ, key, 0, data.LogRecordDeleted)
+}
+
+func (txn *Txn) appendExpire(typ data.DataType, key []byte, expiration int64, recordType data.LogRecordType) error {
+	expireKey := encodeExpireKey(typ, key)
+	logRecord := &data.LogRecord{
+		Key:        encodeKeyWithTxId(expireKey, txn.startTs),
+		Type:       recordType,
+		DataType:   data.Expire,
+		Expiration: expiration,
+	}
+	pos, err := txn.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	txn.putPendingWrite(txn.expirePendingWrites, string(expireKey), &pendingWrite{typ: recordType, LogPos: pos})
+	return nil
+}
+
+func (txn *Txn) updateExpireIndex() {
+	defer txn.waitCommit.Done()
+	if len(txn.expirePendingWrites) == 0 {
+		return
+	}
+
+	// the expirations are read from the log before taking the lock
+	expirations := make(map[*pendingWrite]int64)
+	for _, pw := range txn.expirePendingWrites {
+		if pw.typ == data.LogRecordNormal {
+			logRecord, err := txn.db.getLogRecordByPos(pw.LogPos)
+			if err != nil {
+				continue
+			}
+			expirations[pw] = logRecord.Expiration
+		}
+	}
+
+	lock := txn.db.getIndexLockByType(data.Expire)
+	lock.Lock()
+	defer lock.Unlock()
+	for key, pw := range txn.expirePendingWrites {
+		if expiration, ok := expirations[pw]; ok {
+			txn.db.index.getExpireIndex().Put([]byte(key), pw.LogPos)
+			txn.db.ttl.add(ds.NewJob(key, time.Unix(0, expiration)))
+		}
+		if pw.typ == data.LogRecordDeleted {
+			txn.db.index.getExpireIndex().Del([]byte(key))
+			txn.db.ttl.del(key)
+		}
+	}
+}
+
+// dropEmptiedExpires deletes the expirations of the collections the txn leaves empty, so a collection
+// created again under the key does not inherit them. The commits are locked while it runs, the indexes
+// are the ones the txn is applied over
+func (txn *Txn) dropEmptiedExpires() error {
+	emptied := make([][]byte, 0)
+	check := func(typ data.DataType, pendingWrites map[string]map[string]*pendingWrite, getIndex func(key string) (meta.MemTable, bool)) {
+		if len(pendingWrites) == 0 {
+			return
+		}
+		lock := txn.db.getIndexLockByType(typ)
+		lock.RLock()
+		defer lock.RUnlock()
+		for key, pws := range pendingWrites {
+			idx, ok := getIndex(key)
+			count, deleted := 0, false
+			if ok {
+				count = idx.Count()
+			}
+			for sub, pw := range pws {
+				committed := ok && idx.Get([]byte(sub)) != nil
+				if pw.typ == data.LogRecordDeleted {
+					deleted = true
+					if committed {
+						count--
+					}
+				} else if !committed {
+					count++
+				}
+			}
+			if deleted && count == 0 {
+				emptied = append(emptied, encodeExpireKey(typ, []byte(key)))
+			}
+		}
+	}
+	check(data.Hash, txn.hashPendingWrites, txn.db.index.getHashIndex)
+	check(data.Set, txn.setPendingWrites, txn.db.index.getSetIndex)
+	check(data.Bitmap, txn.bitmapPendingWrites, txn.db.index.getBitmapIndex)
+	check(data.ZSet, txn.zsetPendingWrites, func(key string) (meta.MemTable, bool) {
+		if zs, ok := txn.db.index.getZSetIndex(key); ok {
+			return zs.members, true
+		}
+		return nil, false
+	})
+	// the meta of a list is deleted with its last element
+	for key, pw := range txn.listMetaPendingWrites {
+		if pw.typ == data.LogRecordDeleted {
+			emptied = append(emptied, encodeExpireKey(data.List, []byte(key)))
+		}
+	}
+
+	for _, expireKey := range emptied {
+		if pw, ok := txn.expirePendingWrites[string(expireKey)]; ok {
+			if pw.typ == data.LogRecordDeleted {
+				continue
+			}
+		} else {
+			lock := txn.db.getIndexLockByType(data.Expire)
+			lock.RLock()
+			pos := txn.db.index.getExpireIndex().Get(expireKey)
+			lock.RUnlock()
+			if pos == nil {
+				continue
+			}
+		}
+		typ, key := decodeExpireKey(expireKey)
+		if err := txn.appendExpire(typ, key, 0, data.LogRecordDeleted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeExpireKey(typ data.DataType, key []byte) []byte {
+	return append([]byte{byte(typ)}, key...)
+}
+
+func decodeExpireKey(expireKey []byte) (data.DataType, []byte) {
+	return data.DataType(expireKey[0]), expireKey[1:]
+}
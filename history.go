@@ -0,0 +1,228 @@
+package CouloyDB
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/meta"
+	"github.com/Kirov7/CouloyDB/public"
+)
+
+// The versions of the strings in the history are the commitTs of their writes, which are unix nanoseconds,
+// so a txn which wrote a string before ts but committed after it is not seen at ts. Besides the latest version,
+// the index keeps the versions retained by Options.RetainVersions and Options.RetainDuration, merge keeps
+// their records with their commitTs so the history is rebuilt when the db is opened
+
+// KeyVersion is a version of a string
+type KeyVersion struct {
+	// Version is the unix nanoseconds when the version was committed
+	Version int64
+	Value   []byte
+	// Expiration is the unix nanoseconds when the version expires, zero if it does not expire
+	Expiration int64
+	// Deleted is true if the string was deleted by the version
+	Deleted bool
+}
+
+// strVersion is a version of a string kept in the history
+type strVersion struct {
+	version int64
+	pos     *data.LogPos
+	deleted bool
+}
+
+// GetAt returns the value of the key at ts, in unix nanoseconds. It returns ErrKeyNotFound if the key
+// did not exist at ts, or if its version at ts is no longer retained
+func (db *DB) GetAt(key []byte, ts int64) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, public.ErrKeyIsEmpty
+	}
+
+	db.getIndexLockByType(data.String).RLock()
+	defer db.getIndexLockByType(data.String).RUnlock()
+
+	_, logRecord, err := db.strVersionAt(key, ts)
+	if err != nil {
+		return nil, err
+	}
+	return logRecord.Value, nil
+}
+
+// History returns the retained versions of the key from the oldest to the latest
+func (db *DB) History(key []byte) ([]KeyVersion, error) {
+	if len(key) == 0 {
+		return nil, public.ErrKeyIsEmpty
+	}
+
+	db.getIndexLockByType(data.String).RLock()
+	defer db.getIndexLockByType(data.String).RUnlock()
+
+	versions := db.index.strHistory[string(key)]
+	if len(versions) == 0 {
+		// the latest version is not in the history if the history is not retained
+		pos := db.index.getStrIndex().Get(key)
+		if pos == nil {
+			return nil, public.ErrKeyNotFound
+		}
+		logRecord, err := db.getLogRecordByPos(pos)
+		if err != nil {
+			return nil, err
+		}
+		return []KeyVersion{{Version: logRecord.Version, Value: logRecord.Value, Expiration: logRecord.Expiration}}, nil
+	}
+
+	history := make([]KeyVersion, 0, len(versions))
+	for _, v := range versions {
+		if v.deleted {
+			history = append(history, KeyVersion{Version: v.version, Deleted: true})
+			continue
+		}
+		logRecord, err := db.getLogRecordByPos(v.pos)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, KeyVersion{Version: v.version, Value: logRecord.Value, Expiration: logRecord.Expiration})
+	}
+	return history, nil
+}
+
+// NewIteratorAt returns an iterator over the strings as they were at ts, in unix nanoseconds.
+// The strings are collected when the iterator is created, so it is not affected by the later writes
+func (db *DB) NewIteratorAt(ts int64, options IteratorOptions) *Iterator {
+	db.getIndexLockByType(data.String).RLock()
+	defer db.getIndexLockByType(data.String).RUnlock()
+
+	keys := make(map[string]struct{}, len(db.index.strHistory))
+	for key := range db.index.strHistory {
+		keys[key] = struct{}{}
+	}
+	if iterator := db.index.getStrIndex().Iterator(false); iterator != nil {
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			keys[string(iterator.Key())] = struct{}{}
+		}
+		iterator.Close()
+	}
+
+	snapshot := meta.NewMemTable(meta.Btree)
+	for key := range keys {
+		if pos, _, err := db.strVersionAt([]byte(key), ts); err == nil {
+			snapshot.Put([]byte(key), pos)
+		}
+	}
+	return &Iterator{
+		IndexIterator: snapshot.Iterator(options.Reverse),
+		db:            db,
+		options:       options,
+	}
+}
+
+// strVersionAt returns the position and the record of the version of the string at ts, the string lock must be held
+func (db *DB) strVersionAt(key []byte, ts int64) (*data.LogPos, *data.LogRecord, error) {
+	versions := db.index.strHistory[string(key)]
+	i := sort.Search(len(versions), func(i int) bool {
+		return versions[i].version > ts
+	})
+
+	var pos *data.LogPos
+	if i > 0 {
+		if versions[i-1].deleted {
+			return nil, nil, public.ErrKeyNotFound
+		}
+		pos = versions[i-1].pos
+	} else if len(versions) == 0 {
+		// the latest version is the only one if the history is not retained
+		pos = db.index.getStrIndex().Get(key)
+	}
+	if pos == nil {
+		return nil, nil, public.ErrKeyNotFound
+	}
+
+	logRecord, err := db.getLogRecordByPos(pos)
+	if err != nil {
+		return nil, nil, err
+	}
+	if logRecord.Version > ts || logRecord.Expiration != 0 && logRecord.Expiration <= ts {
+		return nil, nil, public.ErrKeyNotFound
+	}
+	return pos, logRecord, nil
+}
+
+// historyRetained returns whether the old versions of the strings are retained
+func (db *DB) historyRetained() bool {
+	return db.options.RetainVersions > 0 || db.options.RetainDuration > 0
+}
+
+// addStrVersion adds the version of the string to its history and drops the versions no longer retained,
+// the string lock must be held
+func (db *DB) addStrVersion(key []byte, version int64, pos *data.LogPos, deleted bool) {
+	if !db.historyRetained() {
+		return
+	}
+
+	versions := db.index.strHistory[string(key)]
+	// the versions are kept in order, a version may be applied after a greater one
+	i := sort.Search(len(versions), func(i int) bool {
+		return versions[i].version > version
+	})
+	versions = append(versions, nil)
+	copy(versions[i+1:], versions[i:])
+	versions[i] = &strVersion{version: version, pos: pos, deleted: deleted}
+	db.index.strHistory[string(key)] = versions
+	db.pruneStrHistory(string(key), time.Now().UnixNano())
+}
+
+// pruneStrHistory drops the versions of the string which are neither among the last RetainVersions versions
+// nor newer than RetainDuration, the string lock must be held
+func (db *DB) pruneStrHistory(key string, now int64) {
+	versions := db.index.strHistory[key]
+	// the versions from the first one kept to the latest are kept
+	first := len(versions) - db.options.RetainVersions
+	if first < 0 {
+		first = 0
+	}
+	if db.options.RetainDuration > 0 {
+		for first > 0 && versions[first-1].version > now-int64(db.options.RetainDuration) {
+			first--
+		}
+	}
+
+	// nothing can be read from a history of deletes
+	deleted := true
+	for _, v := range versions[first:] {
+		deleted = deleted && v.deleted
+	}
+	if deleted {
+		delete(db.index.strHistory, key)
+		return
+	}
+	if first > 0 {
+		db.index.strHistory[key] = append([]*strVersion(nil), versions[first:]...)
+	}
+}
+
+// retainedStrVersions prunes the histories and returns the positions of the retained versions of the strings
+// with their versions, including the deletes, which merge must keep
+func (db *DB) retainedStrVersions() map[data.LogPos]int64 {
+	db.getIndexLockByType(data.String).Lock()
+	defer db.getIndexLockByType(data.String).Unlock()
+
+	positions := make(map[data.LogPos]int64)
+	now := time.Now().UnixNano()
+	for key := range db.index.strHistory {
+		db.pruneStrHistory(key, now)
+		for _, v := range db.index.strHistory[key] {
+			positions[*v.pos] = v.version
+		}
+	}
+	return positions
+}
+
+// latestStrVersion returns the version of the latest record of the string, which is its commitTs
+// if the history is retained, the string lock must be held
+func (db *DB) latestStrVersion(key []byte, logRecord *data.LogRecord) int64 {
+	if versions := db.index.strHistory[string(key)]; len(versions) > 0 {
+		return versions[len(versions)-1].version
+	}
+	return logRecord.Version
+}
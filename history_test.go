@@ -0,0 +1,203 @@
+package CouloyDB
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_GetAt(t *testing.T) {
+	options := DefaultOptions()
+	options.RetainVersions = 3
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("price")
+	before := time.Now().UnixNano()
+	assert.Nil(t, db.Put(key, []byte("10")))
+	t1 := time.Now().UnixNano()
+	assert.Nil(t, db.Put(key, []byte("11")))
+	t2 := time.Now().UnixNano()
+	assert.Nil(t, db.Del(key))
+	t3 := time.Now().UnixNano()
+
+	_, err = db.GetAt(key, before)
+	assert.Equal(t, public.ErrKeyNotFound, err)
+	value, err := db.GetAt(key, t1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("10"), value)
+
+	assert.Nil(t, db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.Set(key, []byte("12"))
+	}))
+	value, err = db.GetAt(key, t2)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("11"), value)
+	_, err = db.GetAt(key, t3)
+	assert.Equal(t, public.ErrKeyNotFound, err)
+	value, err = db.GetAt(key, time.Now().UnixNano())
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("12"), value)
+
+	// only the last 3 versions are retained
+	history, err := db.History(key)
+	assert.Nil(t, err)
+	assert.Len(t, history, 3)
+	assert.Equal(t, []byte("11"), history[0].Value)
+	assert.True(t, history[1].Deleted)
+	assert.Equal(t, []byte("12"), history[2].Value)
+	_, err = db.GetAt(key, t1)
+	assert.Equal(t, public.ErrKeyNotFound, err)
+
+	_, err = db.History([]byte("missing"))
+	assert.Equal(t, public.ErrKeyNotFound, err)
+}
+
+func TestDB_GetAt_NotRetained(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("k")
+	assert.Nil(t, db.Put(key, []byte("v1")))
+	t1 := time.Now().UnixNano()
+	assert.Nil(t, db.Put(key, []byte("v2")))
+
+	// only the latest version is kept
+	_, err = db.GetAt(key, t1)
+	assert.Equal(t, public.ErrKeyNotFound, err)
+	value, err := db.GetAt(key, time.Now().UnixNano())
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), value)
+	history, err := db.History(key)
+	assert.Nil(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, []byte("v2"), history[0].Value)
+}
+
+func TestDB_NewIteratorAt(t *testing.T) {
+	options := DefaultOptions()
+	options.RetainDuration = time.Hour
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("a1")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("b1")))
+	ts := time.Now().UnixNano()
+	assert.Nil(t, db.Put([]byte("a"), []byte("a2")))
+	assert.Nil(t, db.Del([]byte("b")))
+	assert.Nil(t, db.Put([]byte("c"), []byte("c1")))
+
+	iterator := db.NewIteratorAt(ts, IteratorOptions{})
+	defer iterator.Close()
+	keys, values := make([][]byte, 0), make([][]byte, 0)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		value, err := iterator.Value()
+		assert.Nil(t, err)
+		keys = append(keys, iterator.Key())
+		values = append(values, value)
+	}
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, keys)
+	assert.Equal(t, [][]byte{[]byte("a1"), []byte("b1")}, values)
+}
+
+func TestDB_History_Merge_Restart(t *testing.T) {
+	options := DefaultOptions()
+	options.SyncWrites = false
+	options.RetainVersions = 2
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, v := range []string{"v1", "v2", "v3"} {
+		assert.Nil(t, db.Put([]byte("k"), []byte(v)))
+	}
+	assert.Nil(t, db.Put([]byte("gone"), []byte("v1")))
+	t1 := time.Now().UnixNano()
+	assert.Nil(t, db.Del([]byte("gone")))
+
+	// merge keeps the retained versions and the deletes after them
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+
+	history, err := db.History([]byte("k"))
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, []byte("v2"), history[0].Value)
+	assert.Equal(t, []byte("v3"), history[1].Value)
+	value, err := db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), value)
+
+	_, err = db.Get([]byte("gone"))
+	assert.Equal(t, public.ErrKeyNotFound, err)
+	value, err = db.GetAt([]byte("gone"), t1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), value)
+}
+
+func TestDB_GetAt_CommitTs(t *testing.T) {
+	options := DefaultOptions()
+	options.SyncWrites = false
+	options.RetainVersions = 3
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("k"), []byte("v1")))
+	txn, err := db.Begin(TxnOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Set([]byte("k"), []byte("v2")))
+	assert.Nil(t, txn.Set([]byte("new"), []byte("v1")))
+	ts := time.Now().UnixNano()
+	assert.Nil(t, txn.Commit())
+
+	// the txn wrote before ts but committed after it, it is not seen at ts
+	check := func(db *DB) {
+		value, err := db.GetAt([]byte("k"), ts)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), value)
+		_, err = db.GetAt([]byte("new"), ts)
+		assert.Equal(t, public.ErrKeyNotFound, err)
+
+		iterator := db.NewIteratorAt(ts, IteratorOptions{})
+		keys := make([][]byte, 0)
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			keys = append(keys, iterator.Key())
+		}
+		iterator.Close()
+		assert.Equal(t, [][]byte{[]byte("k")}, keys)
+
+		history, err := db.History([]byte("k"))
+		assert.Nil(t, err)
+		assert.Len(t, history, 2)
+		assert.Less(t, history[0].Version, ts)
+		assert.Greater(t, history[1].Version, ts)
+		_, version, err := db.GetWithVersion([]byte("k"))
+		assert.Nil(t, err)
+		assert.Equal(t, history[1].Version, version)
+	}
+	check(db)
+
+	// the commitTs is kept by the commit mark and by merge
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	check(db)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+	check(db)
+}
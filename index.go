@@ -1,11 +1,52 @@
 package CouloyDB
 
 import (
+	"github.com/Kirov7/CouloyDB/data"
 	"github.com/Kirov7/CouloyDB/meta"
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/public/ds"
 )
 
 type hashIndex map[string]meta.MemTable
 type setIndex map[string]meta.MemTable
+type zsetIndex map[string]*zset
+type bitmapIndex map[string]meta.MemTable
+type streamIndex map[string]*stream
+
+// zsetNodeSize is the estimated size of a skip list node and its dict entry, without the member
+const zsetNodeSize = 96
+
+// zset indexes the members of a sorted set by name and by score
+type zset struct {
+	members meta.MemTable // member to position
+	scores  *ds.SortedSet
+}
+
+// streamPendingSize is the estimated size of a pending entry, without the consumer
+const streamPendingSize = 80
+
+// stream indexes the entries of a stream and the state of its consumer groups
+type stream struct {
+	entries meta.MemTable // entry id to position, always a Btree to keep the ids ordered
+	lastID  StreamID
+	metaPos *data.LogPos
+	groups  map[string]*streamGroup
+}
+
+// streamGroup is a consumer group with its pending entry list
+type streamGroup struct {
+	lastDelivered StreamID
+	pos           *data.LogPos
+	pending       map[StreamID]*streamPending
+}
+
+// streamPending is an entry delivered to a consumer but not acknowledged yet
+type streamPending struct {
+	consumer      string
+	deliveryTime  int64 // unix milliseconds
+	deliveryCount int64
+	pos           *data.LogPos
+}
 
 type listIndex struct {
 	metaIndex meta.MemTable // key to list metadata(head seq and tail seq)
@@ -13,16 +54,62 @@ type listIndex struct {
 }
 
 type index struct {
-	strIndex  meta.MemTable
-	hashIndex hashIndex
-	listIndex listIndex
-	setIndex  setIndex
+	strIndex    meta.MemTable
+	strHistory  map[string][]*strVersion // key to the retained versions of the string, from the oldest
+	hashIndex   hashIndex
+	listIndex   listIndex
+	setIndex    setIndex
+	zsetIndex   zsetIndex
+	bitmapIndex bitmapIndex
+	hllIndex    meta.MemTable
+	streamIndex streamIndex
+	expireIndex meta.MemTable // data type and key to the position of the expiration
+}
+
+// openStrIndex creates the string index, the BPlusTree index is stored in the data directory
+func openStrIndex(opt Options) (meta.MemTable, error) {
+	if opt.IndexType == meta.BPlusTree {
+		return meta.OpenBPTree(opt.DirPath)
+	}
+	return meta.NewMemTable(opt.IndexType), nil
+}
+
+// ascendFrom calls fn for the keys of the memTable not less than key in order until fn returns false,
+// without copying all the keys if the memTable is an Ascender
+func ascendFrom(idx meta.MemTable, key []byte, fn func(key []byte, pos *data.LogPos) bool) {
+	if ascender, ok := idx.(meta.Ascender); ok {
+		ascender.AscendFrom(key, fn)
+		return
+	}
+	iterator := idx.Iterator(false)
+	if iterator == nil {
+		return
+	}
+	defer iterator.Close()
+	for iterator.Seek(key); iterator.Valid() && fn(iterator.Key(), iterator.Value()); iterator.Next() {
+	}
 }
 
 func (i *index) getStrIndex() meta.MemTable {
 	return i.strIndex
 }
 
+// strIndexErr returns the I/O error which made a persistent string index fail, nil if there was none
+func (i *index) strIndexErr() error {
+	if idx, ok := i.strIndex.(meta.PersistentMemTable); ok {
+		return idx.Err()
+	}
+	return nil
+}
+
+// strIndexUpdateErr returns the error of a failed update of the string index
+func (i *index) strIndexUpdateErr() error {
+	if err := i.strIndexErr(); err != nil {
+		return err
+	}
+	return public.ErrUpdateIndexFailed
+}
+
 func (i *index) getHashIndex(key string) (meta.MemTable, bool) {
 	if idx, ok := i.hashIndex[key]; ok {
 		return idx, ok
@@ -34,6 +121,10 @@ func (i *index) setHashIndex(key string, memTable meta.MemTable) {
 	i.hashIndex[key] = memTable
 }
 
+func (i *index) delHashIndex(key string) {
+	delete(i.hashIndex, key)
+}
+
 func (i *index) getListMetaIndex() meta.MemTable {
 	return i.listIndex.metaIndex
 }
@@ -49,6 +140,10 @@ func (i *index) setListDataIndex(key string, memTable meta.MemTable) {
 	i.listIndex.dataIndex[key] = memTable
 }
 
+func (i *index) delListDataIndex(key string) {
+	delete(i.listIndex.dataIndex, key)
+}
+
 func (i *index) getSetIndex(key string) (meta.MemTable, bool) {
 	if idx, ok := i.setIndex[key]; ok {
 		return idx, ok
@@ -59,3 +154,208 @@ func (i *index) getSetIndex(key string) (meta.MemTable, bool) {
 func (i *index) setSetIndex(key string, memTable meta.MemTable) {
 	i.setIndex[key] = memTable
 }
+
+func (i *index) delSetIndex(key string) {
+	delete(i.setIndex, key)
+}
+
+func (i *index) getZSetIndex(key string) (*zset, bool) {
+	if idx, ok := i.zsetIndex[key]; ok {
+		return idx, ok
+	}
+	return nil, false
+}
+
+func (i *index) setZSetIndex(key string, zs *zset) {
+	i.zsetIndex[key] = zs
+}
+
+func (i *index) delZSetIndex(key string) {
+	delete(i.zsetIndex, key)
+}
+
+func (i *index) getBitmapIndex(key string) (meta.MemTable, bool) {
+	if idx, ok := i.bitmapIndex[key]; ok {
+		return idx, ok
+	}
+	return nil, false
+}
+
+func (i *index) setBitmapIndex(key string, memTable meta.MemTable) {
+	i.bitmapIndex[key] = memTable
+}
+
+func (i *index) delBitmapIndex(key string) {
+	delete(i.bitmapIndex, key)
+}
+
+func (i *index) getHLLIndex() meta.MemTable {
+	return i.hllIndex
+}
+
+func (i *index) getStreamIndex(key string) (*stream, bool) {
+	if idx, ok := i.streamIndex[key]; ok {
+		return idx, ok
+	}
+	return nil, false
+}
+
+func (i *index) setStreamIndex(key string, s *stream) {
+	i.streamIndex[key] = s
+}
+
+func (i *index) delStreamIndex(key string) {
+	delete(i.streamIndex, key)
+}
+
+func (i *index) getExpireIndex() meta.MemTable {
+	return i.expireIndex
+}
+
+func newZSet(typ meta.MemTableType) *zset {
+	return &zset{
+		members: meta.NewMemTable(typ),
+		scores:  ds.NewSortedSet(),
+	}
+}
+
+func newStream() *stream {
+	return &stream{
+		entries: meta.NewMemTable(meta.Btree),
+		groups:  make(map[string]*streamGroup),
+	}
+}
+
+// posOf returns the position of the record of the sub key, nil if it is not indexed
+func (s *stream) posOf(sub []byte) *data.LogPos {
+	if len(sub) == 0 {
+		return nil
+	}
+	switch sub[0] {
+	case streamEntryPrefix:
+		return s.entries.Get(sub[1:])
+	case streamMetaPrefix:
+		return s.metaPos
+	case streamGroupPrefix:
+		if g, ok := s.groups[string(sub[1:])]; ok {
+			return g.pos
+		}
+	case streamPendingPrefix:
+		group, id := decodePendingSubKey(sub)
+		if g, ok := s.groups[string(group)]; ok {
+			if p, ok := g.pending[id]; ok {
+				return p.pos
+			}
+		}
+	}
+	return nil
+}
+
+// apply updates the stream with a record of the sub key
+func (s *stream) apply(sub []byte, typ data.LogRecordType, value []byte, pos *data.LogPos) {
+	if len(sub) == 0 {
+		return
+	}
+	deleted := typ == data.LogRecordDeleted
+	switch sub[0] {
+	case streamEntryPrefix:
+		if deleted {
+			s.entries.Del(sub[1:])
+		} else {
+			s.entries.Put(sub[1:], pos)
+		}
+	case streamMetaPrefix:
+		if deleted {
+			s.lastID, s.metaPos = StreamID{}, nil
+		} else {
+			s.lastID, s.metaPos = decodeStreamID(value), pos
+		}
+	case streamGroupPrefix:
+		if deleted {
+			delete(s.groups, string(sub[1:]))
+			return
+		}
+		g := s.group(string(sub[1:]))
+		g.lastDelivered, g.pos = decodeStreamID(value), pos
+	case streamPendingPrefix:
+		group, id := decodePendingSubKey(sub)
+		if deleted {
+			if g, ok := s.groups[string(group)]; ok {
+				delete(g.pending, id)
+			}
+			return
+		}
+		g := s.group(string(group))
+		p := decodeStreamPending(value)
+		p.pos = pos
+		g.pending[id] = p
+	}
+}
+
+// empty returns whether the stream has no record left
+func (s *stream) empty() bool {
+	return s.entries.Count() == 0 && len(s.groups) == 0 && s.metaPos == nil
+}
+
+func (s *stream) group(name string) *streamGroup {
+	g, ok := s.groups[name]
+	if !ok {
+		g = &streamGroup{pending: make(map[StreamID]*streamPending)}
+		s.groups[name] = g
+	}
+	return g
+}
+
+// IndexMemoryUsage reports the memory taken by the index of each data type
+type IndexMemoryUsage struct {
+	String      meta.MemoryUsage
+	Hash        meta.MemoryUsage
+	Set         meta.MemoryUsage
+	List        meta.MemoryUsage
+	ZSet        meta.MemoryUsage
+	Bitmap      meta.MemoryUsage
+	HyperLogLog meta.MemoryUsage
+	Stream      meta.MemoryUsage
+}
+
+// Total returns the sum of all the data types
+func (u IndexMemoryUsage) Total() meta.MemoryUsage {
+	return u.String.Add(u.Hash).Add(u.Set).Add(u.List).Add(u.ZSet).Add(u.Bitmap).Add(u.HyperLogLog).Add(u.Stream)
+}
+
+func (i *index) memoryUsage() IndexMemoryUsage {
+	var usage IndexMemoryUsage
+	usage.String = i.strIndex.MemoryUsage()
+	for _, idx := range i.hashIndex {
+		usage.Hash = usage.Hash.Add(idx.MemoryUsage())
+	}
+	for _, idx := range i.setIndex {
+		usage.Set = usage.Set.Add(idx.MemoryUsage())
+	}
+	usage.List = i.listIndex.metaIndex.MemoryUsage()
+	for _, idx := range i.listIndex.dataIndex {
+		usage.List = usage.List.Add(idx.MemoryUsage())
+	}
+	for _, idx := range i.zsetIndex {
+		members := idx.members.MemoryUsage()
+		// the member and the score are stored once more in the skip list
+		members.Bytes += members.KeyBytes + int64(idx.scores.Len())*zsetNodeSize
+		usage.ZSet = usage.ZSet.Add(members)
+	}
+	for _, idx := range i.bitmapIndex {
+		usage.Bitmap = usage.Bitmap.Add(idx.MemoryUsage())
+	}
+	usage.HyperLogLog = i.hllIndex.MemoryUsage()
+	for _, idx := range i.streamIndex {
+		entries := idx.entries.MemoryUsage()
+		// the pending entries are counted as keys of the stream
+		for _, g := range idx.groups {
+			for _, p := range g.pending {
+				entries.Keys++
+				entries.Bytes += streamPendingSize + int64(len(p.consumer))
+			}
+		}
+		usage.Stream = usage.Stream.Add(entries)
+	}
+	return usage
+}
@@ -2,6 +2,9 @@ package CouloyDB
 
 import (
 	"bytes"
+	"sort"
+
+	"github.com/Kirov7/CouloyDB/data"
 	"github.com/Kirov7/CouloyDB/meta"
 )
 
@@ -20,6 +23,50 @@ func (db *DB) NewIterator(options IteratorOptions) *Iterator {
 	}
 }
 
+// NewIterator returns an iterator over the strings as the txn sees them, its own writes included.
+// The committed strings are read from the index as the iterator moves and merged with the writes of the txn,
+// a serializable txn reads them from its snapshot. A serializable txn conflicts with the writes to any string
+// with the prefix committed after its start
+func (txn *Txn) NewIterator(options IteratorOptions) *Iterator {
+	txn.trackRange(options.Prefix)
+	lock := txn.db.getIndexLockByType(data.String)
+	lock.RLock()
+	base := txn.db.index.getStrIndex().Iterator(options.Reverse)
+	lock.RUnlock()
+
+	// the index must be read before the before images, a txn committed in between is published first
+	overlay := make(map[string]*data.LogPos)
+	for key, pos := range txn.snapshotStrBefore() {
+		if bytes.HasPrefix([]byte(key), options.Prefix) {
+			overlay[key] = pos
+		}
+	}
+	// the writes of the txn hide the committed ones
+	for key, pw := range txn.strPendingWrites {
+		if !bytes.HasPrefix([]byte(key), options.Prefix) {
+			continue
+		}
+		if pw.typ == data.LogRecordDeleted {
+			overlay[key] = nil
+		} else {
+			overlay[key] = pw.LogPos
+		}
+	}
+
+	it := &txnIterator{txn: txn, base: base, prefix: options.Prefix, reverse: options.Reverse}
+	for key, pos := range overlay {
+		it.overlay = append(it.overlay, overlayEntry{key: []byte(key), pos: pos})
+	}
+	sort.Slice(it.overlay, func(i, j int) bool {
+		return it.before(it.overlay[i].key, it.overlay[j].key)
+	})
+	return &Iterator{
+		IndexIterator: it,
+		db:            txn.db,
+		options:       options,
+	}
+}
+
 func (it *Iterator) Rewind() {
 	it.IndexIterator.Rewind()
 	it.skipToNext()
@@ -66,3 +113,147 @@ func (it *Iterator) skipToNext() {
 		}
 	}
 }
+
+// txnIterator merges the iterator of the string index with the sorted writes of a txn and the before images
+// of its snapshot, which hide the committed strings with the same keys. A nil position hides the key
+type txnIterator struct {
+	txn     *Txn
+	base    meta.Iterator // nil if the index is empty
+	prefix  []byte
+	reverse bool
+
+	overlay []overlayEntry // in the order of the iteration
+	next    int            // the first entry of the overlay after the current key
+
+	key      []byte
+	pos      *data.LogPos
+	fromBase bool
+	valid    bool
+}
+
+type overlayEntry struct {
+	key []byte
+	pos *data.LogPos
+}
+
+// before returns whether a comes before b in the order of the iteration
+func (it *txnIterator) before(a, b []byte) bool {
+	if it.reverse {
+		return bytes.Compare(a, b) > 0
+	}
+	return bytes.Compare(a, b) < 0
+}
+
+func (it *txnIterator) Rewind() {
+	it.next = 0
+	if it.base != nil {
+		if it.reverse || len(it.prefix) == 0 {
+			it.base.Rewind()
+		} else {
+			it.base.Seek(it.prefix)
+		}
+	}
+	it.settle()
+}
+
+func (it *txnIterator) Seek(key []byte) bool {
+	it.next = sort.Search(len(it.overlay), func(i int) bool {
+		return !it.before(it.overlay[i].key, key)
+	})
+	if it.base != nil {
+		it.base.Seek(key)
+	}
+	it.settle()
+	return it.valid
+}
+
+func (it *txnIterator) Next() {
+	if !it.valid {
+		return
+	}
+	if it.fromBase {
+		it.base.Next()
+	} else {
+		it.next++
+	}
+	it.settle()
+}
+
+func (it *txnIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *txnIterator) Key() []byte {
+	return it.key
+}
+
+func (it *txnIterator) Value() *data.LogPos {
+	return it.pos
+}
+
+func (it *txnIterator) Close() {
+	if it.base != nil {
+		it.base.Close()
+	}
+}
+
+// baseKey returns the current key of the index iterator, or nil if there is none left with the prefix
+func (it *txnIterator) baseKey() []byte {
+	for it.base != nil && it.base.Valid() {
+		key := it.base.Key()
+		if bytes.HasPrefix(key, it.prefix) {
+			return key
+		}
+		// the keys with the prefix are behind in a forward iteration and ahead in a reverse one
+		if it.reverse != (bytes.Compare(key, it.prefix) > 0) {
+			return nil
+		}
+		it.base.Next()
+	}
+	return nil
+}
+
+// settle moves to the first key which is not hidden
+func (it *txnIterator) settle() {
+	for {
+		baseKey := it.baseKey()
+		var entry *overlayEntry
+		if it.next < len(it.overlay) {
+			entry = &it.overlay[it.next]
+		}
+
+		switch {
+		case baseKey == nil && entry == nil:
+			it.valid = false
+			return
+		case entry == nil || baseKey != nil && it.before(baseKey, entry.key):
+			if pos := it.committedPos(baseKey); pos != nil {
+				it.key, it.pos, it.fromBase, it.valid = baseKey, pos, true, true
+				return
+			}
+			it.base.Next()
+		default:
+			if baseKey != nil && bytes.Equal(baseKey, entry.key) {
+				it.base.Next()
+			}
+			if entry.pos != nil {
+				it.key, it.pos, it.fromBase, it.valid = entry.key, entry.pos, false, true
+				return
+			}
+			it.next++
+		}
+	}
+}
+
+// committedPos returns the position of the committed string in the snapshot of the txn, nil if it was not there
+func (it *txnIterator) committedPos(key []byte) *data.LogPos {
+	lock := it.txn.db.getIndexLockByType(data.String)
+	lock.RLock()
+	pos := it.base.Value()
+	lock.RUnlock()
+
+	if before, ok := it.txn.snapshotBefore(data.String, key)[""]; ok {
+		return before
+	}
+	return pos
+}
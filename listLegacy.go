@@ -0,0 +1,153 @@
+package CouloyDB
+
+import (
+	"context"
+	"encoding/binary"
+	"math/big"
+	"sort"
+
+	"github.com/Kirov7/CouloyDB/data"
+)
+
+// The lists used to store the seqs of their elements as gob encoded big.Float, which were also the keys
+// of the list data index. The lists written that way are migrated to int64 seqs when the db is opened
+
+// listIndexKey returns the list key and the key in the list data index of the encoded key of an element,
+// both the current and the legacy formats are accepted
+func listIndexKey(encodedKey []byte) ([]byte, []byte) {
+	if isLegacyListKey(encodedKey) {
+		realKey, seq, _, _ := decodeLegacyListKey(encodedKey)
+		seqBuf, _ := seq.GobEncode()
+		return realKey, seqBuf
+	}
+	realKey, seq, _, _ := decodeListKey(encodedKey)
+	return realKey, encodeListSeq(seq)
+}
+
+func isLegacyListKey(encodedKey []byte) bool {
+	return encodedKey[0] != listFormat
+}
+
+// migrateLegacyLists rewrites the lists still indexed by legacy seqs, each list is migrated in its own txn.
+// The txn limits don't apply to the migration, so a large list does not keep the db from opening
+func (db *DB) migrateLegacyLists() error {
+	keys := make([]string, 0)
+	for key, idx := range db.index.listIndex.dataIndex {
+		iterator := idx.Iterator(false)
+		if iterator == nil {
+			continue
+		}
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			if len(iterator.Key()) != listSeqSize {
+				keys = append(keys, key)
+				break
+			}
+		}
+		iterator.Close()
+	}
+
+	for _, key := range keys {
+		txn := newTxn(false, db, Serializable)
+		txn.ctx, txn.unlimited = context.Background(), true
+		txn.begin()
+		err := txn.run(func(txn *Txn) error {
+			return txn.migrateLegacyList([]byte(key))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateLegacyList deletes the legacy elements of the list and pushes them again in the same order
+func (txn *Txn) migrateLegacyList(key []byte) error {
+	idx, ok := txn.db.index.getListDataIndex(string(key))
+	if !ok {
+		return nil
+	}
+	type legacyElement struct {
+		seq    *big.Float
+		seqBuf string
+		pos    *data.LogPos
+	}
+	elements := make([]*legacyElement, 0)
+	iterator := idx.Iterator(false)
+	if iterator == nil {
+		return nil
+	}
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		seq := new(big.Float)
+		if err := seq.GobDecode(iterator.Key()); err != nil {
+			iterator.Close()
+			return err
+		}
+		elements = append(elements, &legacyElement{seq: seq, seqBuf: string(iterator.Key()), pos: iterator.Value()})
+	}
+	iterator.Close()
+	sort.Slice(elements, func(i, j int) bool {
+		return elements[i].seq.Cmp(elements[j].seq) < 0
+	})
+
+	for i, element := range elements {
+		value, err := txn.db.getValueByPos(element.pos)
+		if err != nil {
+			return err
+		}
+		logRecord := &data.LogRecord{
+			Key:      encodeKeyWithTxId(encodeLegacyListKey(element.seq, element.seq, element.seq, key), txn.startTs),
+			Type:     data.LogRecordDeleted,
+			DataType: data.List,
+		}
+		logPos, err := txn.appendLogRecord(logRecord)
+		if err != nil {
+			return err
+		}
+		txn.putSubPendingWrite(txn.listDataPendingWrites, string(key), element.seqBuf, &pendingWrite{typ: data.LogRecordDeleted, LogPos: logPos})
+
+		seq := int64(i) * listSeqGap
+		if err := txn.putListElement(key, seq, seq-listSeqGap, seq+listSeqGap, value); err != nil {
+			return err
+		}
+	}
+
+	if len(elements) == 0 {
+		return txn.delListMeta(key)
+	}
+	return txn.putListMeta(key, 0, int64(len(elements)-1)*listSeqGap)
+}
+
+func encodeLegacyListKey(seq, prevSeq, nextSeq *big.Float, key []byte) []byte {
+	seqBuf, _ := seq.GobEncode()
+	prevSeqBuf, _ := prevSeq.GobEncode()
+	nextSeqBuf, _ := nextSeq.GobEncode()
+	header := make([]byte, binary.MaxVarintLen64*3)
+	var index int
+	index += binary.PutVarint(header[index:], int64(len(seqBuf)))
+	index += binary.PutVarint(header[index:], int64(len(prevSeqBuf)))
+	index += binary.PutVarint(header[index:], int64(len(nextSeqBuf)))
+	buf := make([]byte, index+len(seqBuf)+len(prevSeqBuf)+len(nextSeqBuf)+len(key))
+	copy(buf[:index], header[:index])
+	copy(buf[index:index+len(seqBuf)], seqBuf)
+	copy(buf[index+len(seqBuf):index+len(seqBuf)+len(prevSeqBuf)], prevSeqBuf)
+	copy(buf[index+len(seqBuf)+len(prevSeqBuf):index+len(seqBuf)+len(prevSeqBuf)+len(nextSeqBuf)], nextSeqBuf)
+	copy(buf[index+len(seqBuf)+len(prevSeqBuf)+len(nextSeqBuf):], key)
+	return buf
+}
+
+func decodeLegacyListKey(key []byte) ([]byte, *big.Float, *big.Float, *big.Float) {
+	var index int
+	seqLen, i := binary.Varint(key[index:])
+	index += i
+	prevSeqLen, i := binary.Varint(key[index:])
+	index += i
+	nextSeqLen, i := binary.Varint(key[index:])
+	index += i
+	// the seqs keep the precision they are encoded with
+	seq, prevSeq, nextSeq := new(big.Float), new(big.Float), new(big.Float)
+	_ = seq.GobDecode(key[index : index+int(seqLen)])
+	_ = prevSeq.GobDecode(key[index+int(seqLen) : index+int(seqLen)+int(prevSeqLen)])
+	_ = nextSeq.GobDecode(key[index+int(seqLen)+int(prevSeqLen) : index+int(seqLen)+int(prevSeqLen)+int(nextSeqLen)])
+	return key[index+int(seqLen)+int(prevSeqLen)+int(nextSeqLen):], seq,
+		prevSeq, nextSeq
+}
@@ -0,0 +1,145 @@
+package CouloyDB
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Kirov7/CouloyDB/public"
+)
+
+// lockManager holds the key locks of the txns, a txn waits for one key at a time. The txns are known by
+// their start ts, so a txn dropped without being finished can still be garbage collected and release its locks
+type lockManager struct {
+	mu *sync.Mutex
+	// key to the lock
+	locks map[string]*keyLock
+	// start ts of the txn to the keys it holds
+	held map[int64][]string
+	// start ts of the txn to the key it waits for
+	waiting map[int64]string
+}
+
+type keyLock struct {
+	// start ts of the txn holding the lock
+	owner int64
+	// closed when the lock is released
+	released chan struct{}
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		mu:      &sync.Mutex{},
+		locks:   make(map[string]*keyLock),
+		held:    make(map[int64][]string),
+		waiting: make(map[int64]string),
+	}
+}
+
+// LockKeys locks the keys until the txn is finished, whatever data types they are stored as. The txn reads
+// the writes to the keys committed before it got their locks and does not conflict with them, so the keys
+// should be locked before they are read or written. It returns ErrDeadlock if the txn would wait for a txn
+// which waits for it, and ErrLockTimeout if a key is not locked within the lock timeout of the txn
+func (txn *Txn) LockKeys(keys ...[]byte) error {
+	for _, key := range keys {
+		if _, ok := txn.lockedKeys[string(key)]; ok {
+			continue
+		}
+		if err := txn.db.locks.lock(txn, string(key)); err != nil {
+			return err
+		}
+		// the lock ts is taken after the txns being committed, like the start ts
+		o := txn.db.oracle
+		o.mu.RLock()
+		txn.lockedKeys[string(key)] = o.GetTxId()
+		o.mu.RUnlock()
+	}
+	return nil
+}
+
+// conflictTs returns the ts after which the commits conflict with the txn on the key,
+// the commits before the txn locked the key are seen by it
+func (txn *Txn) conflictTs(key string) int64 {
+	if ts, ok := txn.lockedKeys[key]; ok {
+		return ts
+	}
+	return txn.startTs
+}
+
+func (lm *lockManager) lock(txn *Txn, key string) error {
+	var timeout <-chan time.Time
+	if txn.lockTimeout > 0 {
+		timer := time.NewTimer(txn.lockTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var done <-chan struct{}
+	if txn.ctx != nil {
+		done = txn.ctx.Done()
+	}
+
+	id := txn.startTs
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	for {
+		l, ok := lm.locks[key]
+		if !ok {
+			lm.locks[key] = &keyLock{owner: id, released: make(chan struct{})}
+			lm.held[id] = append(lm.held[id], key)
+			return nil
+		}
+		if l.owner == id {
+			return nil
+		}
+		if lm.waitsFor(l.owner, id) {
+			return public.ErrDeadlock
+		}
+
+		lm.waiting[id] = key
+		lm.mu.Unlock()
+		var err error
+		select {
+		case <-l.released:
+		case <-timeout:
+			err = public.ErrLockTimeout
+		case <-done:
+			err = txn.ctx.Err()
+		}
+		lm.mu.Lock()
+		delete(lm.waiting, id)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// waitsFor returns whether the txn waits for the other txn, directly or through the txns it waits for
+func (lm *lockManager) waitsFor(txn, other int64) bool {
+	visited := make(map[int64]bool)
+	for !visited[txn] {
+		if txn == other {
+			return true
+		}
+		visited[txn] = true
+		key, ok := lm.waiting[txn]
+		if !ok {
+			return false
+		}
+		l, ok := lm.locks[key]
+		if !ok {
+			return false
+		}
+		txn = l.owner
+	}
+	return false
+}
+
+// release releases the locks held by the txn with the start ts
+func (lm *lockManager) release(startTs int64) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	for _, key := range lm.held[startTs] {
+		close(lm.locks[key].released)
+		delete(lm.locks, key)
+	}
+	delete(lm.held, startTs)
+}
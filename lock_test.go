@@ -0,0 +1,181 @@
+package CouloyDB
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxn_LockKeys(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("stock")
+	assert.Nil(t, db.Put(key, []byte("0")))
+
+	// the txns holding the lock don't conflict, so none of them is retried
+	policy := RetryPolicy{MaxAttempts: 1}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(level IsolationLevel) {
+			defer wg.Done()
+			err := db.RetryTransaction(TxnOptions{IsolationLevel: level}, policy, func(txn *Txn) error {
+				if err := txn.LockKeys(key); err != nil {
+					return err
+				}
+				_, err := txn.Incr(key)
+				return err
+			})
+			assert.Nil(t, err)
+		}(IsolationLevel(i % 2))
+	}
+	wg.Wait()
+
+	value, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(strconv.Itoa(20)), value)
+}
+
+func TestTxn_LockKeys_Deadlock(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	txn1, err := db.Begin(DefaultTxnOptions())
+	assert.Nil(t, err)
+	defer txn1.Discard()
+	txn2, err := db.Begin(DefaultTxnOptions())
+	assert.Nil(t, err)
+	defer txn2.Discard()
+
+	assert.Nil(t, txn1.LockKeys([]byte("a")))
+	assert.Nil(t, txn2.LockKeys([]byte("b")))
+
+	locked := make(chan error)
+	go func() {
+		locked <- txn1.LockKeys([]byte("b"))
+	}()
+	for !waitingForLock(db, txn1) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// txn1 waits for txn2, so txn2 can't wait for txn1
+	assert.Equal(t, public.ErrDeadlock, txn2.LockKeys([]byte("a")))
+	assert.Nil(t, txn2.Rollback())
+	assert.Nil(t, <-locked)
+	assert.Nil(t, txn1.Set([]byte("b"), []byte("v")))
+	assert.Nil(t, txn1.Commit())
+}
+
+func TestTxn_LockKeys_Timeout(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	txn1, err := db.Begin(DefaultTxnOptions())
+	assert.Nil(t, err)
+	defer txn1.Discard()
+	assert.Nil(t, txn1.LockKeys([]byte("a")))
+
+	opts := DefaultTxnOptions()
+	opts.LockTimeout = 10 * time.Millisecond
+	txn2, err := db.Begin(opts)
+	assert.Nil(t, err)
+	defer txn2.Discard()
+	assert.Equal(t, public.ErrLockTimeout, txn2.LockKeys([]byte("a")))
+
+	// the lock is released when txn1 is finished
+	assert.Nil(t, txn1.Commit())
+	assert.Nil(t, txn2.LockKeys([]byte("a")))
+}
+
+func TestTxn_LockKeys_Dropped(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	func() {
+		txn, err := db.Begin(DefaultTxnOptions())
+		assert.Nil(t, err)
+		assert.Nil(t, txn.LockKeys([]byte("a")))
+	}()
+
+	// the dropped txn is rolled back when it is garbage collected, which releases its lock
+	opts := DefaultTxnOptions()
+	opts.LockTimeout = 10 * time.Millisecond
+	txn, err := db.Begin(opts)
+	assert.Nil(t, err)
+	defer txn.Discard()
+	for i := 0; i < 100; i++ {
+		runtime.GC()
+		if err = txn.LockKeys([]byte("a")); err == nil {
+			break
+		}
+	}
+	assert.Nil(t, err)
+}
+
+func TestDB_RetryTransaction(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("k")
+	conflict := func(attempts *int) func(txn *Txn) error {
+		return func(txn *Txn) error {
+			*attempts++
+			// another txn writes the key before the txn commits
+			assert.Nil(t, db.RWTransaction(false, func(other *Txn) error {
+				return other.Set(key, []byte("other"))
+			}))
+			return txn.Set(key, []byte("v"))
+		}
+	}
+
+	attempts := 0
+	policy := RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	err = db.RetryTransaction(DefaultTxnOptions(), policy, conflict(&attempts))
+	assert.Equal(t, public.ErrTransactionConflict, err)
+	assert.Equal(t, 3, attempts)
+
+	// a serializable txn is not retried unless it is run by RetryTransaction
+	attempts = 0
+	err = db.SerialTransaction(false, conflict(&attempts))
+	assert.Equal(t, public.ErrTransactionConflict, err)
+	assert.Equal(t, 1, attempts)
+
+	// the retries stop at the deadline
+	attempts = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	opts := DefaultTxnOptions()
+	opts.Context = ctx
+	policy = RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	err = db.RetryTransaction(opts, policy, conflict(&attempts))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Greater(t, attempts, 1)
+
+	value, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("other"), value)
+}
+
+func waitingForLock(db *DB, txn *Txn) bool {
+	db.locks.mu.Lock()
+	defer db.locks.mu.Unlock()
+	_, ok := db.locks.waiting[txn.startTs]
+	return ok
+}
@@ -7,8 +7,10 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/meta"
 	"github.com/Kirov7/CouloyDB/public"
 )
 
@@ -75,6 +77,12 @@ func (db *DB) merge() error {
 	mergeOptions.DirPath = mergePath
 	// in merging sync is not needed because it will not affect user's normal behavior If merge panic occurs
 	mergeOptions.SyncWrites = false
+	// the changes are published by the db, the merge instance must not create its own change file
+	mergeOptions.EnableChangeStream = false
+	// the merge instance only appends records, it doesn't need a persistent index
+	if mergeOptions.IndexType == meta.BPlusTree {
+		mergeOptions.IndexType = meta.Btree
+	}
 	mergeDb, err := NewCouloyDB(mergeOptions)
 	if err != nil {
 		return err
@@ -86,6 +94,9 @@ func (db *DB) merge() error {
 		return err
 	}
 
+	// the retained versions of the strings are kept besides the latest ones
+	retained := db.retainedStrVersions()
+
 	// iterate every dataFile and process them
 	for _, oldFile := range mergeFiles {
 		var offset int64 = 0
@@ -103,25 +114,48 @@ func (db *DB) merge() error {
 			var logRecordPos *data.LogPos
 			switch logRecord.DataType {
 			case data.String:
+				// a range tombstone is never in the index, the strings it deleted are not either
 				logRecordPos = db.index.getStrIndex().Get(realKey)
+				if version, ok := retained[data.LogPos{Fid: oldFile.FileId, Offset: offset}]; ok {
+					logRecordPos = &data.LogPos{Fid: oldFile.FileId, Offset: offset}
+					// the commit mark is not merged, the record keeps the commitTs of the version
+					logRecord.Version = version
+				}
 			case data.Hash:
 				decodedKey, field := decodeFieldKey(realKey)
 				if idx, ok := db.index.getHashIndex(string(decodedKey)); ok {
 					logRecordPos = idx.Get(field)
 				}
 			case data.List:
-				decodedKey, seq, _, _ := decodeListKey(realKey)
+				decodedKey, seqBuf := listIndexKey(realKey)
 				if idx, ok := db.index.getListDataIndex(string(decodedKey)); ok {
-					seqBuf, _ := seq.GobEncode()
 					logRecordPos = idx.Get(seqBuf)
 				}
 			case data.ListMeta:
 				logRecordPos = db.index.getListMetaIndex().Get(realKey)
 			case data.Set:
 				realKey, member := decodeMemberKey(realKey)
-				hashKey := hashMemberKey(realKey, member)
 				if idx, ok := db.index.getSetIndex(string(realKey)); ok {
-					logRecordPos = idx.Get(hashKey)
+					logRecordPos = idx.Get(member)
+				}
+			case data.ZSet:
+				realKey, member := decodeMemberKey(realKey)
+				if zs, ok := db.index.getZSetIndex(string(realKey)); ok {
+					logRecordPos = zs.members.Get(member)
+				}
+			case data.Bitmap:
+				realKey, chunk := decodeMemberKey(realKey)
+				if idx, ok := db.index.getBitmapIndex(string(realKey)); ok {
+					logRecordPos = idx.Get(chunk)
+				}
+			case data.HyperLogLog:
+				logRecordPos = db.index.getHLLIndex().Get(realKey)
+			case data.Expire:
+				logRecordPos = db.index.getExpireIndex().Get(realKey)
+			case data.Stream:
+				realKey, sub := decodeMemberKey(realKey)
+				if s, ok := db.index.getStreamIndex(string(realKey)); ok {
+					logRecordPos = s.posOf(sub)
 				}
 			}
 			// compare with the memTable, if the already exist in memTable then rewrite it
@@ -132,9 +166,12 @@ func (db *DB) merge() error {
 				if err != nil {
 					return err
 				}
-				// write the pos to the hint file
-				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
-					return err
+				// write the pos to the hint file, only the string index is loaded from it,
+				// the other data types are rebuilt from the merged records
+				if logRecord.DataType == data.String && logRecord.Type == data.LogRecordNormal {
+					if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+						return err
+					}
 				}
 			}
 			// add offset
@@ -202,7 +239,11 @@ func (db *DB) loadMergeFiles() error {
 		if ent.Name() == public.MergeFinishedFileName {
 			MergeFin = true
 		}
-		mergeFileNames = append(mergeFileNames, ent.Name())
+		// only the data files, the hint file and the mark replace the ones of the db
+		if strings.HasSuffix(ent.Name(), public.DataFileNameSuffix) ||
+			ent.Name() == public.HintFileName || ent.Name() == public.MergeFinishedFileName {
+			mergeFileNames = append(mergeFileNames, ent.Name())
+		}
 	}
 
 	// if the merge does not complete, return directly
@@ -215,11 +256,18 @@ func (db *DB) loadMergeFiles() error {
 		return err
 	}
 
+	// the positions in a persistent index point into the replaced files, rebuild it
+	if idx, ok := db.index.getStrIndex().(meta.PersistentMemTable); ok {
+		if err := idx.Reset(); err != nil {
+			return err
+		}
+	}
+
 	// remove the old dataFile
 	var fileId uint32
 	for ; fileId < nonMergeFileId; fileId++ {
 		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err != nil {
+		if _, err := os.Stat(fileName); err == nil {
 			if err := os.Remove(fileName); err != nil {
 				return err
 			}
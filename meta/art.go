@@ -46,17 +46,30 @@ func (a *AdaptiveRadixTree) Del(key []byte) bool {
 
 func (a *AdaptiveRadixTree) Count() int {
 	a.lock.RLock()
-	defer a.lock.Unlock()
+	defer a.lock.RUnlock()
 	size := a.tree.Size()
 	return size
 }
 
 func (a *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
 	a.lock.RLock()
-	defer a.lock.Unlock()
+	defer a.lock.RUnlock()
 	return newArtIterator(a, reverse)
 }
 
+func (a *AdaptiveRadixTree) MemoryUsage() MemoryUsage {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	usage := MemoryUsage{Keys: a.tree.Size()}
+	a.tree.ForEach(func(node art.Node) bool {
+		usage.KeyBytes += int64(len(node.Key()))
+		return true
+	})
+	// leaf with its key and value interface, the LogPos and a share of the inner nodes
+	usage.Bytes = usage.KeyBytes + int64(usage.Keys)*(64+16+32)
+	return usage
+}
+
 type artIterator struct {
 	currentIndex int
 	reverse      bool
@@ -0,0 +1,742 @@
+package meta
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/driver"
+	"github.com/Kirov7/CouloyDB/public"
+)
+
+const (
+	bpPageSize = 4096
+	// MaxKeySize keeps at least three entries in every page, so a split always produces two valid pages
+	MaxKeySize = 1024
+	// bpCacheNodes is the number of decoded pages kept in memory, 16384 pages take about 64MB
+	bpCacheNodes = 1 << 14
+
+	bpMagic   uint32 = 0x43425054 // "CBPT"
+	bpVersion byte   = 1
+
+	// page id 0 is always the meta page, so it is used as "no page" in the sibling links
+	bpMetaPageId uint64 = 0
+
+	// flags(1) + count(2) + next or first child(8) + prev(8)
+	bpNodeHeaderSize = 19
+)
+
+var errBPTreeCorrupted = errors.New("the bptree index file maybe contaminated or damaged")
+
+// BPTree is a B+tree stored in a page file, only the recently used pages are kept in memory.
+// Nodes are never merged, a leaf emptied by deletes stays in the tree and is skipped by the iterator.
+type BPTree struct {
+	mu   *sync.Mutex
+	file *os.File
+
+	root  uint64
+	pages uint64
+	count uint64
+
+	// checkpoint is only valid if the tree was closed cleanly last time
+	checkpoint *Checkpoint
+
+	cache      *list.List               // LRU list of *bpNode, the most recently used at front
+	nodes      map[uint64]*list.Element // page id to cache element
+	cacheNodes int                      // the number of pages the cache keeps
+
+	// err is the first I/O error, the tree fails every operation after it
+	err error
+}
+
+type bpNode struct {
+	id   uint64
+	leaf bool
+	keys [][]byte
+	// positions of a leaf
+	values []data.LogPos
+	// children of an internal node, len(children) == len(keys)+1
+	children []uint64
+	// sibling links of a leaf
+	next, prev uint64
+
+	dirty bool
+}
+
+// OpenBPTree opens the BPTree index in dirPath, the file is created if not exist
+func OpenBPTree(dirPath string) (*BPTree, error) {
+	fileName := filepath.Join(dirPath, public.BPTreeIndexFileName)
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, driver.DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	bt := &BPTree{
+		mu:         new(sync.Mutex),
+		file:       file,
+		cache:      list.New(),
+		nodes:      make(map[uint64]*list.Element),
+		cacheNodes: bpCacheNodes,
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		err = bt.init()
+	} else if err = bt.readMeta(); err == nil && (bt.root == bpMetaPageId || bt.root >= bt.pages ||
+		info.Size() < int64(bt.pages)*bpPageSize) {
+		err = errBPTreeCorrupted
+	}
+	// a damaged file is started over, the tree is rebuilt from the log as if it was not closed cleanly
+	if err == errBPTreeCorrupted {
+		bt.checkpoint = nil
+		if err = file.Truncate(0); err == nil {
+			err = bt.init()
+		}
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	// from now on the content of the file is only trustable after a clean close
+	if err := bt.writeMeta(false, nil); err != nil {
+		return nil, err
+	}
+	if err := bt.file.Sync(); err != nil {
+		return nil, err
+	}
+	return bt, nil
+}
+
+// Checkpoint returns the log position up to which the tree is complete,
+// ok is false if the tree was not closed cleanly and has to be rebuilt from the log
+func (bt *BPTree) Checkpoint() (*Checkpoint, bool) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	return bt.checkpoint, bt.checkpoint != nil
+}
+
+// Reset drops all the entries of the tree
+func (bt *BPTree) Reset() error {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	if err := bt.file.Truncate(0); err != nil {
+		return err
+	}
+	bt.cache.Init()
+	bt.nodes = make(map[uint64]*list.Element)
+	bt.checkpoint = nil
+	if err := bt.init(); err != nil {
+		return err
+	}
+	return bt.writeMeta(false, nil)
+}
+
+// Err returns the I/O error which made the tree fail, nil if there was none
+func (bt *BPTree) Err() error {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	return bt.err
+}
+
+// fail keeps the first I/O error of the tree, the caller must hold the tree lock
+func (bt *BPTree) fail(err error) {
+	if bt.err == nil {
+		bt.err = err
+	}
+}
+
+// Sync writes all the dirty pages to disk
+func (bt *BPTree) Sync() error {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	return bt.flush()
+}
+
+// Close flushes the tree and records the log position it is complete up to,
+// a nil checkpoint leaves the tree marked as dirty
+func (bt *BPTree) Close(checkpoint *Checkpoint) error {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	if err := bt.flush(); err != nil {
+		return err
+	}
+	if err := bt.writeMeta(checkpoint != nil, checkpoint); err != nil {
+		return err
+	}
+	if err := bt.file.Sync(); err != nil {
+		return err
+	}
+	return bt.file.Close()
+}
+
+func (bt *BPTree) Put(key []byte, pos *data.LogPos) bool {
+	if len(key) > MaxKeySize || pos == nil {
+		return false
+	}
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	if bt.err != nil {
+		return false
+	}
+	defer bt.evict()
+
+	root, err := bt.node(bt.root)
+	if err != nil {
+		bt.fail(err)
+		return false
+	}
+	sep, right, inserted, err := bt.insert(root, key, *pos)
+	if err != nil {
+		bt.fail(err)
+		return false
+	}
+	if right != nil {
+		// the root was split, grow the tree by one level
+		newRoot := bt.newNode(false)
+		newRoot.keys = [][]byte{sep}
+		newRoot.children = []uint64{root.id, right.id}
+		bt.root = newRoot.id
+	}
+	if inserted {
+		bt.count++
+	}
+	return true
+}
+
+func (bt *BPTree) Get(key []byte) *data.LogPos {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	if bt.err != nil {
+		return nil
+	}
+	defer bt.evict()
+
+	leaf, err := bt.findLeaf(key)
+	if err != nil {
+		bt.fail(err)
+		return nil
+	}
+	i, found := leaf.search(key)
+	if !found {
+		return nil
+	}
+	pos := leaf.values[i]
+	return &pos
+}
+
+func (bt *BPTree) Del(key []byte) bool {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	if bt.err != nil {
+		return false
+	}
+	defer bt.evict()
+
+	leaf, err := bt.findLeaf(key)
+	if err != nil {
+		bt.fail(err)
+		return false
+	}
+	i, found := leaf.search(key)
+	if !found {
+		return false
+	}
+	leaf.keys = append(leaf.keys[:i], leaf.keys[i+1:]...)
+	leaf.values = append(leaf.values[:i], leaf.values[i+1:]...)
+	leaf.dirty = true
+	bt.count--
+	return true
+}
+
+func (bt *BPTree) Count() int {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	return int(bt.count)
+}
+
+// MemoryUsage only counts the pages cached in memory, the keys are on disk
+func (bt *BPTree) MemoryUsage() MemoryUsage {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	usage := MemoryUsage{Keys: int(bt.count)}
+	for elem := bt.cache.Front(); elem != nil; elem = elem.Next() {
+		n := elem.Value.(*bpNode)
+		for _, key := range n.keys {
+			usage.KeyBytes += int64(len(key))
+		}
+		usage.Bytes += int64(n.encodedSize()) + int64(len(n.keys))*(24+16)
+	}
+	return usage
+}
+
+func (bt *BPTree) Iterator(reverse bool) Iterator {
+	return newBPTreeIterator(bt, reverse)
+}
+
+// insert puts the key into the subtree of n, if n is split the separator and the new right node are returned
+func (bt *BPTree) insert(n *bpNode, key []byte, pos data.LogPos) ([]byte, *bpNode, bool, error) {
+	if n.leaf {
+		i, found := n.search(key)
+		n.dirty = true
+		if found {
+			n.values[i] = pos
+			return nil, nil, false, nil
+		}
+		n.keys = insertAt(n.keys, i, append([]byte{}, key...))
+		n.values = insertAt(n.values, i, pos)
+		sep, right, err := bt.splitIfNeeded(n)
+		return sep, right, true, err
+	}
+
+	i := n.childIndex(key)
+	child, err := bt.node(n.children[i])
+	if err != nil {
+		return nil, nil, false, err
+	}
+	sep, right, inserted, err := bt.insert(child, key, pos)
+	if err != nil || right == nil {
+		return nil, nil, inserted, err
+	}
+	n.keys = insertAt(n.keys, i, sep)
+	n.children = insertAt(n.children, i+1, right.id)
+	n.dirty = true
+	sep, right, err = bt.splitIfNeeded(n)
+	return sep, right, inserted, err
+}
+
+func (bt *BPTree) splitIfNeeded(n *bpNode) ([]byte, *bpNode, error) {
+	if n.encodedSize() <= bpPageSize {
+		return nil, nil, nil
+	}
+
+	// split by the encoded size so both halves fit into a page
+	half, size, mid := n.encodedSize()/2, bpNodeHeaderSize, 0
+	for mid = 0; mid < len(n.keys)-1; mid++ {
+		size += n.entrySize(mid)
+		if size >= half {
+			break
+		}
+	}
+	if mid == 0 {
+		mid = 1
+	}
+
+	right := bt.newNode(n.leaf)
+	if n.leaf {
+		right.keys = append([][]byte{}, n.keys[mid:]...)
+		right.values = append([]data.LogPos{}, n.values[mid:]...)
+		n.keys, n.values = n.keys[:mid:mid], n.values[:mid:mid]
+
+		right.prev, right.next = n.id, n.next
+		if n.next != bpMetaPageId {
+			next, err := bt.node(n.next)
+			if err != nil {
+				return nil, nil, err
+			}
+			next.prev = right.id
+			next.dirty = true
+		}
+		n.next = right.id
+		n.dirty = true
+		return right.keys[0], right, nil
+	}
+
+	// the middle key of an internal node moves up
+	sep := n.keys[mid]
+	right.keys = append([][]byte{}, n.keys[mid+1:]...)
+	right.children = append([]uint64{}, n.children[mid+1:]...)
+	n.keys, n.children = n.keys[:mid:mid], n.children[:mid+1:mid+1]
+	n.dirty = true
+	return sep, right, nil
+}
+
+func (bt *BPTree) findLeaf(key []byte) (*bpNode, error) {
+	n, err := bt.node(bt.root)
+	for err == nil && !n.leaf {
+		n, err = bt.node(n.children[n.childIndex(key)])
+	}
+	return n, err
+}
+
+// edgeLeaf returns the leftmost or the rightmost leaf
+func (bt *BPTree) edgeLeaf(rightmost bool) (*bpNode, error) {
+	n, err := bt.node(bt.root)
+	for err == nil && !n.leaf {
+		if rightmost {
+			n, err = bt.node(n.children[len(n.children)-1])
+		} else {
+			n, err = bt.node(n.children[0])
+		}
+	}
+	return n, err
+}
+
+func (bt *BPTree) newNode(leaf bool) *bpNode {
+	n := &bpNode{id: bt.pages, leaf: leaf, dirty: true}
+	bt.pages++
+	bt.nodes[n.id] = bt.cache.PushFront(n)
+	return n
+}
+
+// node returns the page from the cache, or reads it from the file
+func (bt *BPTree) node(id uint64) (*bpNode, error) {
+	if elem, ok := bt.nodes[id]; ok {
+		bt.cache.MoveToFront(elem)
+		return elem.Value.(*bpNode), nil
+	}
+	if id == bpMetaPageId || id >= bt.pages {
+		return nil, errBPTreeCorrupted
+	}
+	buf := make([]byte, bpPageSize)
+	if _, err := bt.file.ReadAt(buf, int64(id)*bpPageSize); err != nil {
+		return nil, err
+	}
+	n, err := decodeBPNode(id, buf)
+	if err != nil {
+		return nil, err
+	}
+	bt.nodes[id] = bt.cache.PushFront(n)
+	return n, nil
+}
+
+// evict writes back and drops the least recently used pages,
+// it must only run after an operation finished modifying the nodes it holds
+func (bt *BPTree) evict() {
+	for bt.cache.Len() > bt.cacheNodes {
+		elem := bt.cache.Back()
+		n := elem.Value.(*bpNode)
+		if n.dirty {
+			if err := bt.writeNode(n); err != nil {
+				// keep the page in memory, it will be retried on the next flush
+				return
+			}
+		}
+		bt.cache.Remove(elem)
+		delete(bt.nodes, n.id)
+	}
+}
+
+func (bt *BPTree) flush() error {
+	for elem := bt.cache.Front(); elem != nil; elem = elem.Next() {
+		if n := elem.Value.(*bpNode); n.dirty {
+			if err := bt.writeNode(n); err != nil {
+				return err
+			}
+		}
+	}
+	return bt.writeMeta(false, nil)
+}
+
+func (bt *BPTree) writeNode(n *bpNode) error {
+	if _, err := bt.file.WriteAt(n.encode(), int64(n.id)*bpPageSize); err != nil {
+		return err
+	}
+	n.dirty = false
+	return nil
+}
+
+func (bt *BPTree) init() error {
+	bt.root, bt.pages, bt.count = 1, 1, 0
+	root := bt.newNode(true)
+	if err := bt.writeNode(root); err != nil {
+		return err
+	}
+	return bt.writeMeta(false, nil)
+}
+
+// meta page: magic(4) version(1) clean(1) root(8) pages(8) count(8)
+// checkpoint fid(4) checkpoint offset(8) standalone(1) txId(8)
+func (bt *BPTree) writeMeta(clean bool, checkpoint *Checkpoint) error {
+	buf := make([]byte, bpPageSize)
+	binary.LittleEndian.PutUint32(buf[0:], bpMagic)
+	buf[4] = bpVersion
+	if clean {
+		buf[5] = 1
+		binary.LittleEndian.PutUint32(buf[30:], checkpoint.Pos.Fid)
+		binary.LittleEndian.PutUint64(buf[34:], uint64(checkpoint.Pos.Offset))
+		if checkpoint.Standalone {
+			buf[42] = 1
+		}
+		binary.LittleEndian.PutUint64(buf[43:], uint64(checkpoint.TxId))
+	}
+	binary.LittleEndian.PutUint64(buf[6:], bt.root)
+	binary.LittleEndian.PutUint64(buf[14:], bt.pages)
+	binary.LittleEndian.PutUint64(buf[22:], bt.count)
+	_, err := bt.file.WriteAt(buf, 0)
+	return err
+}
+
+func (bt *BPTree) readMeta() error {
+	buf := make([]byte, bpPageSize)
+	if _, err := bt.file.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(buf[0:]) != bpMagic || buf[4] != bpVersion {
+		return errBPTreeCorrupted
+	}
+	bt.root = binary.LittleEndian.Uint64(buf[6:])
+	bt.pages = binary.LittleEndian.Uint64(buf[14:])
+	bt.count = binary.LittleEndian.Uint64(buf[22:])
+	if buf[5] == 1 {
+		bt.checkpoint = &Checkpoint{
+			Pos: data.LogPos{
+				Fid:    binary.LittleEndian.Uint32(buf[30:]),
+				Offset: int64(binary.LittleEndian.Uint64(buf[34:])),
+			},
+			Standalone: buf[42] == 1,
+			TxId:       int64(binary.LittleEndian.Uint64(buf[43:])),
+		}
+	}
+	return nil
+}
+
+// search returns the index of the first key >= key, and whether it is equal to key
+func (n *bpNode) search(key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) >= 0
+	})
+	return i, i < len(n.keys) && bytes.Equal(n.keys[i], key)
+}
+
+// childIndex returns the child of an internal node the key belongs to
+func (n *bpNode) childIndex(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) > 0
+	})
+}
+
+func (n *bpNode) entrySize(i int) int {
+	size := uvarintLen(uint64(len(n.keys[i]))) + len(n.keys[i])
+	if n.leaf {
+		return size + uvarintLen(uint64(n.values[i].Fid)) + varintLen(n.values[i].Offset)
+	}
+	return size + 8
+}
+
+func (n *bpNode) encodedSize() int {
+	size := bpNodeHeaderSize
+	for i := range n.keys {
+		size += n.entrySize(i)
+	}
+	return size
+}
+
+func (n *bpNode) encode() []byte {
+	buf := make([]byte, bpPageSize)
+	if n.leaf {
+		buf[0] = 1
+		binary.LittleEndian.PutUint64(buf[3:], n.next)
+		binary.LittleEndian.PutUint64(buf[11:], n.prev)
+	} else {
+		binary.LittleEndian.PutUint64(buf[3:], n.children[0])
+	}
+	binary.LittleEndian.PutUint16(buf[1:], uint16(len(n.keys)))
+
+	index := bpNodeHeaderSize
+	for i, key := range n.keys {
+		index += binary.PutUvarint(buf[index:], uint64(len(key)))
+		index += copy(buf[index:], key)
+		if n.leaf {
+			index += binary.PutUvarint(buf[index:], uint64(n.values[i].Fid))
+			index += binary.PutVarint(buf[index:], n.values[i].Offset)
+		} else {
+			binary.LittleEndian.PutUint64(buf[index:], n.children[i+1])
+			index += 8
+		}
+	}
+	return buf
+}
+
+func decodeBPNode(id uint64, buf []byte) (*bpNode, error) {
+	n := &bpNode{id: id, leaf: buf[0] == 1}
+	count := int(binary.LittleEndian.Uint16(buf[1:]))
+	n.keys = make([][]byte, count)
+	if n.leaf {
+		n.next = binary.LittleEndian.Uint64(buf[3:])
+		n.prev = binary.LittleEndian.Uint64(buf[11:])
+		n.values = make([]data.LogPos, count)
+	} else {
+		n.children = make([]uint64, count+1)
+		n.children[0] = binary.LittleEndian.Uint64(buf[3:])
+	}
+
+	index := bpNodeHeaderSize
+	for i := 0; i < count; i++ {
+		keySize, l := binary.Uvarint(buf[index:])
+		if l <= 0 || index+l+int(keySize) > len(buf) {
+			return nil, errBPTreeCorrupted
+		}
+		index += l
+		n.keys[i] = append([]byte{}, buf[index:index+int(keySize)]...)
+		index += int(keySize)
+		if n.leaf {
+			fid, l := binary.Uvarint(buf[index:])
+			index += l
+			offset, l := binary.Varint(buf[index:])
+			index += l
+			n.values[i] = data.LogPos{Fid: uint32(fid), Offset: offset}
+		} else {
+			n.children[i+1] = binary.LittleEndian.Uint64(buf[index:])
+			index += 8
+		}
+	}
+	return n, nil
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func uvarintLen(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+func varintLen(x int64) int {
+	ux := uint64(x) << 1
+	if x < 0 {
+		ux = ^ux
+	}
+	return uvarintLen(ux)
+}
+
+// bpTreeIterator copies one leaf at a time, and continues with its sibling when the leaf is consumed
+type bpTreeIterator struct {
+	tree    *BPTree
+	reverse bool
+
+	// entries of the current leaf, in the iteration order
+	keys   [][]byte
+	values []data.LogPos
+	index  int
+	// the leaf to continue with
+	sibling uint64
+}
+
+func newBPTreeIterator(bt *BPTree, reverse bool) *bpTreeIterator {
+	it := &bpTreeIterator{tree: bt, reverse: reverse}
+	it.Rewind()
+	return it
+}
+
+func (it *bpTreeIterator) Rewind() {
+	it.tree.mu.Lock()
+	defer it.tree.mu.Unlock()
+	defer it.tree.evict()
+
+	leaf, err := it.tree.edgeLeaf(it.reverse)
+	if err != nil {
+		it.tree.fail(err)
+		it.keys = nil
+		return
+	}
+	it.load(leaf, 0)
+}
+
+// Seek positions the iterator at the first key >= key, or the last key <= key if reversed
+func (it *bpTreeIterator) Seek(key []byte) bool {
+	it.tree.mu.Lock()
+	defer it.tree.mu.Unlock()
+	defer it.tree.evict()
+
+	leaf, err := it.tree.findLeaf(key)
+	if err != nil {
+		it.tree.fail(err)
+		it.keys = nil
+		return false
+	}
+	i, found := leaf.search(key)
+	if it.reverse {
+		// index of the last key <= key in the reversed copy
+		if found {
+			i = len(leaf.keys) - 1 - i
+		} else {
+			i = len(leaf.keys) - i
+		}
+	}
+	it.load(leaf, i)
+	return it.Valid()
+}
+
+func (it *bpTreeIterator) Next() {
+	it.index++
+	if it.index < len(it.keys) || it.sibling == bpMetaPageId {
+		return
+	}
+
+	it.tree.mu.Lock()
+	defer it.tree.mu.Unlock()
+	defer it.tree.evict()
+
+	leaf, err := it.tree.node(it.sibling)
+	if err != nil {
+		it.tree.fail(err)
+		it.keys = nil
+		return
+	}
+	it.load(leaf, 0)
+}
+
+func (it *bpTreeIterator) Valid() bool {
+	return it.index < len(it.keys)
+}
+
+func (it *bpTreeIterator) Key() []byte {
+	return it.keys[it.index]
+}
+
+func (it *bpTreeIterator) Value() *data.LogPos {
+	pos := it.values[it.index]
+	return &pos
+}
+
+func (it *bpTreeIterator) Close() {
+	it.keys, it.values = nil, nil
+}
+
+// load copies the leaf starting at index, empty leaves are skipped.
+// the caller must hold the tree lock
+func (it *bpTreeIterator) load(leaf *bpNode, index int) {
+	for {
+		it.keys = append(it.keys[:0], leaf.keys...)
+		it.values = append(it.values[:0], leaf.values...)
+		it.index = index
+		if it.reverse {
+			for i, j := 0, len(it.keys)-1; i < j; i, j = i+1, j-1 {
+				it.keys[i], it.keys[j] = it.keys[j], it.keys[i]
+				it.values[i], it.values[j] = it.values[j], it.values[i]
+			}
+			it.sibling = leaf.prev
+		} else {
+			it.sibling = leaf.next
+		}
+
+		if it.index < len(it.keys) || it.sibling == bpMetaPageId {
+			return
+		}
+		next, err := it.tree.node(it.sibling)
+		if err != nil {
+			it.keys = nil
+			return
+		}
+		leaf, index = next, 0
+	}
+}
@@ -0,0 +1,178 @@
+package meta
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/stretchr/testify/assert"
+)
+
+func bpTestKey(i int) []byte {
+	return []byte(fmt.Sprintf("bptree-key-%09d", i))
+}
+
+func bpTestPos(i int) *data.LogPos {
+	return &data.LogPos{Fid: uint32(i % 7), Offset: int64(i)}
+}
+
+func TestBPTree_Split(t *testing.T) {
+	bt, err := OpenBPTree(t.TempDir())
+	assert.Nil(t, err)
+	defer bt.Close(nil)
+
+	// inserted in reverse, so every split happens at the left edge
+	n := 10000
+	for i := n - 1; i >= 0; i-- {
+		assert.True(t, bt.Put(bpTestKey(i), bpTestPos(i)))
+	}
+	assert.Equal(t, n, bt.Count())
+
+	root, err := bt.node(bt.root)
+	assert.Nil(t, err)
+	assert.False(t, root.leaf)
+	assert.Greater(t, bt.pages, uint64(10))
+
+	for i := 0; i < n; i++ {
+		assert.Equal(t, bpTestPos(i), bt.Get(bpTestKey(i)))
+	}
+
+	// the leaves stay linked in order after the splits
+	it := bt.Iterator(false)
+	i := 0
+	for it.Rewind(); it.Valid(); it.Next() {
+		assert.Equal(t, bpTestKey(i), it.Key())
+		i++
+	}
+	assert.Equal(t, n, i)
+	it = bt.Iterator(true)
+	for it.Rewind(); it.Valid(); it.Next() {
+		i--
+		assert.Equal(t, bpTestKey(i), it.Key())
+	}
+	assert.Equal(t, 0, i)
+
+	// keys as large as MaxKeySize still leave room for a split
+	big := bytes.Repeat([]byte{'k'}, MaxKeySize)
+	for i := 0; i < 10; i++ {
+		big[0] = byte('a' + i)
+		assert.True(t, bt.Put(big, bpTestPos(i)))
+	}
+	assert.False(t, bt.Put(append(big, 'k'), bpTestPos(0)))
+	assert.Equal(t, n+10, bt.Count())
+}
+
+func TestBPTree_Evict(t *testing.T) {
+	bt, err := OpenBPTree(t.TempDir())
+	assert.Nil(t, err)
+	defer bt.Close(nil)
+	bt.cacheNodes = 8
+
+	n := 5000
+	for i := 0; i < n; i++ {
+		assert.True(t, bt.Put(bpTestKey(i), bpTestPos(i)))
+		assert.LessOrEqual(t, bt.cache.Len(), bt.cacheNodes)
+	}
+	assert.Greater(t, bt.pages, uint64(bt.cacheNodes))
+
+	// the evicted pages were written back and are read again
+	for i := 0; i < n; i += 2 {
+		assert.True(t, bt.Del(bpTestKey(i)))
+	}
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			assert.Nil(t, bt.Get(bpTestKey(i)))
+		} else {
+			assert.Equal(t, bpTestPos(i), bt.Get(bpTestKey(i)))
+		}
+	}
+	assert.LessOrEqual(t, bt.cache.Len(), bt.cacheNodes)
+	assert.Equal(t, n/2, bt.Count())
+	assert.Nil(t, bt.Err())
+}
+
+func TestBPTree_Checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	bt, err := OpenBPTree(dir)
+	assert.Nil(t, err)
+	_, ok := bt.Checkpoint()
+	assert.False(t, ok)
+
+	n := 3000
+	for i := 0; i < n; i++ {
+		assert.True(t, bt.Put(bpTestKey(i), bpTestPos(i)))
+	}
+	checkpoint := &Checkpoint{Pos: data.LogPos{Fid: 3, Offset: 1024}, Standalone: true, TxId: 42}
+	assert.Nil(t, bt.Close(checkpoint))
+
+	bt, err = OpenBPTree(dir)
+	assert.Nil(t, err)
+	cp, ok := bt.Checkpoint()
+	assert.True(t, ok)
+	assert.Equal(t, checkpoint, cp)
+	assert.Equal(t, n, bt.Count())
+	for i := 0; i < n; i++ {
+		assert.Equal(t, bpTestPos(i), bt.Get(bpTestKey(i)))
+	}
+
+	// a tree which is not closed cleanly has no checkpoint
+	assert.True(t, bt.Del(bpTestKey(0)))
+	assert.Nil(t, bt.Sync())
+	assert.Nil(t, bt.file.Close())
+	bt, err = OpenBPTree(dir)
+	assert.Nil(t, err)
+	_, ok = bt.Checkpoint()
+	assert.False(t, ok)
+
+	assert.Nil(t, bt.Reset())
+	assert.Equal(t, 0, bt.Count())
+	assert.Nil(t, bt.Get(bpTestKey(1)))
+	assert.Nil(t, bt.Close(nil))
+}
+
+func TestBPTree_Corrupted(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, public.BPTreeIndexFileName)
+
+	corrupt := func(offset int64, b []byte) {
+		bt, err := OpenBPTree(dir)
+		assert.Nil(t, err)
+		for i := 0; i < 1000; i++ {
+			assert.True(t, bt.Put(bpTestKey(i), bpTestPos(i)))
+		}
+		assert.Nil(t, bt.Close(&Checkpoint{Pos: data.LogPos{Offset: 1}}))
+
+		file, err := os.OpenFile(fileName, os.O_RDWR, 0644)
+		assert.Nil(t, err)
+		_, err = file.WriteAt(b, offset)
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+	}
+
+	// a damaged meta page drops the checkpoint and starts the tree over
+	for _, c := range []struct {
+		offset int64
+		b      []byte
+	}{
+		{0, []byte("junk")},                  // magic
+		{6, []byte{0, 0, 0, 0, 0, 0, 0, 0}},  // root is the meta page
+		{14, []byte{1, 0, 0, 0, 0, 0, 0, 0}}, // fewer pages than the root
+		{14, []byte{0, 0, 0, 0, 0, 0, 1, 0}}, // more pages than the file
+	} {
+		corrupt(c.offset, c.b)
+		bt, err := OpenBPTree(dir)
+		assert.Nil(t, err)
+		_, ok := bt.Checkpoint()
+		assert.False(t, ok)
+		assert.Equal(t, 0, bt.Count())
+		assert.Nil(t, bt.Get(bpTestKey(1)))
+		assert.True(t, bt.Put(bpTestKey(1), bpTestPos(1)))
+		assert.Equal(t, bpTestPos(1), bt.Get(bpTestKey(1)))
+		assert.Nil(t, bt.Reset())
+		assert.Nil(t, bt.Close(nil))
+	}
+}
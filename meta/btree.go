@@ -52,10 +52,27 @@ func (bt *BTree) Del(key []byte) bool {
 	return true
 }
 
+func (bt *BTree) AscendFrom(key []byte, fn func(key []byte, pos *data.LogPos) bool) {
+	bt.tree.AscendGreaterOrEqual(&Item{Key: key}, func(it btree.Item) bool {
+		return fn(it.(*Item).Key, it.(*Item).Pos)
+	})
+}
+
 func (bt *BTree) Count() int {
 	return bt.tree.Len()
 }
 
+func (bt *BTree) MemoryUsage() MemoryUsage {
+	usage := MemoryUsage{Keys: bt.tree.Len()}
+	bt.tree.Ascend(func(it btree.Item) bool {
+		usage.KeyBytes += int64(len(it.(*Item).Key))
+		return true
+	})
+	// interface in the node, Item, slice header of the key and LogPos
+	usage.Bytes = usage.KeyBytes + int64(usage.Keys)*(16+8+24+16)
+	return usage
+}
+
 type Item struct {
 	Key []byte
 	Pos *data.LogPos
@@ -0,0 +1,262 @@
+package meta
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"sync"
+	"unsafe"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/google/btree"
+)
+
+const (
+	compactMinArenaSize = 64
+	compactMaxArenaSize = 1 << 20
+	// compactMinGarbage avoids rebuilding small indexes over and over
+	compactMinGarbage = 64 << 10
+
+	// compactProbeArena marks the item used to search the tree, its key is CompactBTree.probe
+	compactProbeArena = math.MaxUint32
+)
+
+// CompactBTree packs the keys into large byte arenas and keeps the positions inline,
+// so the items contain no pointers and are not scanned by the GC.
+// The space of deleted keys is reclaimed by rebuilding the arenas once it exceeds the live keys.
+type CompactBTree struct {
+	lock *sync.Mutex
+	tree *btree.BTreeG[compactItem]
+
+	arenas   [][]byte
+	probe    []byte
+	live     int64 // bytes of the keys in the tree
+	garbage  int64 // bytes of the keys deleted or replaced
+	lastSize int
+}
+
+type compactItem struct {
+	arena  uint32
+	off    uint32
+	size   uint32
+	fid    uint32
+	offset int64
+}
+
+// NewCompactBTree Init CompactBTree struct
+func NewCompactBTree() *CompactBTree {
+	ct := &CompactBTree{lock: new(sync.Mutex)}
+	ct.tree = btree.NewG[compactItem](32, ct.less)
+	return ct
+}
+
+func (ct *CompactBTree) Put(key []byte, pos *data.LogPos) bool {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	if old, ok := ct.tree.Get(ct.probeItem(key)); ok {
+		// the key is already stored, only the position changes
+		old.fid, old.offset = pos.Fid, pos.Offset
+		ct.tree.ReplaceOrInsert(old)
+		return true
+	}
+
+	item := ct.store(key)
+	item.fid, item.offset = pos.Fid, pos.Offset
+	ct.tree.ReplaceOrInsert(item)
+	ct.live += int64(len(key))
+	return true
+}
+
+func (ct *CompactBTree) Get(key []byte) *data.LogPos {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	item, ok := ct.tree.Get(ct.probeItem(key))
+	if !ok {
+		return nil
+	}
+	return &data.LogPos{Fid: item.fid, Offset: item.offset}
+}
+
+func (ct *CompactBTree) Del(key []byte) bool {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	item, ok := ct.tree.Delete(ct.probeItem(key))
+	if !ok {
+		return false
+	}
+	ct.live -= int64(item.size)
+	ct.garbage += int64(item.size)
+	if ct.garbage > ct.live && ct.garbage >= compactMinGarbage {
+		ct.compact()
+	}
+	return true
+}
+
+// AscendFrom holds the lock of the tree, fn must not use the tree
+func (ct *CompactBTree) AscendFrom(key []byte, fn func(key []byte, pos *data.LogPos) bool) {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	ct.tree.AscendGreaterOrEqual(ct.probeItem(key), func(item compactItem) bool {
+		return fn(ct.key(item), &data.LogPos{Fid: item.fid, Offset: item.offset})
+	})
+}
+
+func (ct *CompactBTree) Count() int {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	return ct.tree.Len()
+}
+
+func (ct *CompactBTree) MemoryUsage() MemoryUsage {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	var arenaBytes int64
+	for _, arena := range ct.arenas {
+		arenaBytes += int64(cap(arena))
+	}
+	// the nodes of the btree are at least half full
+	itemBytes := int64(ct.tree.Len()) * int64(unsafe.Sizeof(compactItem{})) * 3 / 2
+	return MemoryUsage{
+		Keys:     ct.tree.Len(),
+		KeyBytes: ct.live,
+		Bytes:    arenaBytes + itemBytes,
+	}
+}
+
+func (ct *CompactBTree) Iterator(reverse bool) Iterator {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	// the arenas are append only and replaced as a whole by compact,
+	// so the iterator can keep referring to the current ones
+	items := make([]compactItem, 0, ct.tree.Len())
+	saveItemsFunc := func(item compactItem) bool {
+		items = append(items, item)
+		return true
+	}
+	if reverse {
+		ct.tree.Descend(saveItemsFunc)
+	} else {
+		ct.tree.Ascend(saveItemsFunc)
+	}
+	return &compactIterator{
+		reverse: reverse,
+		items:   items,
+		arenas:  append([][]byte{}, ct.arenas...),
+	}
+}
+
+func (ct *CompactBTree) less(a, b compactItem) bool {
+	return bytes.Compare(ct.key(a), ct.key(b)) < 0
+}
+
+func (ct *CompactBTree) key(item compactItem) []byte {
+	if item.arena == compactProbeArena {
+		return ct.probe
+	}
+	return ct.arenas[item.arena][item.off : item.off+item.size : item.off+item.size]
+}
+
+func (ct *CompactBTree) probeItem(key []byte) compactItem {
+	ct.probe = key
+	return compactItem{arena: compactProbeArena}
+}
+
+// store copies the key into the last arena, a new arena twice as large is added if it is full
+func (ct *CompactBTree) store(key []byte) compactItem {
+	last := len(ct.arenas) - 1
+	if last < 0 || cap(ct.arenas[last])-len(ct.arenas[last]) < len(key) {
+		size := ct.lastSize * 2
+		if size < compactMinArenaSize {
+			size = compactMinArenaSize
+		}
+		if size > compactMaxArenaSize {
+			size = compactMaxArenaSize
+		}
+		ct.lastSize = size
+		if size < len(key) {
+			size = len(key)
+		}
+		ct.arenas = append(ct.arenas, make([]byte, 0, size))
+		last++
+	}
+	item := compactItem{arena: uint32(last), off: uint32(len(ct.arenas[last])), size: uint32(len(key))}
+	ct.arenas[last] = append(ct.arenas[last], key...)
+	return item
+}
+
+// compact copies the live keys into new arenas and rebuilds the tree
+func (ct *CompactBTree) compact() {
+	items := make([]compactItem, 0, ct.tree.Len())
+	ct.tree.Ascend(func(item compactItem) bool {
+		items = append(items, item)
+		return true
+	})
+
+	// size the first new arena to hold all the live keys
+	oldArenas := ct.arenas
+	ct.arenas, ct.lastSize, ct.garbage = nil, int(ct.live/2), 0
+	tree := btree.NewG[compactItem](32, ct.less)
+	for _, item := range items {
+		moved := ct.store(oldArenas[item.arena][item.off : item.off+item.size])
+		moved.fid, moved.offset = item.fid, item.offset
+		tree.ReplaceOrInsert(moved)
+	}
+	ct.tree = tree
+}
+
+type compactIterator struct {
+	currentIndex int
+	reverse      bool
+	items        []compactItem
+	arenas       [][]byte
+}
+
+func (ci *compactIterator) Rewind() {
+	ci.currentIndex = 0
+}
+
+func (ci *compactIterator) Seek(key []byte) bool {
+	if ci.reverse {
+		ci.currentIndex = sort.Search(len(ci.items), func(i int) bool {
+			return bytes.Compare(ci.key(i), key) <= 0
+		})
+	} else {
+		ci.currentIndex = sort.Search(len(ci.items), func(i int) bool {
+			return bytes.Compare(ci.key(i), key) >= 0
+		})
+	}
+	return ci.Valid()
+}
+
+func (ci *compactIterator) Next() {
+	ci.currentIndex += 1
+}
+
+func (ci *compactIterator) Valid() bool {
+	return ci.currentIndex < len(ci.items)
+}
+
+func (ci *compactIterator) Key() []byte {
+	return ci.key(ci.currentIndex)
+}
+
+func (ci *compactIterator) Value() *data.LogPos {
+	item := ci.items[ci.currentIndex]
+	return &data.LogPos{Fid: item.fid, Offset: item.offset}
+}
+
+func (ci *compactIterator) Close() {
+	ci.items = nil
+	ci.arenas = nil
+}
+
+func (ci *compactIterator) key(i int) []byte {
+	item := ci.items[i]
+	return ci.arenas[item.arena][item.off : item.off+item.size : item.off+item.size]
+}
@@ -58,6 +58,18 @@ func (h *HashMap) Count() int {
 	return count
 }
 
+func (h *HashMap) MemoryUsage() MemoryUsage {
+	var usage MemoryUsage
+	h.hmap.Range(func(key, value any) bool {
+		usage.Keys++
+		usage.KeyBytes += int64(len(key.(string)))
+		return true
+	})
+	// sync.Map keeps an entry with the interfaces of key and value for every key, plus the LogPos
+	usage.Bytes = usage.KeyBytes + int64(usage.Keys)*(48+16+16)
+	return usage
+}
+
 type HashMapIterator struct {
 	currentIndex int
 	reverse      bool
@@ -10,6 +10,11 @@ const (
 	Btree MemTableType = iota
 	ART
 	HASHMAP
+	// BPlusTree keeps the index on disk, it is only used for the string index.
+	// NewMemTable returns a Btree for it, use OpenBPTree instead
+	BPlusTree
+	// CompactBtree packs the keys into arenas to reduce the memory and GC cost per key
+	CompactBtree
 )
 
 type MemTable interface {
@@ -27,6 +32,28 @@ type MemTable interface {
 
 	// Count get the num of all the data
 	Count() int
+
+	// MemoryUsage reports the memory taken by the index
+	MemoryUsage() MemoryUsage
+}
+
+// MemoryUsage is the memory taken by an index, Bytes is an estimate for all but CompactBtree
+type MemoryUsage struct {
+	// Keys the num of the entries
+	Keys int
+	// KeyBytes the size of the keys themselves
+	KeyBytes int64
+	// Bytes the total size including the positions and the structure overhead
+	Bytes int64
+}
+
+// Add returns the sum of two usages
+func (u MemoryUsage) Add(o MemoryUsage) MemoryUsage {
+	return MemoryUsage{
+		Keys:     u.Keys + o.Keys,
+		KeyBytes: u.KeyBytes + o.KeyBytes,
+		Bytes:    u.Bytes + o.Bytes,
+	}
 }
 
 func NewMemTable(typ MemTableType) MemTable {
@@ -37,11 +64,53 @@ func NewMemTable(typ MemTableType) MemTable {
 		return NewAdaptiveRadixTree()
 	case HASHMAP:
 		return NewHashMap()
+	case CompactBtree:
+		return NewCompactBTree()
 	default:
 		return NewBTree()
 	}
 }
 
+// Ascender is a MemTable which visits its keys in order from a key without creating an Iterator,
+// which copies all the keys when it is created
+type Ascender interface {
+	// AscendFrom calls fn for the keys not less than key in order until fn returns false
+	AscendFrom(key []byte, fn func(key []byte, pos *data.LogPos) bool)
+}
+
+// PersistentMemTable is a MemTable whose content survives a restart
+type PersistentMemTable interface {
+	MemTable
+
+	// Checkpoint returns the checkpoint recorded by the last Close,
+	// ok is false if it was not closed cleanly and has to be rebuilt from the log
+	Checkpoint() (checkpoint *Checkpoint, ok bool)
+
+	// Reset drops all the entries
+	Reset() error
+
+	// Sync writes all the pending changes to disk
+	Sync() error
+
+	// Err returns the I/O error which made the memTable fail, its Put and Del return false and its Get
+	// returns nil after it
+	Err() error
+
+	// Close flushes the memTable and records the checkpoint
+	Close(checkpoint *Checkpoint) error
+}
+
+// Checkpoint is the state of the log a PersistentMemTable was closed at
+type Checkpoint struct {
+	// Pos is the end of the log, the memTable is complete up to it
+	Pos data.LogPos
+	// Standalone is true if the records before Pos only matter to the memTable,
+	// the log is replayed from Pos then
+	Standalone bool
+	// TxId is the last txn id given out, the txn ids after the restart stay greater
+	TxId int64
+}
+
 // Iterator Generic index iterator interface
 type Iterator interface {
 	Rewind()
@@ -1,8 +1,10 @@
 package CouloyDB
 
 import (
+	"context"
 	"github.com/Kirov7/CouloyDB/meta"
 	"os"
+	"time"
 )
 
 type Options struct {
@@ -14,6 +16,20 @@ type Options struct {
 	MergeInterval        int64
 	EnableLuaInterpreter bool
 	SerializableLua      bool
+	// the old versions of a string are kept for GetAt and History if they are among its last RetainVersions
+	// versions or newer than RetainDuration, only the latest version is kept if both are zero
+	RetainVersions int
+	RetainDuration time.Duration
+	// the committed changes are kept in the change files for Subscribe, the oldest change files are removed
+	// once there are more than about MaxChangeBytes bytes of changes, zero keeps all of them
+	EnableChangeStream bool
+	MaxChangeBytes     int64
+	// a txn fails to write once it has appended MaxTxnRecords records or MaxTxnBytes bytes of keys and values,
+	// zero means no limit. Every record counts, also when it replaces an earlier write of the txn
+	MaxTxnRecords int
+	MaxTxnBytes   int64
+	// a txn running longer than MaxTxnLifetime is aborted, zero means it may run as long as it likes
+	MaxTxnLifetime time.Duration
 }
 
 type IteratorOptions struct {
@@ -22,10 +38,29 @@ type IteratorOptions struct {
 }
 
 type WriteBatchOptions struct {
+	// the most writes a batch keeps, zero means no limit
 	MaxBatchNum uint32
 	SyncWrites  bool
 }
 
+type TxnOptions struct {
+	ReadOnly       bool
+	IsolationLevel IsolationLevel
+	// the txn is rolled back when the context is done, if it is not finished yet
+	Context context.Context
+	// the longest LockKeys waits for a key, zero if it waits until the context is done
+	LockTimeout time.Duration
+}
+
+// RetryPolicy decides how a txn is retried on conflicts and deadlocks
+type RetryPolicy struct {
+	// zero if the txn is retried until it commits
+	MaxAttempts int
+	// the backoff before a retry doubles from MinBackoff up to MaxBackoff, and a random part of it is cut off
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
 func DefaultOptions() Options {
 	return Options{
 		DirPath:      os.TempDir() + "/couloy",
@@ -42,6 +77,21 @@ func DefaultBatchOptions() WriteBatchOptions {
 	}
 }
 
+func DefaultTxnOptions() TxnOptions {
+	return TxnOptions{
+		IsolationLevel: ReadCommitted,
+		Context:        context.Background(),
+	}
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 10,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  100 * time.Millisecond,
+	}
+}
+
 func (o *Options) SetDirPath(path string) *Options {
 	o.DirPath = path
 	return o
@@ -76,3 +126,9 @@ func (o *Options) SetSyncWrites(sync bool) *Options {
 	o.SyncWrites = sync
 	return o
 }
+
+func (o *Options) SetVersionRetention(versions int, duration time.Duration) *Options {
+	o.RetainVersions = versions
+	o.RetainDuration = duration
+	return o
+}
@@ -0,0 +1,230 @@
+package ds
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllBits      = 6
+	// hllDenseSize is the size of the registers packed in 6 bits
+	hllDenseSize = hllRegisters * hllBits / 8
+	// hllSparseMaxSize is the size above which the sparse encoding is not worth it
+	hllSparseMaxSize = 3000
+
+	hllSparse byte = iota
+	hllDense
+)
+
+var ErrInvalidHyperLogLog = errors.New("the hyperloglog is corrupted")
+
+// HyperLogLog estimates the cardinality of a set with 16384 registers, the standard error is 0.81%.
+// The registers are encoded sparse, as a list of the registers which are not zero, until the
+// dense encoding is smaller
+type HyperLogLog struct {
+	registers [hllRegisters]uint8
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{}
+}
+
+// DecodeHyperLogLog decodes the registers encoded by Encode
+func DecodeHyperLogLog(buf []byte) (*HyperLogLog, error) {
+	h := NewHyperLogLog()
+	if len(buf) == 0 {
+		return nil, ErrInvalidHyperLogLog
+	}
+	switch buf[0] {
+	case hllSparse:
+		buf = buf[1:]
+		if len(buf)%3 != 0 {
+			return nil, ErrInvalidHyperLogLog
+		}
+		for i := 0; i < len(buf); i += 3 {
+			index := binary.BigEndian.Uint16(buf[i:])
+			if index >= hllRegisters || buf[i+2] > hllQ+1 {
+				return nil, ErrInvalidHyperLogLog
+			}
+			h.registers[index] = buf[i+2]
+		}
+	case hllDense:
+		buf = buf[1:]
+		if len(buf) != hllDenseSize {
+			return nil, ErrInvalidHyperLogLog
+		}
+		for i := 0; i < hllRegisters; i += 4 {
+			// every 3 bytes hold 4 registers
+			v := uint32(buf[i/4*3])<<16 | uint32(buf[i/4*3+1])<<8 | uint32(buf[i/4*3+2])
+			h.registers[i] = uint8(v >> 18 & 0x3f)
+			h.registers[i+1] = uint8(v >> 12 & 0x3f)
+			h.registers[i+2] = uint8(v >> 6 & 0x3f)
+			h.registers[i+3] = uint8(v & 0x3f)
+		}
+	default:
+		return nil, ErrInvalidHyperLogLog
+	}
+	return h, nil
+}
+
+// Encode encodes the registers with the smaller of the sparse and the dense encoding
+func (h *HyperLogLog) Encode() []byte {
+	var used int
+	for _, r := range h.registers {
+		if r != 0 {
+			used++
+		}
+	}
+
+	if used*3 <= hllSparseMaxSize {
+		buf := make([]byte, 1, 1+used*3)
+		buf[0] = hllSparse
+		for i, r := range h.registers {
+			if r != 0 {
+				buf = append(buf, byte(i>>8), byte(i), r)
+			}
+		}
+		return buf
+	}
+
+	buf := make([]byte, 1+hllDenseSize)
+	buf[0] = hllDense
+	for i := 0; i < hllRegisters; i += 4 {
+		v := uint32(h.registers[i])<<18 | uint32(h.registers[i+1])<<12 |
+			uint32(h.registers[i+2])<<6 | uint32(h.registers[i+3])
+		buf[1+i/4*3] = byte(v >> 16)
+		buf[1+i/4*3+1] = byte(v >> 8)
+		buf[1+i/4*3+2] = byte(v)
+	}
+	return buf
+}
+
+// Add adds the element and reports whether a register is changed
+func (h *HyperLogLog) Add(element []byte) bool {
+	hash := murmurHash64A(element, 0xadc83b19)
+	index := hash & (hllRegisters - 1)
+	// the position of the first set bit, the bit q is set so it is at most q+1
+	hash = hash>>hllP | 1<<hllQ
+	count := uint8(1)
+	for hash&1 == 0 {
+		count++
+		hash >>= 1
+	}
+	if count > h.registers[index] {
+		h.registers[index] = count
+		return true
+	}
+	return false
+}
+
+// Merge sets the registers to the max of both, the result estimates the cardinality of the union
+func (h *HyperLogLog) Merge(o *HyperLogLog) {
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// Count estimates the cardinality with the estimator of Otmar Ertl,
+// which has no bias for small cardinalities, as redis does
+func (h *HyperLogLog) Count() uint64 {
+	var histogram [hllQ + 2]int
+	for _, r := range h.registers {
+		histogram[r]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for k := hllQ; k >= 1; k-- {
+		z += float64(histogram[k])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(0.5 / math.Ln2 * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A is the 64 bits MurmurHash2 by Austin Appleby,
+// the hashes must not change as the registers are persisted
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const (
+		m = 0xc6a4a7935bd1e995
+		r = 47
+	)
+	h := seed ^ uint64(len(key))*m
+
+	for len(key) >= 8 {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		key = key[8:]
+	}
+
+	switch len(key) {
+	case 7:
+		h ^= uint64(key[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(key[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(key[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(key[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(key[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(key[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(key[0])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -0,0 +1,71 @@
+package ds
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	h := NewHyperLogLog()
+	assert.Equal(t, uint64(0), h.Count())
+
+	assert.True(t, h.Add([]byte("a")))
+	assert.False(t, h.Add([]byte("a")))
+	assert.Equal(t, uint64(1), h.Count())
+
+	for _, n := range []int{100, 1000, 100000} {
+		h := NewHyperLogLog()
+		for i := 0; i < n; i++ {
+			h.Add([]byte(strconv.Itoa(i)))
+		}
+		err := math.Abs(float64(h.Count())-float64(n)) / float64(n)
+		assert.Less(t, err, 0.03, "n = %d, count = %d", n, h.Count())
+	}
+}
+
+func TestHyperLogLog_Encode(t *testing.T) {
+	h := NewHyperLogLog()
+	for i := 0; i < 100; i++ {
+		h.Add([]byte(strconv.Itoa(i)))
+	}
+	buf := h.Encode()
+	assert.Equal(t, hllSparse, buf[0])
+	decoded, err := DecodeHyperLogLog(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, h.registers, decoded.registers)
+
+	for i := 0; i < 100000; i++ {
+		h.Add([]byte(strconv.Itoa(i)))
+	}
+	buf = h.Encode()
+	assert.Equal(t, hllDense, buf[0])
+	assert.Len(t, buf, 1+hllDenseSize)
+	decoded, err = DecodeHyperLogLog(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, h.registers, decoded.registers)
+
+	_, err = DecodeHyperLogLog([]byte{hllDense, 1, 2})
+	assert.Equal(t, ErrInvalidHyperLogLog, err)
+}
+
+func TestHyperLogLog_Merge(t *testing.T) {
+	a, b := NewHyperLogLog(), NewHyperLogLog()
+	for i := 0; i < 6000; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+	}
+	for i := 4000; i < 10000; i++ {
+		b.Add([]byte(strconv.Itoa(i)))
+	}
+	a.Merge(b)
+	err := math.Abs(float64(a.Count())-10000) / 10000
+	assert.Less(t, err, 0.03)
+}
+
+func TestMurmurHash64A(t *testing.T) {
+	// the hashes are persisted in the registers, they must be stable
+	assert.Equal(t, uint64(0xf656f01eecfe400), murmurHash64A([]byte("hello"), 0xadc83b19))
+	assert.Equal(t, uint64(0x255c6b5a7ec75bc5), murmurHash64A([]byte("CouloyDB hyperloglog"), 0xadc83b19))
+}
@@ -0,0 +1,310 @@
+package ds
+
+import (
+	"math/rand"
+)
+
+const (
+	skipListMaxLevel = 32
+	skipListP        = 0.25
+)
+
+// SortedSetItem is a member of the sorted set with its score
+type SortedSetItem struct {
+	Member string
+	Score  float64
+}
+
+// SortedSet keeps the members ordered by score and then by member,
+// the skip list records the span of every link so that ranks are found in O(log n)
+type SortedSet struct {
+	dict map[string]float64
+	zsl  *skipList
+}
+
+func NewSortedSet() *SortedSet {
+	return &SortedSet{
+		dict: make(map[string]float64),
+		zsl:  newSkipList(),
+	}
+}
+
+// Add inserts the member or updates its score
+func (s *SortedSet) Add(member string, score float64) {
+	if old, ok := s.dict[member]; ok {
+		if old == score {
+			return
+		}
+		s.zsl.delete(member, old)
+	}
+	s.dict[member] = score
+	s.zsl.insert(member, score)
+}
+
+// Remove deletes the member, it returns false if the member does not exist
+func (s *SortedSet) Remove(member string) bool {
+	score, ok := s.dict[member]
+	if !ok {
+		return false
+	}
+	delete(s.dict, member)
+	s.zsl.delete(member, score)
+	return true
+}
+
+func (s *SortedSet) Score(member string) (float64, bool) {
+	score, ok := s.dict[member]
+	return score, ok
+}
+
+// Rank returns the 0-based rank of the member in ascending order
+func (s *SortedSet) Rank(member string) (int, bool) {
+	score, ok := s.dict[member]
+	if !ok {
+		return 0, false
+	}
+	return s.zsl.rank(member, score) - 1, true
+}
+
+func (s *SortedSet) Len() int {
+	return s.zsl.length
+}
+
+// Range returns the members whose 0-based rank is in [start, stop]
+func (s *SortedSet) Range(start, stop int) []SortedSetItem {
+	if start < 0 {
+		start = 0
+	}
+	if stop >= s.zsl.length {
+		stop = s.zsl.length - 1
+	}
+	if start > stop {
+		return []SortedSetItem{}
+	}
+	items := make([]SortedSetItem, 0, stop-start+1)
+	for x := s.zsl.byRank(start + 1); x != nil && len(items) < stop-start+1; x = x.level[0].forward {
+		items = append(items, SortedSetItem{Member: x.member, Score: x.score})
+	}
+	return items
+}
+
+// RangeByScore returns the members whose score is in [min, max]
+func (s *SortedSet) RangeByScore(min, max float64) []SortedSetItem {
+	items := make([]SortedSetItem, 0)
+	for x := s.zsl.firstInRange(min); x != nil && x.score <= max; x = x.level[0].forward {
+		items = append(items, SortedSetItem{Member: x.member, Score: x.score})
+	}
+	return items
+}
+
+// CountBefore returns the number of the members which sort before the member with the score,
+// the member does not need to exist
+func (s *SortedSet) CountBefore(member string, score float64) int {
+	count := 0
+	x := s.zsl.header
+	for i := s.zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(member, score) {
+			count += x.level[i].span
+			x = x.level[i].forward
+		}
+	}
+	return count
+}
+
+// ForEach visits the members in ascending order until fn returns false
+func (s *SortedSet) ForEach(fn func(member string, score float64) bool) {
+	s.forEachFrom(s.zsl.header.level[0].forward, fn)
+}
+
+// ForEachFromRank visits the members from the 0-based rank in ascending order until fn returns false
+func (s *SortedSet) ForEachFromRank(start int, fn func(member string, score float64) bool) {
+	if start < 0 {
+		start = 0
+	}
+	if start >= s.zsl.length {
+		return
+	}
+	s.forEachFrom(s.zsl.byRank(start+1), fn)
+}
+
+// ForEachFromScore visits the members whose score is not less than min in ascending order until fn returns false
+func (s *SortedSet) ForEachFromScore(min float64, fn func(member string, score float64) bool) {
+	s.forEachFrom(s.zsl.firstInRange(min), fn)
+}
+
+func (s *SortedSet) forEachFrom(x *skipListNode, fn func(member string, score float64) bool) {
+	for ; x != nil; x = x.level[0].forward {
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
+
+type skipListLevel struct {
+	forward *skipListNode
+	span    int
+}
+
+type skipListNode struct {
+	member   string
+	score    float64
+	backward *skipListNode
+	level    []skipListLevel
+}
+
+type skipList struct {
+	header *skipListNode
+	tail   *skipListNode
+	length int
+	level  int
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		header: &skipListNode{level: make([]skipListLevel, skipListMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// before reports whether the node sorts before the member with the score
+func (x *skipListNode) before(member string, score float64) bool {
+	return x.score < score || x.score == score && x.member < member
+}
+
+func (zsl *skipList) insert(member string, score float64) {
+	var (
+		update [skipListMaxLevel]*skipListNode
+		rank   [skipListMaxLevel]int
+	)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(member, score) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &skipListNode{member: member, score: score, level: make([]skipListLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+}
+
+func (zsl *skipList) delete(member string, score float64) {
+	var update [skipListMaxLevel]*skipListNode
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(member, score) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return
+	}
+
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+// rank returns the 1-based rank of an existing member
+func (zsl *skipList) rank(member string, score float64) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !x.level[i].forward.after(member, score) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// after reports whether the node sorts after the member with the score
+func (x *skipListNode) after(member string, score float64) bool {
+	return x.score > score || x.score == score && x.member > member
+}
+
+// byRank returns the node with the 1-based rank
+func (zsl *skipList) byRank(rank int) *skipListNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstInRange returns the first node whose score is not less than min
+func (zsl *skipList) firstInRange(min float64) *skipListNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.score < min {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
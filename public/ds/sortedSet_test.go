@@ -0,0 +1,88 @@
+package ds
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortedSet(t *testing.T) {
+	s := NewSortedSet()
+
+	s.Add("c", 3)
+	s.Add("a", 1)
+	s.Add("b", 2)
+	s.Add("b2", 2)
+	assert.Equal(t, 4, s.Len())
+
+	rank, ok := s.Rank("b2")
+	assert.True(t, ok)
+	assert.Equal(t, 2, rank)
+
+	// update the score moves the member
+	s.Add("a", 10)
+	rank, _ = s.Rank("a")
+	assert.Equal(t, 3, rank)
+	score, ok := s.Score("a")
+	assert.True(t, ok)
+	assert.Equal(t, float64(10), score)
+
+	assert.Equal(t, []SortedSetItem{{"b", 2}, {"b2", 2}, {"c", 3}}, s.Range(0, 2))
+	assert.Equal(t, []SortedSetItem{{"c", 3}, {"a", 10}}, s.Range(2, 100))
+	assert.Equal(t, []SortedSetItem{{"b2", 2}, {"c", 3}}, s.RangeByScore(2, 3)[1:])
+	assert.Empty(t, s.RangeByScore(4, 9))
+
+	assert.True(t, s.Remove("b"))
+	assert.False(t, s.Remove("b"))
+	_, ok = s.Rank("b")
+	assert.False(t, ok)
+	assert.Equal(t, 3, s.Len())
+}
+
+func TestSortedSet_Rank(t *testing.T) {
+	s := NewSortedSet()
+	members := make([]string, 0)
+	for i := 0; i < 1000; i++ {
+		member := strconv.Itoa(i)
+		s.Add(member, float64(i%37))
+		members = append(members, member)
+	}
+	for i := 0; i < 1000; i += 3 {
+		s.Remove(strconv.Itoa(i))
+	}
+
+	expected := make([]SortedSetItem, 0)
+	for i, member := range members {
+		if i%3 != 0 {
+			expected = append(expected, SortedSetItem{Member: member, Score: float64(i % 37)})
+		}
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		if expected[i].Score != expected[j].Score {
+			return expected[i].Score < expected[j].Score
+		}
+		return expected[i].Member < expected[j].Member
+	})
+
+	assert.Equal(t, expected, s.Range(0, s.Len()-1))
+	for i, item := range expected {
+		rank, ok := s.Rank(item.Member)
+		assert.True(t, ok)
+		assert.Equal(t, i, rank)
+	}
+	assert.Equal(t, expected[10:20], s.Range(10, 19))
+
+	for i, item := range expected {
+		assert.Equal(t, i, s.CountBefore(item.Member, item.Score))
+	}
+	// a member which does not exist sorts after the members with a lower score
+	assert.Equal(t, len(expected), s.CountBefore("", 37))
+	from := make([]SortedSetItem, 0)
+	s.ForEachFromRank(len(expected)-5, func(member string, score float64) bool {
+		from = append(from, SortedSetItem{Member: member, Score: score})
+		return true
+	})
+	assert.Equal(t, expected[len(expected)-5:], from)
+}
@@ -5,6 +5,7 @@ import "errors"
 var (
 	ErrKeyIsEmpty             = errors.New("the key can not be empty")
 	ErrKeyIsControlChar       = errors.New("the key can not be control char (ASCII 0~31 || 127)")
+	ErrKeyTooLarge            = errors.New("the key is too large")
 	ErrUpdateIndexFailed      = errors.New("update memTable failed")
 	ErrKeyNotFound            = errors.New("the key not found")
 	ErrKeyExist               = errors.New("the key already exists")
@@ -16,6 +17,31 @@ var (
 	ErrHeapEmpty              = errors.New("heap is empty")
 	ErrTxnFnEmpty             = errors.New("the txn fn is empty")
 	ErrUpdateInReadOnlyTxn    = errors.New("the read only txn can't update")
+	ErrTxnClosed              = errors.New("the txn is already committed or rolled back")
+	ErrTxnNotBegun            = errors.New("the txn is not opened by begin")
+	ErrSavepointNotFound      = errors.New("the savepoint is not found")
+	ErrDeadlock               = errors.New("the txn would wait for a lock in a deadlock")
+	ErrLockTimeout            = errors.New("timed out waiting for the lock")
 	ErrTxnArgsWrong           = errors.New("the args are wrong")
 	ErrListIsEmpty            = errors.New("the list is empty")
+	ErrListIndexOutOfRange    = errors.New("the list index is out of range")
+	ErrScoreIsNaN             = errors.New("the score is not a number")
+	ErrBitOffsetOutOfRange    = errors.New("the bit offset is out of range")
+	ErrBitOpArgsWrong         = errors.New("the bitop args are wrong")
+	ErrInvalidCoordinates     = errors.New("the coordinates are out of range")
+	ErrGeoQueryWrong          = errors.New("the geo query needs a radius or a box")
+	ErrInvalidStreamID        = errors.New("invalid stream id")
+	ErrStreamIDTooSmall       = errors.New("the stream id must be greater than the last id of the stream")
+	ErrStreamGroupExist       = errors.New("the consumer group already exists")
+	ErrStreamGroupNotFound    = errors.New("the consumer group not found")
+	ErrValueIsNotInteger      = errors.New("the value is not an integer")
+	ErrValueIsNotFloat        = errors.New("the value is not a valid float")
+	ErrIncrOverflow           = errors.New("the increment would overflow")
+	ErrVersionMismatch        = errors.New("the version of the key does not match")
+	ErrChangeStreamDisabled   = errors.New("the change stream is not enabled")
+	ErrChangesDropped         = errors.New("some of the changes after the commitTs were removed by the retention")
+	ErrTxnTooManyRecords      = errors.New("the txn has appended too many records")
+	ErrTxnTooLarge            = errors.New("the records of the txn are too large")
+	ErrTxnTooOld              = errors.New("the txn has run longer than its maximum lifetime")
+	ErrExceedMaxBatchNum      = errors.New("the batch has MaxBatchNum writes already")
 )
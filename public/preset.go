@@ -6,6 +6,10 @@ const (
 	DataFileNameSuffix    = ".cly"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
+	BPTreeIndexFileName   = "bptree-index"
+	// ChangeFileName is the change file written before the changes were split into several files
+	ChangeFileName       = "changes"
+	ChangeFileNameSuffix = ".changes"
 )
 
 var (
@@ -20,6 +24,12 @@ var (
 
 	// TX_BEGIN_KEY This key is used to mark the begin of the transaction
 	TX_BEGIN_KEY = []byte{0x12}
+
+	// TX_SAVEPOINT_KEY This key is used to mark a savepoint of the transaction
+	TX_SAVEPOINT_KEY = []byte{0x16}
+
+	// TX_ROLLBACK_TO_KEY This key is used to mark the rollback of the transaction to a savepoint
+	TX_ROLLBACK_TO_KEY = []byte{0x17}
 )
 
 var (
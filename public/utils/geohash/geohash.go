@@ -0,0 +1,173 @@
+package geohash
+
+import (
+	"math"
+	"sort"
+)
+
+// The coordinates are encoded like redis, so that the scores stay compatible
+const (
+	// Step is the number of bits of each coordinate, the hash takes 52 bits
+	// which are exactly represented by the score of a sorted set
+	Step = 26
+
+	LongitudeMin = -180.0
+	LongitudeMax = 180.0
+	LatitudeMin  = -85.05112878
+	LatitudeMax  = 85.05112878
+
+	// EarthRadius is the radius in meters used by redis
+	EarthRadius = 6372797.560856
+	mercatorMax = 20037726.37
+)
+
+// Range is the range of hashes of a cell, both inclusive
+type Range struct {
+	Min, Max uint64
+}
+
+// Valid reports whether the coordinates can be encoded
+func Valid(longitude, latitude float64) bool {
+	return longitude >= LongitudeMin && longitude <= LongitudeMax &&
+		latitude >= LatitudeMin && latitude <= LatitudeMax
+}
+
+// Encode returns the 52 bits hash of the coordinates
+func Encode(longitude, latitude float64) uint64 {
+	lonIdx, latIdx := cellOf(longitude, latitude, Step)
+	return interleave(latIdx, lonIdx)
+}
+
+// Decode returns the center of the cell of the hash
+func Decode(hash uint64) (float64, float64) {
+	latIdx, lonIdx := deinterleave(hash)
+	return cellCenter(lonIdx, latIdx, Step)
+}
+
+// Distance returns the distance in meters between two points with the haversine formula
+func Distance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lon1r := toRadians(lat1), toRadians(lon1)
+	lat2r, lon2r := toRadians(lat2), toRadians(lon2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	return 2 * EarthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// Ranges returns the ranges of hashes of the cell containing the center and its 8 neighbors,
+// the cells are large enough to contain all the points within radius meters of the center
+func Ranges(longitude, latitude, radius float64) []Range {
+	step := estimateStep(radius, latitude)
+	for ; step > 1; step-- {
+		latHeight := (LatitudeMax - LatitudeMin) / float64(uint64(1)<<step)
+		lonWidth := (LongitudeMax - LongitudeMin) / float64(uint64(1)<<step)
+		// the narrowest cell is the one farthest from the equator
+		cosLat := math.Cos(toRadians(math.Min(math.Abs(latitude)+latHeight, 90)))
+		if toRadians(latHeight)*EarthRadius >= radius && toRadians(lonWidth)*EarthRadius*cosLat >= radius {
+			break
+		}
+	}
+
+	cells := uint32(1) << step
+	lonIdx, latIdx := cellOf(longitude, latitude, step)
+	shift := 2 * (Step - step)
+	seen := make(map[uint64]struct{})
+	ranges := make([]Range, 0, 9)
+	for dLat := -1; dLat <= 1; dLat++ {
+		nLat := int64(latIdx) + int64(dLat)
+		if nLat < 0 || nLat >= int64(cells) {
+			continue
+		}
+		for dLon := -1; dLon <= 1; dLon++ {
+			// the longitude wraps around
+			nLon := (int64(lonIdx) + int64(dLon) + int64(cells)) % int64(cells)
+			hash := interleave(uint32(nLat), uint32(nLon))
+			if _, ok := seen[hash]; ok {
+				continue
+			}
+			seen[hash] = struct{}{}
+			ranges = append(ranges, Range{Min: hash << shift, Max: (hash+1)<<shift - 1})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Min < ranges[j].Min
+	})
+	return ranges
+}
+
+// estimateStep returns the step whose cells are about as large as the radius, the same as redis
+func estimateStep(radius, latitude float64) uint {
+	if radius == 0 {
+		return Step
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	// the cells are narrower near the poles
+	step -= 2
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > Step {
+		step = Step
+	}
+	return uint(step)
+}
+
+func cellOf(longitude, latitude float64, step uint) (uint32, uint32) {
+	cells := float64(uint64(1) << step)
+	lonIdx := math.Floor((longitude - LongitudeMin) / (LongitudeMax - LongitudeMin) * cells)
+	latIdx := math.Floor((latitude - LatitudeMin) / (LatitudeMax - LatitudeMin) * cells)
+	// the max coordinates belong to the last cell
+	return uint32(math.Min(lonIdx, cells-1)), uint32(math.Min(latIdx, cells-1))
+}
+
+func cellCenter(lonIdx, latIdx uint32, step uint) (float64, float64) {
+	cells := float64(uint64(1) << step)
+	lonWidth := (LongitudeMax - LongitudeMin) / cells
+	latHeight := (LatitudeMax - LatitudeMin) / cells
+	longitude := LongitudeMin + (float64(lonIdx)+0.5)*lonWidth
+	latitude := LatitudeMin + (float64(latIdx)+0.5)*latHeight
+	return math.Max(LongitudeMin, math.Min(LongitudeMax, longitude)),
+		math.Max(LatitudeMin, math.Min(LatitudeMax, latitude))
+}
+
+// interleave puts the bits of x at the even positions and the bits of y at the odd positions
+func interleave(x, y uint32) uint64 {
+	return spread(x) | spread(y)<<1
+}
+
+func deinterleave(hash uint64) (uint32, uint32) {
+	return squash(hash), squash(hash >> 1)
+}
+
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000FFFF0000FFFF
+	x = (x | x<<8) & 0x00FF00FF00FF00FF
+	x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+func squash(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x>>4) & 0x00FF00FF00FF00FF
+	x = (x | x>>8) & 0x0000FFFF0000FFFF
+	x = (x | x>>16) & 0x00000000FFFFFFFF
+	return uint32(x)
+}
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
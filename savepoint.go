@@ -0,0 +1,147 @@
+package CouloyDB
+
+import (
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/public"
+)
+
+// savepoint marks the undo log of the txn when the savepoint is set
+type savepoint struct {
+	name string
+	// the length of the undo log when the savepoint is set
+	undo int
+}
+
+// undoEntry keeps the pending write of a key which is replaced after a savepoint, the nested pending
+// writes are found by the key and the sub key
+type undoEntry struct {
+	writes       map[string]*pendingWrite
+	nestedWrites map[string]map[string]*pendingWrite
+	key          string
+	sub          string
+	// nil if the key had no pending write
+	prev *pendingWrite
+}
+
+// Savepoint sets a savepoint which the txn can be rolled back to, a savepoint with the same name is replaced
+func (txn *Txn) Savepoint(name string) error {
+	if err := txn.checkFinished(); err != nil {
+		return err
+	}
+	logRecord := &data.LogRecord{
+		Key:   encodeKeyWithTxId(public.TX_SAVEPOINT_KEY, txn.startTs),
+		Value: []byte(name),
+		Type:  data.LogRecordTxnSavepoint,
+	}
+	if _, err := txn.appendLogRecord(logRecord); err != nil {
+		return err
+	}
+
+	txn.releaseSavepoint(name)
+	txn.savepoints = append(txn.savepoints, &savepoint{name: name, undo: len(txn.undoLog)})
+	return nil
+}
+
+// RollbackTo discards the writes of the txn after the savepoint, the savepoints set after it are released.
+// The txn goes on and can be rolled back to the savepoint again
+func (txn *Txn) RollbackTo(name string) error {
+	if err := txn.checkFinished(); err != nil {
+		return err
+	}
+	i := txn.findSavepoint(name)
+	if i < 0 {
+		return public.ErrSavepointNotFound
+	}
+
+	// the records written after the savepoint are discarded when the log is loaded
+	logRecord := &data.LogRecord{
+		Key:   encodeKeyWithTxId(public.TX_ROLLBACK_TO_KEY, txn.startTs),
+		Value: []byte(name),
+		Type:  data.LogRecordTxnRollbackTo,
+	}
+	if _, err := txn.appendLogRecord(logRecord); err != nil {
+		return err
+	}
+
+	sp := txn.savepoints[i]
+	txn.savepoints = txn.savepoints[:i+1]
+	for j := len(txn.undoLog) - 1; j >= sp.undo; j-- {
+		txn.undoLog[j].undo()
+	}
+	txn.undoLog = txn.undoLog[:sp.undo]
+	return nil
+}
+
+// ReleaseSavepoint removes the savepoint, the writes after it are kept
+func (txn *Txn) ReleaseSavepoint(name string) error {
+	if !txn.releaseSavepoint(name) {
+		return public.ErrSavepointNotFound
+	}
+	return nil
+}
+
+func (txn *Txn) releaseSavepoint(name string) bool {
+	i := txn.findSavepoint(name)
+	if i < 0 {
+		return false
+	}
+	txn.savepoints = append(txn.savepoints[:i], txn.savepoints[i+1:]...)
+	if len(txn.savepoints) == 0 {
+		txn.undoLog = nil
+	}
+	return true
+}
+
+// findSavepoint returns the index of the latest savepoint with the name, -1 if there is none
+func (txn *Txn) findSavepoint(name string) int {
+	for i := len(txn.savepoints) - 1; i >= 0; i-- {
+		if txn.savepoints[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// putPendingWrite sets the pending write of the key, the write it replaces is undone by rolling back
+// to the savepoints of the txn
+func (txn *Txn) putPendingWrite(writes map[string]*pendingWrite, key string, pw *pendingWrite) {
+	if len(txn.savepoints) > 0 {
+		txn.undoLog = append(txn.undoLog, undoEntry{writes: writes, key: key, prev: writes[key]})
+	}
+	writes[key] = pw
+}
+
+// putSubPendingWrite sets the pending write of the sub key of the key, like putPendingWrite
+func (txn *Txn) putSubPendingWrite(writes map[string]map[string]*pendingWrite, key, sub string, pw *pendingWrite) {
+	subWrites, ok := writes[key]
+	if !ok {
+		subWrites = make(map[string]*pendingWrite)
+		writes[key] = subWrites
+	}
+	if len(txn.savepoints) > 0 {
+		txn.undoLog = append(txn.undoLog, undoEntry{nestedWrites: writes, key: key, sub: sub, prev: subWrites[sub]})
+	}
+	subWrites[sub] = pw
+}
+
+// undo restores the pending write replaced by the entry
+func (e undoEntry) undo() {
+	if e.nestedWrites == nil {
+		if e.prev == nil {
+			delete(e.writes, e.key)
+		} else {
+			e.writes[e.key] = e.prev
+		}
+		return
+	}
+
+	subWrites := e.nestedWrites[e.key]
+	if e.prev != nil {
+		subWrites[e.sub] = e.prev
+		return
+	}
+	delete(subWrites, e.sub)
+	if len(subWrites) == 0 {
+		delete(e.nestedWrites, e.key)
+	}
+}
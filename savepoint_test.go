@@ -0,0 +1,111 @@
+package CouloyDB
+
+import (
+	"testing"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxn_Savepoint(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		assert.Nil(t, txn.Set([]byte("row1"), []byte("ok")))
+		assert.Nil(t, txn.HSet([]byte("hash"), []byte("f1"), []byte("v1")))
+		assert.Nil(t, txn.Savepoint("row2"))
+
+		// a bad row is skipped
+		assert.Nil(t, txn.Set([]byte("row2"), []byte("bad")))
+		assert.Nil(t, txn.HSet([]byte("hash"), []byte("f1"), []byte("v2")))
+		assert.Nil(t, txn.SAdd([]byte("set"), []byte("m")))
+		assert.Nil(t, txn.RPush([]byte("list"), [][]byte{[]byte("e")}))
+		assert.Nil(t, txn.RollbackTo("row2"))
+
+		_, err := txn.Get([]byte("row2"))
+		assert.Equal(t, public.ErrKeyNotFound, err)
+		value, err := txn.HGet([]byte("hash"), []byte("f1"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), value)
+		isMember, err := txn.SIsMember([]byte("set"), []byte("m"))
+		assert.Nil(t, err)
+		assert.False(t, isMember)
+		_, err = txn.LLen([]byte("list"))
+		assert.Equal(t, public.ErrKeyNotFound, err)
+
+		// the savepoint is kept after the rollback
+		assert.Nil(t, txn.Set([]byte("row3"), []byte("ok")))
+		assert.Nil(t, txn.Savepoint("row4"))
+		assert.Nil(t, txn.RollbackTo("row2"))
+		assert.Equal(t, public.ErrSavepointNotFound, txn.RollbackTo("row4"))
+		assert.Nil(t, txn.Set([]byte("row3"), []byte("ok")))
+
+		// rolling back to an older savepoint undoes the writes after the newer ones too
+		assert.Nil(t, txn.Savepoint("inner"))
+		assert.Nil(t, txn.HSet([]byte("hash"), []byte("f2"), []byte("v2")))
+		assert.Nil(t, txn.Savepoint("innermost"))
+		assert.Nil(t, txn.HSet([]byte("hash"), []byte("f2"), []byte("v3")))
+		assert.Nil(t, txn.RollbackTo("inner"))
+		_, err = txn.HGet([]byte("hash"), []byte("f2"))
+		assert.Equal(t, public.ErrKeyNotFound, err)
+
+		assert.Nil(t, txn.ReleaseSavepoint("inner"))
+		assert.Nil(t, txn.ReleaseSavepoint("row2"))
+		assert.Equal(t, public.ErrSavepointNotFound, txn.RollbackTo("row2"))
+		return nil
+	})
+	assert.Nil(t, err)
+
+	// the savepoints of a finished txn can't be used
+	txn, err := db.Begin(DefaultTxnOptions())
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Savepoint("sp"))
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, public.ErrTxnClosed, txn.Savepoint("sp"))
+	assert.Equal(t, public.ErrTxnClosed, txn.RollbackTo("sp"))
+
+	for key, want := range map[string][]byte{"row1": []byte("ok"), "row2": nil, "row3": []byte("ok")} {
+		value, err := db.Get([]byte(key))
+		if want == nil {
+			assert.Equal(t, public.ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, want, value)
+		}
+	}
+}
+
+func TestTxn_Savepoint_Restart(t *testing.T) {
+	options := DefaultOptions()
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		assert.Nil(t, txn.Set([]byte("k1"), []byte("v1")))
+		assert.Nil(t, txn.Savepoint("sp"))
+		assert.Nil(t, txn.Set([]byte("k1"), []byte("bad")))
+		assert.Nil(t, txn.Set([]byte("k2"), []byte("bad")))
+		assert.Nil(t, txn.RollbackTo("sp"))
+		return txn.Set([]byte("k3"), []byte("v3"))
+	})
+	assert.Nil(t, err)
+
+	// the records after the savepoint are discarded when the log is loaded
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+
+	value, err := db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), value)
+	_, err = db.Get([]byte("k2"))
+	assert.Equal(t, public.ErrKeyNotFound, err)
+	value, err = db.Get([]byte("k3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), value)
+}
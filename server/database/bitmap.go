@@ -0,0 +1,177 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Kirov7/CouloyDB"
+	"github.com/Kirov7/CouloyDB/server"
+	"github.com/Kirov7/CouloyDB/server/resp/reply"
+)
+
+func init() {
+	RegisterCommand("SetBit", execSetBit, defaultFunc, 4)
+	RegisterCommand("GetBit", execGetBit, defaultFunc, 3)
+	RegisterCommand("BitCount", execBitCount, defaultFunc, -2)
+	RegisterCommand("BitPos", execBitPos, defaultFunc, -3)
+	RegisterCommand("BitOp", execBitOp, execBitOpCluster, -4)
+}
+
+// execSetBit sets or clears the bit at offset and returns the original bit
+func execSetBit(db *SingleDB, args [][]byte) reply.Reply {
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR bit offset is not an integer or out of range")
+	}
+	value, ok := parseBit(args[2])
+	if !ok {
+		return reply.MakeErrReply("ERR bit is not an integer or out of range")
+	}
+
+	var old bool
+	err = db.Txn(false, func(txn *CouloyDB.Txn) error {
+		old, err = txn.SetBit(args[0], offset, value)
+		return err
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeIntReply(bitToInt(old))
+}
+
+// execGetBit returns the bit at offset
+func execGetBit(db *SingleDB, args [][]byte) reply.Reply {
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR bit offset is not an integer or out of range")
+	}
+
+	var bit bool
+	err = db.Txn(true, func(txn *CouloyDB.Txn) error {
+		bit, err = txn.GetBit(args[0], offset)
+		return err
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeIntReply(bitToInt(bit))
+}
+
+// execBitCount counts the set bits, optionally in a range of bytes
+func execBitCount(db *SingleDB, args [][]byte) reply.Reply {
+	var start, end int64 = 0, -1
+	if len(args) == 3 {
+		var ok bool
+		if start, end, ok = parseRange(args[1], args[2]); !ok {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+	} else if len(args) != 1 {
+		return reply.MakeSyntaxErrReply()
+	}
+
+	var count int64
+	err := db.Txn(true, func(txn *CouloyDB.Txn) (err error) {
+		count, err = txn.BitCount(args[0], start, end)
+		return err
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeIntReply(count)
+}
+
+// execBitPos returns the position of the first bit set to 1 or 0, optionally in a range of bytes
+func execBitPos(db *SingleDB, args [][]byte) reply.Reply {
+	bit, ok := parseBit(args[1])
+	if !ok {
+		return reply.MakeErrReply("ERR The bit argument must be 1 or 0.")
+	}
+	var start, end int64 = 0, -1
+	switch len(args) {
+	case 2:
+	case 3:
+		if start, _, ok = parseRange(args[2], []byte("-1")); !ok {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+	case 4:
+		if start, end, ok = parseRange(args[2], args[3]); !ok {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+
+	var pos int64
+	err := db.Txn(true, func(txn *CouloyDB.Txn) (err error) {
+		pos, err = txn.BitPos(args[0], bit, start, end)
+		return err
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeIntReply(pos)
+}
+
+// execBitOp stores the result of AND, OR, XOR or NOT on the source keys in the destination key
+func execBitOp(db *SingleDB, args [][]byte) reply.Reply {
+	var op CouloyDB.BitOperation
+	switch strings.ToLower(string(args[0])) {
+	case "and":
+		op = CouloyDB.BitAnd
+	case "or":
+		op = CouloyDB.BitOr
+	case "xor":
+		op = CouloyDB.BitXor
+	case "not":
+		if len(args) != 3 {
+			return reply.MakeErrReply("ERR BITOP NOT must be called with a single source key.")
+		}
+		op = CouloyDB.BitNot
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+
+	var length int64
+	err := db.Txn(false, func(txn *CouloyDB.Txn) (err error) {
+		length, err = txn.BitOp(op, args[1], args[2:]...)
+		return err
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeIntReply(length)
+}
+
+// execBitOpCluster relays BitOp, the destination and the sources must be within the same node
+func execBitOpCluster(cluster *ClusterDatabase, c *server.Conn, args [][]byte) reply.Reply {
+	return cluster.relaySameSlot(c, args, args[2:])
+}
+
+func parseBit(arg []byte) (bool, bool) {
+	switch string(arg) {
+	case "0":
+		return false, true
+	case "1":
+		return true, true
+	}
+	return false, false
+}
+
+func parseRange(startArg, endArg []byte) (int64, int64, bool) {
+	start, err := strconv.ParseInt(string(startArg), 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err := strconv.ParseInt(string(endArg), 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func bitToInt(bit bool) int64 {
+	if bit {
+		return 1
+	}
+	return 0
+}
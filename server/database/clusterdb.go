@@ -165,6 +165,18 @@ func (cluster *ClusterDatabase) relay(peer string, c *server.Conn, args [][]byte
 	return peerClient.Send(args)
 }
 
+// relaySameSlot relays command to the peer of the keys, the keys must be within the same node
+func (cluster *ClusterDatabase) relaySameSlot(c *server.Conn, args [][]byte, keys [][]byte) reply.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	peer, _ := cluster.consistent.Get(string(keys[0]))
+	for _, key := range keys[1:] {
+		if keyPeer, _ := cluster.consistent.Get(string(key)); keyPeer != peer {
+			return reply.MakeErrReply("ERR " + cmdName + " must within one slot in cluster mode")
+		}
+	}
+	return cluster.relay(peer, c, args)
+}
+
 // broadcast broadcasts command to all node in cluster
 func (cluster *ClusterDatabase) broadcast(c *server.Conn, args [][]byte) map[string]reply.Reply {
 	result := make(map[string]reply.Reply)
@@ -19,6 +19,11 @@ func NewCouloyDict(opt CouloyDB.Options) *CouloyDict {
 	return &CouloyDict{couloy: db}
 }
 
+// DB returns the storage engine, it is used by the data types implemented in the engine
+func (cdb *CouloyDict) DB() *CouloyDB.DB {
+	return cdb.couloy
+}
+
 func (cdb *CouloyDict) Get(key string) (val []byte, exists bool) {
 	value, err := cdb.couloy.Get([]byte(key))
 	if err == public.ErrKeyNotFound {
@@ -87,13 +92,11 @@ func (cdb *CouloyDict) Keys() []string {
 }
 
 func (cdb *CouloyDict) RandomKeys(limit int) []string {
-	//TODO implement me
-	panic("implement me")
+	return byteToStringSlice(cdb.couloy.RandomKeys(limit))
 }
 
 func (cdb *CouloyDict) RandomDistinctKeys(limit int) []string {
-	//TODO implement me
-	panic("implement me")
+	return byteToStringSlice(cdb.couloy.RandomDistinctKeys(limit))
 }
 
 func (cdb *CouloyDict) Exist(key string) bool {
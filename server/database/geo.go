@@ -0,0 +1,250 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Kirov7/CouloyDB"
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/public/utils/geohash"
+	"github.com/Kirov7/CouloyDB/server/resp/reply"
+)
+
+func init() {
+	RegisterCommand("GeoAdd", execGeoAdd, defaultFunc, -5)
+	RegisterCommand("GeoPos", execGeoPos, defaultFunc, -3)
+	RegisterCommand("GeoDist", execGeoDist, defaultFunc, -4)
+	RegisterCommand("GeoSearch", execGeoSearch, defaultFunc, -7)
+}
+
+// execGeoAdd adds the locations, returns the number of members added
+func execGeoAdd(db *SingleDB, args [][]byte) reply.Reply {
+	if (len(args)-1)%3 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	locations := make([]CouloyDB.GeoLocation, 0, (len(args)-1)/3)
+	for i := 1; i < len(args); i += 3 {
+		longitude, errLon := strconv.ParseFloat(string(args[i]), 64)
+		latitude, errLat := strconv.ParseFloat(string(args[i+1]), 64)
+		if errLon != nil || errLat != nil {
+			return reply.MakeErrReply("ERR value is not a valid float")
+		}
+		locations = append(locations, CouloyDB.GeoLocation{Member: args[i+2], Longitude: longitude, Latitude: latitude})
+	}
+
+	var added int64
+	err := db.Txn(false, func(txn *CouloyDB.Txn) error {
+		for _, location := range locations {
+			if _, err := txn.ZScore(args[0], location.Member); err == public.ErrKeyNotFound {
+				added++
+			}
+		}
+		return txn.GeoAdd(args[0], locations...)
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeIntReply(added)
+}
+
+// execGeoPos returns the coordinates of the members
+func execGeoPos(db *SingleDB, args [][]byte) reply.Reply {
+	var locations []*CouloyDB.GeoLocation
+	err := db.Txn(true, func(txn *CouloyDB.Txn) (err error) {
+		locations, err = txn.GeoPos(args[0], args[1:]...)
+		return err
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+
+	replies := make([]reply.Reply, len(locations))
+	for i, location := range locations {
+		if location == nil {
+			replies[i] = reply.MakeNullBulkReply()
+			continue
+		}
+		replies[i] = reply.MakeMultiBulkReply([][]byte{
+			formatCoordinate(location.Longitude),
+			formatCoordinate(location.Latitude),
+		})
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// execGeoDist returns the distance between two members in the unit, meters by default
+func execGeoDist(db *SingleDB, args [][]byte) reply.Reply {
+	unit := 1.0
+	switch len(args) {
+	case 3:
+	case 4:
+		var ok bool
+		if unit, ok = parseGeoUnit(args[3]); !ok {
+			return reply.MakeErrReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+		}
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+
+	var dist float64
+	err := db.Txn(true, func(txn *CouloyDB.Txn) (err error) {
+		dist, err = txn.GeoDist(args[0], args[1], args[2])
+		return err
+	})
+	if err == public.ErrKeyNotFound {
+		return reply.MakeNullBulkReply()
+	}
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeBulkReply(formatDistance(dist / unit))
+}
+
+// execGeoSearch searches the members within a radius or a box, the syntax is the same as redis:
+// GEOSEARCH key FROMMEMBER member | FROMLONLAT longitude latitude BYRADIUS radius unit | BYBOX width height unit
+// [ASC | DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func execGeoSearch(db *SingleDB, args [][]byte) reply.Reply {
+	var (
+		query                           CouloyDB.GeoQuery
+		unit                            = 1.0
+		hasFrom, hasBy                  bool
+		withCoord, withDist, withHash   bool
+		errLon, errLat, errSize, errNum error
+	)
+	for i := 1; i < len(args); i++ {
+		remain := len(args) - i - 1
+		switch strings.ToLower(string(args[i])) {
+		case "frommember":
+			if hasFrom || remain < 1 {
+				return reply.MakeSyntaxErrReply()
+			}
+			query.FromMember = args[i+1]
+			hasFrom = true
+			i++
+		case "fromlonlat":
+			if hasFrom || remain < 2 {
+				return reply.MakeSyntaxErrReply()
+			}
+			query.Longitude, errLon = strconv.ParseFloat(string(args[i+1]), 64)
+			query.Latitude, errLat = strconv.ParseFloat(string(args[i+2]), 64)
+			hasFrom = true
+			i += 2
+		case "byradius":
+			if hasBy || remain < 2 {
+				return reply.MakeSyntaxErrReply()
+			}
+			query.Radius, errSize = strconv.ParseFloat(string(args[i+1]), 64)
+			unit, hasBy = parseGeoUnit(args[i+2])
+			if !hasBy || query.Radius <= 0 {
+				return reply.MakeErrReply("ERR radius must be a positive number with a unit of M, KM, FT, MI")
+			}
+			i += 2
+		case "bybox":
+			if hasBy || remain < 3 {
+				return reply.MakeSyntaxErrReply()
+			}
+			var errHeight error
+			query.Width, errSize = strconv.ParseFloat(string(args[i+1]), 64)
+			query.Height, errHeight = strconv.ParseFloat(string(args[i+2]), 64)
+			unit, hasBy = parseGeoUnit(args[i+3])
+			if !hasBy || errHeight != nil || query.Width <= 0 || query.Height <= 0 {
+				return reply.MakeErrReply("ERR width and height must be positive numbers with a unit of M, KM, FT, MI")
+			}
+			i += 3
+		case "asc":
+			query.Desc = false
+		case "desc":
+			query.Desc = true
+		case "count":
+			if remain < 1 {
+				return reply.MakeSyntaxErrReply()
+			}
+			query.Count, errNum = strconv.Atoi(string(args[i+1]))
+			if errNum != nil || query.Count <= 0 {
+				return reply.MakeErrReply("ERR COUNT must be > 0")
+			}
+			i++
+			// the nearest members are always returned, ANY is accepted for compatibility
+			if remain > 1 && strings.ToLower(string(args[i+1])) == "any" {
+				i++
+			}
+		case "withcoord":
+			withCoord = true
+		case "withdist":
+			withDist = true
+		case "withhash":
+			withHash = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if !hasFrom || !hasBy {
+		return reply.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT and one of BYRADIUS or BYBOX can be specified")
+	}
+	if errLon != nil || errLat != nil || errSize != nil {
+		return reply.MakeErrReply("ERR value is not a valid float")
+	}
+	query.Radius, query.Width, query.Height = query.Radius*unit, query.Width*unit, query.Height*unit
+
+	var results []CouloyDB.GeoResult
+	err := db.Txn(true, func(txn *CouloyDB.Txn) (err error) {
+		results, err = txn.GeoSearch(args[0], query)
+		return err
+	})
+	if err == public.ErrKeyNotFound {
+		return reply.MakeErrReply("ERR could not decode requested zset member")
+	}
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+
+	if !withCoord && !withDist && !withHash {
+		members := make([][]byte, len(results))
+		for i, result := range results {
+			members[i] = result.Member
+		}
+		return reply.MakeMultiBulkReply(members)
+	}
+
+	replies := make([]reply.Reply, len(results))
+	for i, result := range results {
+		item := []reply.Reply{reply.MakeBulkReply(result.Member)}
+		if withDist {
+			item = append(item, reply.MakeBulkReply(formatDistance(result.Distance/unit)))
+		}
+		if withHash {
+			item = append(item, reply.MakeIntReply(int64(geohash.Encode(result.Longitude, result.Latitude))))
+		}
+		if withCoord {
+			item = append(item, reply.MakeMultiBulkReply([][]byte{
+				formatCoordinate(result.Longitude),
+				formatCoordinate(result.Latitude),
+			}))
+		}
+		replies[i] = reply.MakeMultiRawReply(item)
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// parseGeoUnit returns the number of meters of the unit
+func parseGeoUnit(arg []byte) (float64, bool) {
+	switch strings.ToLower(string(arg)) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+	return 0, false
+}
+
+func formatDistance(dist float64) []byte {
+	return []byte(strconv.FormatFloat(dist, 'f', 4, 64))
+}
+
+func formatCoordinate(coordinate float64) []byte {
+	return []byte(strconv.FormatFloat(coordinate, 'f', 17, 64))
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"github.com/Kirov7/CouloyDB"
+	"github.com/Kirov7/CouloyDB/server"
+	"github.com/Kirov7/CouloyDB/server/resp/reply"
+)
+
+func init() {
+	RegisterCommand("PFAdd", execPFAdd, defaultFunc, -2)
+	RegisterCommand("PFCount", execPFCount, execPFCluster, -2)
+	RegisterCommand("PFMerge", execPFMerge, execPFCluster, -2)
+}
+
+// execPFAdd adds the elements to the HyperLogLog, returns 1 if the estimated cardinality may have changed
+func execPFAdd(db *SingleDB, args [][]byte) reply.Reply {
+	var changed bool
+	err := db.Txn(false, func(txn *CouloyDB.Txn) (err error) {
+		changed, err = txn.PFAdd(args[0], args[1:]...)
+		return err
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeIntReply(bitToInt(changed))
+}
+
+// execPFCount returns the estimated cardinality of the union of the HyperLogLogs
+func execPFCount(db *SingleDB, args [][]byte) reply.Reply {
+	var count uint64
+	err := db.Txn(true, func(txn *CouloyDB.Txn) (err error) {
+		count, err = txn.PFCount(args...)
+		return err
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeIntReply(int64(count))
+}
+
+// execPFMerge merges the HyperLogLogs of the source keys into the destination key
+func execPFMerge(db *SingleDB, args [][]byte) reply.Reply {
+	err := db.Txn(false, func(txn *CouloyDB.Txn) error {
+		return txn.PFMerge(args[0], args[1:]...)
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeOkReply()
+}
+
+// execPFCluster relays the commands on several HyperLogLogs, the keys must be within the same node
+func execPFCluster(cluster *ClusterDatabase, c *server.Conn, args [][]byte) reply.Reply {
+	return cluster.relaySameSlot(c, args, args[1:])
+}
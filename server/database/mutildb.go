@@ -83,6 +83,8 @@ type SingleDB struct {
 	index int
 	// key -> DataEntity
 	data dict.Dict
+	// engine stores the data types implemented in the storage engine
+	engine *CouloyDB.DB
 }
 
 // ExecFunc is interface for command executor
@@ -91,8 +93,10 @@ type ExecFunc func(db *SingleDB, args [][]byte) reply.Reply
 
 // NewSingleDB create single DB instance
 func NewSingleDB(opt CouloyDB.Options) *SingleDB {
+	data := dict.NewCouloyDict(opt)
 	db := &SingleDB{
-		data: dict.NewCouloyDict(opt),
+		data:   data,
+		engine: data.DB(),
 	}
 	return db
 }
@@ -175,6 +179,16 @@ func (db *SingleDB) Removes(keys ...string) (deleted int) {
 	return deleted
 }
 
+// Txn executes fn in a serializable transaction of the storage engine
+func (db *SingleDB) Txn(readOnly bool, fn func(txn *CouloyDB.Txn) error) error {
+	return db.engine.SerialTransaction(readOnly, fn)
+}
+
+// makeEngineErrReply converts an error of the storage engine to a reply
+func makeEngineErrReply(err error) reply.ErrorReply {
+	return reply.MakeErrReply("ERR " + err.Error())
+}
+
 // Flush clean database
 func (db *SingleDB) Flush() {
 	db.data.Clear()
@@ -0,0 +1,485 @@
+package database
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kirov7/CouloyDB"
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/server"
+	"github.com/Kirov7/CouloyDB/server/resp/reply"
+)
+
+func init() {
+	RegisterCommand("XAdd", execXAdd, defaultFunc, -5)
+	RegisterCommand("XRange", execXRange, defaultFunc, -4)
+	RegisterCommand("XRevRange", execXRevRange, defaultFunc, -4)
+	RegisterCommand("XLen", execXLen, defaultFunc, 2)
+	RegisterCommand("XTrim", execXTrim, defaultFunc, -4)
+	RegisterCommand("XGroup", execXGroup, execXGroupCluster, -4)
+	RegisterCommand("XReadGroup", execXReadGroup, execXReadGroupCluster, -7)
+	RegisterCommand("XAck", execXAck, defaultFunc, -4)
+	RegisterCommand("XPending", execXPending, defaultFunc, -3)
+	RegisterCommand("XClaim", execXClaim, defaultFunc, -6)
+}
+
+// execXAdd appends an entry and returns its id: XADD key [MAXLEN [=|~] count] *|id field value [field value ...]
+func execXAdd(db *SingleDB, args [][]byte) reply.Reply {
+	maxLen := -1
+	i := 1
+	if strings.ToLower(string(args[i])) == "maxlen" {
+		i++
+		if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+			i++
+		}
+		if i >= len(args) {
+			return reply.MakeSyntaxErrReply()
+		}
+		var err error
+		if maxLen, err = strconv.Atoi(string(args[i])); err != nil || maxLen < 0 {
+			return reply.MakeErrReply("ERR The MAXLEN argument must be >= 0.")
+		}
+		i++
+	}
+	if i >= len(args) || (len(args)-i-1)%2 != 0 || len(args)-i-1 == 0 {
+		return reply.MakeArgNumErrReply("xadd")
+	}
+
+	var (
+		id     CouloyDB.StreamID
+		autoID = string(args[i]) == "*"
+	)
+	if !autoID {
+		var err error
+		if id, err = CouloyDB.ParseStreamID(string(args[i])); err != nil {
+			return makeStreamIDErrReply()
+		}
+	}
+
+	fields := args[i+1:]
+	err := db.Txn(false, func(txn *CouloyDB.Txn) (err error) {
+		if autoID {
+			id, err = txn.XAdd(args[0], fields...)
+		} else {
+			err = txn.XAddWithID(args[0], id, fields...)
+		}
+		if err != nil || maxLen < 0 {
+			return err
+		}
+		_, err = txn.XTrim(args[0], maxLen)
+		return err
+	})
+	if err == public.ErrStreamIDTooSmall {
+		return reply.MakeErrReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeBulkReply([]byte(id.String()))
+}
+
+// execXRange returns the entries in a range of ids: XRANGE key start end [COUNT count]
+func execXRange(db *SingleDB, args [][]byte) reply.Reply {
+	return execStreamRange(db, args, false)
+}
+
+// execXRevRange returns the entries in a range of ids in reverse order: XREVRANGE key end start [COUNT count]
+func execXRevRange(db *SingleDB, args [][]byte) reply.Reply {
+	return execStreamRange(db, args, true)
+}
+
+func execStreamRange(db *SingleDB, args [][]byte, reverse bool) reply.Reply {
+	startArg, endArg := args[1], args[2]
+	if reverse {
+		startArg, endArg = args[2], args[1]
+	}
+	start, ok := parseStreamBound(startArg, CouloyDB.MinStreamID)
+	if !ok {
+		return makeStreamIDErrReply()
+	}
+	end, ok := parseStreamBound(endArg, CouloyDB.MaxStreamID)
+	if !ok {
+		return makeStreamIDErrReply()
+	}
+	count := 0
+	switch len(args) {
+	case 3:
+	case 5:
+		var err error
+		if strings.ToLower(string(args[3])) != "count" {
+			return reply.MakeSyntaxErrReply()
+		}
+		if count, err = strconv.Atoi(string(args[4])); err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if count <= 0 {
+			return &reply.EmptyMultiBulkReply{}
+		}
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+
+	var entries []CouloyDB.StreamEntry
+	err := db.Txn(true, func(txn *CouloyDB.Txn) (err error) {
+		if reverse {
+			entries, err = txn.XRevRange(args[0], end, start, count)
+		} else {
+			entries, err = txn.XRange(args[0], start, end, count)
+		}
+		return err
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return makeStreamEntriesReply(entries)
+}
+
+// execXLen returns the number of entries
+func execXLen(db *SingleDB, args [][]byte) reply.Reply {
+	var length int
+	err := db.Txn(true, func(txn *CouloyDB.Txn) (err error) {
+		length, err = txn.XLen(args[0])
+		return err
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeIntReply(int64(length))
+}
+
+// execXTrim removes the oldest entries and returns the number of entries removed: XTRIM key MAXLEN [=|~] count
+func execXTrim(db *SingleDB, args [][]byte) reply.Reply {
+	if strings.ToLower(string(args[1])) != "maxlen" {
+		return reply.MakeSyntaxErrReply()
+	}
+	countArg := args[2]
+	if len(args) == 4 && (string(args[2]) == "=" || string(args[2]) == "~") {
+		countArg = args[3]
+	} else if len(args) != 3 {
+		return reply.MakeSyntaxErrReply()
+	}
+	maxLen, err := strconv.Atoi(string(countArg))
+	if err != nil || maxLen < 0 {
+		return reply.MakeErrReply("ERR The MAXLEN argument must be >= 0.")
+	}
+
+	var removed int
+	err = db.Txn(false, func(txn *CouloyDB.Txn) (err error) {
+		removed, err = txn.XTrim(args[0], maxLen)
+		return err
+	})
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeIntReply(int64(removed))
+}
+
+// execXGroup creates a consumer group: XGROUP CREATE key group id|$
+func execXGroup(db *SingleDB, args [][]byte) reply.Reply {
+	if strings.ToLower(string(args[0])) != "create" || len(args) != 4 {
+		return reply.MakeErrReply("ERR unknown subcommand or wrong number of arguments, only CREATE is supported")
+	}
+	var start *CouloyDB.StreamID
+	if string(args[3]) != "$" {
+		id, err := CouloyDB.ParseStreamID(string(args[3]))
+		if err != nil {
+			return makeStreamIDErrReply()
+		}
+		start = &id
+	}
+
+	err := db.Txn(false, func(txn *CouloyDB.Txn) error {
+		return txn.XGroupCreate(args[1], args[2], start)
+	})
+	if err == public.ErrKeyNotFound {
+		return reply.MakeErrReply("ERR The XGROUP subcommand requires the key to exist")
+	}
+	if err == public.ErrStreamGroupExist {
+		return reply.MakeErrReply("BUSYGROUP Consumer Group name already exists")
+	}
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeOkReply()
+}
+
+// execXGroupCluster relays XGroup to the node of the key after the subcommand
+func execXGroupCluster(cluster *ClusterDatabase, c *server.Conn, args [][]byte) reply.Reply {
+	return cluster.relaySameSlot(c, args, args[2:3])
+}
+
+// execXReadGroup delivers the entries to a consumer, the pending entries of the consumer
+// are returned instead if the id is not ">":
+// XREADGROUP GROUP group consumer [COUNT count] STREAMS key [key ...] id [id ...]
+func execXReadGroup(db *SingleDB, args [][]byte) reply.Reply {
+	group, consumer, count, keys, ids, errReply := parseXReadGroup(args)
+	if errReply != nil {
+		return errReply
+	}
+
+	replies := make([]reply.Reply, 0, len(keys))
+	err := db.Txn(false, func(txn *CouloyDB.Txn) error {
+		for i, key := range keys {
+			var (
+				entries []CouloyDB.StreamEntry
+				err     error
+			)
+			if string(ids[i]) == ">" {
+				entries, err = txn.XReadGroup(key, group, consumer, count)
+			} else {
+				after, parseErr := CouloyDB.ParseStreamID(string(ids[i]))
+				if parseErr != nil {
+					return parseErr
+				}
+				entries, err = txn.XReadGroupPending(key, group, consumer, after, count)
+			}
+			if err != nil {
+				return err
+			}
+			if len(entries) > 0 || string(ids[i]) != ">" {
+				replies = append(replies, reply.MakeMultiRawReply([]reply.Reply{
+					reply.MakeBulkReply(key),
+					makeStreamEntriesReply(entries),
+				}))
+			}
+		}
+		return nil
+	})
+	if err == public.ErrInvalidStreamID {
+		return makeStreamIDErrReply()
+	}
+	if err == public.ErrStreamGroupNotFound {
+		return reply.MakeErrReply("NOGROUP No such key or consumer group in XREADGROUP with GROUP option")
+	}
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	if len(replies) == 0 {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// execXReadGroupCluster relays XReadGroup, the streams must be within the same node
+func execXReadGroupCluster(cluster *ClusterDatabase, c *server.Conn, args [][]byte) reply.Reply {
+	_, _, _, keys, _, errReply := parseXReadGroup(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	return cluster.relaySameSlot(c, args, keys)
+}
+
+func parseXReadGroup(args [][]byte) (group, consumer []byte, count int, keys, ids [][]byte, errReply reply.Reply) {
+	if strings.ToLower(string(args[0])) != "group" {
+		return nil, nil, 0, nil, nil, reply.MakeSyntaxErrReply()
+	}
+	group, consumer = args[1], args[2]
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "count":
+			if i+1 >= len(args) {
+				return nil, nil, 0, nil, nil, reply.MakeSyntaxErrReply()
+			}
+			var err error
+			if count, err = strconv.Atoi(string(args[i+1])); err != nil {
+				return nil, nil, 0, nil, nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			i++
+		case "streams":
+			streams := args[i+1:]
+			if len(streams) == 0 || len(streams)%2 != 0 {
+				return nil, nil, 0, nil, nil, reply.MakeErrReply("ERR Unbalanced 'xreadgroup' list of streams: " +
+					"for each stream key an ID or '>' must be specified.")
+			}
+			return group, consumer, count, streams[:len(streams)/2], streams[len(streams)/2:], nil
+		default:
+			return nil, nil, 0, nil, nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return nil, nil, 0, nil, nil, reply.MakeSyntaxErrReply()
+}
+
+// execXAck acknowledges the entries and returns the number of entries which were pending: XACK key group id [id ...]
+func execXAck(db *SingleDB, args [][]byte) reply.Reply {
+	ids, ok := parseStreamIDs(args[2:])
+	if !ok {
+		return makeStreamIDErrReply()
+	}
+
+	var acked int
+	err := db.Txn(false, func(txn *CouloyDB.Txn) (err error) {
+		acked, err = txn.XAck(args[0], args[1], ids...)
+		return err
+	})
+	if err == public.ErrStreamGroupNotFound {
+		return reply.MakeIntReply(0)
+	}
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return reply.MakeIntReply(int64(acked))
+}
+
+// execXPending returns the summary of the pending entries of a group, or the pending entries in a range:
+// XPENDING key group [start end count [consumer]]
+func execXPending(db *SingleDB, args [][]byte) reply.Reply {
+	start, end, count := CouloyDB.MinStreamID, CouloyDB.MaxStreamID, 0
+	var consumer []byte
+	extended := len(args) > 2
+	if extended {
+		if len(args) != 5 && len(args) != 6 {
+			return reply.MakeSyntaxErrReply()
+		}
+		var ok bool
+		if start, ok = parseStreamBound(args[2], CouloyDB.MinStreamID); !ok {
+			return makeStreamIDErrReply()
+		}
+		if end, ok = parseStreamBound(args[3], CouloyDB.MaxStreamID); !ok {
+			return makeStreamIDErrReply()
+		}
+		var err error
+		if count, err = strconv.Atoi(string(args[4])); err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if count <= 0 {
+			return &reply.EmptyMultiBulkReply{}
+		}
+		if len(args) == 6 {
+			consumer = args[5]
+		}
+	}
+
+	var pending []CouloyDB.StreamPending
+	err := db.Txn(true, func(txn *CouloyDB.Txn) (err error) {
+		pending, err = txn.XPending(args[0], args[1], start, end, count, consumer)
+		return err
+	})
+	if err == public.ErrStreamGroupNotFound {
+		return reply.MakeErrReply("NOGROUP No such key '" + string(args[0]) + "' or consumer group '" + string(args[1]) + "'")
+	}
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+
+	if extended {
+		replies := make([]reply.Reply, len(pending))
+		for i, p := range pending {
+			replies[i] = reply.MakeMultiRawReply([]reply.Reply{
+				reply.MakeBulkReply([]byte(p.ID.String())),
+				reply.MakeBulkReply(p.Consumer),
+				reply.MakeIntReply(p.Idle.Milliseconds()),
+				reply.MakeIntReply(p.DeliveryCount),
+			})
+		}
+		return reply.MakeMultiRawReply(replies)
+	}
+
+	if len(pending) == 0 {
+		return reply.MakeMultiRawReply([]reply.Reply{
+			reply.MakeIntReply(0),
+			reply.MakeNullBulkReply(),
+			reply.MakeNullBulkReply(),
+			reply.MakeNullBulkReply(),
+		})
+	}
+	// the consumers are sorted by name like redis
+	counts := make(map[string]int64)
+	for _, p := range pending {
+		counts[string(p.Consumer)]++
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	consumers := make([]reply.Reply, len(names))
+	for i, name := range names {
+		consumers[i] = reply.MakeMultiBulkReply([][]byte{[]byte(name), []byte(strconv.FormatInt(counts[name], 10))})
+	}
+	return reply.MakeMultiRawReply([]reply.Reply{
+		reply.MakeIntReply(int64(len(pending))),
+		reply.MakeBulkReply([]byte(pending[0].ID.String())),
+		reply.MakeBulkReply([]byte(pending[len(pending)-1].ID.String())),
+		reply.MakeMultiRawReply(consumers),
+	})
+}
+
+// execXClaim transfers the pending entries idle for at least min-idle milliseconds to a consumer:
+// XCLAIM key group consumer min-idle id [id ...]
+func execXClaim(db *SingleDB, args [][]byte) reply.Reply {
+	minIdle, err := strconv.ParseInt(string(args[3]), 10, 64)
+	if err != nil || minIdle < 0 {
+		return reply.MakeErrReply("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	ids, ok := parseStreamIDs(args[4:])
+	if !ok {
+		return makeStreamIDErrReply()
+	}
+
+	var entries []CouloyDB.StreamEntry
+	err = db.Txn(false, func(txn *CouloyDB.Txn) (err error) {
+		entries, err = txn.XClaim(args[0], args[1], args[2], time.Duration(minIdle)*time.Millisecond, ids...)
+		return err
+	})
+	if err == public.ErrStreamGroupNotFound {
+		return reply.MakeErrReply("NOGROUP No such key '" + string(args[0]) + "' or consumer group '" + string(args[1]) + "'")
+	}
+	if err != nil {
+		return makeEngineErrReply(err)
+	}
+	return makeStreamEntriesReply(entries)
+}
+
+// parseStreamBound parses an id of a range, "-" and "+" are the smallest and the greatest ids.
+// A bound without the sequence number covers the whole millisecond
+func parseStreamBound(arg []byte, unlimited CouloyDB.StreamID) (CouloyDB.StreamID, bool) {
+	switch string(arg) {
+	case "-":
+		return CouloyDB.MinStreamID, true
+	case "+":
+		return CouloyDB.MaxStreamID, true
+	}
+	id, err := CouloyDB.ParseStreamID(string(arg))
+	if err != nil {
+		return id, false
+	}
+	if unlimited == CouloyDB.MaxStreamID && !strings.Contains(string(arg), "-") {
+		id.Seq = CouloyDB.MaxStreamID.Seq
+	}
+	return id, true
+}
+
+func parseStreamIDs(args [][]byte) ([]CouloyDB.StreamID, bool) {
+	ids := make([]CouloyDB.StreamID, len(args))
+	for i, arg := range args {
+		id, err := CouloyDB.ParseStreamID(string(arg))
+		if err != nil {
+			return nil, false
+		}
+		ids[i] = id
+	}
+	return ids, true
+}
+
+// makeStreamEntriesReply returns the entries as redis does, an array of id and field value pairs,
+// the fields of the entries removed from the stream are nil
+func makeStreamEntriesReply(entries []CouloyDB.StreamEntry) reply.Reply {
+	replies := make([]reply.Reply, len(entries))
+	for i, entry := range entries {
+		var fields reply.Reply = reply.MakeNullBulkReply()
+		if entry.Fields != nil {
+			fields = reply.MakeMultiBulkReply(entry.Fields)
+		}
+		replies[i] = reply.MakeMultiRawReply([]reply.Reply{
+			reply.MakeBulkReply([]byte(entry.ID.String())),
+			fields,
+		})
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+func makeStreamIDErrReply() reply.Reply {
+	return reply.MakeErrReply("ERR Invalid stream ID specified as stream command argument")
+}
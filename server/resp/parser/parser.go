@@ -3,9 +3,9 @@ package parser
 import (
 	"bufio"
 	"errors"
-	"fmt"
 	"github.com/Kirov7/CouloyDB/server/resp/reply"
 	"io"
+	"log"
 	"runtime/debug"
 	"strconv"
 	"strings"
@@ -39,7 +39,7 @@ func (s *readState) finished() bool {
 func parse0(reader io.Reader, ch chan<- *Payload) {
 	defer func() {
 		if err := recover(); err != nil {
-			fmt.Errorf(string(debug.Stack()))
+			log.Println(string(debug.Stack()))
 		}
 	}()
 	bufReader := bufio.NewReader(reader)
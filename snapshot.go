@@ -0,0 +1,468 @@
+package CouloyDB
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/meta"
+	"github.com/Kirov7/CouloyDB/public"
+)
+
+// A serializable txn reads the db as it was at its startTs. A committed txn keeps the positions its records
+// replaced in the indexes, its before images, as long as a txn started before its commit is active.
+// The txn reads a record from the before image of the first txn committed after its start which wrote it,
+// or from the index if there is none. The writes outside the txns are kept like the committed txns.
+// Streams are always read from the indexes, a serializable txn which reads a stream written after its start
+// fails with ErrTransactionConflict instead.
+//
+// A serializable txn also keeps the keys it read, it conflicts with a txn committed after its start which
+// wrote one of them. The prefixes it scanned are kept as well, it conflicts with a txn which wrote a string
+// with one of them, including the strings it did not see. A read only txn which only read from its snapshot
+// can't conflict.
+//
+// A key locked by the txn is read as it was when the txn got the lock instead, and the txns committed
+// before that don't conflict with the txn on the key
+
+// snapshotTypes are the data types read from the snapshot by the serializable txns
+var snapshotTypes = map[data.DataType]bool{
+	data.String:      true,
+	data.Hash:        true,
+	data.List:        true,
+	data.ListMeta:    true,
+	data.Set:         true,
+	data.ZSet:        true,
+	data.Bitmap:      true,
+	data.HyperLogLog: true,
+	data.Expire:      true,
+}
+
+// typedKey returns the data type followed by the key
+func typedKey(typ data.DataType, key []byte) string {
+	return string(encodeExpireKey(typ, key))
+}
+
+// userKey returns the key of a typed key, the key of an expiration is typed twice
+func userKey(tk string) string {
+	if data.DataType(tk[0]) == data.Expire {
+		return tk[2:]
+	}
+	return tk[1:]
+}
+
+// trackRead adds the key to the read set of a serializable txn
+func (txn *Txn) trackRead(typ data.DataType, key []byte) {
+	if txn.isolationLevel == Serializable {
+		txn.readSet[typedKey(typ, key)] = struct{}{}
+	}
+}
+
+// publishStrWrite adds a write of the strings outside the txns to the committed txns, so the txns which read
+// or wrote the strings conflict with it and the serializable txns started before it read their before images.
+// It is called with the oracle and the string index locked, before the index is updated
+func (db *DB) publishStrWrite(commitTs int64, keys ...[]byte) {
+	o := db.oracle
+	o.txnsMu.Lock()
+	defer o.txnsMu.Unlock()
+	o.cleanupCommitTxn()
+	// the txns started after the write see it in the index
+	if o.activeTxnHeap.Len() == 0 {
+		return
+	}
+
+	committed := &committedTxn{
+		commitTs:     commitTs,
+		writes:       make(map[string]map[string]struct{}, len(keys)),
+		beforeImages: make(map[string]map[string]*data.LogPos, len(keys)),
+	}
+	for _, key := range keys {
+		tk := typedKey(data.String, key)
+		committed.writes[tk] = map[string]struct{}{"": {}}
+		committed.beforeImages[tk] = map[string]*data.LogPos{"": db.index.getStrIndex().Get(key)}
+	}
+	o.committedTxns = append(o.committedTxns, committed)
+}
+
+// checkStreamSnapshot returns ErrTransactionConflict if a txn committed after the start of a serializable txn
+// wrote the stream, it is called after the stream is read from the index
+func (txn *Txn) checkStreamSnapshot(key []byte) error {
+	if txn.isolationLevel != Serializable {
+		return nil
+	}
+	o := txn.db.oracle
+	o.txnsMu.Lock()
+	defer o.txnsMu.Unlock()
+
+	tk := typedKey(data.Stream, key)
+	readTs := txn.conflictTs(string(key))
+	for _, committedTxn := range o.committedTxns {
+		if _, ok := committedTxn.writes[tk]; ok && committedTxn.commitTs > readTs {
+			return public.ErrTransactionConflict
+		}
+	}
+	return nil
+}
+
+// trackRange adds the prefix of the strings scanned by a serializable txn to its range reads
+func (txn *Txn) trackRange(prefix []byte) {
+	if txn.isolationLevel == Serializable {
+		txn.rangeReads = append(txn.rangeReads, string(prefix))
+	}
+}
+
+// committedPos returns the position of a committed record as seen by the txn, get reads it from the index
+func (txn *Txn) committedPos(typ data.DataType, key []byte, sub string, get func() *data.LogPos) *data.LogPos {
+	txn.trackRead(typ, key)
+	lock := txn.db.getIndexLockByType(typ)
+	lock.RLock()
+	pos := get()
+	lock.RUnlock()
+
+	// the index must be read before the before images, a txn committed in between is published first
+	if before, ok := txn.snapshotBefore(typ, key)[sub]; ok {
+		return before
+	}
+	return pos
+}
+
+// committedPositions returns the positions of the committed records of the key as seen by the txn,
+// get returns the index of the key
+func (txn *Txn) committedPositions(typ data.DataType, key []byte, get func() (meta.MemTable, bool)) map[string]*data.LogPos {
+	txn.trackRead(typ, key)
+	positions := make(map[string]*data.LogPos)
+	lock := txn.db.getIndexLockByType(typ)
+	lock.RLock()
+	if idx, ok := get(); ok {
+		if iterator := idx.Iterator(false); iterator != nil {
+			for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+				positions[string(iterator.Key())] = iterator.Value()
+			}
+			iterator.Close()
+		}
+	}
+	lock.RUnlock()
+
+	for sub, pos := range txn.snapshotBefore(typ, key) {
+		if pos == nil {
+			delete(positions, sub)
+		} else {
+			positions[sub] = pos
+		}
+	}
+	return positions
+}
+
+// scanPositions returns in order up to limit sub keys after cursor of the records of the key as seen by the txn,
+// with the writes of the txn applied, and whether there are more. The index is read from the cursor in chunks
+// instead of as a whole, get returns the index of the key
+func (txn *Txn) scanPositions(typ data.DataType, key, cursor []byte, limit int, get func() (meta.MemTable, bool),
+	pendingWrites map[string]*pendingWrite) ([]string, []*data.LogPos, bool) {
+	txn.trackRead(typ, key)
+	lock := txn.db.getIndexLockByType(typ)
+	committed := make(map[string]*data.LogPos)
+	from := cursor
+	for {
+		// one more than limit is read to tell whether there are more
+		read, exhausted := 0, true
+		lock.RLock()
+		if idx, ok := get(); ok {
+			visit := func(k []byte, pos *data.LogPos) bool {
+				// the keys up to from are skipped even if the iterator does not seek exactly
+				if from != nil && bytes.Compare(k, from) <= 0 {
+					return true
+				}
+				if read > limit {
+					exhausted = false
+					return false
+				}
+				committed[string(k)] = pos
+				from = append([]byte{}, k...)
+				read++
+				return true
+			}
+			ascendFrom(idx, from, visit)
+		}
+		lock.RUnlock()
+
+		// the before images and the writes of the txn only count up to the last sub key read from the index
+		inRange := func(sub string) bool {
+			return (cursor == nil || sub > string(cursor)) && (exhausted || sub <= string(from))
+		}
+		positions := make(map[string]*data.LogPos, len(committed))
+		for sub, pos := range committed {
+			positions[sub] = pos
+		}
+		for sub, pos := range txn.snapshotBefore(typ, key) {
+			if inRange(sub) {
+				positions[sub] = pos
+			}
+		}
+		for sub, pw := range pendingWrites {
+			if !inRange(sub) {
+				continue
+			}
+			if pw.typ == data.LogRecordDeleted {
+				positions[sub] = nil
+			} else {
+				positions[sub] = pw.LogPos
+			}
+		}
+
+		subs := make([]string, 0, len(positions))
+		for sub, pos := range positions {
+			if pos != nil {
+				subs = append(subs, sub)
+			}
+		}
+		if len(subs) > limit || exhausted {
+			sort.Strings(subs)
+			more := len(subs) > limit
+			if more {
+				subs = subs[:limit]
+			}
+			result := make([]*data.LogPos, len(subs))
+			for i, sub := range subs {
+				result[i] = positions[sub]
+			}
+			return subs, result, more
+		}
+	}
+}
+
+// snapshotBefore returns the before images of the records of the key which the txns committed after the start
+// of the txn wrote, the image of the first of them for each record. It is nil unless the txn is serializable
+func (txn *Txn) snapshotBefore(typ data.DataType, key []byte) map[string]*data.LogPos {
+	if txn.isolationLevel != Serializable {
+		return nil
+	}
+	o := txn.db.oracle
+	o.txnsMu.Lock()
+	defer o.txnsMu.Unlock()
+
+	var images map[string]*data.LogPos
+	tk := typedKey(typ, key)
+	readTs := txn.conflictTs(userKey(tk))
+	// the committed txns are in the order of their commitTs
+	for _, committedTxn := range o.committedTxns {
+		if committedTxn.commitTs <= readTs {
+			continue
+		}
+		for sub, pos := range committedTxn.beforeImages[tk] {
+			if images == nil {
+				images = make(map[string]*data.LogPos)
+			}
+			if _, ok := images[sub]; !ok {
+				images[sub] = pos
+			}
+		}
+	}
+	return images
+}
+
+// snapshotStrBefore returns the before images of the strings which the txns committed after the start of the txn
+// wrote, the image of the first of them for each string. It is nil unless the txn is serializable
+func (txn *Txn) snapshotStrBefore() map[string]*data.LogPos {
+	if txn.isolationLevel != Serializable {
+		return nil
+	}
+	o := txn.db.oracle
+	o.txnsMu.Lock()
+	defer o.txnsMu.Unlock()
+
+	images := make(map[string]*data.LogPos)
+	for _, committedTxn := range o.committedTxns {
+		for tk, image := range committedTxn.beforeImages {
+			if data.DataType(tk[0]) != data.String {
+				continue
+			}
+			key := userKey(tk)
+			if _, ok := images[key]; ok || committedTxn.commitTs <= txn.conflictTs(key) {
+				continue
+			}
+			images[key] = image[""]
+		}
+	}
+	return images
+}
+
+// captureBeforeImages returns the positions in the indexes of the records of the snapshot types written by the txn,
+// it is called before the indexes are updated
+func (txn *Txn) captureBeforeImages() map[string]map[string]*data.LogPos {
+	images := make(map[string]map[string]*data.LogPos)
+	capture := func(typ data.DataType, key string, sub string, pos *data.LogPos) {
+		tk := typedKey(typ, []byte(key))
+		if _, ok := images[tk]; !ok {
+			images[tk] = make(map[string]*data.LogPos)
+		}
+		images[tk][sub] = pos
+	}
+	index := txn.db.index
+
+	lock := txn.db.getIndexLockByType(data.String)
+	lock.RLock()
+	for key := range txn.strPendingWrites {
+		capture(data.String, key, "", index.getStrIndex().Get([]byte(key)))
+	}
+	lock.RUnlock()
+
+	lock = txn.db.getIndexLockByType(data.Hash)
+	lock.RLock()
+	for key, pendingWrites := range txn.hashPendingWrites {
+		idx, ok := index.getHashIndex(key)
+		for field := range pendingWrites {
+			var pos *data.LogPos
+			if ok {
+				pos = idx.Get([]byte(field))
+			}
+			capture(data.Hash, key, field, pos)
+		}
+	}
+	lock.RUnlock()
+
+	lock = txn.db.getIndexLockByType(data.Set)
+	lock.RLock()
+	for key, pendingWrites := range txn.setPendingWrites {
+		idx, ok := index.getSetIndex(key)
+		for member := range pendingWrites {
+			var pos *data.LogPos
+			if ok {
+				pos = idx.Get([]byte(member))
+			}
+			capture(data.Set, key, member, pos)
+		}
+	}
+	lock.RUnlock()
+
+	lock = txn.db.getIndexLockByType(data.ZSet)
+	lock.RLock()
+	for key, pendingWrites := range txn.zsetPendingWrites {
+		zs, ok := index.getZSetIndex(key)
+		for member := range pendingWrites {
+			var pos *data.LogPos
+			if ok {
+				pos = zs.members.Get([]byte(member))
+			}
+			capture(data.ZSet, key, member, pos)
+		}
+	}
+	lock.RUnlock()
+
+	lock = txn.db.getIndexLockByType(data.Bitmap)
+	lock.RLock()
+	for key, pendingWrites := range txn.bitmapPendingWrites {
+		idx, ok := index.getBitmapIndex(key)
+		for chunk := range pendingWrites {
+			var pos *data.LogPos
+			if ok {
+				pos = idx.Get([]byte(chunk))
+			}
+			capture(data.Bitmap, key, chunk, pos)
+		}
+	}
+	lock.RUnlock()
+
+	lock = txn.db.getIndexLockByType(data.List)
+	lock.RLock()
+	for key := range txn.listMetaPendingWrites {
+		capture(data.ListMeta, key, "", index.getListMetaIndex().Get([]byte(key)))
+	}
+	for key, pendingWrites := range txn.listDataPendingWrites {
+		idx, ok := index.getListDataIndex(key)
+		for seq := range pendingWrites {
+			var pos *data.LogPos
+			if ok {
+				pos = idx.Get([]byte(seq))
+			}
+			capture(data.List, key, seq, pos)
+		}
+	}
+	lock.RUnlock()
+
+	lock = txn.db.getIndexLockByType(data.HyperLogLog)
+	lock.RLock()
+	for key := range txn.hllPendingWrites {
+		capture(data.HyperLogLog, key, "", index.getHLLIndex().Get([]byte(key)))
+	}
+	lock.RUnlock()
+
+	lock = txn.db.getIndexLockByType(data.Expire)
+	lock.RLock()
+	for key := range txn.expirePendingWrites {
+		capture(data.Expire, key, "", index.getExpireIndex().Get([]byte(key)))
+	}
+	lock.RUnlock()
+
+	return images
+}
+
+// writeSet returns the data types and keys written by the txn with their sub keys
+func (txn *Txn) writeSet() map[string]map[string]struct{} {
+	keys := make(map[string]map[string]struct{})
+	add := func(typ data.DataType, key string, sub string) {
+		tk := typedKey(typ, []byte(key))
+		if _, ok := keys[tk]; !ok {
+			keys[tk] = make(map[string]struct{})
+		}
+		keys[tk][sub] = struct{}{}
+	}
+	addSubs := func(typ data.DataType, pendingWrites map[string]map[string]*pendingWrite) {
+		for key, subWrites := range pendingWrites {
+			for sub := range subWrites {
+				add(typ, key, sub)
+			}
+		}
+	}
+	for key := range txn.strPendingWrites {
+		add(data.String, key, "")
+	}
+	addSubs(data.Hash, txn.hashPendingWrites)
+	addSubs(data.Set, txn.setPendingWrites)
+	addSubs(data.ZSet, txn.zsetPendingWrites)
+	addSubs(data.Bitmap, txn.bitmapPendingWrites)
+	for key := range txn.hllPendingWrites {
+		add(data.HyperLogLog, key, "")
+	}
+	addSubs(data.Stream, txn.streamPendingWrites)
+	for key := range txn.expirePendingWrites {
+		add(data.Expire, key, "")
+	}
+	for key := range txn.listMetaPendingWrites {
+		add(data.ListMeta, key, "")
+	}
+	addSubs(data.List, txn.listDataPendingWrites)
+	return keys
+}
+
+// readConflict returns whether the committed txn wrote a key read by the txn
+func (txn *Txn) readConflict(committed *committedTxn) bool {
+	for tk := range txn.readSet {
+		if txn.readOnly && snapshotTypes[data.DataType(tk[0])] {
+			continue
+		}
+		if committed.commitTs <= txn.conflictTs(userKey(tk)) {
+			continue
+		}
+		if _, ok := committed.writes[tk]; ok {
+			return true
+		}
+	}
+	if len(txn.rangeReads) == 0 || txn.readOnly {
+		return false
+	}
+	for tk := range committed.writes {
+		if data.DataType(tk[0]) != data.String {
+			continue
+		}
+		key := userKey(tk)
+		if committed.commitTs <= txn.conflictTs(key) {
+			continue
+		}
+		for _, prefix := range txn.rangeReads {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -17,13 +17,17 @@ type ttl struct {
 }
 
 func newTTL(deleter func(key string) error) *ttl {
-	return &ttl{
+	t := &ttl{
 		mu:       &sync.RWMutex{},
 		started:  &atomic.Bool{},
-		eventCh:  make(chan struct{}),
+		eventCh:  make(chan struct{}, 1),
 		timeHeap: ds.NewTimeHeap(),
 		deleter:  deleter,
 	}
+	// mark as started on creation, so that a stop issued before the
+	// worker goroutine is scheduled can not be overridden by start
+	t.started.Store(true)
+	return t
 }
 
 func (ttl *ttl) add(job *ds.Job) {
@@ -47,9 +51,14 @@ func (ttl *ttl) isExpired(key string) bool {
 	return job != nil && !job.Expiration.After(time.Now())
 }
 
-func (ttl *ttl) start() {
-	ttl.started.Store(true)
+// empty returns whether there is no job waiting
+func (ttl *ttl) empty() bool {
+	ttl.mu.RLock()
+	defer ttl.mu.RUnlock()
+	return ttl.timeHeap.IsEmpty()
+}
 
+func (ttl *ttl) start() {
 	for {
 		if !ttl.started.Load() {
 			break
@@ -111,7 +120,14 @@ func (ttl *ttl) exec() {
 }
 
 func (ttl *ttl) notify() {
+	// hold the read lock so that stop can not close the channel while sending,
+	// and never block: one pending event is enough to wake up exec
+	ttl.mu.RLock()
+	defer ttl.mu.RUnlock()
 	if ttl.started.Load() {
-		ttl.eventCh <- struct{}{}
+		select {
+		case ttl.eventCh <- struct{}{}:
+		default:
+		}
 	}
 }
@@ -1,6 +1,7 @@
 package CouloyDB
 
 import (
+	"github.com/Kirov7/CouloyDB/data"
 	"github.com/Kirov7/CouloyDB/public"
 	"github.com/Kirov7/CouloyDB/public/utils/bytex"
 	"github.com/stretchr/testify/assert"
@@ -100,7 +101,7 @@ func TestDB_TTL_Persist(t *testing.T) {
 	err = db.PutWithExpiration(bytex.GetTestKey(0), bytex.RandomBytes(24), 100*time.Millisecond)
 	assert.Nil(t, err)
 
-	db.Persist(bytex.GetTestKey(0))
+	assert.Nil(t, db.Persist(bytex.GetTestKey(0)))
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -132,3 +133,311 @@ func TestDB_TTL_Reset(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, value)
 }
+
+func TestDB_Expire_Collection(t *testing.T) {
+	options := DefaultOptions()
+	options.SyncWrites = false
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	session := []byte("session")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		if err := txn.HMSet(session, [][]byte{[]byte("user"), []byte("alice"), []byte("role"), []byte("admin")}); err != nil {
+			return err
+		}
+		if err := txn.SAdd(session, []byte("read"), []byte("write")); err != nil {
+			return err
+		}
+		return txn.RPush([]byte("queue"), [][]byte{[]byte("a"), []byte("b")})
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, public.ErrKeyNotFound, db.Expire([]byte("missing"), time.Second))
+	assert.Nil(t, db.Expire(session, 200*time.Millisecond))
+	assert.Nil(t, db.Expire([]byte("queue"), time.Hour))
+
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		length, err := txn.HLen(session)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), length)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	time.Sleep(400 * time.Millisecond)
+
+	// the hash and the set under the key are removed with their indexes
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		fields, _, err := txn.HGetAll(session)
+		assert.Nil(t, err)
+		assert.Len(t, fields, 0)
+		_, err = txn.SCard(session)
+		assert.Equal(t, public.ErrKeyNotFound, err)
+		length, err := txn.LLen([]byte("queue"))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, length)
+		return nil
+	})
+	assert.Nil(t, err)
+	_, ok := db.index.getHashIndex(string(session))
+	assert.False(t, ok)
+	_, ok = db.index.getSetIndex(string(session))
+	assert.False(t, ok)
+	assert.Nil(t, db.index.getExpireIndex().Get(encodeExpireKey(data.Hash, session)))
+}
+
+func TestDB_Expire_Collection_Recreate(t *testing.T) {
+	options := DefaultOptions()
+	options.SyncWrites = false
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		if err := txn.HSet([]byte("hash"), []byte("f"), []byte("v")); err != nil {
+			return err
+		}
+		if err := txn.SAdd([]byte("set"), []byte("m")); err != nil {
+			return err
+		}
+		if err := txn.RPush([]byte("list"), [][]byte{[]byte("a")}); err != nil {
+			return err
+		}
+		return txn.HSet([]byte("short"), []byte("f"), []byte("v"))
+	})
+	assert.Nil(t, err)
+	for _, key := range []string{"hash", "set", "list"} {
+		assert.Nil(t, db.Expire([]byte(key), time.Hour))
+	}
+	assert.Nil(t, db.Expire([]byte("short"), 100*time.Millisecond))
+
+	// emptying the collections removes their expirations
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		if err := txn.HDel([]byte("hash"), []byte("f")); err != nil {
+			return err
+		}
+		if err := txn.SRem([]byte("set"), []byte("m")); err != nil {
+			return err
+		}
+		if _, err := txn.LPop([]byte("list")); err != nil {
+			return err
+		}
+		return txn.HDel([]byte("short"), []byte("f"))
+	})
+	assert.Nil(t, err)
+	time.Sleep(200 * time.Millisecond)
+
+	recreate := func(db *DB) {
+		err := db.SerialTransaction(false, func(txn *Txn) error {
+			if err := txn.HSet([]byte("hash"), []byte("f"), []byte("v")); err != nil {
+				return err
+			}
+			if err := txn.SAdd([]byte("set"), []byte("m")); err != nil {
+				return err
+			}
+			if err := txn.RPush([]byte("list"), [][]byte{[]byte("a")}); err != nil {
+				return err
+			}
+			return txn.HSet([]byte("short"), []byte("f"), []byte("v"))
+		})
+		assert.Nil(t, err)
+	}
+	check := func(db *DB) {
+		for _, key := range []string{"hash", "set", "list", "short"} {
+			ttl, err := db.TTL([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, NoExpiration, ttl)
+		}
+	}
+	// the collections created again under the keys don't inherit the expirations, even the passed one
+	recreate(db)
+	check(db)
+
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+	check(db)
+}
+
+func TestDB_Expire_Collection_Restart(t *testing.T) {
+	options := DefaultOptions()
+	options.SyncWrites = false
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		if err := txn.HSet([]byte("short"), []byte("f"), []byte("v")); err != nil {
+			return err
+		}
+		if err := txn.HSet([]byte("long"), []byte("f"), []byte("v")); err != nil {
+			return err
+		}
+		return txn.ZAdd([]byte("board"), ZMember{Member: []byte("m"), Score: 1})
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Expire([]byte("short"), 200*time.Millisecond))
+	assert.Nil(t, db.Expire([]byte("long"), time.Hour))
+	assert.Nil(t, db.Expire([]byte("board"), 600*time.Millisecond))
+
+	// the expirations are kept by merge
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	time.Sleep(300 * time.Millisecond)
+
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+
+	// the key expired while the db was closed is removed on load
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		_, err := txn.HGet([]byte("short"), []byte("f"))
+		assert.Equal(t, public.ErrKeyNotFound, err)
+		value, err := txn.HGet([]byte("long"), []byte("f"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v"), value)
+		card, err := txn.ZCard([]byte("board"))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, card)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		_, err := txn.ZCard([]byte("board"))
+		assert.Equal(t, public.ErrKeyNotFound, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestDB_TTL_Persist_Restart(t *testing.T) {
+	options := DefaultOptions()
+	options.SyncWrites = false
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.PutWithExpiration([]byte("token"), []byte("v"), 200*time.Millisecond))
+	assert.Nil(t, db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.SAdd([]byte("online"), []byte("alice"))
+	}))
+	assert.Nil(t, db.Expire([]byte("online"), 200*time.Millisecond))
+
+	assert.Nil(t, db.Persist([]byte("token")))
+	assert.Nil(t, db.Persist([]byte("online")))
+	assert.Equal(t, public.ErrKeyNotFound, db.Persist([]byte("missing")))
+
+	// the removed expirations are not restored from the log
+	assert.Nil(t, db.Close())
+	time.Sleep(300 * time.Millisecond)
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+
+	value, err := db.Get([]byte("token"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+	ttl, err := db.TTL([]byte("online"))
+	assert.Nil(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+}
+
+func TestDB_ExpireAt_TTL(t *testing.T) {
+	options := DefaultOptions()
+	options.SyncWrites = false
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("report")
+	assert.Nil(t, db.Put(key, []byte("v")))
+	assert.Nil(t, db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.HSet(key, []byte("f"), []byte("v"))
+	}))
+
+	ttl, err := db.TTL(key)
+	assert.Nil(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+	_, err = db.TTL([]byte("missing"))
+	assert.Equal(t, public.ErrKeyNotFound, err)
+	assert.Equal(t, public.ErrKeyNotFound, db.ExpireAt([]byte("missing"), time.Now()))
+
+	// the string and the hash under the key expire together
+	assert.Nil(t, db.ExpireAt(key, time.Now().Add(time.Hour)))
+	ttl, err = db.TTL(key)
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour)
+
+	assert.Nil(t, db.ExpireAt(key, time.Now().Add(-time.Second)))
+	time.Sleep(100 * time.Millisecond)
+	_, err = db.Get(key)
+	assert.Equal(t, public.ErrKeyNotFound, err)
+	_, err = db.TTL(key)
+	assert.Equal(t, public.ErrKeyNotFound, err)
+}
+
+func TestTxn_Expire(t *testing.T) {
+	options := DefaultOptions()
+	options.SyncWrites = false
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		assert.Nil(t, txn.Set([]byte("counter"), []byte("1")))
+		assert.Nil(t, txn.Expire([]byte("counter"), time.Hour))
+		assert.Nil(t, txn.RPush([]byte("jobs"), [][]byte{[]byte("a")}))
+		assert.Nil(t, txn.Expire([]byte("jobs"), time.Hour))
+
+		// the expirations set in the txn are visible to it
+		ttl, err := txn.TTL([]byte("jobs"))
+		assert.Nil(t, err)
+		assert.True(t, ttl > 59*time.Minute)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		// incr keeps the expiration and set removes it
+		_, err := txn.Incr([]byte("counter"))
+		assert.Nil(t, err)
+		ttl, err := txn.TTL([]byte("counter"))
+		assert.Nil(t, err)
+		assert.True(t, ttl > 59*time.Minute)
+
+		assert.Nil(t, txn.Persist([]byte("jobs")))
+		ttl, err = txn.TTL([]byte("jobs"))
+		assert.Nil(t, err)
+		assert.Equal(t, NoExpiration, ttl)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	ttl, err := db.TTL([]byte("jobs"))
+	assert.Nil(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		assert.Nil(t, txn.Set([]byte("counter"), []byte("5")))
+		return txn.Expire([]byte("jobs"), -time.Second)
+	})
+	assert.Nil(t, err)
+	ttl, err = db.TTL([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+
+	time.Sleep(100 * time.Millisecond)
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		_, err := txn.LLen([]byte("jobs"))
+		assert.NotNil(t, err)
+		return txn.Expire([]byte("counter"), time.Hour)
+	})
+	assert.Equal(t, public.ErrUpdateInReadOnlyTxn, err)
+}
@@ -2,6 +2,11 @@ package CouloyDB
 
 import (
 	"container/heap"
+	"context"
+	"errors"
+	"math/rand"
+	"runtime"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -10,6 +15,7 @@ import (
 	"github.com/Kirov7/CouloyDB/data"
 	"github.com/Kirov7/CouloyDB/meta"
 	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/public/ds"
 	"github.com/Kirov7/CouloyDB/public/utils/wait"
 )
 
@@ -22,51 +28,82 @@ const (
 
 // Global transaction manager
 type oracle struct {
+	// A commit holds the lock from its conflict check until its writes are in the indexes,
+	// a txn gets its startTs under the read lock so it sees the writes of all the txns committed before
 	mu *sync.RWMutex
+	// Guards activeTxnHeap and committedTxns
+	txnsMu *sync.Mutex
 	// Unique transaction ID
 	txId int64
 
 	// A minimum heap for maintaining active transactions
 	activeTxnHeap int64Heap
 	// The committed transaction list used for conflict detection
-	committedTxns []*Txn
+	committedTxns []*committedTxn
+}
+
+// committedTxn is what the oracle keeps of a committed txn, the txn itself is not pinned by the older txns
+type committedTxn struct {
+	commitTs int64
+	// data type and key to the sub keys written, the sub key of a record without one is empty
+	writes map[string]map[string]struct{}
+	// data type and key to sub key to the position replaced, nil if there was none
+	beforeImages map[string]map[string]*data.LogPos
+}
+
+// wrote returns whether the committed txn wrote the sub key of the key
+func (c *committedTxn) wrote(typ data.DataType, key, sub string) bool {
+	_, ok := c.writes[typedKey(typ, []byte(key))][sub]
+	return ok
 }
 
 func (db *DB) initOracle() *oracle {
 	o := &oracle{
-		mu: &sync.RWMutex{},
+		mu:     &sync.RWMutex{},
+		txnsMu: &sync.Mutex{},
 		// When CouloyDB is started, the current timestamp is taken as the initial transaction id,
 		// and the atoms increment on this basis each time a new transaction id is fetched
 		txId:          time.Now().UnixNano(),
 		activeTxnHeap: int64Heap{},
-		committedTxns: make([]*Txn, 0),
+		committedTxns: make([]*committedTxn, 0),
 	}
 	db.oracle = o
 	return o
 }
 
 func (o *oracle) hasConflict(txn *Txn) bool {
-	if len(txn.strPendingWrites) == 0 && len(txn.hashPendingWrites) == 0 && len(txn.setPendingWrites) == 0 {
+	if len(txn.strPendingWrites) == 0 && len(txn.hashPendingWrites) == 0 && len(txn.setPendingWrites) == 0 && len(txn.zsetPendingWrites) == 0 &&
+		len(txn.bitmapPendingWrites) == 0 && len(txn.hllPendingWrites) == 0 && len(txn.streamPendingWrites) == 0 &&
+		len(txn.expirePendingWrites) == 0 && len(txn.listMetaPendingWrites) == 0 && len(txn.listDataPendingWrites) == 0 &&
+		len(txn.readSet) == 0 && len(txn.rangeReads) == 0 {
 		return false
 	}
 
+	o.txnsMu.Lock()
+	defer o.txnsMu.Unlock()
 	// go through all the old transactions looking for conflicts
 	for _, committedTxn := range o.committedTxns {
 		if committedTxn.commitTs <= txn.startTs {
 			continue
 		}
 
+		// a serializable txn conflicts with the writes to the keys it read
+		if txn.readConflict(committedTxn) {
+			return true
+		}
+
 		// if the startTs is less than the commitTs of the committed transaction
-		// possible transaction conflicts (especially dirty writing)
+		// possible transaction conflicts (especially dirty writing),
+		// the writes committed before the txn locked a key are not conflicts
 		for key := range txn.strPendingWrites {
-			if _, has := committedTxn.strPendingWrites[key]; has {
+			if committedTxn.wrote(data.String, key, "") && committedTxn.commitTs > txn.conflictTs(key) {
 				return true
 			}
 		}
 
 		for key, pendingWrites := range txn.hashPendingWrites {
 			for field := range pendingWrites {
-				if _, has := committedTxn.hashPendingWrites[key][field]; has {
+				if committedTxn.wrote(data.Hash, key, field) && committedTxn.commitTs > txn.conflictTs(key) {
 					return true
 				}
 			}
@@ -74,7 +111,57 @@ func (o *oracle) hasConflict(txn *Txn) bool {
 
 		for key, pendingWrites := range txn.setPendingWrites {
 			for member := range pendingWrites {
-				if _, has := committedTxn.setPendingWrites[key][member]; has {
+				if committedTxn.wrote(data.Set, key, member) && committedTxn.commitTs > txn.conflictTs(key) {
+					return true
+				}
+			}
+		}
+
+		for key, pendingWrites := range txn.zsetPendingWrites {
+			for member := range pendingWrites {
+				if committedTxn.wrote(data.ZSet, key, member) && committedTxn.commitTs > txn.conflictTs(key) {
+					return true
+				}
+			}
+		}
+
+		for key, pendingWrites := range txn.bitmapPendingWrites {
+			for chunk := range pendingWrites {
+				if committedTxn.wrote(data.Bitmap, key, chunk) && committedTxn.commitTs > txn.conflictTs(key) {
+					return true
+				}
+			}
+		}
+
+		for key := range txn.hllPendingWrites {
+			if committedTxn.wrote(data.HyperLogLog, key, "") && committedTxn.commitTs > txn.conflictTs(key) {
+				return true
+			}
+		}
+
+		for key, pendingWrites := range txn.streamPendingWrites {
+			for sub := range pendingWrites {
+				if committedTxn.wrote(data.Stream, key, sub) && committedTxn.commitTs > txn.conflictTs(key) {
+					return true
+				}
+			}
+		}
+
+		for key := range txn.expirePendingWrites {
+			if committedTxn.wrote(data.Expire, key, "") && committedTxn.commitTs > txn.conflictTs(key[1:]) {
+				return true
+			}
+		}
+
+		for key := range txn.listMetaPendingWrites {
+			if committedTxn.wrote(data.ListMeta, key, "") && committedTxn.commitTs > txn.conflictTs(key) {
+				return true
+			}
+		}
+
+		for key, pendingWrites := range txn.listDataPendingWrites {
+			for seq := range pendingWrites {
+				if committedTxn.wrote(data.List, key, seq) && committedTxn.commitTs > txn.conflictTs(key) {
 					return true
 				}
 			}
@@ -84,29 +171,68 @@ func (o *oracle) hasConflict(txn *Txn) bool {
 	return false
 }
 
-func (o *oracle) newCommit(txn *Txn) {
+// publishCommit adds the txn to the committed txns before its writes are applied to the indexes,
+// so the txns started before it read their snapshot from its before images. The commitTs of the txn
+// is taken with the oracle locked before its commit mark is written
+func (o *oracle) publishCommit(txn *Txn) {
+	committed := &committedTxn{commitTs: txn.commitTs, writes: txn.writeSet(), beforeImages: txn.captureBeforeImages()}
+
+	o.txnsMu.Lock()
+	defer o.txnsMu.Unlock()
 	// Clean up overdue submission records
 	o.cleanupCommitTxn()
-	// Get the commit timestamp
-	txn.commitTs = o.GetTxId()
-	o.committedTxns = append(o.committedTxns, txn)
-	// Remove this transaction from the active transaction minimum heap
-	o.removeActiveTxn(txn.startTs)
+	o.committedTxns = append(o.committedTxns, committed)
+}
 
-	if txn.isolationLevel == Serializable {
-		txn.unlock()
+// publishBatch adds a committed batch to the committed txns like publishCommit, only if a txn is active,
+// the txns started after the batch see it in the indexes
+func (o *oracle) publishBatch(txn *Txn) {
+	o.txnsMu.Lock()
+	active := o.activeTxnHeap.Len() > 0
+	o.txnsMu.Unlock()
+	// no txn begins while the commits are locked
+	if !active {
+		return
 	}
+	o.publishCommit(txn)
+}
+
+func (o *oracle) newCommit(txn *Txn) {
+	o.txnsMu.Lock()
+	defer o.txnsMu.Unlock()
+	// Remove this transaction from the active transaction minimum heap
+	o.removeActiveTxn(txn.startTs)
 }
 
 func (o *oracle) newBegin(txn *Txn) {
-	// Get the start timestamp
+	// Get the start timestamp after the txns being committed
+	o.mu.RLock()
 	txn.startTs = txn.db.oracle.GetTxId()
+	o.mu.RUnlock()
 	// Insert this transaction from the active transaction minimum heap
+	o.txnsMu.Lock()
 	o.addActiveTxn(txn.startTs)
+	o.txnsMu.Unlock()
 }
 
+func (o *oracle) newRollback(startTs int64) {
+	o.txnsMu.Lock()
+	o.removeActiveTxn(startTs)
+	o.txnsMu.Unlock()
+}
+
+// GetTxId returns a new txn id, the ids follow the unix nanoseconds unless they are taken faster than the clock
 func (o *oracle) GetTxId() int64 {
-	return atomic.AddInt64(&o.txId, 1)
+	for {
+		last := atomic.LoadInt64(&o.txId)
+		next := time.Now().UnixNano()
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&o.txId, last, next) {
+			return next
+		}
+	}
 }
 
 // Clears all transactions whose commitTs is less than the minimum startTs in all active transactions
@@ -114,15 +240,24 @@ func (o *oracle) cleanupCommitTxn() {
 	// Get the minimum transaction timestamp from the active transaction timestamp
 	startTs, err := o.peekActiveTxn()
 	if err != nil {
+		// the txns started later see all the committed txns
+		for i := range o.committedTxns {
+			o.committedTxns[i] = nil
+		}
+		o.committedTxns = o.committedTxns[:0]
 		return
 	}
 
 	tmp := o.committedTxns[:0]
-	for _, txn := range o.committedTxns {
-		if txn.commitTs <= startTs {
+	for _, committed := range o.committedTxns {
+		if committed.commitTs <= startTs {
 			continue
 		}
-		tmp = append(tmp, txn)
+		tmp = append(tmp, committed)
+	}
+	// the pruned txns are dropped from the backing array too
+	for i := len(tmp); i < len(o.committedTxns); i++ {
+		o.committedTxns[i] = nil
 	}
 	o.committedTxns = tmp
 }
@@ -175,11 +310,60 @@ type Txn struct {
 	strPendingWrites  map[string]*pendingWrite
 	hashPendingWrites map[string]map[string]*pendingWrite // key to field to pendingWrite
 	setPendingWrites  map[string]map[string]*pendingWrite // key to member to pendingWrite
+	zsetPendingWrites map[string]map[string]*pendingWrite // key to member to pendingWrite
+	// key to chunk index to pendingWrite
+	bitmapPendingWrites map[string]map[string]*pendingWrite
+	hllPendingWrites    map[string]*pendingWrite
+	// key to sub key to pendingWrite, the sub key is an entry, a group or a pending entry
+	streamPendingWrites map[string]map[string]*pendingWrite
+	// data type and key to pendingWrite of the expiration
+	expirePendingWrites map[string]*pendingWrite
 
 	listMetaPendingWrites map[string]*pendingWrite
 	listDataPendingWrites map[string]map[string]*pendingWrite
+	// the savepoints from the oldest
+	savepoints []*savepoint
+	// the pending writes replaced since the oldest savepoint
+	undoLog []undoEntry
+	// key locked by the txn to the ts when it got the lock
+	lockedKeys map[string]int64
+	// the longest LockKeys waits for a key, zero if it waits until the context is done
+	lockTimeout time.Duration
+
+	// the txn stages the records of a WriteBatch or migrates a legacy list, the txn limits don't apply to it
+	unlimited bool
+
+	// data type and key read by a serializable txn
+	readSet map[string]struct{}
+	// the prefixes of the strings scanned by a serializable txn
+	rangeReads []string
+	// the failure of the string index when the txn was committed
+	indexErr error
+	// the records appended by the txn and the bytes of their keys and values
+	recordCount int
+	writeBytes  int64
 
 	waitCommit *wait.Wait
+
+	ctx context.Context
+	// the state of a txn opened by Begin or of a watched txn running a closure, nil for the other txns
+	handle *txnHandle
+}
+
+// txnHandle is the state of a txn opened by Begin, or of a txn running a closure which is rolled back when its
+// context is done or it passes its lifetime. The goroutine watching the context of the txn only holds
+// the handle, so a txn dropped without being finished can still be garbage collected and rolled back
+type txnHandle struct {
+	db       *DB
+	startTs  int64
+	mu       sync.Mutex
+	finished bool
+	// closed when the txn is finished
+	done chan struct{}
+	// the reason the db aborted the txn, nil if it did not
+	abortErr error
+	// the txn runs a closure and is finished when the closure returns, not by Commit or Rollback
+	closure bool
 }
 
 func newTxn(readOnly bool, db *DB, isolationLevel IsolationLevel) *Txn {
@@ -191,8 +375,15 @@ func newTxn(readOnly bool, db *DB, isolationLevel IsolationLevel) *Txn {
 		strPendingWrites:      make(map[string]*pendingWrite),
 		hashPendingWrites:     make(map[string]map[string]*pendingWrite),
 		setPendingWrites:      make(map[string]map[string]*pendingWrite),
+		zsetPendingWrites:     make(map[string]map[string]*pendingWrite),
+		bitmapPendingWrites:   make(map[string]map[string]*pendingWrite),
+		hllPendingWrites:      make(map[string]*pendingWrite),
+		streamPendingWrites:   make(map[string]map[string]*pendingWrite),
+		expirePendingWrites:   make(map[string]*pendingWrite),
 		listMetaPendingWrites: make(map[string]*pendingWrite),
 		listDataPendingWrites: make(map[string]map[string]*pendingWrite),
+		readSet:               make(map[string]struct{}),
+		lockedKeys:            make(map[string]int64),
 		waitCommit:            wait.NewWait(),
 	}
 }
@@ -200,50 +391,244 @@ func newTxn(readOnly bool, db *DB, isolationLevel IsolationLevel) *Txn {
 type pendingWrite struct {
 	typ data.LogRecordType
 	*data.LogPos
+	expiration int64   // the expiration of a string, zero if it does not expire
+	score      float64 // the score of a sorted set member
 }
 
 // SerialTransaction serializable transaction
-// For now, the commit of a serializable transaction is unlikely to conflict
-// so no retry is required
+// The transaction reads a snapshot of the db taken when it begins, it returns ErrTransactionConflict
+// if it conflicts with another transaction, RetryTransaction retries it instead
 func (db *DB) SerialTransaction(readOnly bool, fn func(txn *Txn) error) error {
+	opts := TxnOptions{ReadOnly: readOnly, IsolationLevel: Serializable}
+	return db.RetryTransaction(opts, RetryPolicy{MaxAttempts: 1}, fn)
+}
+
+// RWTransaction Read/Write transaction
+// if retryOnConflict is true, then the transaction will automatically retry by the default retry policy,
+// which gives up after a bounded number of attempts
+// fn is the real transaction that you want to perform
+func (db *DB) RWTransaction(retryOnConflict bool, fn func(txn *Txn) error) error {
+	policy := DefaultRetryPolicy()
+	if !retryOnConflict {
+		policy.MaxAttempts = 1
+	}
+	return db.RetryTransaction(TxnOptions{IsolationLevel: ReadCommitted}, policy, fn)
+}
+
+// RetryTransaction runs fn in a txn opened with the options, the txn is retried by the policy when it
+// conflicts with another txn or fn returns ErrDeadlock. It stops retrying when the context of the options
+// is done, the txn is rolled back then and the error of the context is returned
+func (db *DB) RetryTransaction(opts TxnOptions, policy RetryPolicy, fn func(txn *Txn) error) error {
 	if fn == nil {
 		return public.ErrTxnFnEmpty
 	}
-	txn := newTxn(readOnly, db, Serializable)
-	txn.begin()
-	if err := fn(txn); err != nil {
-		txn.rollback()
-		return err
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	if err := txn.commit(); err != nil {
-		return err
+
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		txn := newTxn(opts.ReadOnly, db, opts.IsolationLevel)
+		txn.ctx, txn.lockTimeout = ctx, opts.LockTimeout
+		txn.begin()
+		// the txn is rolled back like the ones opened by Begin, even if fn has not returned yet
+		if txn.watched() {
+			txn.handle = &txnHandle{db: db, startTs: txn.startTs, done: make(chan struct{}), closure: true}
+			txn.watch()
+		}
+
+		err := txn.run(fn)
+		if err != public.ErrTransactionConflict && !errors.Is(err, public.ErrDeadlock) {
+			return err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
 	}
-	return nil
 }
 
-// RWTransaction Read/Write transaction
-// if retryOnConflict is true, then the transaction will automatically retry until the transaction commits correctly
-// fn is the real transaction that you want to perform
-func (db *DB) RWTransaction(retryOnConflict bool, fn func(txn *Txn) error) error {
-	for {
-		tx := newTxn(false, db, ReadCommitted)
+// run runs fn in the txn and commits it, the txn is rolled back if fn fails, the context is done or fn panics.
+// It returns the error of the abort if the txn was aborted while fn ran and fn did not fail
+func (txn *Txn) run(fn func(txn *Txn) error) error {
+	finished := false
+	defer func() {
+		if !finished {
+			if txn.finishClosure() == nil {
+				txn.rollback()
+			}
+		}
+	}()
 
-		tx.begin()
-		err := fn(tx)
+	err := fn(txn)
+	if err == nil {
+		err = txn.ctx.Err()
+	}
+	finished = true
+	if abortErr := txn.finishClosure(); abortErr != nil {
 		if err != nil {
-			tx.rollback()
 			return err
 		}
-		err = tx.commit()
-		if err == nil {
-			return nil
-		}
+		return abortErr
+	}
+	if err != nil {
+		txn.rollback()
+		return err
+	}
+	return txn.commit()
+}
 
-		if err == public.ErrTransactionConflict && retryOnConflict {
-			continue
+// finishClosure finishes the handle of a txn running a closure, it returns the error of finishing the txn
+// if the txn was aborted already
+func (txn *Txn) finishClosure() error {
+	h := txn.handle
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.finished {
+		return h.closedErr()
+	}
+	h.finish()
+	return nil
+}
+
+// backoff returns how long to wait before the retry after the attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// the jitter keeps the txns which conflicted from retrying together
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Begin opens a txn which is finished by Commit, Rollback or Discard, the txn is rolled back when
+// its context is done. A txn dropped without being finished is rolled back when it is garbage collected,
+// but the before images kept for it are only released then, so it should always be finished
+func (db *DB) Begin(opts TxnOptions) (*Txn, error) {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	txn := newTxn(opts.ReadOnly, db, opts.IsolationLevel)
+	txn.ctx, txn.lockTimeout = ctx, opts.LockTimeout
+	txn.begin()
+	txn.handle = &txnHandle{db: db, startTs: txn.startTs, done: make(chan struct{})}
+
+	if txn.watched() {
+		txn.watch()
+	}
+	runtime.SetFinalizer(txn, (*Txn).Discard)
+	return txn, nil
+}
+
+// watched reports whether the txn has to be rolled back when its context is done or it passes its lifetime
+func (txn *Txn) watched() bool {
+	return txn.ctx.Done() != nil || txn.db.options.MaxTxnLifetime > 0
+}
+
+// watch starts watching the context and the lifetime of the txn with its handle
+func (txn *Txn) watch() {
+	var lifetime time.Duration
+	if txn.db.options.MaxTxnLifetime > 0 {
+		// a txn which has passed its lifetime already is rolled back at once
+		if lifetime = txn.db.options.MaxTxnLifetime - txn.age(); lifetime <= 0 {
+			lifetime = time.Nanosecond
 		}
+	}
+	go txn.handle.watch(txn.ctx, lifetime)
+}
+
+// Commit commits a txn opened by Begin, the txn is rolled back instead if its context is done
+func (txn *Txn) Commit() error {
+	h := txn.handle
+	if h == nil || h.closure {
+		return public.ErrTxnNotBegun
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.finished {
+		return h.closedErr()
+	}
+	txn.finish()
+
+	if err := txn.ctx.Err(); err != nil {
+		txn.rollback()
 		return err
 	}
+	return txn.commit()
+}
+
+// Rollback rolls back a txn opened by Begin
+func (txn *Txn) Rollback() error {
+	h := txn.handle
+	if h == nil || h.closure {
+		return public.ErrTxnNotBegun
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.finished {
+		return h.closedErr()
+	}
+	txn.finish()
+
+	txn.rollback()
+	return nil
+}
+
+// Discard rolls back a txn opened by Begin if it is not finished yet, so it can be deferred after Begin
+func (txn *Txn) Discard() {
+	_ = txn.Rollback()
+}
+
+func (txn *Txn) finish() {
+	txn.handle.finish()
+	runtime.SetFinalizer(txn, nil)
+}
+
+func (h *txnHandle) finish() {
+	h.finished = true
+	close(h.done)
+}
+
+// watch rolls back the txn when the context is done or the rest of its lifetime passes before the txn
+// is finished, the lifetime is zero if it is not bounded
+func (h *txnHandle) watch(ctx context.Context, lifetime time.Duration) {
+	var expired <-chan time.Time
+	if lifetime != 0 {
+		timer := time.NewTimer(lifetime)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-ctx.Done():
+		h.abort(nil)
+	case <-expired:
+		h.abort(public.ErrTxnTooOld)
+	case <-h.done:
+	}
 }
 
 // begin
@@ -251,10 +636,6 @@ func (txn *Txn) begin() {
 	// the real begin
 	txn.db.oracle.newBegin(txn)
 
-	if txn.isolationLevel == Serializable {
-		txn.lock()
-	}
-
 	// write the begin-mark to datafile
 	logRecord := &data.LogRecord{
 		Key:  encodeKeyWithTxId(public.TX_BEGIN_KEY, txn.startTs),
@@ -265,35 +646,45 @@ func (txn *Txn) begin() {
 
 // Check for conflicts and finally perform a commit or rollback
 func (txn *Txn) commit() error {
-	// because activeTxnHeap and committedTxns are not concurrent secure locks
-	// so the locks should be obtained first when commit
-	if txn.isolationLevel == ReadCommitted {
-		txn.db.oracle.mu.Lock()
-		defer txn.db.oracle.mu.Unlock()
+	// the locks are released after the writes are in the indexes
+	defer txn.db.locks.release(txn.startTs)
+	// no txn may commit between the conflict check and the update of the indexes
+	txn.db.oracle.mu.Lock()
+	defer txn.db.oracle.mu.Unlock()
+	// the committed txns a txn older than its lifetime conflicts with may have been pruned
+	if err := txn.checkLifetime(); err != nil {
+		txn.rollback()
+		return err
 	}
 	// check whether data conflicts exist
-	if txn.isolationLevel == Serializable || !txn.db.oracle.hasConflict(txn) {
+	if !txn.db.oracle.hasConflict(txn) {
+		if err := txn.dropEmptiedExpires(); err != nil {
+			txn.rollback()
+			return err
+		}
 		// write the commit-mark to datafile
+		txn.commitTs = txn.db.oracle.GetTxId()
 		logRecord := &data.LogRecord{
-			Key:  encodeKeyWithTxId(public.TX_COMMIT_KEY, txn.startTs),
-			Type: data.LogRecordTxnCommit,
+			Key:   encodeKeyWithTxId(public.TX_COMMIT_KEY, txn.startTs),
+			Value: encodeCommitTs(txn.commitTs),
+			Type:  data.LogRecordTxnCommit,
 		}
 		_, err := txn.db.appendLogRecordWithLock(logRecord)
 		if err != nil {
+			txn.rollback()
 			return err
 		}
 
-		// traverse the operations done by the transaction on each data structure
-		go txn.updateStrIndex()
-		go txn.updateHashIndex()
-		go txn.updateListIndex()
-		go txn.updateSetIndex()
-
-		txn.waitCommit.Wait()
+		txn.db.oracle.publishCommit(txn)
+		indexErr := txn.updateIndexes()
 
 		// the real commit
 		txn.db.oracle.newCommit(txn)
 		//fmt.Printf("=== %d === commit\n", id(txn.startTs))
+		if indexErr != nil {
+			return indexErr
+		}
+		txn.publishChange()
 		return nil
 	}
 
@@ -303,34 +694,76 @@ func (txn *Txn) commit() error {
 	return public.ErrTransactionConflict
 }
 
+// updateIndexes applies the pending writes of the txn to the indexes, the error is the failure of the string
+// index, the writes are committed in the log anyway
+func (txn *Txn) updateIndexes() error {
+	// traverse the operations done by the transaction on each data structure
+	txn.waitCommit.Add(9)
+	go txn.updateStrIndex()
+	go txn.updateHashIndex()
+	go txn.updateListIndex()
+	go txn.updateSetIndex()
+	go txn.updateZSetIndex()
+	go txn.updateBitmapIndex()
+	go txn.updateHLLIndex()
+	go txn.updateStreamIndex()
+	go txn.updateExpireIndex()
+
+	txn.waitCommit.Wait()
+	return txn.indexErr
+}
+
 // rollback
 func (txn *Txn) rollback() {
+	txn.db.rollbackTxn(txn.startTs)
+}
+
+// rollbackTxn rolls back the txn with the start ts, the pending writes of the txn are not needed for it
+func (db *DB) rollbackTxn(startTs int64) {
 	// write the rollback-mark to datafile
 	logRecord := &data.LogRecord{
-		Key:  encodeKeyWithTxId(public.TX_ROLLBACK_KEY, txn.startTs),
+		Key:  encodeKeyWithTxId(public.TX_ROLLBACK_KEY, startTs),
 		Type: data.LogRecordTxnRollback,
 	}
-	_, _ = txn.db.appendLogRecordWithLock(logRecord)
-	if txn.isolationLevel == Serializable {
-		txn.unlock()
-	}
+	_, _ = db.appendLogRecordWithLock(logRecord)
+	db.oracle.newRollback(startTs)
+	db.locks.release(startTs)
 }
 
 func (txn *Txn) updateStrIndex() {
-	txn.waitCommit.Add(1)
+	defer txn.waitCommit.Done()
+	lock := txn.db.getIndexLockByType(data.String)
+	lock.Lock()
+	defer lock.Unlock()
 	for key, pw := range txn.strPendingWrites {
+		jobKey := string(encodeExpireKey(data.String, []byte(key)))
+		// the version in the history is the commitTs, the txn is not seen before it commits
+		txn.db.addStrVersion([]byte(key), txn.commitTs, pw.LogPos, pw.typ == data.LogRecordDeleted)
 		if pw.typ == data.LogRecordNormal {
-			txn.db.index.getStrIndex().Put([]byte(key), pw.LogPos)
+			if ok := txn.db.index.getStrIndex().Put([]byte(key), pw.LogPos); !ok && txn.indexErr == nil {
+				txn.indexErr = txn.db.index.strIndexUpdateErr()
+			}
+			if pw.expiration != 0 {
+				txn.db.ttl.add(ds.NewJob(jobKey, time.Unix(0, pw.expiration)))
+			} else {
+				txn.db.ttl.del(jobKey)
+			}
 		}
 		if pw.typ == data.LogRecordDeleted {
-			txn.db.index.getStrIndex().Del([]byte(key))
+			// a key deleted by the txn may not be in the index
+			if ok := txn.db.index.getStrIndex().Del([]byte(key)); !ok && txn.indexErr == nil {
+				txn.indexErr = txn.db.index.strIndexErr()
+			}
+			txn.db.ttl.del(jobKey)
 		}
 	}
-	txn.waitCommit.Done()
 }
 
 func (txn *Txn) updateHashIndex() {
-	txn.waitCommit.Add(1)
+	defer txn.waitCommit.Done()
+	lock := txn.db.getIndexLockByType(data.Hash)
+	lock.Lock()
+	defer lock.Unlock()
 	for key, pendingWrites := range txn.hashPendingWrites {
 
 		idx, ok := txn.db.index.getHashIndex(key)
@@ -347,12 +780,17 @@ func (txn *Txn) updateHashIndex() {
 				idx.Del([]byte(field))
 			}
 		}
+		if idx.Count() == 0 {
+			txn.db.index.delHashIndex(key)
+		}
 	}
-	txn.waitCommit.Done()
 }
 
 func (txn *Txn) updateSetIndex() {
-	txn.waitCommit.Add(1)
+	defer txn.waitCommit.Done()
+	lock := txn.db.getIndexLockByType(data.Set)
+	lock.Lock()
+	defer lock.Unlock()
 	for key, pendingWrites := range txn.setPendingWrites {
 
 		idx, ok := txn.db.index.getSetIndex(key)
@@ -361,20 +799,151 @@ func (txn *Txn) updateSetIndex() {
 			idx, _ = txn.db.index.getSetIndex(key)
 		}
 
-		for hashKey, pw := range pendingWrites {
+		for member, pw := range pendingWrites {
 			if pw.typ == data.LogRecordNormal {
-				idx.Put([]byte(hashKey), pw.LogPos)
+				idx.Put([]byte(member), pw.LogPos)
 			}
 			if pw.typ == data.LogRecordDeleted {
-				idx.Del([]byte(hashKey))
+				idx.Del([]byte(member))
 			}
 		}
+		if idx.Count() == 0 {
+			txn.db.index.delSetIndex(key)
+		}
+	}
+}
+
+func (txn *Txn) updateZSetIndex() {
+	defer txn.waitCommit.Done()
+	if len(txn.zsetPendingWrites) == 0 {
+		return
+	}
+
+	lock := txn.db.getIndexLockByType(data.ZSet)
+	lock.Lock()
+	defer lock.Unlock()
+	for key, pendingWrites := range txn.zsetPendingWrites {
+		zs, ok := txn.db.index.getZSetIndex(key)
+		if !ok {
+			zs = newZSet(txn.db.options.IndexType)
+			txn.db.index.setZSetIndex(key, zs)
+		}
+
+		for member, pw := range pendingWrites {
+			if pw.typ == data.LogRecordNormal {
+				zs.members.Put([]byte(member), pw.LogPos)
+				zs.scores.Add(member, pw.score)
+			}
+			if pw.typ == data.LogRecordDeleted {
+				zs.members.Del([]byte(member))
+				zs.scores.Remove(member)
+			}
+		}
+		if zs.scores.Len() == 0 {
+			txn.db.index.delZSetIndex(key)
+		}
+	}
+}
+
+func (txn *Txn) updateBitmapIndex() {
+	defer txn.waitCommit.Done()
+	if len(txn.bitmapPendingWrites) == 0 {
+		return
+	}
+
+	lock := txn.db.getIndexLockByType(data.Bitmap)
+	lock.Lock()
+	defer lock.Unlock()
+	for key, pendingWrites := range txn.bitmapPendingWrites {
+		idx, ok := txn.db.index.getBitmapIndex(key)
+		if !ok {
+			idx = meta.NewMemTable(txn.db.options.IndexType)
+			txn.db.index.setBitmapIndex(key, idx)
+		}
+
+		for chunk, pw := range pendingWrites {
+			if pw.typ == data.LogRecordNormal {
+				idx.Put([]byte(chunk), pw.LogPos)
+			}
+			if pw.typ == data.LogRecordDeleted {
+				idx.Del([]byte(chunk))
+			}
+		}
+		if idx.Count() == 0 {
+			txn.db.index.delBitmapIndex(key)
+		}
+	}
+}
+
+func (txn *Txn) updateHLLIndex() {
+	defer txn.waitCommit.Done()
+	if len(txn.hllPendingWrites) == 0 {
+		return
+	}
+
+	lock := txn.db.getIndexLockByType(data.HyperLogLog)
+	lock.Lock()
+	defer lock.Unlock()
+	for key, pw := range txn.hllPendingWrites {
+		if pw.typ == data.LogRecordNormal {
+			txn.db.index.getHLLIndex().Put([]byte(key), pw.LogPos)
+		} else {
+			txn.db.index.getHLLIndex().Del([]byte(key))
+		}
+	}
+}
+
+func (txn *Txn) updateStreamIndex() {
+	defer txn.waitCommit.Done()
+	if len(txn.streamPendingWrites) == 0 {
+		return
+	}
+
+	// the values are read from the log before taking the lock
+	values := make(map[*pendingWrite][]byte)
+	for _, pendingWrites := range txn.streamPendingWrites {
+		for sub, pw := range pendingWrites {
+			if pw.typ == data.LogRecordNormal && sub[0] != streamEntryPrefix {
+				v, err := txn.db.getValueByPos(pw.LogPos)
+				if err != nil {
+					continue
+				}
+				values[pw] = v
+			}
+		}
+	}
+
+	lock := txn.db.getIndexLockByType(data.Stream)
+	lock.Lock()
+	defer lock.Unlock()
+	for key, pendingWrites := range txn.streamPendingWrites {
+		s, ok := txn.db.index.getStreamIndex(key)
+		if !ok {
+			s = newStream()
+			txn.db.index.setStreamIndex(key, s)
+		}
+
+		// apply the groups before their pending entries
+		subs := make([]string, 0, len(pendingWrites))
+		for sub := range pendingWrites {
+			subs = append(subs, sub)
+		}
+		sort.Strings(subs)
+		for _, sub := range subs {
+			pw := pendingWrites[sub]
+			s.apply([]byte(sub), pw.typ, values[pw], pw.LogPos)
+		}
+		if s.empty() {
+			txn.db.index.delStreamIndex(key)
+		}
 	}
-	txn.waitCommit.Done()
 }
 
 func (txn *Txn) updateListIndex() {
-	txn.waitCommit.Add(1)
+	defer txn.waitCommit.Done()
+	lock := txn.db.getIndexLockByType(data.List)
+	lock.Lock()
+	defer lock.Unlock()
 	for key, pw := range txn.listMetaPendingWrites {
 		if pw.typ == data.LogRecordNormal {
 			txn.db.index.getListMetaIndex().Put([]byte(key), pw.LogPos)
@@ -396,8 +965,10 @@ func (txn *Txn) updateListIndex() {
 				index.Del([]byte(seq))
 			}
 		}
+		if index.Count() == 0 {
+			txn.db.index.delListDataIndex(key)
+		}
 	}
-	txn.waitCommit.Done()
 }
 
 // Get the key first in pendingWrites, if not then in db
@@ -417,33 +988,58 @@ func (txn *Txn) Get(key []byte) ([]byte, error) {
 		return nil, public.ErrKeyNotFound
 	}
 
-	pos := txn.db.index.getStrIndex().Get(key)
+	pos := txn.committedPos(data.String, key, "", func() *data.LogPos {
+		return txn.db.index.getStrIndex().Get(key)
+	})
 	if pos == nil {
+		if err := txn.db.index.strIndexErr(); err != nil {
+			return nil, err
+		}
 		return nil, public.ErrKeyNotFound
 	}
 
 	return txn.db.getValueByPos(pos)
 }
 
-// Set writes data to the db, but instead of writing it back to memtable, it writes to pendingWrites first
+// Set writes data to the db, but instead of writing it back to memtable, it writes to pendingWrites first.
+// The expiration of the key is removed
 func (txn *Txn) Set(key []byte, value []byte) error {
+	return txn.set(key, value, 0)
+}
+
+// set writes the string with the expiration, zero means the string does not expire
+func (txn *Txn) set(key, value []byte, expiration int64) error {
 	if txn.readOnly {
 		return public.ErrUpdateInReadOnlyTxn
 	}
+	if err := checkKey(key); err != nil {
+		return err
+	}
 	logRecord := &data.LogRecord{
-		Key:      encodeKeyWithTxId(key, txn.startTs),
-		Value:    value,
-		Type:     data.LogRecordNormal,
-		DataType: data.String,
+		Key:        encodeKeyWithTxId(key, txn.startTs),
+		Value:      value,
+		Type:       data.LogRecordNormal,
+		DataType:   data.String,
+		Expiration: expiration,
+		Version:    txn.db.GetTxId(),
 	}
-	pos, err := txn.db.appendLogRecordWithLock(logRecord)
+	pos, err := txn.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
-	txn.strPendingWrites[string(key)] = &pendingWrite{typ: data.LogRecordNormal, LogPos: pos}
+	txn.putPendingWrite(txn.strPendingWrites, string(key), &pendingWrite{typ: data.LogRecordNormal, LogPos: pos, expiration: expiration})
 	return nil
 }
 
+// setKeepTTL writes the string and keeps its expiration
+func (txn *Txn) setKeepTTL(key, value []byte) error {
+	expiration, _, err := txn.getStrExpiration(key)
+	if err != nil {
+		return err
+	}
+	return txn.set(key, value, expiration)
+}
+
 // Del delete data to the db, but instead of writing it back to memtable, it writes to pendingWrites first
 func (txn *Txn) Del(key []byte) error {
 	if txn.readOnly {
@@ -453,12 +1049,13 @@ func (txn *Txn) Del(key []byte) error {
 		Key:      encodeKeyWithTxId(key, txn.startTs),
 		Type:     data.LogRecordDeleted,
 		DataType: data.String,
+		Version:  txn.db.GetTxId(),
 	}
-	pos, err := txn.db.appendLogRecordWithLock(logRecord)
+	pos, err := txn.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
-	txn.strPendingWrites[string(key)] = &pendingWrite{typ: data.LogRecordDeleted, LogPos: pos}
+	txn.putPendingWrite(txn.strPendingWrites, string(key), &pendingWrite{typ: data.LogRecordDeleted, LogPos: pos})
 	return nil
 }
 
@@ -552,7 +1149,7 @@ func (txn *Txn) incrOrDecr(key []byte, isIncr bool, delta int) (int, error) {
 		newVal = []byte(strconv.Itoa(i))
 	}
 
-	err = txn.Set(key, newVal)
+	err = txn.setKeepTTL(key, newVal)
 	if err != nil {
 		return 0, err
 	}
@@ -567,11 +1164,10 @@ func (txn *Txn) Exist(key []byte) bool {
 		return false
 	}
 
-	if pos := txn.db.index.getStrIndex().Get(key); pos != nil {
-		return true
-	}
-
-	return false
+	pos := txn.committedPos(data.String, key, "", func() *data.LogPos {
+		return txn.db.index.getStrIndex().Get(key)
+	})
+	return pos != nil
 }
 
 func (txn *Txn) Append(key []byte, value []byte) error {
@@ -583,7 +1179,7 @@ func (txn *Txn) Append(key []byte, value []byte) error {
 		return err
 	}
 	v = append(v, value...)
-	return txn.Set(key, v)
+	return txn.setKeepTTL(key, v)
 }
 
 func (txn *Txn) MGet(keys [][]byte) ([][]byte, error) {
@@ -619,22 +1215,6 @@ func (txn *Txn) MSet(args [][]byte) error {
 	return nil
 }
 
-func (txn *Txn) lock() {
-	if txn.readOnly {
-		txn.db.oracle.mu.RLock()
-	} else {
-		txn.db.oracle.mu.Lock()
-	}
-}
-
-func (txn *Txn) unlock() {
-	if txn.readOnly {
-		txn.db.oracle.mu.RUnlock()
-	} else {
-		txn.db.oracle.mu.Unlock()
-	}
-}
-
 type int64Heap []int64
 
 func (h int64Heap) Len() int            { return len(h) }
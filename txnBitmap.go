@@ -0,0 +1,367 @@
+package CouloyDB
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sort"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/meta"
+	"github.com/Kirov7/CouloyDB/public"
+)
+
+const (
+	// bitmapChunkSize is the number of bytes of a bitmap stored in one record,
+	// so that setting a bit only rewrites the chunk containing it
+	bitmapChunkSize = 1024
+	// bitmapMaxOffset is the largest bit offset, the same as redis
+	bitmapMaxOffset = 1<<32 - 1
+)
+
+type BitOperation uint8
+
+const (
+	BitAnd BitOperation = iota
+	BitOr
+	BitXor
+	BitNot
+)
+
+// SetBit sets or clears the bit at offset and returns the original bit.
+// The bitmap is padded with zeros, the chunks containing only zeros are not stored
+func (txn *Txn) SetBit(key []byte, offset int64, value bool) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+	if txn.readOnly {
+		return false, public.ErrUpdateInReadOnlyTxn
+	}
+	if offset < 0 || offset > bitmapMaxOffset {
+		return false, public.ErrBitOffsetOutOfRange
+	}
+
+	chunkIdx, byteIdx, mask := bitLocation(offset)
+	chunk, err := txn.getBitmapChunk(key, chunkIdx)
+	if err != nil {
+		return false, err
+	}
+
+	old := byteIdx < len(chunk) && chunk[byteIdx]&mask != 0
+	if old == value {
+		return old, nil
+	}
+
+	size := len(chunk)
+	if byteIdx >= size {
+		size = byteIdx + 1
+	}
+	buf := make([]byte, size)
+	copy(buf, chunk)
+	if value {
+		buf[byteIdx] |= mask
+	} else {
+		buf[byteIdx] &^= mask
+	}
+	return old, txn.putBitmapChunk(key, chunkIdx, buf)
+}
+
+// GetBit returns the bit at offset
+func (txn *Txn) GetBit(key []byte, offset int64) (bool, error) {
+	if offset < 0 || offset > bitmapMaxOffset {
+		return false, public.ErrBitOffsetOutOfRange
+	}
+	chunkIdx, byteIdx, mask := bitLocation(offset)
+	chunk, err := txn.getBitmapChunk(key, chunkIdx)
+	if err != nil {
+		return false, err
+	}
+	return byteIdx < len(chunk) && chunk[byteIdx]&mask != 0, nil
+}
+
+// BitCount counts the set bits in the bytes from start to end inclusive,
+// negative indexes count from the last byte which is not zero
+func (txn *Txn) BitCount(key []byte, start, end int64) (int64, error) {
+	length, err := txn.bitmapLen(key)
+	if err != nil {
+		return 0, err
+	}
+	start, end = normalizeBitRange(start, end, length)
+
+	var count int64
+	for ci := start / bitmapChunkSize; start <= end && ci <= end/bitmapChunkSize; ci++ {
+		chunk, err := txn.getBitmapChunk(key, uint32(ci))
+		if err != nil {
+			return 0, err
+		}
+		base := ci * bitmapChunkSize
+		for b := maxInt64(start, base); b <= end && b-base < int64(len(chunk)); b++ {
+			count += int64(bits.OnesCount8(chunk[b-base]))
+		}
+	}
+	return count, nil
+}
+
+// BitPos returns the position of the first bit set to bit in the bytes from start to end inclusive,
+// or -1 if there is none. As the bitmap is padded with zeros, looking for a clear bit
+// in a range reaching the end of the bitmap returns the first bit after the end if there is none before
+func (txn *Txn) BitPos(key []byte, bit bool, start, end int64) (int64, error) {
+	length, err := txn.bitmapLen(key)
+	if err != nil {
+		return 0, err
+	}
+	start, end = normalizeBitRange(start, end, length)
+
+	for ci := start / bitmapChunkSize; start <= end && ci <= end/bitmapChunkSize; ci++ {
+		chunk, err := txn.getBitmapChunk(key, uint32(ci))
+		if err != nil {
+			return 0, err
+		}
+		if bit && chunk == nil {
+			continue
+		}
+		base := ci * bitmapChunkSize
+		for b := maxInt64(start, base); b <= end && b < base+bitmapChunkSize; b++ {
+			var v byte
+			if b-base < int64(len(chunk)) {
+				v = chunk[b-base]
+			}
+			if !bit {
+				v = ^v
+			}
+			if v != 0 {
+				return b*8 + int64(bits.LeadingZeros8(v)), nil
+			}
+		}
+	}
+
+	if !bit && end == length-1 {
+		return length * 8, nil
+	}
+	return -1, nil
+}
+
+// BitOp stores the result of the operation on the bitmaps of keys in dest and returns its length in bytes,
+// the length is the one of the longest bitmap. BitNot takes exactly one key
+func (txn *Txn) BitOp(op BitOperation, dest []byte, keys ...[]byte) (int64, error) {
+	if err := checkKey(dest); err != nil {
+		return 0, err
+	}
+	if txn.readOnly {
+		return 0, public.ErrUpdateInReadOnlyTxn
+	}
+	if len(keys) == 0 || op > BitNot || op == BitNot && len(keys) != 1 {
+		return 0, public.ErrBitOpArgsWrong
+	}
+
+	// read all the sources first, dest may be one of them
+	var length int64
+	sources := make([]map[uint32][]byte, len(keys))
+	for i, key := range keys {
+		chunkIdxs, err := txn.bitmapChunkIndexes(key)
+		if err != nil {
+			return 0, err
+		}
+		sources[i] = make(map[uint32][]byte, len(chunkIdxs))
+		for _, ci := range chunkIdxs {
+			if sources[i][ci], err = txn.getBitmapChunk(key, ci); err != nil {
+				return 0, err
+			}
+		}
+		if l := bitmapLenOf(chunkIdxs, sources[i]); l > length {
+			length = l
+		}
+	}
+
+	oldChunkIdxs, err := txn.bitmapChunkIndexes(dest)
+	if err != nil {
+		return 0, err
+	}
+	written := make(map[uint32]struct{})
+	for ci := int64(0); ci*bitmapChunkSize < length; ci++ {
+		size := minInt64(bitmapChunkSize, length-ci*bitmapChunkSize)
+		chunk := make([]byte, size)
+		for i, source := range sources {
+			src := source[uint32(ci)]
+			for b := range chunk {
+				var v byte
+				if b < len(src) {
+					v = src[b]
+				}
+				switch {
+				case i == 0 && op == BitNot:
+					chunk[b] = ^v
+				case i == 0:
+					chunk[b] = v
+				case op == BitAnd:
+					chunk[b] &= v
+				case op == BitOr:
+					chunk[b] |= v
+				case op == BitXor:
+					chunk[b] ^= v
+				}
+			}
+		}
+		if err := txn.putBitmapChunk(dest, uint32(ci), chunk); err != nil {
+			return 0, err
+		}
+		written[uint32(ci)] = struct{}{}
+	}
+
+	for _, ci := range oldChunkIdxs {
+		if _, ok := written[ci]; !ok {
+			if err := txn.putBitmapChunk(dest, ci, nil); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return length, nil
+}
+
+// getBitmapChunk returns the chunk as seen by the txn, nil if it contains only zeros
+func (txn *Txn) getBitmapChunk(key []byte, chunkIdx uint32) ([]byte, error) {
+	chunkKey := encodeChunkIndex(chunkIdx)
+	if pw, ok := txn.bitmapPendingWrites[string(key)][string(chunkKey)]; ok {
+		if pw.typ == data.LogRecordDeleted {
+			return nil, nil
+		}
+		return txn.db.getValueByPos(pw.LogPos)
+	}
+
+	pos := txn.committedPos(data.Bitmap, key, string(chunkKey), func() *data.LogPos {
+		if idx, ok := txn.db.index.getBitmapIndex(string(key)); ok {
+			return idx.Get(chunkKey)
+		}
+		return nil
+	})
+	if pos == nil {
+		return nil, nil
+	}
+	return txn.db.getValueByPos(pos)
+}
+
+// putBitmapChunk writes the chunk without its trailing zeros, a chunk of zeros is deleted
+func (txn *Txn) putBitmapChunk(key []byte, chunkIdx uint32, chunk []byte) error {
+	for len(chunk) > 0 && chunk[len(chunk)-1] == 0 {
+		chunk = chunk[:len(chunk)-1]
+	}
+
+	chunkKey := encodeChunkIndex(chunkIdx)
+	logRecord := &data.LogRecord{
+		Key:      encodeKeyWithTxId(encodeMemberKey(key, chunkKey), txn.startTs),
+		Value:    chunk,
+		Type:     data.LogRecordNormal,
+		DataType: data.Bitmap,
+	}
+	if len(chunk) == 0 {
+		logRecord.Value = nil
+		logRecord.Type = data.LogRecordDeleted
+	}
+
+	pos, err := txn.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+
+	txn.putSubPendingWrite(txn.bitmapPendingWrites, string(key), string(chunkKey), &pendingWrite{typ: logRecord.Type, LogPos: pos})
+	return nil
+}
+
+// bitmapChunkIndexes returns the sorted indexes of the chunks stored for the bitmap
+func (txn *Txn) bitmapChunkIndexes(key []byte) ([]uint32, error) {
+	chunkIdxs := make(map[uint32]struct{})
+
+	positions := txn.committedPositions(data.Bitmap, key, func() (meta.MemTable, bool) {
+		return txn.db.index.getBitmapIndex(string(key))
+	})
+	for chunkKey := range positions {
+		chunkIdxs[decodeChunkIndex([]byte(chunkKey))] = struct{}{}
+	}
+
+	for chunkKey, pw := range txn.bitmapPendingWrites[string(key)] {
+		if pw.typ == data.LogRecordDeleted {
+			delete(chunkIdxs, decodeChunkIndex([]byte(chunkKey)))
+		} else {
+			chunkIdxs[decodeChunkIndex([]byte(chunkKey))] = struct{}{}
+		}
+	}
+
+	sorted := make([]uint32, 0, len(chunkIdxs))
+	for ci := range chunkIdxs {
+		sorted = append(sorted, ci)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return sorted, nil
+}
+
+// bitmapLen returns the length in bytes of the bitmap up to its last byte which is not zero
+func (txn *Txn) bitmapLen(key []byte) (int64, error) {
+	chunkIdxs, err := txn.bitmapChunkIndexes(key)
+	if err != nil || len(chunkIdxs) == 0 {
+		return 0, err
+	}
+	last := chunkIdxs[len(chunkIdxs)-1]
+	chunk, err := txn.getBitmapChunk(key, last)
+	if err != nil {
+		return 0, err
+	}
+	return int64(last)*bitmapChunkSize + int64(len(chunk)), nil
+}
+
+func bitmapLenOf(chunkIdxs []uint32, chunks map[uint32][]byte) int64 {
+	if len(chunkIdxs) == 0 {
+		return 0
+	}
+	last := chunkIdxs[len(chunkIdxs)-1]
+	return int64(last)*bitmapChunkSize + int64(len(chunks[last]))
+}
+
+// bitLocation returns the chunk, the byte in the chunk and the mask of the bit at offset,
+// the bits of a byte are numbered from the most significant one like redis
+func bitLocation(offset int64) (uint32, int, byte) {
+	byteOffset := offset / 8
+	return uint32(byteOffset / bitmapChunkSize), int(byteOffset % bitmapChunkSize), byte(1) << (7 - offset%8)
+}
+
+// normalizeBitRange converts negative indexes and clamps the range to the length,
+// the range is empty if start > end
+func normalizeBitRange(start, end, length int64) (int64, int64) {
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	return start, end
+}
+
+func encodeChunkIndex(chunkIdx uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, chunkIdx)
+	return buf
+}
+
+func decodeChunkIndex(buf []byte) uint32 {
+	return binary.BigEndian.Uint32(buf)
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
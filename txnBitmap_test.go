@@ -0,0 +1,150 @@
+package CouloyDB
+
+import (
+	"testing"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxnBitmap(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("active-users")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		for _, offset := range []int64{1, 7, 100, bitmapChunkSize*8*3 + 5} {
+			old, err := txn.SetBit(key, offset, true)
+			assert.Nil(t, err)
+			assert.False(t, old)
+		}
+		old, err := txn.SetBit(key, 7, true)
+		assert.Nil(t, err)
+		assert.True(t, old)
+
+		_, err = txn.SetBit(key, bitmapMaxOffset+1, true)
+		assert.Equal(t, public.ErrBitOffsetOutOfRange, err)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		bit, err := txn.GetBit(key, 100)
+		assert.Nil(t, err)
+		assert.True(t, bit)
+		bit, err = txn.GetBit(key, 99)
+		assert.Nil(t, err)
+		assert.False(t, bit)
+		bit, err = txn.GetBit(key, 1<<30)
+		assert.Nil(t, err)
+		assert.False(t, bit)
+
+		count, err := txn.BitCount(key, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(4), count)
+		count, err = txn.BitCount(key, 0, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), count)
+		count, err = txn.BitCount(key, -1, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), count)
+
+		pos, err := txn.BitPos(key, true, 1, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(100), pos)
+		pos, err = txn.BitPos(key, false, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), pos)
+		pos, err = txn.BitPos(key, true, 13, 100)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(-1), pos)
+
+		// clearing the last bit drops its chunk
+		old, err := txn.SetBit(key, bitmapChunkSize*8*3+5, false)
+		assert.Nil(t, err)
+		assert.True(t, old)
+		length, err := txn.bitmapLen(key)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(13), length)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		count, err := txn.BitCount([]byte("missing"), 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), count)
+		pos, err := txn.BitPos([]byte("missing"), false, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), pos)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxnBitOp(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	day1, day2, dest := []byte("day1"), []byte("day2"), []byte("dest")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		for _, offset := range []int64{0, 2, 4, bitmapChunkSize * 8} {
+			if _, err := txn.SetBit(day1, offset, true); err != nil {
+				return err
+			}
+		}
+		for _, offset := range []int64{2, 3} {
+			if _, err := txn.SetBit(day2, offset, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	count := func(txn *Txn, key []byte) int64 {
+		count, err := txn.BitCount(key, 0, -1)
+		assert.Nil(t, err)
+		return count
+	}
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		length, err := txn.BitOp(BitAnd, dest, day1, day2)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(bitmapChunkSize+1), length)
+		assert.Equal(t, int64(1), count(txn, dest))
+
+		_, err = txn.BitOp(BitOr, dest, day1, day2)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(5), count(txn, dest))
+
+		_, err = txn.BitOp(BitXor, dest, day1, day2)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(4), count(txn, dest))
+
+		length, err = txn.BitOp(BitNot, dest, day2)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), length)
+		assert.Equal(t, int64(6), count(txn, dest))
+
+		_, err = txn.BitOp(BitNot, dest, day1, day2)
+		assert.Equal(t, public.ErrBitOpArgsWrong, err)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	// reboot and check the result is rebuilt from the chunks
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(db.options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		assert.Equal(t, int64(6), count(txn, dest))
+		assert.Equal(t, int64(4), count(txn, day1))
+		return nil
+	})
+	assert.Nil(t, err)
+}
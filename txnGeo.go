@@ -0,0 +1,158 @@
+package CouloyDB
+
+import (
+	"math"
+	"sort"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/public/utils/geohash"
+)
+
+// The locations are stored in a sorted set, the score of a member is the 52 bits geohash
+// of its coordinates, so the members close to each other have close scores like redis
+
+// GeoLocation is a member with its coordinates
+type GeoLocation struct {
+	Member    []byte
+	Longitude float64
+	Latitude  float64
+}
+
+// GeoQuery is the area searched by GeoSearch
+type GeoQuery struct {
+	// FromMember is the center if it is not nil, otherwise the center is Longitude and Latitude
+	FromMember []byte
+	Longitude  float64
+	Latitude   float64
+
+	// search within Radius meters if it is positive, otherwise in the box of Width by Height meters
+	Radius float64
+	Width  float64
+	Height float64
+
+	// Count limits the number of results if it is positive
+	Count int
+	// Desc sorts the results from the farthest
+	Desc bool
+}
+
+// GeoResult is a location found by GeoSearch with its distance in meters to the center
+type GeoResult struct {
+	GeoLocation
+	Distance float64
+}
+
+// GeoAdd adds the locations to key, the coordinates of an existing member are updated
+func (txn *Txn) GeoAdd(key []byte, locations ...GeoLocation) error {
+	members := make([]ZMember, len(locations))
+	for i, location := range locations {
+		if !geohash.Valid(location.Longitude, location.Latitude) {
+			return public.ErrInvalidCoordinates
+		}
+		members[i] = ZMember{
+			Member: location.Member,
+			Score:  float64(geohash.Encode(location.Longitude, location.Latitude)),
+		}
+	}
+	return txn.ZAdd(key, members...)
+}
+
+// GeoPos returns the coordinates of the members, nil for the members which do not exist
+func (txn *Txn) GeoPos(key []byte, members ...[]byte) ([]*GeoLocation, error) {
+	locations := make([]*GeoLocation, len(members))
+	for i, member := range members {
+		score, err := txn.ZScore(key, member)
+		if err == public.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		longitude, latitude := geohash.Decode(uint64(score))
+		locations[i] = &GeoLocation{Member: member, Longitude: longitude, Latitude: latitude}
+	}
+	return locations, nil
+}
+
+// GeoDist returns the distance in meters between two members
+func (txn *Txn) GeoDist(key, member1, member2 []byte) (float64, error) {
+	locations, err := txn.GeoPos(key, member1, member2)
+	if err != nil {
+		return 0, err
+	}
+	if locations[0] == nil || locations[1] == nil {
+		return 0, public.ErrKeyNotFound
+	}
+	return geohash.Distance(locations[0].Longitude, locations[0].Latitude,
+		locations[1].Longitude, locations[1].Latitude), nil
+}
+
+// GeoSearch returns the members within the radius or the box of the query sorted by distance
+func (txn *Txn) GeoSearch(key []byte, query GeoQuery) ([]GeoResult, error) {
+	if query.FromMember != nil {
+		locations, err := txn.GeoPos(key, query.FromMember)
+		if err != nil {
+			return nil, err
+		}
+		if locations[0] == nil {
+			return nil, public.ErrKeyNotFound
+		}
+		query.Longitude, query.Latitude = locations[0].Longitude, locations[0].Latitude
+	}
+	if !geohash.Valid(query.Longitude, query.Latitude) {
+		return nil, public.ErrInvalidCoordinates
+	}
+
+	byRadius := query.Radius > 0
+	radius := query.Radius
+	if !byRadius {
+		if query.Width <= 0 || query.Height <= 0 {
+			return nil, public.ErrGeoQueryWrong
+		}
+		radius = math.Hypot(query.Width/2, query.Height/2)
+	}
+
+	results := make([]GeoResult, 0)
+	err := txn.viewZSet(key, func(scores *zsetView) error {
+		for _, r := range geohash.Ranges(query.Longitude, query.Latitude, radius) {
+			for _, item := range scores.RangeByScore(float64(r.Min), float64(r.Max)) {
+				longitude, latitude := geohash.Decode(uint64(item.Score))
+				distance := geohash.Distance(query.Longitude, query.Latitude, longitude, latitude)
+				if byRadius && distance > query.Radius {
+					continue
+				}
+				if !byRadius && !inBox(query, longitude, latitude) {
+					continue
+				}
+				results = append(results, GeoResult{
+					GeoLocation: GeoLocation{Member: []byte(item.Member), Longitude: longitude, Latitude: latitude},
+					Distance:    distance,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if query.Desc {
+			return results[i].Distance > results[j].Distance
+		}
+		return results[i].Distance < results[j].Distance
+	})
+	if query.Count > 0 && len(results) > query.Count {
+		results = results[:query.Count]
+	}
+	return results, nil
+}
+
+// inBox reports whether the point is in the box of the query centered on its coordinates
+func inBox(query GeoQuery, longitude, latitude float64) bool {
+	if geohash.Distance(query.Longitude, query.Latitude, query.Longitude, latitude) > query.Height/2 {
+		return false
+	}
+	// the width is measured along the latitude of the point
+	return geohash.Distance(query.Longitude, latitude, longitude, latitude) <= query.Width/2
+}
@@ -0,0 +1,124 @@
+package CouloyDB
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/public/utils/geohash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxnGeo(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("Sicily")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.GeoAdd(key,
+			GeoLocation{Member: []byte("Palermo"), Longitude: 13.361389, Latitude: 38.115556},
+			GeoLocation{Member: []byte("Catania"), Longitude: 15.087269, Latitude: 37.502669},
+		)
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		// the same distance as redis
+		dist, err := txn.GeoDist(key, []byte("Palermo"), []byte("Catania"))
+		assert.Nil(t, err)
+		assert.InDelta(t, 166274.1516, dist, 0.5)
+
+		locations, err := txn.GeoPos(key, []byte("Palermo"), []byte("missing"))
+		assert.Nil(t, err)
+		assert.InDelta(t, 13.361389, locations[0].Longitude, 0.00001)
+		assert.InDelta(t, 38.115556, locations[0].Latitude, 0.00001)
+		assert.Nil(t, locations[1])
+
+		results, err := txn.GeoSearch(key, GeoQuery{Longitude: 15, Latitude: 37, Radius: 200 * 1000})
+		assert.Nil(t, err)
+		assert.Len(t, results, 2)
+		assert.Equal(t, []byte("Catania"), results[0].Member)
+		assert.InDelta(t, 56441.2660, results[0].Distance, 0.5)
+
+		results, err = txn.GeoSearch(key, GeoQuery{FromMember: []byte("Palermo"), Width: 400 * 1000, Height: 400 * 1000, Desc: true})
+		assert.Nil(t, err)
+		assert.Len(t, results, 2)
+		assert.Equal(t, []byte("Catania"), results[0].Member)
+
+		_, err = txn.GeoSearch(key, GeoQuery{FromMember: []byte("missing"), Radius: 1})
+		assert.Equal(t, public.ErrKeyNotFound, err)
+
+		err = txn.GeoAdd(key, GeoLocation{Member: []byte("pole"), Longitude: 0, Latitude: 89})
+		assert.Equal(t, public.ErrInvalidCoordinates, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxnGeoSearch(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	// drivers scattered around the center, some of them across the antimeridian
+	key := []byte("drivers")
+	centers := [][2]float64{{116.397, 39.909}, {179.99, -16.5}, {-73.98, 40.75}}
+	locations := make([]GeoLocation, 0)
+	r := rand.New(rand.NewSource(1))
+	for c, center := range centers {
+		for i := 0; i < 300; i++ {
+			longitude := center[0] + (r.Float64()-0.5)*0.3
+			if longitude > geohash.LongitudeMax {
+				longitude -= 360
+			}
+			locations = append(locations, GeoLocation{
+				Member:    []byte(strconv.Itoa(c) + "-" + strconv.Itoa(i)),
+				Longitude: longitude,
+				Latitude:  center[1] + (r.Float64()-0.5)*0.3,
+			})
+		}
+	}
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.GeoAdd(key, locations...)
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		for _, center := range centers {
+			for _, radius := range []float64{500, 5000, 12000} {
+				results, err := txn.GeoSearch(key, GeoQuery{Longitude: center[0], Latitude: center[1], Radius: radius})
+				assert.Nil(t, err)
+
+				expected := make([]string, 0)
+				for _, location := range locations {
+					positions, err := txn.GeoPos(key, location.Member)
+					assert.Nil(t, err)
+					if geohash.Distance(center[0], center[1], positions[0].Longitude, positions[0].Latitude) <= radius {
+						expected = append(expected, string(location.Member))
+					}
+				}
+				found := make([]string, 0)
+				for i, result := range results {
+					found = append(found, string(result.Member))
+					if i > 0 {
+						assert.LessOrEqual(t, results[i-1].Distance, result.Distance)
+					}
+				}
+				sort.Strings(expected)
+				sort.Strings(found)
+				assert.Equal(t, expected, found, "center %v radius %v", center, radius)
+			}
+		}
+
+		results, err := txn.GeoSearch(key, GeoQuery{Longitude: centers[0][0], Latitude: centers[0][1], Radius: 5000, Count: 3})
+		assert.Nil(t, err)
+		assert.Len(t, results, 3)
+		return nil
+	})
+	assert.Nil(t, err)
+}
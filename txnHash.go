@@ -1,11 +1,21 @@
 package CouloyDB
 
 import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+
 	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/meta"
 	"github.com/Kirov7/CouloyDB/public"
 	"github.com/Kirov7/CouloyDB/public/utils/bytex"
+	"github.com/Kirov7/CouloyDB/public/utils/wildcard"
 )
 
+// defaultHScanCount is the number of fields examined by HScan if the count is not given
+const defaultHScanCount = 10
+
 func (txn *Txn) HSet(key, field, value []byte) error {
 	if err := checkKey(key); err != nil {
 		return err
@@ -25,15 +35,12 @@ func (txn *Txn) HSet(key, field, value []byte) error {
 		DataType: data.Hash,
 	}
 
-	pos, err := txn.db.appendLogRecordWithLock(logRecord)
+	pos, err := txn.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
-	if _, ok := txn.hashPendingWrites[string(key)]; !ok {
-		txn.hashPendingWrites[string(key)] = make(map[string]*pendingWrite)
-	}
-	txn.hashPendingWrites[string(key)][string(field)] = &pendingWrite{typ: data.LogRecordNormal, LogPos: pos}
+	txn.putSubPendingWrite(txn.hashPendingWrites, string(key), string(field), &pendingWrite{typ: data.LogRecordNormal, LogPos: pos})
 	return nil
 }
 
@@ -49,10 +56,8 @@ func (txn *Txn) HGet(key, field []byte) ([]byte, error) {
 		return nil, public.ErrKeyNotFound
 	}
 
-	if idx, ok := txn.db.index.getHashIndex(string(key)); ok {
-		if pos := idx.Get(field); pos != nil {
-			return txn.db.getValueByPos(pos)
-		}
+	if pos := txn.hashFieldPos(key, field); pos != nil {
+		return txn.db.getValueByPos(pos)
 	}
 	return nil, public.ErrKeyNotFound
 }
@@ -66,14 +71,8 @@ func (txn *Txn) HDel(key, field []byte) error {
 		if pw.typ == data.LogRecordDeleted {
 			return public.ErrKeyNotFound
 		}
-	} else {
-		if idx, ok := txn.db.index.getHashIndex(string(key)); !ok {
-			return public.ErrKeyNotFound
-		} else {
-			if pos := idx.Get(field); pos == nil {
-				return public.ErrKeyNotFound
-			}
-		}
+	} else if pos := txn.hashFieldPos(key, field); pos == nil {
+		return public.ErrKeyNotFound
 	}
 
 	logRecord := &data.LogRecord{
@@ -81,12 +80,12 @@ func (txn *Txn) HDel(key, field []byte) error {
 		Type:     data.LogRecordDeleted,
 		DataType: data.Hash,
 	}
-	pos, err := txn.db.appendLogRecordWithLock(logRecord)
+	pos, err := txn.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
-	txn.hashPendingWrites[string(key)][string(field)] = &pendingWrite{typ: data.LogRecordDeleted, LogPos: pos}
+	txn.putSubPendingWrite(txn.hashPendingWrites, string(key), string(field), &pendingWrite{typ: data.LogRecordDeleted, LogPos: pos})
 	return nil
 }
 
@@ -98,43 +97,20 @@ func (txn *Txn) HExist(key, field []byte) bool {
 		return false
 	}
 
-	if idx, ok := txn.db.index.getHashIndex(string(key)); ok {
-		if pos := idx.Get(field); pos != nil {
-			return true
-		}
-	}
-	return true
+	return txn.hashFieldPos(key, field) != nil
 }
 
 func (txn *Txn) HGetAll(key []byte) ([][]byte, [][]byte, error) {
 	fields, values := make([][]byte, 0), make([][]byte, 0)
 
-	for field, pw := range txn.hashPendingWrites[string(key)] {
-		if pw.typ != data.LogRecordDeleted {
-			v, err := txn.db.getValueByPos(pw.LogPos)
-			if err != nil {
-				return nil, nil, err
-			}
-			fields = append(fields, []byte(field))
-			values = append(values, v)
-		}
-	}
-
-	if hash, ok := txn.db.index.getHashIndex(string(key)); ok {
-		iterator := hash.Iterator(false)
-
-		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-			if _, ok := txn.hashPendingWrites[string(key)][string(iterator.Key())]; ok {
-				continue
-			}
-
-			v, err := txn.db.getValueByPos(iterator.Value())
-			if err != nil {
-				return nil, nil, err
-			}
-			fields = append(fields, iterator.Key())
-			values = append(values, v)
+	positions := txn.hashFields(key)
+	for _, field := range sortedFields(positions) {
+		v, err := txn.db.getValueByPos(positions[field])
+		if err != nil {
+			return nil, nil, err
 		}
+		fields = append(fields, []byte(field))
+		values = append(values, v)
 	}
 
 	return fields, values, nil
@@ -175,20 +151,8 @@ func (txn *Txn) HMSet(key []byte, args [][]byte) error {
 
 func (txn *Txn) HKeys(key []byte) ([][]byte, error) {
 	fields := make([][]byte, 0)
-	for field, pw := range txn.hashPendingWrites[string(key)] {
-		if pw.typ != data.LogRecordDeleted {
-			fields = append(fields, []byte(field))
-		}
-	}
-
-	if hash, ok := txn.db.index.getHashIndex(string(key)); ok {
-		iterator := hash.Iterator(false)
-		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-			if _, ok := txn.hashPendingWrites[string(key)][string(iterator.Key())]; ok {
-				continue
-			}
-			fields = append(fields, iterator.Key())
-		}
+	for _, field := range sortedFields(txn.hashFields(key)) {
+		fields = append(fields, []byte(field))
 	}
 	if len(fields) == 0 {
 		return [][]byte{}, public.ErrKeyNotFound
@@ -198,27 +162,13 @@ func (txn *Txn) HKeys(key []byte) ([][]byte, error) {
 
 func (txn *Txn) HValues(key []byte) ([][]byte, error) {
 	values := make([][]byte, 0)
-	for _, pw := range txn.hashPendingWrites[string(key)] {
-		if pw.typ != data.LogRecordDeleted {
-			v, err := txn.db.getValueByPos(pw.LogPos)
-			if err != nil {
-				return nil, err
-			}
-			values = append(values, v)
-		}
-	}
-	if hash, ok := txn.db.index.getHashIndex(string(key)); ok {
-		iterator := hash.Iterator(false)
-		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-			if _, ok := txn.hashPendingWrites[string(key)][string(iterator.Key())]; ok {
-				continue
-			}
-			v, err := txn.db.getValueByPos(iterator.Value())
-			if err != nil {
-				return nil, err
-			}
-			values = append(values, v)
+	positions := txn.hashFields(key)
+	for _, field := range sortedFields(positions) {
+		v, err := txn.db.getValueByPos(positions[field])
+		if err != nil {
+			return nil, err
 		}
+		values = append(values, v)
 	}
 	if len(values) == 0 {
 		return [][]byte{}, public.ErrKeyNotFound
@@ -242,6 +192,174 @@ func (txn *Txn) HLen(key []byte) (int64, error) {
 	return int64(len(fields)), nil
 }
 
+// HSetNX sets the field only if it does not exist, it returns whether the field is set
+func (txn *Txn) HSetNX(key, field, value []byte) (bool, error) {
+	if _, err := txn.HGet(key, field); err == nil {
+		return false, nil
+	} else if err != public.ErrKeyNotFound {
+		return false, err
+	}
+	if err := txn.HSet(key, field, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// HIncrBy adds delta to the integer stored in the field and returns the new value,
+// a field which does not exist is taken as 0
+func (txn *Txn) HIncrBy(key, field []byte, delta int64) (int64, error) {
+	var i int64
+	value, err := txn.HGet(key, field)
+	if err == nil {
+		if i, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, public.ErrValueIsNotInteger
+		}
+	} else if err != public.ErrKeyNotFound {
+		return 0, err
+	}
+
+	if (delta > 0 && i > math.MaxInt64-delta) || (delta < 0 && i < math.MinInt64-delta) {
+		return 0, public.ErrIncrOverflow
+	}
+	i += delta
+	if err := txn.HSet(key, field, []byte(strconv.FormatInt(i, 10))); err != nil {
+		return 0, err
+	}
+	return i, nil
+}
+
+// HIncrByFloat adds delta to the float stored in the field and returns the new value,
+// a field which does not exist is taken as 0
+func (txn *Txn) HIncrByFloat(key, field []byte, delta float64) (float64, error) {
+	var f float64
+	value, err := txn.HGet(key, field)
+	if err == nil {
+		if f, err = strconv.ParseFloat(string(value), 64); err != nil {
+			return 0, public.ErrValueIsNotFloat
+		}
+	} else if err != public.ErrKeyNotFound {
+		return 0, err
+	}
+
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, public.ErrValueIsNotFloat
+	}
+	if err := txn.HSet(key, field, []byte(strconv.FormatFloat(f, 'f', -1, 64))); err != nil {
+		return 0, err
+	}
+	return f, nil
+}
+
+// HRandField returns random fields of the hash and their values like redis, a positive count returns
+// up to count distinct fields and a negative count returns -count fields which may repeat
+func (txn *Txn) HRandField(key []byte, count int) ([][]byte, [][]byte, error) {
+	positions := txn.hashFields(key)
+	fields := make([]string, 0, len(positions))
+	for field := range positions {
+		fields = append(fields, field)
+	}
+
+	picked := make([]string, 0)
+	if count < 0 {
+		for len(fields) > 0 && len(picked) < -count {
+			picked = append(picked, fields[rand.Intn(len(fields))])
+		}
+	} else {
+		rand.Shuffle(len(fields), func(i, j int) {
+			fields[i], fields[j] = fields[j], fields[i]
+		})
+		if count < len(fields) {
+			fields = fields[:count]
+		}
+		picked = fields
+	}
+
+	resFields, values := make([][]byte, len(picked)), make([][]byte, len(picked))
+	for i, field := range picked {
+		v, err := txn.db.getValueByPos(positions[field])
+		if err != nil {
+			return nil, nil, err
+		}
+		resFields[i], values[i] = []byte(field), v
+	}
+	return resFields, values, nil
+}
+
+// HScan examines up to count fields after cursor in field order and returns the ones matching the pattern.
+// A nil cursor starts the scan, and the cursor returned is nil once all the fields are examined.
+// The fields which exist during the whole scan are returned exactly once, an empty match matches all the fields
+func (txn *Txn) HScan(key, cursor []byte, match string, count int) ([]byte, [][]byte, [][]byte, error) {
+	if count < 0 {
+		return nil, nil, nil, public.ErrTxnArgsWrong
+	}
+	if count == 0 {
+		count = defaultHScanCount
+	}
+	var pattern *wildcard.Pattern
+	if match != "" {
+		pattern = wildcard.CompilePattern(match)
+	}
+
+	names, positions, more := txn.scanPositions(data.Hash, key, cursor, count, func() (meta.MemTable, bool) {
+		return txn.db.index.getHashIndex(string(key))
+	}, txn.hashPendingWrites[string(key)])
+
+	fields, values := make([][]byte, 0), make([][]byte, 0)
+	for i, field := range names {
+		if pattern != nil && !pattern.IsMatch(field) {
+			continue
+		}
+		v, err := txn.db.getValueByPos(positions[i])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		fields = append(fields, []byte(field))
+		values = append(values, v)
+	}
+
+	var next []byte
+	if more {
+		next = []byte(names[len(names)-1])
+	}
+	return next, fields, values, nil
+}
+
+// hashFields returns the positions of the fields of the hash with the writes of the txn applied
+func (txn *Txn) hashFields(key []byte) map[string]*data.LogPos {
+	positions := txn.committedPositions(data.Hash, key, func() (meta.MemTable, bool) {
+		return txn.db.index.getHashIndex(string(key))
+	})
+	for field, pw := range txn.hashPendingWrites[string(key)] {
+		if pw.typ == data.LogRecordDeleted {
+			delete(positions, field)
+		} else {
+			positions[field] = pw.LogPos
+		}
+	}
+	return positions
+}
+
+// hashFieldPos returns the position of the committed field as seen by the txn
+func (txn *Txn) hashFieldPos(key, field []byte) *data.LogPos {
+	return txn.committedPos(data.Hash, key, string(field), func() *data.LogPos {
+		if idx, ok := txn.db.index.getHashIndex(string(key)); ok {
+			return idx.Get(field)
+		}
+		return nil
+	})
+}
+
+// sortedFields returns the fields or members of the positions in order
+func sortedFields(positions map[string]*data.LogPos) []string {
+	fields := make([]string, 0, len(positions))
+	for field := range positions {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
 func encodeFieldKey(key, field []byte) []byte {
 	return bytex.EncodeByteSlices(key, field)
 }
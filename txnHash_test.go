@@ -1,10 +1,13 @@
 package CouloyDB
 
 import (
+	"fmt"
+	"math"
+	"testing"
+
 	"github.com/Kirov7/CouloyDB/public"
 	"github.com/Kirov7/CouloyDB/public/utils/bytex"
 	"github.com/stretchr/testify/assert"
-	"testing"
 )
 
 func TestTxn_HSet(t *testing.T) {
@@ -92,9 +95,16 @@ func TestTxn_HExist(t *testing.T) {
 		exist = txn.HExist(bytex.GetTestKey(0), bytex.GetTestKey(0))
 		assert.False(t, exist)
 
-		return nil
+		return txn.HSet(bytex.GetTestKey(0), bytex.GetTestKey(1), bytex.GetTestKey(1))
 	})
+	assert.Nil(t, err)
 
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		assert.True(t, txn.HExist(bytex.GetTestKey(0), bytex.GetTestKey(1)))
+		assert.False(t, txn.HExist(bytex.GetTestKey(0), bytex.GetTestKey(2)))
+		assert.False(t, txn.HExist(bytex.GetTestKey(1), bytex.GetTestKey(1)))
+		return nil
+	})
 	assert.Nil(t, err)
 }
 
@@ -390,3 +400,189 @@ func TestTxn_HLEN(t *testing.T) {
 	})
 	assert.Nil(t, err)
 }
+
+func TestTxn_HIncrBy_HSetNX(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("counters")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		n, err := txn.HIncrBy(key, []byte("hits"), 5)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(5), n)
+		n, err = txn.HIncrBy(key, []byte("hits"), -7)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(-2), n)
+
+		f, err := txn.HIncrByFloat(key, []byte("ratio"), 0.5)
+		assert.Nil(t, err)
+		assert.Equal(t, 0.5, f)
+		f, err = txn.HIncrByFloat(key, []byte("hits"), 0.25)
+		assert.Nil(t, err)
+		assert.Equal(t, -1.75, f)
+
+		set, err := txn.HSetNX(key, []byte("flag"), []byte("on"))
+		assert.Nil(t, err)
+		assert.True(t, set)
+		set, err = txn.HSetNX(key, []byte("flag"), []byte("off"))
+		assert.Nil(t, err)
+		assert.False(t, set)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		v, err := txn.HGet(key, []byte("hits"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("-1.75"), v)
+		v, err = txn.HGet(key, []byte("flag"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("on"), v)
+
+		_, err = txn.HIncrBy(key, []byte("hits"), 1)
+		assert.Equal(t, public.ErrValueIsNotInteger, err)
+		_, err = txn.HIncrBy(key, []byte("flag"), 1)
+		assert.Equal(t, public.ErrValueIsNotInteger, err)
+		_, err = txn.HIncrByFloat(key, []byte("flag"), 1)
+		assert.Equal(t, public.ErrValueIsNotFloat, err)
+
+		_, err = txn.HIncrBy(key, []byte("max"), math.MaxInt64)
+		assert.Nil(t, err)
+		_, err = txn.HIncrBy(key, []byte("max"), 1)
+		assert.Equal(t, public.ErrIncrOverflow, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxn_HRandField_HScan(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("flags")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		for i := 0; i < 25; i++ {
+			if err := txn.HSet(key, []byte(fmt.Sprintf("feature:%02d", i)), []byte(fmt.Sprintf("%d", i))); err != nil {
+				return err
+			}
+		}
+		return txn.HSet(key, []byte("other"), []byte("x"))
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		fields, values, err := txn.HRandField(key, 5)
+		assert.Nil(t, err)
+		assert.Len(t, fields, 5)
+		for i := range fields {
+			v, err := txn.HGet(key, fields[i])
+			assert.Nil(t, err)
+			assert.Equal(t, v, values[i])
+		}
+		fields, _, err = txn.HRandField(key, 100)
+		assert.Nil(t, err)
+		assert.Len(t, fields, 26)
+		fields, _, err = txn.HRandField(key, -100)
+		assert.Nil(t, err)
+		assert.Len(t, fields, 100)
+
+		// the fields set during the scan are visited if they are after the cursor
+		seen := make(map[string]bool)
+		var cursor []byte
+		for rounds := 0; ; rounds++ {
+			next, fields, values, err := txn.HScan(key, cursor, "feature:*", 7)
+			assert.Nil(t, err)
+			for i, field := range fields {
+				assert.False(t, seen[string(field)])
+				seen[string(field)] = true
+				v, err := txn.HGet(key, field)
+				assert.Nil(t, err)
+				assert.Equal(t, v, values[i])
+			}
+			if rounds == 0 {
+				assert.Nil(t, txn.HSet(key, []byte("feature:99"), []byte("99")))
+			}
+			if next == nil {
+				break
+			}
+			cursor = next
+		}
+		assert.Len(t, seen, 26)
+		assert.False(t, seen["other"])
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxn_HScan_Writes(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("hash")
+	field := func(i int) []byte {
+		return []byte(fmt.Sprintf("field:%03d", i))
+	}
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		for i := 0; i < 100; i++ {
+			if err := txn.HSet(key, field(i), []byte("v")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	scan := func(txn *Txn) []string {
+		var result []string
+		var cursor []byte
+		for {
+			next, fields, _, err := txn.HScan(key, cursor, "", 5)
+			assert.Nil(t, err)
+			assert.LessOrEqual(t, len(fields), 5)
+			for _, f := range fields {
+				result = append(result, string(f))
+			}
+			if next == nil {
+				return result
+			}
+			cursor = next
+		}
+	}
+
+	reader, err := db.Begin(TxnOptions{ReadOnly: true, IsolationLevel: Serializable})
+	assert.Nil(t, err)
+	defer reader.Discard()
+
+	// the fields deleted by the txn span several chunks of the index
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		for i := 10; i < 60; i++ {
+			if err := txn.HDel(key, field(i)); err != nil {
+				return err
+			}
+		}
+		if err := txn.HSet(key, []byte("field:055x"), []byte("v")); err != nil {
+			return err
+		}
+		fields := scan(txn)
+		assert.Len(t, fields, 51)
+		assert.Equal(t, "field:009", fields[9])
+		assert.Equal(t, "field:055x", fields[10])
+		assert.Equal(t, "field:060", fields[11])
+		assert.Equal(t, "field:099", fields[50])
+		return nil
+	})
+	assert.Nil(t, err)
+
+	// the reader started before the deletes still scans all the fields
+	fields := scan(reader)
+	assert.Len(t, fields, 100)
+	for i, f := range fields {
+		assert.Equal(t, string(field(i)), f)
+	}
+}
@@ -0,0 +1,130 @@
+package CouloyDB
+
+import (
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/public/ds"
+)
+
+// PFAdd adds the elements to the HyperLogLog of key, it is created if it does not exist.
+// It reports whether the estimated cardinality may have changed
+func (txn *Txn) PFAdd(key []byte, elements ...[]byte) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+	if txn.readOnly {
+		return false, public.ErrUpdateInReadOnlyTxn
+	}
+
+	hll, err := txn.getHLL(key)
+	if err != nil && err != public.ErrKeyNotFound {
+		return false, err
+	}
+	changed := err == public.ErrKeyNotFound
+	if changed {
+		hll = ds.NewHyperLogLog()
+	}
+	for _, element := range elements {
+		if hll.Add(element) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return false, nil
+	}
+	return true, txn.putHLL(key, hll)
+}
+
+// PFCount returns the estimated cardinality of the union of the HyperLogLogs of keys,
+// the keys which do not exist are ignored
+func (txn *Txn) PFCount(keys ...[]byte) (uint64, error) {
+	union, err := txn.unionHLL(keys)
+	if err != nil {
+		return 0, err
+	}
+	return union.Count(), nil
+}
+
+// PFMerge stores the union of the HyperLogLogs of dest and keys in dest
+func (txn *Txn) PFMerge(dest []byte, keys ...[]byte) error {
+	if err := checkKey(dest); err != nil {
+		return err
+	}
+	if txn.readOnly {
+		return public.ErrUpdateInReadOnlyTxn
+	}
+
+	union, err := txn.unionHLL(append([][]byte{dest}, keys...))
+	if err != nil {
+		return err
+	}
+	return txn.putHLL(dest, union)
+}
+
+func (txn *Txn) unionHLL(keys [][]byte) (*ds.HyperLogLog, error) {
+	union := ds.NewHyperLogLog()
+	for _, key := range keys {
+		hll, err := txn.getHLL(key)
+		if err == public.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		union.Merge(hll)
+	}
+	return union, nil
+}
+
+func (txn *Txn) getHLL(key []byte) (*ds.HyperLogLog, error) {
+	var pos *data.LogPos
+	if pw, ok := txn.hllPendingWrites[string(key)]; ok {
+		if pw.typ == data.LogRecordDeleted {
+			return nil, public.ErrKeyNotFound
+		}
+		pos = pw.LogPos
+	} else {
+		pos = txn.committedPos(data.HyperLogLog, key, "", func() *data.LogPos {
+			return txn.db.index.getHLLIndex().Get(key)
+		})
+		if pos == nil {
+			return nil, public.ErrKeyNotFound
+		}
+	}
+
+	v, err := txn.db.getValueByPos(pos)
+	if err != nil {
+		return nil, err
+	}
+	return ds.DecodeHyperLogLog(v)
+}
+
+func (txn *Txn) putHLL(key []byte, hll *ds.HyperLogLog) error {
+	logRecord := &data.LogRecord{
+		Key:      encodeKeyWithTxId(key, txn.startTs),
+		Value:    hll.Encode(),
+		Type:     data.LogRecordNormal,
+		DataType: data.HyperLogLog,
+	}
+	pos, err := txn.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	txn.putPendingWrite(txn.hllPendingWrites, string(key), &pendingWrite{typ: data.LogRecordNormal, LogPos: pos})
+	return nil
+}
+
+func (txn *Txn) delHLL(key []byte) error {
+	logRecord := &data.LogRecord{
+		Key:      encodeKeyWithTxId(key, txn.startTs),
+		Type:     data.LogRecordDeleted,
+		DataType: data.HyperLogLog,
+	}
+	pos, err := txn.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	txn.putPendingWrite(txn.hllPendingWrites, string(key), &pendingWrite{typ: data.LogRecordDeleted, LogPos: pos})
+	return nil
+}
@@ -0,0 +1,88 @@
+package CouloyDB
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxnHyperLogLog(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	page1, page2, all := []byte("page1"), []byte("page2"), []byte("all")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		changed, err := txn.PFAdd(page1)
+		assert.Nil(t, err)
+		assert.True(t, changed)
+		changed, err = txn.PFAdd(page1)
+		assert.Nil(t, err)
+		assert.False(t, changed)
+
+		for i := 0; i < 30000; i++ {
+			if _, err := txn.PFAdd(page1, []byte("user"+strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		visitors := make([][]byte, 0)
+		for i := 20000; i < 50000; i++ {
+			visitors = append(visitors, []byte("user"+strconv.Itoa(i)))
+		}
+		_, err = txn.PFAdd(page2, visitors...)
+		return err
+	})
+	assert.Nil(t, err)
+
+	near := func(expected float64, count uint64) {
+		assert.Less(t, math.Abs(float64(count)-expected)/expected, 0.03, "count = %d", count)
+	}
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		count, err := txn.PFCount(page1)
+		assert.Nil(t, err)
+		near(30000, count)
+
+		count, err = txn.PFCount(page1, page2, []byte("missing"))
+		assert.Nil(t, err)
+		near(50000, count)
+
+		assert.Nil(t, txn.PFMerge(all, page1, page2))
+		count, err = txn.PFCount(all)
+		assert.Nil(t, err)
+		near(50000, count)
+
+		count, err = txn.PFCount([]byte("missing"))
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(0), count)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		_, err := txn.PFAdd(page1, []byte("user"))
+		assert.Equal(t, public.ErrUpdateInReadOnlyTxn, err)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	// the merged registers are found after merge and reboot
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(db.options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		count, err := txn.PFCount(all)
+		assert.Nil(t, err)
+		near(50000, count)
+		count, err = txn.PFCount(page1)
+		assert.Nil(t, err)
+		near(30000, count)
+		return nil
+	})
+	assert.Nil(t, err)
+}
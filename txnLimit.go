@@ -0,0 +1,101 @@
+package CouloyDB
+
+import (
+	"time"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/public"
+)
+
+// The size of a txn is bounded by MaxTxnRecords and MaxTxnBytes, the writes passing them fail and the
+// txn can still be committed with the writes before. The lifetime of a txn is bounded by MaxTxnLifetime, an
+// older txn fails to write and to commit, and it is rolled back once it passes the lifetime even if its closure has
+// not returned yet, so it does not keep the committed txns from being pruned by the oracle
+
+// appendLogRecord appends a record written by the txn, unless it passes the limits of the options.
+// Every record of the txn is appended through it, the records stay in the log until the txn finishes
+func (txn *Txn) appendLogRecord(logRecord *data.LogRecord) (*data.LogPos, error) {
+	if txn.unlimited {
+		return txn.db.appendLogRecordWithLock(logRecord)
+	}
+	if err := txn.checkLifetime(); err != nil {
+		return nil, err
+	}
+	opt := txn.db.options
+	size := int64(len(logRecord.Key) + len(logRecord.Value))
+	if opt.MaxTxnRecords > 0 && txn.recordCount >= opt.MaxTxnRecords {
+		return nil, public.ErrTxnTooManyRecords
+	}
+	if opt.MaxTxnBytes > 0 && txn.writeBytes+size > opt.MaxTxnBytes {
+		return nil, public.ErrTxnTooLarge
+	}
+
+	pos, err := txn.db.appendLogRecordWithLock(logRecord)
+	if err != nil {
+		return nil, err
+	}
+	txn.recordCount++
+	txn.writeBytes += size
+	return pos, nil
+}
+
+// checkLifetime returns ErrTxnTooOld if the txn has run longer than MaxTxnLifetime
+func (txn *Txn) checkLifetime() error {
+	if lifetime := txn.db.options.MaxTxnLifetime; lifetime > 0 && !txn.unlimited && txn.age() > lifetime {
+		return public.ErrTxnTooOld
+	}
+	return nil
+}
+
+// age returns how long the txn has run, the start ts follows the unix nanoseconds
+func (txn *Txn) age() time.Duration {
+	return time.Since(time.Unix(0, txn.startTs))
+}
+
+// abort rolls back the txn of the handle if it is not finished yet, its Commit and Rollback return the err
+func (h *txnHandle) abort(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.finished {
+		return
+	}
+	h.finish()
+	h.abortErr = err
+
+	h.db.rollbackTxn(h.startTs)
+}
+
+// closedErr returns the error of finishing a txn which is finished already
+func (h *txnHandle) closedErr() error {
+	if h.abortErr != nil {
+		return h.abortErr
+	}
+	return public.ErrTxnClosed
+}
+
+// checkFinished returns the error of finishing a txn with a handle if it is finished already
+func (txn *Txn) checkFinished() error {
+	h := txn.handle
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.finished {
+		return h.closedErr()
+	}
+	return nil
+}
+
+// OldestTxnAge returns how long the oldest active txn has run, zero if there is no active txn.
+// The committed txns are kept for the conflict checks until the oldest active txn started after them
+func (db *DB) OldestTxnAge() time.Duration {
+	o := db.oracle
+	o.txnsMu.Lock()
+	startTs, err := o.peekActiveTxn()
+	o.txnsMu.Unlock()
+	if err != nil {
+		return 0
+	}
+	return time.Since(time.Unix(0, startTs))
+}
@@ -0,0 +1,106 @@
+package CouloyDB
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxn_Limits(t *testing.T) {
+	options := DefaultOptions()
+	options.MaxTxnRecords = 2
+	options.MaxTxnBytes = 64
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		assert.Nil(t, txn.Set([]byte("k1"), []byte("v1")))
+		assert.Nil(t, txn.HSet([]byte("hash"), []byte("f1"), []byte("v1")))
+		// the writes before the limit are still committed
+		assert.Equal(t, public.ErrTxnTooManyRecords, txn.Set([]byte("k2"), []byte("v2")))
+		return nil
+	})
+	assert.Nil(t, err)
+	value, err := db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), value)
+	_, err = db.Get([]byte("k2"))
+	assert.Equal(t, public.ErrKeyNotFound, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.Set([]byte("k3"), make([]byte, 64))
+	})
+	assert.Equal(t, public.ErrTxnTooLarge, err)
+	_, err = db.Get([]byte("k3"))
+	assert.Equal(t, public.ErrKeyNotFound, err)
+}
+
+func TestTxn_Limits_List(t *testing.T) {
+	options := DefaultOptions()
+	options.MaxTxnRecords = 2
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+
+	// every element pushed is a record
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.RPush([]byte("list"), [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	})
+	assert.Equal(t, public.ErrTxnTooManyRecords, err)
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		_, err := txn.LLen([]byte("list"))
+		return err
+	})
+	assert.Equal(t, public.ErrKeyNotFound, err)
+}
+
+func TestTxn_MaxLifetime(t *testing.T) {
+	options := DefaultOptions()
+	options.MaxTxnLifetime = 100 * time.Millisecond
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	assert.Equal(t, time.Duration(0), db.OldestTxnAge())
+	txn, err := db.Begin(TxnOptions{IsolationLevel: Serializable})
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Set([]byte("k1"), []byte("v1")))
+	assert.Greater(t, db.OldestTxnAge(), time.Duration(0))
+
+	// the txn is aborted once it passes its lifetime, and no longer counts as active
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, public.ErrTxnTooOld, txn.Set([]byte("k2"), []byte("v2")))
+	assert.Equal(t, public.ErrTxnTooOld, txn.Commit())
+	assert.Equal(t, time.Duration(0), db.OldestTxnAge())
+	_, err = db.Get([]byte("k1"))
+	assert.Equal(t, public.ErrKeyNotFound, err)
+
+	// a txn which is not opened by Begin is aborted while its closure runs and fails to commit
+	err = db.RWTransaction(false, func(txn *Txn) error {
+		assert.Nil(t, txn.Set([]byte("k1"), []byte("v1")))
+		assert.Greater(t, db.OldestTxnAge(), time.Duration(0))
+		time.Sleep(200 * time.Millisecond)
+		assert.Equal(t, time.Duration(0), db.OldestTxnAge())
+		assert.Equal(t, public.ErrTxnTooOld, txn.Set([]byte("k2"), []byte("v2")))
+		assert.Equal(t, public.ErrTxnNotBegun, txn.Commit())
+		return nil
+	})
+	assert.Equal(t, public.ErrTxnTooOld, err)
+	_, err = db.Get([]byte("k1"))
+	assert.Equal(t, public.ErrKeyNotFound, err)
+
+	// the error of the closure is returned even if the txn was aborted
+	failed := errors.New("failed")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		time.Sleep(200 * time.Millisecond)
+		return failed
+	})
+	assert.Equal(t, failed, err)
+	assert.Equal(t, time.Duration(0), db.OldestTxnAge())
+}
@@ -1,12 +1,43 @@
 package CouloyDB
 
 import (
+	"bytes"
 	"encoding/binary"
+	"sort"
+
 	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/meta"
 	"github.com/Kirov7/CouloyDB/public"
-	"math/big"
 )
 
+// Every element of a list is stored with its seq and the seqs of its neighbours, the seqs are increasing
+// from head to tail. Pushing takes the seq listSeqGap beyond the head or the tail, inserting takes the middle
+// of the neighbours, and the neighbours are rewritten when an element is inserted or removed between them.
+// When there is no room left between two neighbours, the elements around them are respaced.
+// The seqs beyond the head and the tail are only placeholders, they are smaller than the head or
+// greater than the tail so that a list is empty once its head seq is greater than its tail seq
+
+const (
+	// listFormat is the first byte of the keys and the metas of the lists, it is odd so that
+	// it never equals the first byte of the legacy format which is an even zigzag varint
+	listFormat byte = 1
+
+	// listSeqSize is the size of a seq in the list data index
+	listSeqSize = 8
+
+	// listSeqGap is the distance between two pushed elements
+	listSeqGap int64 = 1 << 20
+
+	// listMinSpacing is the minimum distance between two elements after the list is respaced
+	listMinSpacing int64 = 1 << 10
+)
+
+// listElement is an element of a list as seen by the txn
+type listElement struct {
+	seq int64
+	pos *data.LogPos
+}
+
 func (txn *Txn) LPush(key []byte, values [][]byte) error {
 	return txn.push(key, values, true)
 }
@@ -23,94 +54,322 @@ func (txn *Txn) RPop(key []byte) ([]byte, error) {
 	return txn.pop(key, false)
 }
 
+// LLen returns the number of elements in the list, it returns ErrKeyNotFound if the list does not exist
 func (txn *Txn) LLen(key []byte) (int, error) {
-	idx, ok := txn.db.index.getListDataIndex(string(key))
-	var l int
-	if ok {
-		l = idx.Count()
-	}
-	if pendingWrites, has := txn.listDataPendingWrites[string(key)]; has {
-		for seq, pw := range pendingWrites {
-			if pw.typ == data.LogRecordDeleted {
-				l--
-			} else if ok {
-				if pos := idx.Get([]byte(seq)); pos == nil {
-					l++
-				}
-			} else {
-				l++
-			}
-		}
-		return l, nil
+	length := txn.listLen(key)
+	if length == 0 {
+		return 0, public.ErrKeyNotFound
 	}
-	return 0, public.ErrKeyNotFound
+	return length, nil
 }
 
+// LIndex returns the element at index, negative indexes count from the tail
 func (txn *Txn) LIndex(key []byte, index int) ([]byte, error) {
-	return nil, nil
+	element := txn.listElementAt(key, index)
+	if element == nil {
+		return nil, public.ErrListIndexOutOfRange
+	}
+	value, _, _, err := txn.readListElement(element.pos)
+	return value, err
 }
 
+// LSet replaces the element at index, negative indexes count from the tail
 func (txn *Txn) LSet(key []byte, index int, value []byte) error {
-	return nil
+	if txn.readOnly {
+		return public.ErrUpdateInReadOnlyTxn
+	}
+	element := txn.listElementAt(key, index)
+	if element == nil {
+		if txn.listLen(key) == 0 {
+			return public.ErrKeyNotFound
+		}
+		return public.ErrListIndexOutOfRange
+	}
+	_, prev, next, err := txn.readListElement(element.pos)
+	if err != nil {
+		return err
+	}
+	return txn.putListElement(key, element.seq, prev, next, value)
 }
 
-func (txn *Txn) LRem(key []byte, index int) error {
-	return nil
+// LRem removes the elements equal to value and returns the number of elements removed like redis:
+// count > 0 removes count elements from head to tail, count < 0 removes -count elements
+// from tail to head and count = 0 removes all the elements equal to value
+func (txn *Txn) LRem(key []byte, count int, value []byte) (int, error) {
+	if txn.readOnly {
+		return 0, public.ErrUpdateInReadOnlyTxn
+	}
+	elements, err := txn.listElements(key)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := make(map[int]bool)
+	for i := range elements {
+		idx := i
+		if count < 0 {
+			idx = len(elements) - 1 - i
+		}
+		v, _, _, err := txn.readListElement(elements[idx].pos)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(v, value) {
+			removed[idx] = true
+			if count != 0 && len(removed) == abs(count) {
+				break
+			}
+		}
+	}
+	return len(removed), txn.removeListElements(key, elements, removed)
 }
 
+// LRange returns the elements from start to stop inclusive, negative indexes count from the tail
 func (txn *Txn) LRange(key []byte, start, stop int) ([][]byte, error) {
-	return nil, nil
+	start, stop = normalizeListRange(start, stop, txn.listLen(key))
+	elements := make([]*listElement, 0)
+	if start <= stop {
+		i := 0
+		txn.walkList(key, false, func(element *listElement) bool {
+			if i >= start {
+				elements = append(elements, element)
+			}
+			i++
+			return i <= stop
+		})
+	}
+
+	values := make([][]byte, 0, len(elements))
+	for _, element := range elements {
+		value, _, _, err := txn.readListElement(element.pos)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
 }
 
+// LTrim keeps the elements from start to stop inclusive and removes the others,
+// negative indexes count from the tail
 func (txn *Txn) LTrim(key []byte, start, stop int) error {
-	return nil
+	if txn.readOnly {
+		return public.ErrUpdateInReadOnlyTxn
+	}
+	elements, err := txn.listElements(key)
+	if err != nil {
+		return err
+	}
+	start, stop = normalizeListRange(start, stop, len(elements))
+	removed := make(map[int]bool)
+	for i := range elements {
+		if i < start || i > stop {
+			removed[i] = true
+		}
+	}
+	return txn.removeListElements(key, elements, removed)
+}
+
+// LInsert inserts value before or after the first element equal to pivot and returns the length of the list.
+// Like redis, it returns 0 if the list does not exist and -1 if pivot is not found
+func (txn *Txn) LInsert(key []byte, before bool, pivot, value []byte) (int, error) {
+	if txn.readOnly {
+		return 0, public.ErrUpdateInReadOnlyTxn
+	}
+	elements, err := txn.listElements(key)
+	if err != nil || len(elements) == 0 {
+		return 0, err
+	}
+
+	at := -1
+	for i, element := range elements {
+		v, _, _, err := txn.readListElement(element.pos)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(v, pivot) {
+			at = i
+			break
+		}
+	}
+	if at == -1 {
+		return -1, nil
+	}
+	// the new element is inserted between left and right
+	left, right := at-1, at
+	if !before {
+		left, right = at, at+1
+	}
+
+	if left >= 0 && right < len(elements) && elements[right].seq-elements[left].seq < 2 {
+		if elements, err = txn.respaceList(key, elements, right); err != nil {
+			return 0, err
+		}
+	}
+
+	var seq int64
+	switch {
+	case left < 0:
+		seq = elements[right].seq - listSeqGap
+	case right == len(elements):
+		seq = elements[left].seq + listSeqGap
+	default:
+		seq = elements[left].seq + (elements[right].seq-elements[left].seq)/2
+	}
+
+	prev, next := seq-listSeqGap, seq+listSeqGap
+	if left >= 0 {
+		prev = elements[left].seq
+		if err := txn.relinkListElement(key, elements[left], nil, &seq); err != nil {
+			return 0, err
+		}
+	}
+	if right < len(elements) {
+		next = elements[right].seq
+		if err := txn.relinkListElement(key, elements[right], &seq, nil); err != nil {
+			return 0, err
+		}
+	}
+	if err := txn.putListElement(key, seq, prev, next, value); err != nil {
+		return 0, err
+	}
+
+	headSeq, tailSeq := elements[0].seq, elements[len(elements)-1].seq
+	if left < 0 {
+		headSeq = seq
+	}
+	if right == len(elements) {
+		tailSeq = seq
+	}
+	if err := txn.putListMeta(key, headSeq, tailSeq); err != nil {
+		return 0, err
+	}
+	return len(elements) + 1, nil
 }
 
-func (txn *Txn) getListMeta(key []byte) (*big.Float, *big.Float, error) {
-	var headSeq, tailSeq *big.Float
+// LPos returns the indexes of the elements equal to value like redis.
+// rank is the first match to return, a negative rank searches from tail to head.
+// count is the number of matches to return, 0 returns all the matches.
+// maxLen is the number of elements to compare, 0 compares all the elements
+func (txn *Txn) LPos(key, value []byte, rank, count, maxLen int) ([]int, error) {
+	if rank == 0 || count < 0 || maxLen < 0 {
+		return nil, public.ErrTxnArgsWrong
+	}
+	var length int
+	if rank < 0 {
+		length = txn.listLen(key)
+	}
+
+	positions := make([]int, 0)
+	skip := abs(rank) - 1
+	i := 0
+	var err error
+	txn.walkList(key, rank < 0, func(element *listElement) bool {
+		if maxLen > 0 && i == maxLen {
+			return false
+		}
+		idx := i
+		if rank < 0 {
+			idx = length - 1 - i
+		}
+		i++
+		var v []byte
+		if v, _, _, err = txn.readListElement(element.pos); err != nil {
+			return false
+		}
+		if !bytes.Equal(v, value) {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		positions = append(positions, idx)
+		return count == 0 || len(positions) < count
+	})
+	if err != nil {
+		return nil, err
+	}
+	return positions, nil
+}
+
+// LMove pops an element from one end of src, pushes it to one end of dst and returns it,
+// src and dst can be the same list to rotate it
+func (txn *Txn) LMove(src, dst []byte, srcLeft, dstLeft bool) ([]byte, error) {
+	value, err := txn.pop(src, srcLeft)
+	if err != nil {
+		return nil, err
+	}
+	if err := txn.push(dst, [][]byte{value}, dstLeft); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (txn *Txn) getListMeta(key []byte) (int64, int64, error) {
 	if pw, ok := txn.listMetaPendingWrites[string(key)]; ok {
 		if pw.typ != data.LogRecordDeleted {
 			v, err := txn.db.getValueByPos(pw.LogPos)
 			if err != nil {
-				return big.NewFloat(0), big.NewFloat(0), err
+				return 0, 0, err
 			}
-			headSeq, tailSeq = decodeListMeta(v)
+			headSeq, tailSeq := decodeListMeta(v)
 			return headSeq, tailSeq, nil
 		}
-	} else if logPos := txn.db.index.getListMetaIndex().Get(key); logPos != nil {
+	} else if logPos := txn.committedPos(data.ListMeta, key, "", func() *data.LogPos {
+		return txn.db.index.getListMetaIndex().Get(key)
+	}); logPos != nil {
 		v, err := txn.db.getValueByPos(logPos)
 		if err != nil {
-			return big.NewFloat(0), big.NewFloat(0), err
+			return 0, 0, err
 		}
-		headSeq, tailSeq = decodeListMeta(v)
+		headSeq, tailSeq := decodeListMeta(v)
 		return headSeq, tailSeq, nil
 	}
 
-	return big.NewFloat(1), big.NewFloat(0), nil
+	// the first element pushed to either end takes the seq 0 or -listSeqGap
+	return 0, -listSeqGap, nil
 }
 
 func (txn *Txn) push(key []byte, values [][]byte, isLeft bool) error {
 	if txn.readOnly {
 		return public.ErrUpdateInReadOnlyTxn
 	}
-	if _, ok := txn.listDataPendingWrites[string(key)]; !ok {
-		txn.listDataPendingWrites[string(key)] = make(map[string]*pendingWrite)
-	}
 
 	headSeq, tailSeq, err := txn.getListMeta(key)
 	if err != nil {
 		return err
 	}
 
-	curSeq, prevSeq, nextSeq := new(big.Float), new(big.Float), new(big.Float)
+	// the end of a non-empty list must link to the first element pushed
+	if headSeq <= tailSeq {
+		endSeq, endPos := tailSeq, txn.getLogPosByLeftOrRight(key, headSeq, tailSeq, isLeft)
+		if isLeft {
+			endSeq = headSeq
+		}
+		if endPos != nil {
+			firstSeq := txn.allocPushSeq(headSeq, tailSeq, isLeft)
+			end := &listElement{seq: endSeq, pos: endPos}
+			if isLeft {
+				err = txn.relinkListElement(key, end, &firstSeq, nil)
+			} else {
+				err = txn.relinkListElement(key, end, nil, &firstSeq)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var curSeq, prevSeq, nextSeq int64
 
 	for _, value := range values {
 		curSeq = txn.allocPushSeq(headSeq, tailSeq, isLeft)
 		if isLeft {
 			nextSeq = headSeq
-			prevSeq = prevSeq.Sub(curSeq, big.NewFloat(1))
+			prevSeq = curSeq - listSeqGap
 		} else {
-			nextSeq = nextSeq.Add(curSeq, big.NewFloat(1))
+			nextSeq = curSeq + listSeqGap
 			prevSeq = tailSeq
 		}
 
@@ -123,17 +382,12 @@ func (txn *Txn) push(key []byte, values [][]byte, isLeft bool) error {
 			DataType: data.List,
 		}
 
-		logPos, err := txn.db.appendLogRecord(listDataLogRecord)
-		if err != nil {
-			return err
-		}
-
-		buf, err := curSeq.GobEncode()
+		logPos, err := txn.appendLogRecord(listDataLogRecord)
 		if err != nil {
 			return err
 		}
 
-		txn.listDataPendingWrites[string(key)][string(buf)] = &pendingWrite{typ: data.LogRecordNormal, LogPos: logPos}
+		txn.putSubPendingWrite(txn.listDataPendingWrites, string(key), string(encodeListSeq(curSeq)), &pendingWrite{typ: data.LogRecordNormal, LogPos: logPos})
 
 		if isLeft {
 			headSeq = curSeq
@@ -149,12 +403,12 @@ func (txn *Txn) push(key []byte, values [][]byte, isLeft bool) error {
 		DataType: data.ListMeta,
 	}
 
-	logPos, err := txn.db.appendLogRecordWithLock(listMetaLogRecord)
+	logPos, err := txn.appendLogRecord(listMetaLogRecord)
 	if err != nil {
 		return err
 	}
 
-	txn.listMetaPendingWrites[string(key)] = &pendingWrite{typ: data.LogRecordNormal, LogPos: logPos}
+	txn.putPendingWrite(txn.listMetaPendingWrites, string(key), &pendingWrite{typ: data.LogRecordNormal, LogPos: logPos})
 
 	return nil
 }
@@ -163,9 +417,6 @@ func (txn *Txn) pop(key []byte, isLeft bool) ([]byte, error) {
 	if txn.readOnly {
 		return nil, public.ErrUpdateInReadOnlyTxn
 	}
-	if _, ok := txn.listDataPendingWrites[string(key)]; !ok {
-		txn.listDataPendingWrites[string(key)] = make(map[string]*pendingWrite)
-	}
 
 	headSeq, tailSeq, err := txn.getListMeta(key)
 	if err != nil {
@@ -190,16 +441,12 @@ func (txn *Txn) pop(key []byte, isLeft bool) ([]byte, error) {
 			Type:     data.LogRecordDeleted,
 			DataType: data.List,
 		}
-		logPos, err = txn.db.appendLogRecordWithLock(logRecord)
+		logPos, err = txn.appendLogRecord(logRecord)
 		if err != nil {
 			return nil, err
 		}
 
-		buf, err := seq.GobEncode()
-		if err != nil {
-			return nil, err
-		}
-		txn.listDataPendingWrites[string(key)][string(buf)] = &pendingWrite{typ: data.LogRecordDeleted, LogPos: logPos}
+		txn.putSubPendingWrite(txn.listDataPendingWrites, string(key), string(encodeListSeq(seq)), &pendingWrite{typ: data.LogRecordDeleted, LogPos: logPos})
 
 		if isLeft {
 			headSeq = next
@@ -212,19 +459,19 @@ func (txn *Txn) pop(key []byte, isLeft bool) ([]byte, error) {
 			DataType: data.ListMeta,
 		}
 
-		if headSeq.Cmp(tailSeq) != 1 {
+		if headSeq <= tailSeq {
 			listMetaLogRecord.Value = encodeListMeta(headSeq, tailSeq)
 			listMetaLogRecord.Type = data.LogRecordNormal
 		} else {
 			listMetaLogRecord.Type = data.LogRecordDeleted
 		}
 
-		logPos, err = txn.db.appendLogRecordWithLock(listMetaLogRecord)
+		logPos, err = txn.appendLogRecord(listMetaLogRecord)
 		if err != nil {
 			return nil, err
 		}
 
-		txn.listMetaPendingWrites[string(key)] = &pendingWrite{typ: listMetaLogRecord.Type, LogPos: logPos}
+		txn.putPendingWrite(txn.listMetaPendingWrites, string(key), &pendingWrite{typ: listMetaLogRecord.Type, LogPos: logPos})
 
 		return value, nil
 	}
@@ -232,97 +479,430 @@ func (txn *Txn) pop(key []byte, isLeft bool) ([]byte, error) {
 	return nil, public.ErrListIsEmpty
 }
 
-func (txn *Txn) allocPushSeq(headSeq, tailSeq *big.Float, left bool) *big.Float {
-	seq := new(big.Float)
+// listElements returns the elements of the list from head to tail
+func (txn *Txn) listElements(key []byte) ([]*listElement, error) {
+	elements := make([]*listElement, 0)
+	txn.walkList(key, false, func(element *listElement) bool {
+		elements = append(elements, element)
+		return true
+	})
+	return elements, nil
+}
+
+// listElementAt returns the element at index, negative indexes count from the tail. It is nil if the index
+// is out of range
+func (txn *Txn) listElementAt(key []byte, index int) *listElement {
+	reverse := index < 0
+	if reverse {
+		index = -index - 1
+	}
+	var found *listElement
+	i := 0
+	txn.walkList(key, reverse, func(element *listElement) bool {
+		if i == index {
+			found = element
+			return false
+		}
+		i++
+		return true
+	})
+	return found
+}
+
+// listChange is a change of the txn or of its snapshot to the committed element with the seq,
+// a nil pos removes the element
+type listChange struct {
+	seq []byte
+	pos *data.LogPos
+}
+
+// listChanges returns the changes to the committed elements of the list sorted by seq, or sorted from
+// the tail if reverse. The list index must be locked
+func (txn *Txn) listChanges(key []byte, reverse bool) []listChange {
+	merged := txn.snapshotBefore(data.List, key)
+	if merged == nil {
+		merged = make(map[string]*data.LogPos)
+	}
+	for seq, pw := range txn.listDataPendingWrites[string(key)] {
+		if pw.typ == data.LogRecordDeleted {
+			merged[seq] = nil
+		} else {
+			merged[seq] = pw.LogPos
+		}
+	}
+
+	changes := make([]listChange, 0, len(merged))
+	for seq, pos := range merged {
+		changes = append(changes, listChange{seq: []byte(seq), pos: pos})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return bytes.Compare(changes[i].seq, changes[j].seq) < 0 != reverse
+	})
+	return changes
+}
+
+// listLen returns the number of elements of the list as seen by the txn, the committed elements are counted
+// by the index and only the changes of the txn and its snapshot are looked up
+func (txn *Txn) listLen(key []byte) int {
+	txn.trackRead(data.List, key)
+	lock := txn.db.getIndexLockByType(data.List)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	// a txn committed after the index is locked only changes the index after the unlock,
+	// so the before images read under the lock cover all the changes in the index
+	changes := txn.listChanges(key, false)
+	idx, ok := txn.db.index.getListDataIndex(string(key))
+	var length int
+	if ok {
+		length = idx.Count()
+	}
+	for _, change := range changes {
+		committed := ok && idx.Get(change.seq) != nil
+		if committed && change.pos == nil {
+			length--
+		} else if !committed && change.pos != nil {
+			length++
+		}
+	}
+	return length
+}
+
+// walkList visits the elements of the list as seen by the txn from head to tail, or from tail to head if
+// reverse, until fn returns false. The committed elements are read from the index in the order of their
+// seqs and the changes of the txn and its snapshot are merged in, fn is called with the list index locked
+func (txn *Txn) walkList(key []byte, reverse bool, fn func(element *listElement) bool) {
+	txn.trackRead(data.List, key)
+	lock := txn.db.getIndexLockByType(data.List)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	changes := txn.listChanges(key, reverse)
+	var iterator meta.Iterator
+	if idx, ok := txn.db.index.getListDataIndex(string(key)); ok {
+		if iterator = idx.Iterator(reverse); iterator != nil {
+			defer iterator.Close()
+			iterator.Rewind()
+		}
+	}
+
+	i := 0
+	for {
+		committed := iterator != nil && iterator.Valid()
+		if !committed && i == len(changes) {
+			return
+		}
+		var seq []byte
+		var pos *data.LogPos
+		if i < len(changes) && (!committed || bytes.Compare(changes[i].seq, iterator.Key()) <= 0 != reverse ||
+			bytes.Equal(changes[i].seq, iterator.Key())) {
+			change := changes[i]
+			i++
+			if committed && bytes.Equal(change.seq, iterator.Key()) {
+				iterator.Next()
+			}
+			if change.pos == nil {
+				continue
+			}
+			seq, pos = change.seq, change.pos
+		} else {
+			seq, pos = iterator.Key(), iterator.Value()
+			iterator.Next()
+		}
+		if !fn(&listElement{seq: decodeListSeq(seq), pos: pos}) {
+			return
+		}
+	}
+}
+
+// readListElement returns the value of the element and the seqs of its neighbours
+func (txn *Txn) readListElement(pos *data.LogPos) ([]byte, int64, int64, error) {
+	logRecord, err := txn.db.getLogRecordByPos(pos)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	realKey, _ := parseLogRecordKey(logRecord.Key)
+	_, _, prev, next := decodeListKey(realKey)
+	return logRecord.Value, prev, next, nil
+}
+
+func (txn *Txn) putListElement(key []byte, seq, prev, next int64, value []byte) error {
+	return txn.appendListElement(key, seq, prev, next, value, data.LogRecordNormal)
+}
+
+func (txn *Txn) delListElement(key []byte, seq int64) error {
+	return txn.appendListElement(key, seq, seq, seq, nil, data.LogRecordDeleted)
+}
+
+func (txn *Txn) appendListElement(key []byte, seq, prev, next int64, value []byte, typ data.LogRecordType) error {
+	logRecord := &data.LogRecord{
+		Key:      encodeKeyWithTxId(encodeListKey(seq, prev, next, key), txn.startTs),
+		Value:    value,
+		Type:     typ,
+		DataType: data.List,
+	}
+	logPos, err := txn.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+
+	txn.putSubPendingWrite(txn.listDataPendingWrites, string(key), string(encodeListSeq(seq)), &pendingWrite{typ: typ, LogPos: logPos})
+	return nil
+}
+
+// relinkListElement rewrites the element if its neighbours change, a nil seq keeps the neighbour
+func (txn *Txn) relinkListElement(key []byte, element *listElement, prev, next *int64) error {
+	value, oldPrev, oldNext, err := txn.readListElement(element.pos)
+	if err != nil {
+		return err
+	}
+	newPrev, newNext := oldPrev, oldNext
+	if prev != nil {
+		newPrev = *prev
+	}
+	if next != nil {
+		newNext = *next
+	}
+	if newPrev == oldPrev && newNext == oldNext {
+		return nil
+	}
+	return txn.putListElement(key, element.seq, newPrev, newNext, value)
+}
+
+// removeListElements removes the elements at the indexes, the remaining elements are relinked
+func (txn *Txn) removeListElements(key []byte, elements []*listElement, removed map[int]bool) error {
+	if len(removed) == 0 {
+		return nil
+	}
+	survivors := make([]int, 0, len(elements)-len(removed))
+	for i, element := range elements {
+		if removed[i] {
+			if err := txn.delListElement(key, element.seq); err != nil {
+				return err
+			}
+		} else {
+			survivors = append(survivors, i)
+		}
+	}
+
+	for j, i := range survivors {
+		var prev, next *int64
+		if i > 0 && removed[i-1] && j > 0 {
+			prev = &elements[survivors[j-1]].seq
+		}
+		if i < len(elements)-1 && removed[i+1] && j < len(survivors)-1 {
+			next = &elements[survivors[j+1]].seq
+		}
+		if prev == nil && next == nil {
+			continue
+		}
+		if err := txn.relinkListElement(key, elements[i], prev, next); err != nil {
+			return err
+		}
+	}
+
+	if len(survivors) == 0 {
+		return txn.delListMeta(key)
+	}
+	return txn.putListMeta(key, elements[survivors[0]].seq, elements[survivors[len(survivors)-1]].seq)
+}
+
+// respaceList spreads out the elements around the index at until there is room to insert an element
+// before it, the window of respaced elements doubles until its elements are at least listMinSpacing apart.
+// It returns the elements of the list with their new seqs
+func (txn *Txn) respaceList(key []byte, elements []*listElement, at int) ([]*listElement, error) {
+	lo, hi := at-1, at+1
+	var lower, spacing int64
+	for width := 1; ; width *= 2 {
+		n := int64(hi - lo)
+		// the seqs beyond the ends of the list are free, so a window reaching an end may grow beyond it
+		lower = elements[0].seq - n*listSeqGap
+		if lo > 0 {
+			lower = elements[lo-1].seq
+		}
+		upper := elements[len(elements)-1].seq + n*listSeqGap
+		if hi < len(elements) {
+			upper = elements[hi].seq
+		}
+		if spacing = (upper - lower) / (n + 1); spacing >= listMinSpacing {
+			break
+		}
+		if lo -= width; lo < 0 {
+			lo = 0
+		}
+		if hi += width; hi > len(elements) {
+			hi = len(elements)
+		}
+	}
+
+	// all the old seqs are deleted before the new seqs are written since they may overlap
+	respaced := make([]*listElement, len(elements))
+	copy(respaced, elements)
+	values := make([][]byte, hi-lo)
+	for i := lo; i < hi; i++ {
+		value, _, _, err := txn.readListElement(elements[i].pos)
+		if err != nil {
+			return nil, err
+		}
+		values[i-lo] = value
+		if err := txn.delListElement(key, elements[i].seq); err != nil {
+			return nil, err
+		}
+		respaced[i] = &listElement{seq: lower + spacing*int64(i-lo+1)}
+	}
+	for i := lo; i < hi; i++ {
+		prev, next := respaced[i].seq-listSeqGap, respaced[i].seq+listSeqGap
+		if i > 0 {
+			prev = respaced[i-1].seq
+		}
+		if i < len(elements)-1 {
+			next = respaced[i+1].seq
+		}
+		if err := txn.putListElement(key, respaced[i].seq, prev, next, values[i-lo]); err != nil {
+			return nil, err
+		}
+		respaced[i].pos = txn.listDataPendingWrites[string(key)][string(encodeListSeq(respaced[i].seq))].LogPos
+	}
+
+	// the neighbours of the window link to the new seqs
+	if lo > 0 {
+		if err := txn.relinkListElement(key, respaced[lo-1], nil, &respaced[lo].seq); err != nil {
+			return nil, err
+		}
+	}
+	if hi < len(elements) {
+		if err := txn.relinkListElement(key, respaced[hi], &respaced[hi-1].seq, nil); err != nil {
+			return nil, err
+		}
+	}
+	if lo == 0 || hi == len(elements) {
+		if err := txn.putListMeta(key, respaced[0].seq, respaced[len(respaced)-1].seq); err != nil {
+			return nil, err
+		}
+	}
+	return respaced, nil
+}
+
+func (txn *Txn) putListMeta(key []byte, headSeq, tailSeq int64) error {
+	return txn.appendListMeta(key, encodeListMeta(headSeq, tailSeq), data.LogRecordNormal)
+}
+
+func (txn *Txn) delListMeta(key []byte) error {
+	return txn.appendListMeta(key, nil, data.LogRecordDeleted)
+}
+
+func (txn *Txn) appendListMeta(key, value []byte, typ data.LogRecordType) error {
+	logRecord := &data.LogRecord{
+		Key:      encodeKeyWithTxId(key, txn.startTs),
+		Value:    value,
+		Type:     typ,
+		DataType: data.ListMeta,
+	}
+	logPos, err := txn.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	txn.putPendingWrite(txn.listMetaPendingWrites, string(key), &pendingWrite{typ: typ, LogPos: logPos})
+	return nil
+}
+
+// normalizeListRange converts the range to non-negative indexes within the list like redis,
+// start is greater than stop if the range is empty
+func normalizeListRange(start, stop, length int) (int, int) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	return start, stop
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func (txn *Txn) allocPushSeq(headSeq, tailSeq int64, left bool) int64 {
 	if left {
-		seq = seq.Sub(headSeq, big.NewFloat(1))
-	} else {
-		seq = seq.Add(tailSeq, big.NewFloat(1))
+		return headSeq - listSeqGap
 	}
-	return seq
+	return tailSeq + listSeqGap
 }
 
-func (txn *Txn) getLogPosByLeftOrRight(key []byte, left, right *big.Float, isLeft bool) *data.LogPos {
+func (txn *Txn) getLogPosByLeftOrRight(key []byte, left, right int64, isLeft bool) *data.LogPos {
 	var pw *pendingWrite
 	var logPos *data.LogPos
 
-	leftBuf, _ := left.GobEncode()
-	rightBuf, _ := right.GobEncode()
-
+	seqBuf := encodeListSeq(right)
 	if isLeft {
-		pw = txn.listDataPendingWrites[string(key)][string(leftBuf)]
-	} else {
-		pw = txn.listDataPendingWrites[string(key)][string(rightBuf)]
+		seqBuf = encodeListSeq(left)
 	}
 
-	if pw != nil && pw.typ != data.LogRecordDeleted {
-		logPos = pw.LogPos
-	} else if listDataIndex, ok := txn.db.index.getListDataIndex(string(key)); ok {
-		if isLeft {
-			logPos = listDataIndex.Get(leftBuf)
-		} else {
-			logPos = listDataIndex.Get(rightBuf)
+	pw = txn.listDataPendingWrites[string(key)][string(seqBuf)]
+
+	if pw != nil {
+		// the element may be deleted by the txn but still in the index
+		if pw.typ != data.LogRecordDeleted {
+			logPos = pw.LogPos
 		}
+	} else {
+		logPos = txn.committedPos(data.List, key, string(seqBuf), func() *data.LogPos {
+			if listDataIndex, ok := txn.db.index.getListDataIndex(string(key)); ok {
+				return listDataIndex.Get(seqBuf)
+			}
+			return nil
+		})
 	}
 	return logPos
 }
 
-func encodeListMeta(headSeq, tailSeq *big.Float) []byte {
-	headSeqBuf, _ := headSeq.GobEncode()
-	tailSeqBuf, _ := tailSeq.GobEncode()
-	header := make([]byte, binary.MaxVarintLen64*2)
-	var index int
-	index += binary.PutVarint(header[index:], int64(len(headSeqBuf)))
-	index += binary.PutVarint(header[index:], int64(len(tailSeqBuf)))
-	buf := make([]byte, len(headSeqBuf)+len(tailSeqBuf)+index)
-	copy(buf[:index], header[:index])
-	copy(buf[index:index+len(headSeqBuf)], headSeqBuf)
-	copy(buf[index+len(headSeqBuf):], tailSeqBuf)
+// encodeListSeq encodes the seq as the key of the list data index,
+// the sign bit is flipped so that the keys sort like the seqs
+func encodeListSeq(seq int64) []byte {
+	buf := make([]byte, listSeqSize)
+	binary.BigEndian.PutUint64(buf, uint64(seq)^1<<63)
+	return buf
+}
+
+func decodeListSeq(buf []byte) int64 {
+	return int64(binary.BigEndian.Uint64(buf) ^ 1<<63)
+}
+
+func encodeListMeta(headSeq, tailSeq int64) []byte {
+	buf := make([]byte, 1+listSeqSize*2)
+	buf[0] = listFormat
+	binary.BigEndian.PutUint64(buf[1:], uint64(headSeq))
+	binary.BigEndian.PutUint64(buf[1+listSeqSize:], uint64(tailSeq))
 	return buf
 }
 
-func decodeListMeta(listMeta []byte) (*big.Float, *big.Float) {
-	var index int
-	headSeqLen, i := binary.Varint(listMeta[index:])
-	index += i
-	_, i = binary.Varint(listMeta[index:])
-	index += i
-	headSeq, tailSeq := big.NewFloat(0), big.NewFloat(0)
-	_ = headSeq.GobDecode(listMeta[index : index+int(headSeqLen)])
-	_ = tailSeq.GobDecode(listMeta[index+int(headSeqLen):])
-	return headSeq, tailSeq
-}
-
-func encodeListKey(seq, prevSeq, nextSeq *big.Float, key []byte) []byte {
-	seqBuf, _ := seq.GobEncode()
-	prevSeqBuf, _ := prevSeq.GobEncode()
-	nextSeqBuf, _ := nextSeq.GobEncode()
-	header := make([]byte, binary.MaxVarintLen64*3)
-	var index int
-	index += binary.PutVarint(header[index:], int64(len(seqBuf)))
-	index += binary.PutVarint(header[index:], int64(len(prevSeqBuf)))
-	index += binary.PutVarint(header[index:], int64(len(nextSeqBuf)))
-	buf := make([]byte, index+len(seqBuf)+len(prevSeqBuf)+len(nextSeqBuf)+len(key))
-	copy(buf[:index], header[:index])
-	copy(buf[index:index+len(seqBuf)], seqBuf)
-	copy(buf[index+len(seqBuf):index+len(seqBuf)+len(prevSeqBuf)], prevSeqBuf)
-	copy(buf[index+len(seqBuf)+len(prevSeqBuf):index+len(seqBuf)+len(prevSeqBuf)+len(nextSeqBuf)], nextSeqBuf)
-	copy(buf[index+len(seqBuf)+len(prevSeqBuf)+len(nextSeqBuf):], key)
+func decodeListMeta(listMeta []byte) (int64, int64) {
+	return int64(binary.BigEndian.Uint64(listMeta[1:])), int64(binary.BigEndian.Uint64(listMeta[1+listSeqSize:]))
+}
+
+// encodeListKey encodes the key of an element as listFormat | seq | prevSeq | nextSeq | key
+func encodeListKey(seq, prevSeq, nextSeq int64, key []byte) []byte {
+	buf := make([]byte, 1+listSeqSize*3+len(key))
+	buf[0] = listFormat
+	binary.BigEndian.PutUint64(buf[1:], uint64(seq))
+	binary.BigEndian.PutUint64(buf[1+listSeqSize:], uint64(prevSeq))
+	binary.BigEndian.PutUint64(buf[1+listSeqSize*2:], uint64(nextSeq))
+	copy(buf[1+listSeqSize*3:], key)
 	return buf
 }
 
-func decodeListKey(key []byte) ([]byte, *big.Float, *big.Float, *big.Float) {
-	var index int
-	seqLen, i := binary.Varint(key[index:])
-	index += i
-	prevSeqLen, i := binary.Varint(key[index:])
-	index += i
-	nextSeqLen, i := binary.Varint(key[index:])
-	index += i
-	seq, prevSeq, nextSeq := big.NewFloat(0), big.NewFloat(0), big.NewFloat(0)
-	_ = seq.GobDecode(key[index : index+int(seqLen)])
-	_ = prevSeq.GobDecode(key[index+int(seqLen) : index+int(seqLen)+int(prevSeqLen)])
-	_ = nextSeq.GobDecode(key[index+int(seqLen)+int(prevSeqLen) : index+int(seqLen)+int(prevSeqLen)+int(nextSeqLen)])
-	return key[index+int(seqLen)+int(prevSeqLen)+int(nextSeqLen):], seq,
-		prevSeq, nextSeq
+func decodeListKey(key []byte) ([]byte, int64, int64, int64) {
+	seq := int64(binary.BigEndian.Uint64(key[1:]))
+	prevSeq := int64(binary.BigEndian.Uint64(key[1+listSeqSize:]))
+	nextSeq := int64(binary.BigEndian.Uint64(key[1+listSeqSize*2:]))
+	return key[1+listSeqSize*3:], seq, prevSeq, nextSeq
 }
@@ -1,10 +1,16 @@
 package CouloyDB
 
 import (
+	"encoding/binary"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/Kirov7/CouloyDB/data"
 	"github.com/Kirov7/CouloyDB/public"
 	"github.com/Kirov7/CouloyDB/public/utils/bytex"
 	"github.com/stretchr/testify/assert"
-	"testing"
 )
 
 func TestTxn_List_Push(t *testing.T) {
@@ -204,3 +210,398 @@ func TestTxn_LLen(t *testing.T) {
 		return err
 	})
 }
+
+func TestTxn_List_PendingMerge(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := bytex.GetTestKey(0)
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.RPush(key, [][]byte{[]byte("b"), []byte("c"), []byte("d")})
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		// a b c d e -> a c d e
+		assert.Nil(t, txn.LPush(key, [][]byte{[]byte("a")}))
+		assert.Nil(t, txn.RPush(key, [][]byte{[]byte("e")}))
+		n, err := txn.LRem(key, 1, []byte("b"))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		// a c x e
+		assert.Nil(t, txn.LSet(key, -2, []byte("x")))
+
+		l, err := txn.LLen(key)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, l)
+
+		values, err := txn.LRange(key, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{[]byte("a"), []byte("c"), []byte("x"), []byte("e")}, values)
+		values, err = txn.LRange(key, 1, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{[]byte("c"), []byte("x")}, values)
+
+		for i, want := range []string{"a", "c", "x", "e"} {
+			value, err := txn.LIndex(key, i)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte(want), value)
+			value, err = txn.LIndex(key, i-4)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte(want), value)
+		}
+		_, err = txn.LIndex(key, 4)
+		assert.Equal(t, public.ErrListIndexOutOfRange, err)
+		_, err = txn.LIndex(key, -5)
+		assert.Equal(t, public.ErrListIndexOutOfRange, err)
+
+		positions, err := txn.LPos(key, []byte("x"), -1, 0, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, []int{2}, positions)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		l, err := txn.LLen(key)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, l)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxn_List_Range(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("list")
+	values := func(s ...string) [][]byte {
+		bs := make([][]byte, len(s))
+		for i, v := range s {
+			bs[i] = []byte(v)
+		}
+		return bs
+	}
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.RPush(key, values("a", "b", "c", "b", "d"))
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		l, err := txn.LLen(key)
+		assert.Nil(t, err)
+		assert.Equal(t, 5, l)
+
+		v, err := txn.LRange(key, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, values("a", "b", "c", "b", "d"), v)
+		v, err = txn.LRange(key, -3, 100)
+		assert.Nil(t, err)
+		assert.Equal(t, values("c", "b", "d"), v)
+		v, err = txn.LRange(key, 3, 1)
+		assert.Nil(t, err)
+		assert.Len(t, v, 0)
+
+		value, err := txn.LIndex(key, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("d"), value)
+		_, err = txn.LIndex(key, 5)
+		assert.Equal(t, public.ErrListIndexOutOfRange, err)
+
+		assert.Nil(t, txn.LSet(key, -2, []byte("e")))
+		assert.Equal(t, public.ErrKeyNotFound, txn.LSet([]byte("missing"), 0, []byte("e")))
+
+		positions, err := txn.LPos(key, []byte("b"), 1, 0, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, []int{1}, positions)
+
+		n, err := txn.LInsert(key, true, []byte("c"), []byte("x"))
+		assert.Nil(t, err)
+		assert.Equal(t, 6, n)
+		n, err = txn.LInsert(key, false, []byte("d"), []byte("y"))
+		assert.Nil(t, err)
+		assert.Equal(t, 7, n)
+		n, err = txn.LInsert(key, false, []byte("z"), []byte("y"))
+		assert.Nil(t, err)
+		assert.Equal(t, -1, n)
+
+		v, err = txn.LRange(key, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, values("a", "b", "x", "c", "e", "d", "y"), v)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		removed, err := txn.LRem(key, -1, []byte("y"))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, removed)
+		assert.Nil(t, txn.LTrim(key, 1, -2))
+
+		v, err := txn.LRange(key, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, values("b", "x", "c", "e"), v)
+
+		// the head and the tail follow the removed elements
+		value, err := txn.LPop(key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("b"), value)
+		value, err = txn.RPop(key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("e"), value)
+
+		value, err = txn.LMove(key, []byte("other"), true, false)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("x"), value)
+
+		assert.Nil(t, txn.LTrim(key, 1, 0))
+		_, err = txn.LLen(key)
+		assert.Equal(t, public.ErrKeyNotFound, err)
+		_, err = txn.LPop(key)
+		assert.Equal(t, public.ErrListIsEmpty, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxn_List_Random(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// apply random operations to the list and to a slice, then compare them
+	key := []byte("list")
+	expected := make([][]byte, 0)
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		err = db.SerialTransaction(false, func(txn *Txn) error {
+			for i := 0; i < 20; i++ {
+				value := []byte(strconv.Itoa(r.Intn(10)))
+				switch op := r.Intn(7); {
+				case op == 0:
+					assert.Nil(t, txn.LPush(key, [][]byte{value}))
+					expected = append([][]byte{value}, expected...)
+				case op == 1:
+					assert.Nil(t, txn.RPush(key, [][]byte{value}))
+					expected = append(expected, value)
+				case op == 2 && len(expected) > 0:
+					v, err := txn.LPop(key)
+					assert.Nil(t, err)
+					assert.Equal(t, expected[0], v)
+					expected = expected[1:]
+				case op == 3 && len(expected) > 0:
+					v, err := txn.RPop(key)
+					assert.Nil(t, err)
+					assert.Equal(t, expected[len(expected)-1], v)
+					expected = expected[:len(expected)-1]
+				case op == 4:
+					pivot := []byte(strconv.Itoa(r.Intn(10)))
+					before := r.Intn(2) == 0
+					n, err := txn.LInsert(key, before, pivot, value)
+					assert.Nil(t, err)
+					for j, v := range expected {
+						if string(v) == string(pivot) {
+							if !before {
+								j++
+							}
+							expected = append(expected[:j], append([][]byte{value}, expected[j:]...)...)
+							break
+						}
+					}
+					if n > 0 {
+						assert.Equal(t, len(expected), n)
+					}
+				case op == 5:
+					removed, err := txn.LRem(key, 1, value)
+					assert.Nil(t, err)
+					for j, v := range expected {
+						if string(v) == string(value) {
+							expected = append(expected[:j], expected[j+1:]...)
+							assert.Equal(t, 1, removed)
+							break
+						}
+					}
+				case op == 6 && len(expected) > 2:
+					assert.Nil(t, txn.LTrim(key, 1, -2))
+					expected = append([][]byte{}, expected[1:len(expected)-1]...)
+				}
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+
+		err = db.SerialTransaction(true, func(txn *Txn) error {
+			v, err := txn.LRange(key, 0, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, len(expected), len(v), "round %d", round)
+			for j := range expected {
+				assert.Equal(t, expected[j], v[j], "round %d", round)
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+	}
+
+	// the seqs and the links survive a reboot
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(db.options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		for range expected {
+			v, err := txn.LPop(key)
+			assert.Nil(t, err)
+			assert.Equal(t, expected[0], v)
+			expected = expected[1:]
+		}
+		_, err := txn.LPop(key)
+		assert.Equal(t, public.ErrListIsEmpty, err)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxn_List_Respace(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	key := []byte("list")
+	expected := []string{"head", "tail"}
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.RPush(key, [][]byte{[]byte("head"), []byte("tail")})
+	})
+	assert.Nil(t, err)
+
+	// every insertion halves the room before tail, so the list is respaced many times
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		for i := 0; i < 100; i++ {
+			value := strconv.Itoa(i)
+			n, err := txn.LInsert(key, true, []byte("tail"), []byte(value))
+			assert.Nil(t, err)
+			expected = append(expected[:len(expected)-1], value, "tail")
+			assert.Equal(t, len(expected), n)
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(db.options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		values, err := txn.LRange(key, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, len(expected), len(values))
+		for i := range expected {
+			assert.Equal(t, expected[i], string(values[i]))
+		}
+		// the links are consistent with the respaced seqs
+		for i := len(expected) - 1; i >= 0; i-- {
+			v, err := txn.RPop(key)
+			assert.Nil(t, err)
+			assert.Equal(t, expected[i], string(v))
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxn_List_MigrateLegacy(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// a list written with big.Float seqs, x was inserted between b and c
+	key := []byte("legacy")
+	one, two, three := big.NewFloat(1), big.NewFloat(2), big.NewFloat(3)
+	half := new(big.Float).SetPrec(64).Quo(big.NewFloat(5), big.NewFloat(2))
+	elements := []struct {
+		seq, prev, next *big.Float
+		value           string
+	}{
+		{one, big.NewFloat(0), two, "a"},
+		{two, one, half, "b"},
+		{half, two, three, "x"},
+		{three, half, big.NewFloat(4), "c"},
+	}
+	for _, element := range elements {
+		_, err := db.appendLogRecordWithLock(&data.LogRecord{
+			Key:      encodeKeyWithTxId(encodeLegacyListKey(element.seq, element.prev, element.next, key), public.NO_TX_ID),
+			Value:    []byte(element.value),
+			Type:     data.LogRecordNormal,
+			DataType: data.List,
+		})
+		assert.Nil(t, err)
+	}
+	_, err = db.appendLogRecordWithLock(&data.LogRecord{
+		Key:      encodeKeyWithTxId(key, public.NO_TX_ID),
+		Value:    encodeLegacyListMeta(one, three),
+		Type:     data.LogRecordNormal,
+		DataType: data.ListMeta,
+	})
+	assert.Nil(t, err)
+
+	// the list is migrated when the db is opened even if it passes the txn limits, opening it again keeps it as is
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, db.Close())
+		options := DefaultOptions()
+		if i == 0 {
+			options.MaxTxnRecords, options.MaxTxnBytes = 2, 8
+		}
+		db, err = NewCouloyDB(options)
+		assert.Nil(t, err)
+
+		idx, ok := db.index.getListDataIndex(string(key))
+		assert.True(t, ok)
+		iterator := idx.Iterator(false)
+		n := 0
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			assert.Equal(t, listSeqSize, len(iterator.Key()))
+			n++
+		}
+		iterator.Close()
+		assert.Equal(t, 4, n)
+
+		err = db.SerialTransaction(true, func(txn *Txn) error {
+			values, err := txn.LRange(key, 0, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("x"), []byte("c")}, values)
+			return nil
+		})
+		assert.Nil(t, err)
+	}
+	defer destroyCouloyDB(db)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		assert.Nil(t, txn.LPush(key, [][]byte{[]byte("z")}))
+		v, err := txn.RPop(key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("c"), v)
+		v, err = txn.RPop(key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("x"), v)
+		values, err := txn.LRange(key, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{[]byte("z"), []byte("a"), []byte("b")}, values)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+// encodeLegacyListMeta encodes the meta of a list the way it was before the seqs were int64
+func encodeLegacyListMeta(headSeq, tailSeq *big.Float) []byte {
+	headSeqBuf, _ := headSeq.GobEncode()
+	tailSeqBuf, _ := tailSeq.GobEncode()
+	header := make([]byte, binary.MaxVarintLen64*2)
+	var index int
+	index += binary.PutVarint(header[index:], int64(len(headSeqBuf)))
+	index += binary.PutVarint(header[index:], int64(len(tailSeqBuf)))
+	return append(append(header[:index], headSeqBuf...), tailSeqBuf...)
+}
@@ -1,13 +1,19 @@
 package CouloyDB
 
 import (
+	"bytes"
+	"math/rand"
+
 	"github.com/Kirov7/CouloyDB/data"
 	"github.com/Kirov7/CouloyDB/meta"
 	"github.com/Kirov7/CouloyDB/public"
 	"github.com/Kirov7/CouloyDB/public/utils/bytex"
-	"github.com/Kirov7/CouloyDB/public/utils/consistent"
 )
 
+// The members of a set are indexed by their own bytes, the members used to be indexed by a 32-bit hash
+// which lost the members colliding with others. The log records always keep the whole member,
+// so the sets written that way are indexed by member again when the index is rebuilt from the log
+
 func (txn *Txn) SAdd(key []byte, members ...[]byte) error {
 	if err := checkKey(key); err != nil {
 		return err
@@ -21,28 +27,9 @@ func (txn *Txn) SAdd(key []byte, members ...[]byte) error {
 			return public.ErrUpdateInReadOnlyTxn
 		}
 
-		if _, ok := txn.db.index.getSetIndex(string(key)); !ok {
-			txn.db.index.setSetIndex(string(key), meta.NewMemTable(txn.db.options.IndexType))
-		}
-
-		logRecord := &data.LogRecord{
-			Key:      encodeKeyWithTxId(encodeMemberKey(key, member), txn.startTs),
-			Value:    member,
-			Type:     data.LogRecordNormal,
-			DataType: data.Set,
-		}
-
-		pos, err := txn.db.appendLogRecordWithLock(logRecord)
-		if err != nil {
+		if err := txn.appendSetMember(key, member, data.LogRecordNormal); err != nil {
 			return err
 		}
-
-		if _, ok := txn.setPendingWrites[string(key)]; !ok {
-			txn.setPendingWrites[string(key)] = make(map[string]*pendingWrite)
-		}
-
-		hashKey := hashMemberKey(key, member)
-		txn.setPendingWrites[string(key)][string(hashKey)] = &pendingWrite{typ: data.LogRecordNormal, LogPos: pos}
 	}
 	return nil
 }
@@ -53,103 +40,272 @@ func (txn *Txn) SRem(key []byte, members ...[]byte) error {
 	}
 
 	for _, member := range members {
-		hashKey := hashMemberKey(key, member)
 		if pw, ok := txn.setPendingWrites[string(key)][string(member)]; ok {
 			if pw.typ == data.LogRecordDeleted {
 				return public.ErrKeyNotFound
 			}
-		} else {
-			if idx, ok := txn.db.index.getSetIndex(string(key)); !ok {
-				return public.ErrKeyNotFound
-			} else {
-				if pos := idx.Get(hashKey); pos == nil {
-					return public.ErrKeyNotFound
-				}
-			}
+		} else if pos := txn.setMemberPos(key, member); pos == nil {
+			return public.ErrKeyNotFound
 		}
 
-		logRecord := &data.LogRecord{
-			Key:      encodeKeyWithTxId(encodeMemberKey(key, member), txn.startTs),
-			Type:     data.LogRecordDeleted,
-			DataType: data.Set,
-		}
-
-		pos, err := txn.db.appendLogRecordWithLock(logRecord)
-		if err != nil {
+		if err := txn.appendSetMember(key, member, data.LogRecordDeleted); err != nil {
 			return err
 		}
-
-		if _, ok := txn.setPendingWrites[string(key)]; !ok {
-			txn.setPendingWrites[string(key)] = make(map[string]*pendingWrite)
-		}
-
-		txn.setPendingWrites[string(key)][string(hashKey)] = &pendingWrite{typ: data.LogRecordDeleted, LogPos: pos}
 	}
 
 	return nil
 }
 
 func (txn *Txn) SMembers(key []byte) ([][]byte, error) {
-	members := make([][]byte, 0)
-	for _, pw := range txn.setPendingWrites[string(key)] {
-		if pw.typ != data.LogRecordDeleted {
-			v, err := txn.db.getValueByPos(pw.LogPos)
-			if err != nil {
-				return nil, err
-			}
-			members = append(members, v)
+	positions := txn.setMembers(key)
+	members := make([][]byte, 0, len(positions))
+	for _, pos := range positions {
+		v, err := txn.db.getValueByPos(pos)
+		if err != nil {
+			return nil, err
 		}
+		members = append(members, v)
 	}
+	return members, nil
+}
 
-	if setIdx, ok := txn.db.index.getSetIndex(string(key)); ok {
-		iterator := setIdx.Iterator(false)
+func (txn *Txn) SCard(key []byte) (int64, error) {
+	count := len(txn.setMembers(key))
+	if count == 0 {
+		return 0, public.ErrKeyNotFound
+	}
+	return int64(count), nil
+}
 
-		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-			v, err := txn.db.getValueByPos(iterator.Value())
-			if err != nil {
-				return nil, err
-			}
-			members = append(members, v)
+// SIsMember returns whether member is in the set
+func (txn *Txn) SIsMember(key, member []byte) (bool, error) {
+	if pw, ok := txn.setPendingWrites[string(key)][string(member)]; ok {
+		return pw.typ != data.LogRecordDeleted, nil
+	}
+	return txn.setMemberPos(key, member) != nil, nil
+}
 
+// SMIsMember returns whether each of the members is in the set
+func (txn *Txn) SMIsMember(key []byte, members ...[]byte) ([]bool, error) {
+	result := make([]bool, len(members))
+	for i, member := range members {
+		isMember, err := txn.SIsMember(key, member)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = isMember
+	}
+	return result, nil
+}
+
+// SPop removes count random members from the set and returns them,
+// all the members are removed if the set has no more than count members
+func (txn *Txn) SPop(key []byte, count int) ([][]byte, error) {
+	if txn.readOnly {
+		return nil, public.ErrUpdateInReadOnlyTxn
+	}
+	if count < 0 {
+		return nil, public.ErrTxnArgsWrong
+	}
+	members, err := txn.SRandMember(key, count)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if err := txn.appendSetMember(key, member, data.LogRecordDeleted); err != nil {
+			return nil, err
 		}
 	}
 	return members, nil
 }
 
-func (txn *Txn) SCard(key []byte) (int64, error) {
-	var count int64
-	setIdx, ok := txn.db.index.getSetIndex(string(key))
-	if !ok {
-		return 0, public.ErrKeyNotFound
+// SRandMember returns random members of the set like redis, a positive count returns
+// up to count distinct members and a negative count returns -count members which may repeat
+func (txn *Txn) SRandMember(key []byte, count int) ([][]byte, error) {
+	members, err := txn.SMembers(key)
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		result := make([][]byte, 0, -count)
+		for len(members) > 0 && len(result) < -count {
+			result = append(result, members[rand.Intn(len(members))])
+		}
+		return result, nil
+	}
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	if count < len(members) {
+		members = members[:count]
 	}
+	return members, nil
+}
 
-	iterator := setIdx.Iterator(false)
+// SMove moves member from src to dst, it returns false if member is not in src
+func (txn *Txn) SMove(src, dst, member []byte) (bool, error) {
+	if txn.readOnly {
+		return false, public.ErrUpdateInReadOnlyTxn
+	}
+	if err := checkKey(dst); err != nil {
+		return false, err
+	}
+	isMember, err := txn.SIsMember(src, member)
+	if err != nil || !isMember {
+		return false, err
+	}
+	if bytes.Equal(src, dst) {
+		return true, nil
+	}
+	if err := txn.appendSetMember(src, member, data.LogRecordDeleted); err != nil {
+		return false, err
+	}
+	return true, txn.appendSetMember(dst, member, data.LogRecordNormal)
+}
 
-	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		if pw, ok := txn.setPendingWrites[string(key)][string(iterator.Key())]; ok {
-			if pw.typ != data.LogRecordDeleted {
-				_, err := txn.db.getValueByPos(pw.LogPos)
-				if err != nil {
-					return 0, err
-				}
-				count++
+// SInter returns the members in all the sets
+func (txn *Txn) SInter(keys ...[]byte) ([][]byte, error) {
+	return txn.setAlgebra(keys, func(result, members map[string]bool) {
+		for member := range result {
+			if !members[member] {
+				delete(result, member)
 			}
-			continue
 		}
+	})
+}
 
-		_, err := txn.db.getValueByPos(iterator.Value())
-		if err != nil {
+// SUnion returns the members in any of the sets
+func (txn *Txn) SUnion(keys ...[]byte) ([][]byte, error) {
+	return txn.setAlgebra(keys, func(result, members map[string]bool) {
+		for member := range members {
+			result[member] = true
+		}
+	})
+}
+
+// SDiff returns the members of the first set which are not in the other sets
+func (txn *Txn) SDiff(keys ...[]byte) ([][]byte, error) {
+	return txn.setAlgebra(keys, func(result, members map[string]bool) {
+		for member := range members {
+			delete(result, member)
+		}
+	})
+}
+
+// SInterStore stores the members in all the sets to dst and returns the number of them
+func (txn *Txn) SInterStore(dst []byte, keys ...[]byte) (int, error) {
+	return txn.setAlgebraStore(dst, keys, txn.SInter)
+}
+
+// SUnionStore stores the members in any of the sets to dst and returns the number of them
+func (txn *Txn) SUnionStore(dst []byte, keys ...[]byte) (int, error) {
+	return txn.setAlgebraStore(dst, keys, txn.SUnion)
+}
+
+// SDiffStore stores the members of the first set which are not in the other sets to dst
+// and returns the number of them
+func (txn *Txn) SDiffStore(dst []byte, keys ...[]byte) (int, error) {
+	return txn.setAlgebraStore(dst, keys, txn.SDiff)
+}
+
+// setAlgebra starts from the members of the first set and combines the members of each other set with fn
+func (txn *Txn) setAlgebra(keys [][]byte, fn func(result, members map[string]bool)) ([][]byte, error) {
+	if len(keys) == 0 {
+		return nil, public.ErrTxnArgsWrong
+	}
+	result := make(map[string]bool)
+	for member := range txn.setMembers(keys[0]) {
+		result[member] = true
+	}
+	for _, key := range keys[1:] {
+		members := make(map[string]bool)
+		for member := range txn.setMembers(key) {
+			members[member] = true
+		}
+		fn(result, members)
+	}
+
+	values := make([][]byte, 0, len(result))
+	for member := range result {
+		values = append(values, []byte(member))
+	}
+	return values, nil
+}
+
+// setAlgebraStore replaces the members of dst with the result of op, dst may be one of the keys
+func (txn *Txn) setAlgebraStore(dst []byte, keys [][]byte, op func(keys ...[]byte) ([][]byte, error)) (int, error) {
+	if txn.readOnly {
+		return 0, public.ErrUpdateInReadOnlyTxn
+	}
+	if err := checkKey(dst); err != nil {
+		return 0, err
+	}
+	members, err := op(keys...)
+	if err != nil {
+		return 0, err
+	}
+
+	stored := make(map[string]bool, len(members))
+	for _, member := range members {
+		stored[string(member)] = true
+	}
+	for member := range txn.setMembers(dst) {
+		if !stored[member] {
+			if err := txn.appendSetMember(dst, []byte(member), data.LogRecordDeleted); err != nil {
+				return 0, err
+			}
+		}
+	}
+	for _, member := range members {
+		if err := txn.appendSetMember(dst, member, data.LogRecordNormal); err != nil {
 			return 0, err
 		}
-		count++
 	}
-	return count, nil
+	return len(members), nil
 }
 
-func hashMemberKey(key, member []byte) []byte {
-	c := consistent.New()
-	encodeKey := encodeMemberKey(key, member)
-	return c.HashKey(string(encodeKey))
+// setMembers returns the positions of the members of the set with the writes of the txn applied
+func (txn *Txn) setMembers(key []byte) map[string]*data.LogPos {
+	positions := txn.committedPositions(data.Set, key, func() (meta.MemTable, bool) {
+		return txn.db.index.getSetIndex(string(key))
+	})
+	for member, pw := range txn.setPendingWrites[string(key)] {
+		if pw.typ == data.LogRecordDeleted {
+			delete(positions, member)
+		} else {
+			positions[member] = pw.LogPos
+		}
+	}
+	return positions
+}
+
+// setMemberPos returns the position of the committed member as seen by the txn
+func (txn *Txn) setMemberPos(key, member []byte) *data.LogPos {
+	return txn.committedPos(data.Set, key, string(member), func() *data.LogPos {
+		if idx, ok := txn.db.index.getSetIndex(string(key)); ok {
+			return idx.Get(member)
+		}
+		return nil
+	})
+}
+
+func (txn *Txn) appendSetMember(key, member []byte, typ data.LogRecordType) error {
+	logRecord := &data.LogRecord{
+		Key:      encodeKeyWithTxId(encodeMemberKey(key, member), txn.startTs),
+		Type:     typ,
+		DataType: data.Set,
+	}
+	if typ == data.LogRecordNormal {
+		logRecord.Value = member
+	}
+
+	pos, err := txn.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+
+	txn.putSubPendingWrite(txn.setPendingWrites, string(key), string(member), &pendingWrite{typ: typ, LogPos: pos})
+	return nil
 }
 
 func encodeMemberKey(key, member []byte) []byte {
@@ -409,6 +409,196 @@ func TestTxnSMEMBER(t *testing.T) {
 	}
 }
 
+func TestTxnSet_Collision(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// plumless and buckeroo have the same crc32, they used to overwrite each other in the index
+	key := []byte("words")
+	plumless, buckeroo := []byte("plumless"), []byte("buckeroo")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		if err := txn.SAdd(key, plumless, buckeroo, []byte("other")); err != nil {
+			return err
+		}
+		members, err := txn.SMembers(key)
+		assert.True(t, compareSlices(members, [][]byte{plumless, buckeroo, []byte("other")}))
+		return err
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		count, err := txn.SCard(key)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3), count)
+		if err := txn.SRem(key, []byte("other")); err != nil {
+			return err
+		}
+		// a member removed by the txn can not be removed again
+		assert.Equal(t, public.ErrKeyNotFound, txn.SRem(key, []byte("other")))
+		members, err := txn.SMembers(key)
+		assert.True(t, compareSlices(members, [][]byte{plumless, buckeroo}))
+		return err
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(db.options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		members, err := txn.SMembers(key)
+		assert.True(t, compareSlices(members, [][]byte{plumless, buckeroo}))
+		if err != nil {
+			return err
+		}
+		if err := txn.SRem(key, plumless); err != nil {
+			return err
+		}
+		members, err = txn.SMembers(key)
+		assert.True(t, compareSlices(members, [][]byte{buckeroo}))
+		return err
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxnSet_Algebra(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		assert.Nil(t, txn.SAdd(a, []byte("1"), []byte("2"), []byte("3"), []byte("4")))
+		assert.Nil(t, txn.SAdd(b, []byte("3"), []byte("4"), []byte("5")))
+		assert.Nil(t, txn.SAdd(c, []byte("4"), []byte("6")))
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		members, err := txn.SInter(a, b, c)
+		assert.Nil(t, err)
+		assert.True(t, compareSlices(members, [][]byte{[]byte("4")}))
+
+		members, err = txn.SUnion(b, c)
+		assert.Nil(t, err)
+		assert.True(t, compareSlices(members, [][]byte{[]byte("3"), []byte("4"), []byte("5"), []byte("6")}))
+
+		members, err = txn.SDiff(a, b, []byte("missing"))
+		assert.Nil(t, err)
+		assert.True(t, compareSlices(members, [][]byte{[]byte("1"), []byte("2")}))
+
+		_, err = txn.SInter()
+		assert.Equal(t, public.ErrTxnArgsWrong, err)
+
+		// the pending writes of the txn take part in the operations
+		assert.Nil(t, txn.SRem(b, []byte("4")))
+		members, err = txn.SInter(a, b)
+		assert.Nil(t, err)
+		assert.True(t, compareSlices(members, [][]byte{[]byte("3")}))
+
+		// dst can be one of the keys
+		n, err := txn.SDiffStore(a, a, c)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, n)
+		n, err = txn.SUnionStore([]byte("all"), a, b, c)
+		assert.Nil(t, err)
+		assert.Equal(t, 6, n)
+		n, err = txn.SInterStore([]byte("none"), a, c)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		members, err := txn.SMembers(a)
+		assert.Nil(t, err)
+		assert.True(t, compareSlices(members, [][]byte{[]byte("1"), []byte("2"), []byte("3")}))
+
+		members, err = txn.SMembers([]byte("all"))
+		assert.Nil(t, err)
+		assert.Len(t, members, 6)
+
+		isMember, err := txn.SIsMember(b, []byte("5"))
+		assert.Nil(t, err)
+		assert.True(t, isMember)
+		isMembers, err := txn.SMIsMember(b, []byte("3"), []byte("4"), []byte("5"))
+		assert.Nil(t, err)
+		assert.Equal(t, []bool{true, false, true}, isMembers)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxnSet_Random(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key, dst := []byte("src"), []byte("dst")
+	all := [][]byte{[]byte("1"), []byte("2"), []byte("3"), []byte("4"), []byte("5")}
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.SAdd(key, all...)
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		members, err := txn.SRandMember(key, 3)
+		assert.Nil(t, err)
+		assert.Len(t, members, 3)
+		assert.Len(t, map[string]bool{string(members[0]): true, string(members[1]): true, string(members[2]): true}, 3)
+
+		members, err = txn.SRandMember(key, 10)
+		assert.Nil(t, err)
+		assert.True(t, compareSlices(members, all))
+
+		members, err = txn.SRandMember(key, -10)
+		assert.Nil(t, err)
+		assert.Len(t, members, 10)
+
+		popped, err := txn.SPop(key, 2)
+		assert.Nil(t, err)
+		assert.Len(t, popped, 2)
+		for _, member := range popped {
+			isMember, err := txn.SIsMember(key, member)
+			assert.Nil(t, err)
+			assert.False(t, isMember)
+		}
+
+		members, err = txn.SMembers(key)
+		assert.Nil(t, err)
+		moved, err := txn.SMove(key, dst, members[0])
+		assert.Nil(t, err)
+		assert.True(t, moved)
+		moved, err = txn.SMove(key, dst, popped[0])
+		assert.Nil(t, err)
+		assert.False(t, moved)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		count, err := txn.SCard(key)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), count)
+		count, err = txn.SCard(dst)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), count)
+
+		members, err := txn.SRandMember([]byte("missing"), 3)
+		assert.Nil(t, err)
+		assert.Len(t, members, 0)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
 // byteSlices represents a type for a slice of byte slices.
 type byteSlices [][]byte
 
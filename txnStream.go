@@ -0,0 +1,729 @@
+package CouloyDB
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/public/utils/bytex"
+)
+
+// A stream is stored as several kinds of records under the key, the kind is the first byte of the sub key:
+// the entries, the last generated id, the consumer groups and the pending entries of the groups.
+// The pending entry lists are records as well, so they are rebuilt by loadIndex after a restart
+const (
+	streamEntryPrefix   byte = 'e' // 'e' + id -> fields
+	streamGroupPrefix   byte = 'g' // 'g' + group -> last delivered id
+	streamMetaPrefix    byte = 'm' // 'm' -> last generated id
+	streamPendingPrefix byte = 'p' // 'p' + (group, id) -> consumer, delivery time and delivery count
+)
+
+// StreamID identifies an entry of a stream, it is made of a millisecond timestamp and a sequence number
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	MinStreamID = StreamID{}
+	MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+// ParseStreamID parses an id in the format of redis, "ms-seq" or "ms" which means "ms-0"
+func ParseStreamID(s string) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, public.ErrInvalidStreamID
+	}
+	var seq uint64
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return StreamID{}, public.ErrInvalidStreamID
+		}
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less reports whether id is before other
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || id.Ms == other.Ms && id.Seq < other.Seq
+}
+
+// next returns the smallest id after id, MaxStreamID has no next id
+func (id StreamID) next() (StreamID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return StreamID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// StreamEntry is an entry of a stream, Fields are the field value pairs
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+// StreamPending is an entry delivered to a consumer of a group but not acknowledged yet
+type StreamPending struct {
+	ID            StreamID
+	Consumer      []byte
+	Idle          time.Duration
+	DeliveryCount int64
+}
+
+// XAdd appends an entry with the field value pairs to the stream and returns its id,
+// the id is generated from the current time and always greater than the ids generated before
+func (txn *Txn) XAdd(key []byte, fields ...[]byte) (StreamID, error) {
+	return txn.xAdd(key, nil, fields)
+}
+
+// XAddWithID appends an entry with the given id, it must be greater than all the ids of the stream
+func (txn *Txn) XAddWithID(key []byte, id StreamID, fields ...[]byte) error {
+	_, err := txn.xAdd(key, &id, fields)
+	return err
+}
+
+func (txn *Txn) xAdd(key []byte, id *StreamID, fields [][]byte) (StreamID, error) {
+	if err := checkKey(key); err != nil {
+		return StreamID{}, err
+	}
+	if txn.readOnly {
+		return StreamID{}, public.ErrUpdateInReadOnlyTxn
+	}
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return StreamID{}, public.ErrTxnArgsWrong
+	}
+
+	lastID, _, err := txn.getStreamLastID(key)
+	if err != nil {
+		return StreamID{}, err
+	}
+	var newID StreamID
+	if id != nil {
+		if !lastID.Less(*id) {
+			return StreamID{}, public.ErrStreamIDTooSmall
+		}
+		newID = *id
+	} else if now := uint64(time.Now().UnixMilli()); now > lastID.Ms {
+		newID = StreamID{Ms: now}
+	} else {
+		// the clock went backwards or several entries are added in the same millisecond
+		var ok bool
+		if newID, ok = lastID.next(); !ok {
+			return StreamID{}, public.ErrStreamIDTooSmall
+		}
+	}
+	return newID, txn.appendStreamEntry(key, newID, fields)
+}
+
+func (txn *Txn) appendStreamEntry(key []byte, id StreamID, fields [][]byte) error {
+	if err := txn.putStreamRecord(key, entrySubKey(id), encodeStreamFields(fields), data.LogRecordNormal); err != nil {
+		return err
+	}
+	return txn.putStreamRecord(key, []byte{streamMetaPrefix}, id.encode(), data.LogRecordNormal)
+}
+
+// XRange returns the entries whose id is between start and end inclusive,
+// count limits the number of entries if it is positive
+func (txn *Txn) XRange(key []byte, start, end StreamID, count int) ([]StreamEntry, error) {
+	return txn.rangeStream(key, start, end, false, count)
+}
+
+// XRevRange is like XRange but returns the entries from end to start
+func (txn *Txn) XRevRange(key []byte, end, start StreamID, count int) ([]StreamEntry, error) {
+	return txn.rangeStream(key, start, end, true, count)
+}
+
+// XLen returns the number of entries in the stream, 0 if it does not exist
+func (txn *Txn) XLen(key []byte) (int, error) {
+	pendingWrites := txn.streamPendingWrites[string(key)]
+
+	txn.trackRead(data.Stream, key)
+	lock := txn.db.getIndexLockByType(data.Stream)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	var length int
+	s, ok := txn.db.index.getStreamIndex(string(key))
+	if ok {
+		length = s.entries.Count()
+	}
+	for sub, pw := range pendingWrites {
+		if sub[0] != streamEntryPrefix {
+			continue
+		}
+		committed := ok && s.entries.Get([]byte(sub[1:])) != nil
+		if pw.typ == data.LogRecordNormal && !committed {
+			length++
+		}
+		if pw.typ == data.LogRecordDeleted && committed {
+			length--
+		}
+	}
+	if err := txn.checkStreamSnapshot(key); err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// XTrim removes the oldest entries so that the stream has at most maxLen entries,
+// it returns the number of entries removed
+func (txn *Txn) XTrim(key []byte, maxLen int) (int, error) {
+	if txn.readOnly {
+		return 0, public.ErrUpdateInReadOnlyTxn
+	}
+	if maxLen < 0 {
+		return 0, public.ErrTxnArgsWrong
+	}
+	length, err := txn.XLen(key)
+	if err != nil || length <= maxLen {
+		return 0, err
+	}
+
+	ids, _, err := txn.rangeStreamIDs(key, MinStreamID, MaxStreamID, false, length-maxLen)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := txn.putStreamRecord(key, entrySubKey(id), nil, data.LogRecordDeleted); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// XGroupCreate creates a consumer group which delivers the entries after start,
+// the group starts from the last entry of the stream if start is nil
+func (txn *Txn) XGroupCreate(key, group []byte, start *StreamID) error {
+	if err := checkKey(group); err != nil {
+		return err
+	}
+	if txn.readOnly {
+		return public.ErrUpdateInReadOnlyTxn
+	}
+	lastID, exist, err := txn.getStreamLastID(key)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return public.ErrKeyNotFound
+	}
+	if _, err := txn.getStreamGroup(key, group); err == nil {
+		return public.ErrStreamGroupExist
+	} else if err != public.ErrStreamGroupNotFound {
+		return err
+	}
+
+	if start == nil {
+		start = &lastID
+	}
+	return txn.putStreamRecord(key, groupSubKey(group), start.encode(), data.LogRecordNormal)
+}
+
+// XReadGroup delivers to the consumer the entries never delivered to the group,
+// they are added to the pending entry list of the group until they are acknowledged by XAck.
+// count limits the number of entries if it is positive
+func (txn *Txn) XReadGroup(key, group, consumer []byte, count int) ([]StreamEntry, error) {
+	if err := checkKey(consumer); err != nil {
+		return nil, err
+	}
+	if txn.readOnly {
+		return nil, public.ErrUpdateInReadOnlyTxn
+	}
+	g, err := txn.getStreamGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+	start, ok := g.lastDelivered.next()
+	if !ok {
+		return []StreamEntry{}, nil
+	}
+	entries, err := txn.rangeStream(key, start, MaxStreamID, false, count)
+	if err != nil || len(entries) == 0 {
+		return entries, err
+	}
+
+	now := time.Now().UnixMilli()
+	for _, entry := range entries {
+		p := &streamPending{consumer: string(consumer), deliveryTime: now, deliveryCount: 1}
+		if err := txn.putStreamRecord(key, pendingSubKey(group, entry.ID), encodeStreamPending(p), data.LogRecordNormal); err != nil {
+			return nil, err
+		}
+	}
+	lastDelivered := entries[len(entries)-1].ID
+	if err := txn.putStreamRecord(key, groupSubKey(group), lastDelivered.encode(), data.LogRecordNormal); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// XReadGroupPending returns the entries pending for the consumer whose id is greater than after,
+// the entries removed from the stream have nil fields. It is used by a consumer to recover after a crash
+func (txn *Txn) XReadGroupPending(key, group, consumer []byte, after StreamID, count int) ([]StreamEntry, error) {
+	g, err := txn.getStreamGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]StreamEntry, 0)
+	for _, id := range g.sortedPending() {
+		if !after.Less(id) || g.pending[id].consumer != string(consumer) {
+			continue
+		}
+		if count > 0 && len(entries) == count {
+			break
+		}
+		fields, err := txn.getStreamEntry(key, id)
+		if err != nil && err != public.ErrKeyNotFound {
+			return nil, err
+		}
+		entries = append(entries, StreamEntry{ID: id, Fields: fields})
+	}
+	return entries, nil
+}
+
+// XAck removes the entries from the pending entry list of the group,
+// it returns the number of entries which were pending
+func (txn *Txn) XAck(key, group []byte, ids ...StreamID) (int, error) {
+	if txn.readOnly {
+		return 0, public.ErrUpdateInReadOnlyTxn
+	}
+	g, err := txn.getStreamGroup(key, group)
+	if err != nil {
+		return 0, err
+	}
+	var acked int
+	for _, id := range ids {
+		if _, ok := g.pending[id]; !ok {
+			continue
+		}
+		if err := txn.putStreamRecord(key, pendingSubKey(group, id), nil, data.LogRecordDeleted); err != nil {
+			return 0, err
+		}
+		delete(g.pending, id)
+		acked++
+	}
+	return acked, nil
+}
+
+// XPending returns the pending entries of the group whose id is between start and end inclusive,
+// only the entries of the consumer are returned if it is not nil. count limits the number of entries if it is positive
+func (txn *Txn) XPending(key, group []byte, start, end StreamID, count int, consumer []byte) ([]StreamPending, error) {
+	g, err := txn.getStreamGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	pending := make([]StreamPending, 0)
+	for _, id := range g.sortedPending() {
+		if id.Less(start) || end.Less(id) {
+			continue
+		}
+		p := g.pending[id]
+		if consumer != nil && p.consumer != string(consumer) {
+			continue
+		}
+		if count > 0 && len(pending) == count {
+			break
+		}
+		pending = append(pending, StreamPending{
+			ID:            id,
+			Consumer:      []byte(p.consumer),
+			Idle:          time.Duration(now-p.deliveryTime) * time.Millisecond,
+			DeliveryCount: p.deliveryCount,
+		})
+	}
+	return pending, nil
+}
+
+// XClaim transfers the pending entries idle for at least minIdle to the consumer and returns them.
+// The delivery count of the claimed entries is incremented, the entries removed from the stream
+// are removed from the pending entry list as well and not returned
+func (txn *Txn) XClaim(key, group, consumer []byte, minIdle time.Duration, ids ...StreamID) ([]StreamEntry, error) {
+	if err := checkKey(consumer); err != nil {
+		return nil, err
+	}
+	if txn.readOnly {
+		return nil, public.ErrUpdateInReadOnlyTxn
+	}
+	g, err := txn.getStreamGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	entries := make([]StreamEntry, 0)
+	for _, id := range ids {
+		p, ok := g.pending[id]
+		if !ok || time.Duration(now-p.deliveryTime)*time.Millisecond < minIdle {
+			continue
+		}
+		fields, err := txn.getStreamEntry(key, id)
+		if err == public.ErrKeyNotFound {
+			if err := txn.putStreamRecord(key, pendingSubKey(group, id), nil, data.LogRecordDeleted); err != nil {
+				return nil, err
+			}
+			delete(g.pending, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		claimed := &streamPending{consumer: string(consumer), deliveryTime: now, deliveryCount: p.deliveryCount + 1}
+		if err := txn.putStreamRecord(key, pendingSubKey(group, id), encodeStreamPending(claimed), data.LogRecordNormal); err != nil {
+			return nil, err
+		}
+		g.pending[id] = claimed
+		entries = append(entries, StreamEntry{ID: id, Fields: fields})
+	}
+	return entries, nil
+}
+
+func (txn *Txn) putStreamRecord(key, sub, value []byte, typ data.LogRecordType) error {
+	logRecord := &data.LogRecord{
+		Key:      encodeKeyWithTxId(encodeMemberKey(key, sub), txn.startTs),
+		Value:    value,
+		Type:     typ,
+		DataType: data.Stream,
+	}
+	pos, err := txn.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+
+	txn.putSubPendingWrite(txn.streamPendingWrites, string(key), string(sub), &pendingWrite{typ: typ, LogPos: pos})
+	return nil
+}
+
+// delStream deletes every record of the stream as seen by the txn
+func (txn *Txn) delStream(key []byte) error {
+	subs := make(map[string]bool)
+	txn.trackRead(data.Stream, key)
+	lock := txn.db.getIndexLockByType(data.Stream)
+	lock.RLock()
+	if s, ok := txn.db.index.getStreamIndex(string(key)); ok {
+		iterator := s.entries.Iterator(false)
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			subs[string(append([]byte{streamEntryPrefix}, iterator.Key()...))] = true
+		}
+		iterator.Close()
+		for name, g := range s.groups {
+			subs[string(groupSubKey([]byte(name)))] = true
+			for id := range g.pending {
+				subs[string(pendingSubKey([]byte(name), id))] = true
+			}
+		}
+		if s.metaPos != nil {
+			subs[string([]byte{streamMetaPrefix})] = true
+		}
+	}
+	lock.RUnlock()
+	if err := txn.checkStreamSnapshot(key); err != nil {
+		return err
+	}
+
+	for sub, pw := range txn.streamPendingWrites[string(key)] {
+		subs[sub] = pw.typ == data.LogRecordNormal
+	}
+	for sub, exist := range subs {
+		if !exist {
+			continue
+		}
+		if err := txn.putStreamRecord(key, []byte(sub), nil, data.LogRecordDeleted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getStreamRecord returns the value of the record of the sub key as seen by the txn
+func (txn *Txn) getStreamRecord(key, sub []byte) ([]byte, error) {
+	var pos *data.LogPos
+	if pw, ok := txn.streamPendingWrites[string(key)][string(sub)]; ok {
+		if pw.typ == data.LogRecordDeleted {
+			return nil, public.ErrKeyNotFound
+		}
+		pos = pw.LogPos
+	} else {
+		txn.trackRead(data.Stream, key)
+		lock := txn.db.getIndexLockByType(data.Stream)
+		lock.RLock()
+		if s, ok := txn.db.index.getStreamIndex(string(key)); ok {
+			pos = s.posOf(sub)
+		}
+		lock.RUnlock()
+		if err := txn.checkStreamSnapshot(key); err != nil {
+			return nil, err
+		}
+		if pos == nil {
+			return nil, public.ErrKeyNotFound
+		}
+	}
+	return txn.db.getValueByPos(pos)
+}
+
+// getStreamLastID returns the last id generated for the stream and whether the stream exists
+func (txn *Txn) getStreamLastID(key []byte) (StreamID, bool, error) {
+	v, err := txn.getStreamRecord(key, []byte{streamMetaPrefix})
+	if err == public.ErrKeyNotFound {
+		return StreamID{}, false, nil
+	}
+	if err != nil {
+		return StreamID{}, false, err
+	}
+	return decodeStreamID(v), true, nil
+}
+
+func (txn *Txn) getStreamEntry(key []byte, id StreamID) ([][]byte, error) {
+	v, err := txn.getStreamRecord(key, entrySubKey(id))
+	if err != nil {
+		return nil, err
+	}
+	return decodeStreamFields(v), nil
+}
+
+// getStreamGroup returns a copy of the consumer group as seen by the txn
+func (txn *Txn) getStreamGroup(key, group []byte) (*streamGroup, error) {
+	pendingWrites := txn.streamPendingWrites[string(key)]
+	values := make(map[string][]byte)
+	for sub, pw := range pendingWrites {
+		if pw.typ == data.LogRecordNormal && (sub[0] == streamGroupPrefix || sub[0] == streamPendingPrefix) {
+			v, err := txn.db.getValueByPos(pw.LogPos)
+			if err != nil {
+				return nil, err
+			}
+			values[sub] = v
+		}
+	}
+
+	g := &streamGroup{pending: make(map[StreamID]*streamPending)}
+	exist := false
+	txn.trackRead(data.Stream, key)
+	lock := txn.db.getIndexLockByType(data.Stream)
+	lock.RLock()
+	if s, ok := txn.db.index.getStreamIndex(string(key)); ok {
+		if committed, ok := s.groups[string(group)]; ok {
+			exist = true
+			g.lastDelivered = committed.lastDelivered
+			for id, p := range committed.pending {
+				g.pending[id] = p
+			}
+		}
+	}
+	lock.RUnlock()
+	if err := txn.checkStreamSnapshot(key); err != nil {
+		return nil, err
+	}
+
+	for sub, pw := range pendingWrites {
+		switch sub[0] {
+		case streamGroupPrefix:
+			if sub[1:] != string(group) {
+				continue
+			}
+			exist = pw.typ == data.LogRecordNormal
+			if exist {
+				g.lastDelivered = decodeStreamID(values[sub])
+			}
+		case streamPendingPrefix:
+			name, id := decodePendingSubKey([]byte(sub))
+			if string(name) != string(group) {
+				continue
+			}
+			if pw.typ == data.LogRecordDeleted {
+				delete(g.pending, id)
+			} else {
+				g.pending[id] = decodeStreamPending(values[sub])
+			}
+		}
+	}
+	if !exist {
+		return nil, public.ErrStreamGroupNotFound
+	}
+	return g, nil
+}
+
+// rangeStream returns the entries between start and end inclusive as seen by the txn
+func (txn *Txn) rangeStream(key []byte, start, end StreamID, reverse bool, count int) ([]StreamEntry, error) {
+	ids, positions, err := txn.rangeStreamIDs(key, start, end, reverse, count)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]StreamEntry, len(ids))
+	for i, id := range ids {
+		v, err := txn.db.getValueByPos(positions[id])
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = StreamEntry{ID: id, Fields: decodeStreamFields(v)}
+	}
+	return entries, nil
+}
+
+func (txn *Txn) rangeStreamIDs(key []byte, start, end StreamID, reverse bool, count int) ([]StreamID, map[StreamID]*data.LogPos, error) {
+	positions := make(map[StreamID]*data.LogPos)
+	inRange := func(id StreamID) bool {
+		return !id.Less(start) && !end.Less(id)
+	}
+
+	// the entries deleted by the txn may be within the committed entries read
+	pendingWrites := txn.streamPendingWrites[string(key)]
+	limit := count
+	for sub, pw := range pendingWrites {
+		if sub[0] == streamEntryPrefix && pw.typ == data.LogRecordDeleted {
+			limit++
+		}
+	}
+
+	txn.trackRead(data.Stream, key)
+	lock := txn.db.getIndexLockByType(data.Stream)
+	lock.RLock()
+	if s, ok := txn.db.index.getStreamIndex(string(key)); ok {
+		if iterator := s.entries.Iterator(reverse); iterator != nil {
+			from := start
+			if reverse {
+				from = end
+			}
+			for iterator.Seek(from.encode()); iterator.Valid(); iterator.Next() {
+				id := decodeStreamID(iterator.Key())
+				if !inRange(id) || count > 0 && len(positions) == limit {
+					break
+				}
+				positions[id] = iterator.Value()
+			}
+			iterator.Close()
+		}
+	}
+	lock.RUnlock()
+	if err := txn.checkStreamSnapshot(key); err != nil {
+		return nil, nil, err
+	}
+
+	for sub, pw := range pendingWrites {
+		if sub[0] != streamEntryPrefix {
+			continue
+		}
+		id := decodeStreamID([]byte(sub[1:]))
+		if !inRange(id) {
+			continue
+		}
+		if pw.typ == data.LogRecordDeleted {
+			delete(positions, id)
+		} else {
+			positions[id] = pw.LogPos
+		}
+	}
+
+	ids := make([]StreamID, 0, len(positions))
+	for id := range positions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if reverse {
+			return ids[j].Less(ids[i])
+		}
+		return ids[i].Less(ids[j])
+	})
+	if count > 0 && len(ids) > count {
+		ids = ids[:count]
+	}
+	return ids, positions, nil
+}
+
+func (g *streamGroup) sortedPending() []StreamID {
+	ids := make([]StreamID, 0, len(g.pending))
+	for id := range g.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].Less(ids[j])
+	})
+	return ids
+}
+
+func entrySubKey(id StreamID) []byte {
+	return append([]byte{streamEntryPrefix}, id.encode()...)
+}
+
+func groupSubKey(group []byte) []byte {
+	return append([]byte{streamGroupPrefix}, group...)
+}
+
+func pendingSubKey(group []byte, id StreamID) []byte {
+	return append([]byte{streamPendingPrefix}, bytex.EncodeByteSlices(group, id.encode())...)
+}
+
+func decodePendingSubKey(sub []byte) ([]byte, StreamID) {
+	group, id := bytex.DecodeByteSlices(sub[1:])
+	return group, decodeStreamID(id)
+}
+
+// encode returns the id in big endian, so the encoded ids are in the same order as the ids
+func (id StreamID) encode() []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[:8], id.Ms)
+	binary.BigEndian.PutUint64(buf[8:], id.Seq)
+	return buf
+}
+
+func decodeStreamID(buf []byte) StreamID {
+	return StreamID{Ms: binary.BigEndian.Uint64(buf[:8]), Seq: binary.BigEndian.Uint64(buf[8:16])}
+}
+
+func encodeStreamFields(fields [][]byte) []byte {
+	size := binary.MaxVarintLen64
+	for _, field := range fields {
+		size += binary.MaxVarintLen64 + len(field)
+	}
+	buf := make([]byte, size)
+	index := binary.PutUvarint(buf, uint64(len(fields)))
+	for _, field := range fields {
+		index += binary.PutUvarint(buf[index:], uint64(len(field)))
+		index += copy(buf[index:], field)
+	}
+	return buf[:index]
+}
+
+func decodeStreamFields(buf []byte) [][]byte {
+	n, index := binary.Uvarint(buf)
+	fields := make([][]byte, n)
+	for i := range fields {
+		size, l := binary.Uvarint(buf[index:])
+		index += l
+		fields[i] = buf[index : index+int(size)]
+		index += int(size)
+	}
+	return fields
+}
+
+func encodeStreamPending(p *streamPending) []byte {
+	buf := make([]byte, binary.MaxVarintLen64*2+len(p.consumer))
+	index := binary.PutVarint(buf, p.deliveryTime)
+	index += binary.PutVarint(buf[index:], p.deliveryCount)
+	index += copy(buf[index:], p.consumer)
+	return buf[:index]
+}
+
+func decodeStreamPending(buf []byte) *streamPending {
+	deliveryTime, index := binary.Varint(buf)
+	deliveryCount, l := binary.Varint(buf[index:])
+	index += l
+	return &streamPending{
+		consumer:      string(buf[index:]),
+		deliveryTime:  deliveryTime,
+		deliveryCount: deliveryCount,
+	}
+}
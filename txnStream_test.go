@@ -0,0 +1,247 @@
+package CouloyDB
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxnStream(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("events")
+	ids := make([]StreamID, 0)
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		for i := 0; i < 10; i++ {
+			id, err := txn.XAdd(key, []byte("n"), []byte(strconv.Itoa(i)))
+			assert.Nil(t, err)
+			if len(ids) > 0 {
+				assert.True(t, ids[len(ids)-1].Less(id))
+			}
+			ids = append(ids, id)
+		}
+		_, err := txn.XAdd(key, []byte("odd"))
+		assert.Equal(t, public.ErrTxnArgsWrong, err)
+
+		// the entries added in the txn are visible to it
+		length, err := txn.XLen(key)
+		assert.Nil(t, err)
+		assert.Equal(t, 10, length)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		entries, err := txn.XRange(key, MinStreamID, MaxStreamID, 0)
+		assert.Nil(t, err)
+		assert.Len(t, entries, 10)
+		assert.Equal(t, ids[0], entries[0].ID)
+		assert.Equal(t, [][]byte{[]byte("n"), []byte("0")}, entries[0].Fields)
+
+		entries, err = txn.XRange(key, ids[3], ids[6], 2)
+		assert.Nil(t, err)
+		assert.Equal(t, []StreamID{ids[3], ids[4]}, []StreamID{entries[0].ID, entries[1].ID})
+
+		entries, err = txn.XRevRange(key, MaxStreamID, ids[7], 0)
+		assert.Nil(t, err)
+		assert.Equal(t, []StreamID{ids[9], ids[8], ids[7]}, []StreamID{entries[0].ID, entries[1].ID, entries[2].ID})
+
+		err = txn.XAddWithID(key, ids[9], []byte("n"), []byte("late"))
+		assert.Equal(t, public.ErrStreamIDTooSmall, err)
+
+		removed, err := txn.XTrim(key, 4)
+		assert.Nil(t, err)
+		assert.Equal(t, 6, removed)
+		entries, err = txn.XRange(key, MinStreamID, MaxStreamID, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, ids[6], entries[0].ID)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		length, err := txn.XLen(key)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, length)
+
+		// the ids keep increasing after the stream is emptied
+		_, err = txn.XTrim(key, 0)
+		assert.Nil(t, err)
+		id, err := txn.XAdd(key, []byte("n"), []byte("10"))
+		assert.Nil(t, err)
+		assert.True(t, ids[9].Less(id))
+
+		length, err = txn.XLen([]byte("missing"))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, length)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	id, err := ParseStreamID("1526919030474-55")
+	assert.Nil(t, err)
+	assert.Equal(t, StreamID{Ms: 1526919030474, Seq: 55}, id)
+	assert.Equal(t, "1526919030474-55", id.String())
+	_, err = ParseStreamID("abc")
+	assert.Equal(t, public.ErrInvalidStreamID, err)
+}
+
+func TestTxnStreamGroup(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	key, group := []byte("orders"), []byte("billing")
+	alice, bob := []byte("alice"), []byte("bob")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		for i := 1; i <= 5; i++ {
+			if err := txn.XAddWithID(key, StreamID{Ms: uint64(i)}, []byte("order"), []byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		assert.Equal(t, public.ErrKeyNotFound, txn.XGroupCreate([]byte("missing"), group, nil))
+		assert.Nil(t, txn.XGroupCreate(key, group, &MinStreamID))
+		assert.Equal(t, public.ErrStreamGroupExist, txn.XGroupCreate(key, group, nil))
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		entries, err := txn.XReadGroup(key, group, alice, 2)
+		assert.Nil(t, err)
+		assert.Len(t, entries, 2)
+		assert.Equal(t, StreamID{Ms: 1}, entries[0].ID)
+
+		entries, err = txn.XReadGroup(key, group, bob, 0)
+		assert.Nil(t, err)
+		assert.Len(t, entries, 3)
+		assert.Equal(t, StreamID{Ms: 3}, entries[0].ID)
+
+		entries, err = txn.XReadGroup(key, group, bob, 0)
+		assert.Nil(t, err)
+		assert.Len(t, entries, 0)
+
+		acked, err := txn.XAck(key, group, StreamID{Ms: 1}, StreamID{Ms: 3}, StreamID{Ms: 100})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, acked)
+
+		_, err = txn.XReadGroup(key, []byte("missing"), alice, 0)
+		assert.Equal(t, public.ErrStreamGroupNotFound, err)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	// reboot, the pending entry lists are rebuilt from the log
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(db.options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		pending, err := txn.XPending(key, group, MinStreamID, MaxStreamID, 0, nil)
+		assert.Nil(t, err)
+		assert.Len(t, pending, 3)
+		assert.Equal(t, StreamID{Ms: 2}, pending[0].ID)
+		assert.Equal(t, alice, pending[0].Consumer)
+		assert.Equal(t, int64(1), pending[0].DeliveryCount)
+
+		pending, err = txn.XPending(key, group, MinStreamID, MaxStreamID, 0, bob)
+		assert.Nil(t, err)
+		assert.Equal(t, []StreamID{{Ms: 4}, {Ms: 5}}, []StreamID{pending[0].ID, pending[1].ID})
+
+		entries, err := txn.XReadGroupPending(key, group, bob, MinStreamID, 0)
+		assert.Nil(t, err)
+		assert.Len(t, entries, 2)
+		assert.Equal(t, [][]byte{[]byte("order"), []byte("4")}, entries[0].Fields)
+
+		// the group continues after the last delivered entry
+		assert.Nil(t, txn.XAddWithID(key, StreamID{Ms: 6}, []byte("order"), []byte("6")))
+		entries, err = txn.XReadGroup(key, group, alice, 0)
+		assert.Nil(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, StreamID{Ms: 6}, entries[0].ID)
+
+		entries, err = txn.XClaim(key, group, alice, time.Hour, StreamID{Ms: 4})
+		assert.Nil(t, err)
+		assert.Len(t, entries, 0)
+		entries, err = txn.XClaim(key, group, alice, 0, StreamID{Ms: 4}, StreamID{Ms: 3})
+		assert.Nil(t, err)
+		assert.Len(t, entries, 1)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		pending, err := txn.XPending(key, group, StreamID{Ms: 4}, StreamID{Ms: 4}, 0, nil)
+		assert.Nil(t, err)
+		assert.Len(t, pending, 1)
+		assert.Equal(t, alice, pending[0].Consumer)
+		assert.Equal(t, int64(2), pending[0].DeliveryCount)
+
+		// the claimed entries removed from the stream are removed from the pending entry list
+		_, err = txn.XTrim(key, 0)
+		assert.Nil(t, err)
+		entries, err := txn.XClaim(key, group, bob, 0, StreamID{Ms: 2})
+		assert.Nil(t, err)
+		assert.Len(t, entries, 0)
+		pending, err = txn.XPending(key, group, MinStreamID, MaxStreamID, 0, nil)
+		assert.Nil(t, err)
+		assert.Len(t, pending, 3)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxnStream_Merge(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	key, group := []byte("logs"), []byte("indexer")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		for i := 0; i < 100; i++ {
+			if _, err := txn.XAdd(key, []byte("line"), []byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		if err := txn.XGroupCreate(key, group, &MinStreamID); err != nil {
+			return err
+		}
+		_, err := txn.XReadGroup(key, group, []byte("worker"), 10)
+		return err
+	})
+	assert.Nil(t, err)
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		_, err := txn.XTrim(key, 50)
+		return err
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(db.options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		length, err := txn.XLen(key)
+		assert.Nil(t, err)
+		assert.Equal(t, 50, length)
+
+		pending, err := txn.XPending(key, group, MinStreamID, MaxStreamID, 0, nil)
+		assert.Nil(t, err)
+		assert.Len(t, pending, 10)
+
+		entries, err := txn.XReadGroup(key, group, []byte("worker"), 1)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{[]byte("line"), []byte("50")}, entries[0].Fields)
+		return nil
+	})
+	assert.Nil(t, err)
+}
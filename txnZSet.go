@@ -0,0 +1,418 @@
+package CouloyDB
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/Kirov7/CouloyDB/public/ds"
+)
+
+// ZMember is a member of a sorted set with its score
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// ZAdd adds the members to the sorted set, the score of an existing member is updated
+func (txn *Txn) ZAdd(key []byte, members ...ZMember) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if txn.readOnly {
+		return public.ErrUpdateInReadOnlyTxn
+	}
+	for _, member := range members {
+		if err := checkKey(member.Member); err != nil {
+			return err
+		}
+		if math.IsNaN(member.Score) {
+			return public.ErrScoreIsNaN
+		}
+
+		logRecord := &data.LogRecord{
+			Key:      encodeKeyWithTxId(encodeMemberKey(key, member.Member), txn.startTs),
+			Value:    encodeScore(member.Score),
+			Type:     data.LogRecordNormal,
+			DataType: data.ZSet,
+		}
+
+		pos, err := txn.appendLogRecord(logRecord)
+		if err != nil {
+			return err
+		}
+
+		txn.putSubPendingWrite(txn.zsetPendingWrites, string(key), string(member.Member), &pendingWrite{typ: data.LogRecordNormal, LogPos: pos, score: member.Score})
+	}
+	return nil
+}
+
+// ZRem removes the members from the sorted set, it returns ErrKeyNotFound if a member does not exist
+func (txn *Txn) ZRem(key []byte, members ...[]byte) error {
+	if txn.readOnly {
+		return public.ErrUpdateInReadOnlyTxn
+	}
+
+	for _, member := range members {
+		if _, err := txn.ZScore(key, member); err != nil {
+			return err
+		}
+
+		logRecord := &data.LogRecord{
+			Key:      encodeKeyWithTxId(encodeMemberKey(key, member), txn.startTs),
+			Type:     data.LogRecordDeleted,
+			DataType: data.ZSet,
+		}
+
+		pos, err := txn.appendLogRecord(logRecord)
+		if err != nil {
+			return err
+		}
+
+		txn.putSubPendingWrite(txn.zsetPendingWrites, string(key), string(member), &pendingWrite{typ: data.LogRecordDeleted, LogPos: pos})
+	}
+	return nil
+}
+
+// ZScore returns the score of the member
+func (txn *Txn) ZScore(key, member []byte) (float64, error) {
+	if pw, ok := txn.zsetPendingWrites[string(key)][string(member)]; ok {
+		if pw.typ == data.LogRecordDeleted {
+			return 0, public.ErrKeyNotFound
+		}
+		return pw.score, nil
+	}
+
+	txn.trackRead(data.ZSet, key)
+	lock := txn.db.getIndexLockByType(data.ZSet)
+	lock.RLock()
+	defer lock.RUnlock()
+	// a txn committed after the index is locked only changes the index after the unlock,
+	// so the before images read under the lock cover all the changes in the index
+	if pos, ok := txn.snapshotBefore(data.ZSet, key)[string(member)]; ok {
+		if pos == nil {
+			return 0, public.ErrKeyNotFound
+		}
+		value, err := txn.db.getValueByPos(pos)
+		if err != nil {
+			return 0, err
+		}
+		return decodeScore(value), nil
+	}
+	zs, ok := txn.db.index.getZSetIndex(string(key))
+	if !ok {
+		return 0, public.ErrKeyNotFound
+	}
+	score, ok := zs.scores.Score(string(member))
+	if !ok {
+		return 0, public.ErrKeyNotFound
+	}
+	return score, nil
+}
+
+// ZIncrBy adds the increment to the score of the member and returns the new score,
+// a member that does not exist is added with the increment as its score
+func (txn *Txn) ZIncrBy(key []byte, increment float64, member []byte) (float64, error) {
+	score, err := txn.ZScore(key, member)
+	if err != nil && err != public.ErrKeyNotFound {
+		return 0, err
+	}
+	score += increment
+	if err := txn.ZAdd(key, ZMember{Member: member, Score: score}); err != nil {
+		return 0, err
+	}
+	return score, nil
+}
+
+// ZRank returns the 0-based rank of the member ordered by score from low to high
+func (txn *Txn) ZRank(key, member []byte) (int, error) {
+	var rank int
+	err := txn.viewZSet(key, func(scores *zsetView) error {
+		var ok bool
+		if rank, ok = scores.Rank(string(member)); !ok {
+			return public.ErrKeyNotFound
+		}
+		return nil
+	})
+	return rank, err
+}
+
+// ZRange returns the members ranked from start to stop inclusive,
+// negative indexes count from the highest ranked member
+func (txn *Txn) ZRange(key []byte, start, stop int) ([]ZMember, error) {
+	var members []ZMember
+	err := txn.viewZSet(key, func(scores *zsetView) error {
+		if start < 0 {
+			start += scores.Len()
+		}
+		if stop < 0 {
+			stop += scores.Len()
+		}
+		members = toZMembers(scores.Range(start, stop))
+		return nil
+	})
+	return members, err
+}
+
+// ZRangeByScore returns the members whose score is between min and max inclusive
+func (txn *Txn) ZRangeByScore(key []byte, min, max float64) ([]ZMember, error) {
+	var members []ZMember
+	err := txn.viewZSet(key, func(scores *zsetView) error {
+		members = toZMembers(scores.RangeByScore(min, max))
+		return nil
+	})
+	return members, err
+}
+
+// ZRangeByLex returns the members between min and max inclusive in lexicographical order,
+// a nil bound is unlimited. Like redis, it is meant for sorted sets whose members share the same score
+func (txn *Txn) ZRangeByLex(key []byte, min, max []byte) ([]ZMember, error) {
+	members := make([]ZMember, 0)
+	err := txn.viewZSet(key, func(scores *zsetView) error {
+		scores.ForEach(func(member string, score float64) bool {
+			if min != nil && bytes.Compare([]byte(member), min) < 0 {
+				return true
+			}
+			if max != nil && bytes.Compare([]byte(member), max) > 0 {
+				return true
+			}
+			members = append(members, ZMember{Member: []byte(member), Score: score})
+			return true
+		})
+		return nil
+	})
+	return members, err
+}
+
+// ZCard returns the number of members in the sorted set
+func (txn *Txn) ZCard(key []byte) (int, error) {
+	var count int
+	err := txn.viewZSet(key, func(scores *zsetView) error {
+		if count = scores.Len(); count == 0 {
+			return public.ErrKeyNotFound
+		}
+		return nil
+	})
+	return count, err
+}
+
+// viewZSet calls fn with the sorted set as seen by the txn, fn must not keep the view.
+// The pending writes of the txn overlay the committed sorted set, which is not copied
+func (txn *Txn) viewZSet(key []byte, fn func(scores *zsetView) error) error {
+	view := &zsetView{
+		hidden:      make(map[string]struct{}),
+		addedScores: make(map[string]float64),
+	}
+	for member, pw := range txn.zsetPendingWrites[string(key)] {
+		if pw.typ == data.LogRecordNormal {
+			view.addedScores[member] = pw.score
+			view.added = append(view.added, ds.SortedSetItem{Member: member, Score: pw.score})
+		}
+	}
+
+	txn.trackRead(data.ZSet, key)
+	lock := txn.db.getIndexLockByType(data.ZSet)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	view.committed = ds.NewSortedSet()
+	if zs, ok := txn.db.index.getZSetIndex(string(key)); ok {
+		view.committed = zs.scores
+	}
+	// the committed members written by the txn are replaced by their pending writes
+	for member := range txn.zsetPendingWrites[string(key)] {
+		if _, ok := view.committed.Score(member); ok {
+			view.hidden[member] = struct{}{}
+		}
+	}
+	// the committed members written after the start of a serializable txn are replaced by their before images
+	for member, pos := range txn.snapshotBefore(data.ZSet, key) {
+		if _, ok := txn.zsetPendingWrites[string(key)][member]; ok {
+			continue
+		}
+		if _, ok := view.committed.Score(member); ok {
+			view.hidden[member] = struct{}{}
+		}
+		if pos == nil {
+			continue
+		}
+		value, err := txn.db.getValueByPos(pos)
+		if err != nil {
+			return err
+		}
+		score := decodeScore(value)
+		view.addedScores[member] = score
+		view.added = append(view.added, ds.SortedSetItem{Member: member, Score: score})
+	}
+	sort.Slice(view.added, func(i, j int) bool {
+		return itemBefore(view.added[i], view.added[j].Member, view.added[j].Score)
+	})
+	return fn(view)
+}
+
+// zsetView is a sorted set as seen by a txn, the members written by the txn overlay the committed ones
+type zsetView struct {
+	committed *ds.SortedSet
+	// the committed members written by the txn
+	hidden map[string]struct{}
+	// the members added or updated by the txn and the members of its snapshot in ascending order
+	added       []ds.SortedSetItem
+	addedScores map[string]float64
+}
+
+func (v *zsetView) Len() int {
+	return v.committed.Len() - len(v.hidden) + len(v.added)
+}
+
+func (v *zsetView) Score(member string) (float64, bool) {
+	if score, ok := v.addedScores[member]; ok {
+		return score, true
+	}
+	if _, ok := v.hidden[member]; ok {
+		return 0, false
+	}
+	return v.committed.Score(member)
+}
+
+// Rank returns the 0-based rank of the member in ascending order
+func (v *zsetView) Rank(member string) (int, bool) {
+	score, ok := v.Score(member)
+	if !ok {
+		return 0, false
+	}
+	return v.committed.CountBefore(member, score) - v.hiddenBefore(member, score) + v.addedBefore(member, score), true
+}
+
+// Range returns the members whose 0-based rank is in [start, stop]
+func (v *zsetView) Range(start, stop int) []ds.SortedSetItem {
+	if start < 0 {
+		start = 0
+	}
+	if stop >= v.Len() {
+		stop = v.Len() - 1
+	}
+	items := make([]ds.SortedSetItem, 0)
+	if start > stop {
+		return items
+	}
+
+	// the committed member at the rank start-len(added) is at most at the rank start in the view,
+	// so the members from it are visited and the ones before start are skipped
+	var rank, addedStart int
+	from := start - len(v.added)
+	if from > 0 {
+		v.committed.ForEachFromRank(from, func(member string, score float64) bool {
+			addedStart = v.addedBefore(member, score)
+			rank = from - v.hiddenBefore(member, score) + addedStart
+			return false
+		})
+	} else {
+		from = 0
+	}
+	v.merge(func(fn func(member string, score float64) bool) {
+		v.committed.ForEachFromRank(from, fn)
+	}, v.added[addedStart:], func(member string, score float64) bool {
+		if rank >= start {
+			items = append(items, ds.SortedSetItem{Member: member, Score: score})
+		}
+		rank++
+		return rank <= stop
+	})
+	return items
+}
+
+// RangeByScore returns the members whose score is in [min, max]
+func (v *zsetView) RangeByScore(min, max float64) []ds.SortedSetItem {
+	items := make([]ds.SortedSetItem, 0)
+	addedStart := sort.Search(len(v.added), func(i int) bool {
+		return v.added[i].Score >= min
+	})
+	v.merge(func(fn func(member string, score float64) bool) {
+		v.committed.ForEachFromScore(min, fn)
+	}, v.added[addedStart:], func(member string, score float64) bool {
+		if score > max {
+			return false
+		}
+		items = append(items, ds.SortedSetItem{Member: member, Score: score})
+		return true
+	})
+	return items
+}
+
+// ForEach visits the members in ascending order until fn returns false
+func (v *zsetView) ForEach(fn func(member string, score float64) bool) {
+	v.merge(v.committed.ForEach, v.added, fn)
+}
+
+// merge visits the committed members visited by committed which are not hidden and the added members
+// in ascending order until fn returns false
+func (v *zsetView) merge(committed func(fn func(member string, score float64) bool), added []ds.SortedSetItem,
+	fn func(member string, score float64) bool) {
+	i, stopped := 0, false
+	committed(func(member string, score float64) bool {
+		if _, ok := v.hidden[member]; ok {
+			return true
+		}
+		for ; i < len(added) && itemBefore(added[i], member, score); i++ {
+			if !fn(added[i].Member, added[i].Score) {
+				stopped = true
+				return false
+			}
+		}
+		if !fn(member, score) {
+			stopped = true
+			return false
+		}
+		return true
+	})
+	for ; !stopped && i < len(added); i++ {
+		if !fn(added[i].Member, added[i].Score) {
+			return
+		}
+	}
+}
+
+// hiddenBefore returns the number of the hidden members which sort before the member with the score
+func (v *zsetView) hiddenBefore(member string, score float64) int {
+	count := 0
+	for hidden := range v.hidden {
+		hiddenScore, _ := v.committed.Score(hidden)
+		if itemBefore(ds.SortedSetItem{Member: hidden, Score: hiddenScore}, member, score) {
+			count++
+		}
+	}
+	return count
+}
+
+// addedBefore returns the number of the added members which sort before the member with the score
+func (v *zsetView) addedBefore(member string, score float64) int {
+	return sort.Search(len(v.added), func(i int) bool {
+		return !itemBefore(v.added[i], member, score)
+	})
+}
+
+// itemBefore reports whether the item sorts before the member with the score
+func itemBefore(item ds.SortedSetItem, member string, score float64) bool {
+	return item.Score < score || item.Score == score && item.Member < member
+}
+
+func toZMembers(items []ds.SortedSetItem) []ZMember {
+	members := make([]ZMember, len(items))
+	for i, item := range items {
+		members[i] = ZMember{Member: []byte(item.Member), Score: item.Score}
+	}
+	return members
+}
+
+func encodeScore(score float64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, math.Float64bits(score))
+	return buf
+}
+
+func decodeScore(buf []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(buf))
+}
@@ -0,0 +1,253 @@
+package CouloyDB
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxnZSet(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("leaderboard")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.ZAdd(key,
+			ZMember{Member: []byte("alice"), Score: 30},
+			ZMember{Member: []byte("bob"), Score: 10},
+			ZMember{Member: []byte("carol"), Score: 20},
+		)
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		// the pending writes are visible inside the txn
+		score, err := txn.ZIncrBy(key, 25, []byte("bob"))
+		assert.Nil(t, err)
+		assert.Equal(t, float64(35), score)
+
+		rank, err := txn.ZRank(key, []byte("bob"))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, rank)
+
+		assert.Nil(t, txn.ZRem(key, []byte("carol")))
+		assert.Equal(t, public.ErrKeyNotFound, txn.ZRem(key, []byte("carol")))
+
+		card, err := txn.ZCard(key)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, card)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		members, err := txn.ZRange(key, 0, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, []ZMember{{[]byte("alice"), 30}, {[]byte("bob"), 35}}, members)
+
+		members, err = txn.ZRange(key, -1, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, []ZMember{{[]byte("bob"), 35}}, members)
+
+		members, err = txn.ZRangeByScore(key, 31, math.Inf(1))
+		assert.Nil(t, err)
+		assert.Equal(t, []ZMember{{[]byte("bob"), 35}}, members)
+
+		_, err = txn.ZScore(key, []byte("carol"))
+		assert.Equal(t, public.ErrKeyNotFound, err)
+
+		_, err = txn.ZRank(key, []byte("carol"))
+		assert.Equal(t, public.ErrKeyNotFound, err)
+
+		assert.Equal(t, public.ErrUpdateInReadOnlyTxn, txn.ZAdd(key, ZMember{Member: []byte("dave")}))
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.ZAdd(key, ZMember{Member: []byte("dave"), Score: math.NaN()})
+	})
+	assert.Equal(t, public.ErrScoreIsNaN, err)
+}
+
+func TestTxnZRangeByLex(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("lex")
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		for _, member := range []string{"d", "a", "c", "b", "e"} {
+			if err := txn.ZAdd(key, ZMember{Member: []byte(member)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		members, err := txn.ZRangeByLex(key, []byte("b"), []byte("d"))
+		assert.Nil(t, err)
+		assert.Equal(t, []ZMember{{[]byte("b"), 0}, {[]byte("c"), 0}, {[]byte("d"), 0}}, members)
+
+		members, err = txn.ZRangeByLex(key, []byte("c"), nil)
+		assert.Nil(t, err)
+		assert.Len(t, members, 3)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxnZSet_Conflict(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("conflict")
+	txn1 := newTxn(false, db, ReadCommitted)
+	txn1.begin()
+	txn2 := newTxn(false, db, ReadCommitted)
+	txn2.begin()
+
+	assert.Nil(t, txn1.ZAdd(key, ZMember{Member: []byte("member"), Score: 1}))
+	assert.Nil(t, txn2.ZAdd(key, ZMember{Member: []byte("member"), Score: 2}))
+	assert.Nil(t, txn1.commit())
+	assert.Equal(t, public.ErrTransactionConflict, txn2.commit())
+
+	err = db.SerialTransaction(true, func(txn *Txn) error {
+		score, err := txn.ZScore(key, []byte("member"))
+		assert.Nil(t, err)
+		assert.Equal(t, float64(1), score)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxnZSet_Reboot(t *testing.T) {
+	options := DefaultOptions()
+	options.DataFileSize = 32 * 1024
+	db, err := NewCouloyDB(options)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	key := []byte("reboot")
+	for i := 0; i < 1000; i++ {
+		err = db.SerialTransaction(false, func(txn *Txn) error {
+			return txn.ZAdd(key, ZMember{Member: []byte{byte(i % 100), 'm'}, Score: float64(i)})
+		})
+		assert.Nil(t, err)
+	}
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.ZRem(key, []byte{0, 'm'})
+	})
+	assert.Nil(t, err)
+
+	check := func(db *DB) {
+		err := db.SerialTransaction(true, func(txn *Txn) error {
+			card, err := txn.ZCard(key)
+			assert.Nil(t, err)
+			assert.Equal(t, 99, card)
+
+			members, err := txn.ZRange(key, 0, 0)
+			assert.Nil(t, err)
+			assert.Equal(t, []ZMember{{[]byte{1, 'm'}, 901}}, members)
+			return nil
+		})
+		assert.Nil(t, err)
+	}
+	check(db)
+
+	// the old scores are dropped by merge
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+	check(db)
+	assert.Equal(t, 0, db.Size())
+}
+
+func TestTxnZSet_PendingOverlay(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	key := []byte("zset")
+	scores := make(map[string]float64)
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		for i := 0; i < 100; i++ {
+			member := fmt.Sprintf("m%02d", i)
+			scores[member] = float64(i % 10)
+			if err := txn.ZAdd(key, ZMember{Member: []byte(member), Score: scores[member]}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		// update, remove and add members on both sides of the committed ones
+		for i := 0; i < 100; i += 7 {
+			member := fmt.Sprintf("m%02d", i)
+			scores[member] = float64(i%13) - 1.5
+			assert.Nil(t, txn.ZAdd(key, ZMember{Member: []byte(member), Score: scores[member]}))
+		}
+		for i := 3; i < 100; i += 11 {
+			member := fmt.Sprintf("m%02d", i)
+			delete(scores, member)
+			assert.Nil(t, txn.ZRem(key, []byte(member)))
+		}
+		for _, member := range []string{"a", "z"} {
+			scores[member] = 4
+			assert.Nil(t, txn.ZAdd(key, ZMember{Member: []byte(member), Score: scores[member]}))
+		}
+
+		expected := make([]ZMember, 0, len(scores))
+		for member, score := range scores {
+			expected = append(expected, ZMember{Member: []byte(member), Score: score})
+		}
+		sort.Slice(expected, func(i, j int) bool {
+			if expected[i].Score != expected[j].Score {
+				return expected[i].Score < expected[j].Score
+			}
+			return bytes.Compare(expected[i].Member, expected[j].Member) < 0
+		})
+
+		count, err := txn.ZCard(key)
+		assert.Nil(t, err)
+		assert.Equal(t, len(expected), count)
+		for i, member := range expected {
+			rank, err := txn.ZRank(key, member.Member)
+			assert.Nil(t, err)
+			assert.Equal(t, i, rank)
+		}
+		for start := 0; start < len(expected); start += 9 {
+			members, err := txn.ZRange(key, start, start+12)
+			assert.Nil(t, err)
+			assert.Equal(t, expected[start:int(math.Min(float64(start+13), float64(len(expected))))], members)
+		}
+		members, err := txn.ZRangeByScore(key, 2, 4)
+		assert.Nil(t, err)
+		inScore := make([]ZMember, 0)
+		for _, member := range expected {
+			if member.Score >= 2 && member.Score <= 4 {
+				inScore = append(inScore, member)
+			}
+		}
+		assert.Equal(t, inScore, members)
+		return nil
+	})
+	assert.Nil(t, err)
+}
@@ -1,10 +1,14 @@
 package CouloyDB
 
 import (
+	"context"
+	"errors"
+	"github.com/Kirov7/CouloyDB/driver"
 	"github.com/Kirov7/CouloyDB/public"
 	"github.com/Kirov7/CouloyDB/public/utils/bytex"
 	"github.com/Kirov7/CouloyDB/public/utils/wait"
 	"github.com/stretchr/testify/assert"
+	"runtime"
 	"strconv"
 	"testing"
 	"time"
@@ -346,3 +350,501 @@ func TestDB_SerialTransaction_With_RWTransaction(t *testing.T) {
 		assert.Equal(t, []byte("new_value"), finalValue)
 	})
 }
+
+func TestDB_Begin(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	txn, err := db.Begin(DefaultTxnOptions())
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Set([]byte("k1"), []byte("v1")))
+	_, err = db.Get([]byte("k1"))
+	assert.Equal(t, public.ErrKeyNotFound, err)
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, public.ErrTxnClosed, txn.Commit())
+	assert.Equal(t, public.ErrTxnClosed, txn.Rollback())
+	value, err := db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), value)
+
+	txn, err = db.Begin(TxnOptions{IsolationLevel: Serializable})
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Set([]byte("k2"), []byte("v2")))
+	assert.Nil(t, txn.Rollback())
+	txn.Discard()
+	_, err = db.Get([]byte("k2"))
+	assert.Equal(t, public.ErrKeyNotFound, err)
+
+	// the rolled back txn is no longer active
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		assert.Equal(t, public.ErrTxnNotBegun, txn.Commit())
+		return txn.Set([]byte("k2"), []byte("v2"))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, db.oracle.activeTxnHeap.Len())
+}
+
+func TestDB_Begin_Context(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.Begin(TxnOptions{Context: ctx})
+	assert.Equal(t, context.Canceled, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	txn, err := db.Begin(TxnOptions{Context: ctx})
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Set([]byte("k"), []byte("v")))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, public.ErrTxnClosed, txn.Commit())
+	_, err = db.Get([]byte("k"))
+	assert.Equal(t, public.ErrKeyNotFound, err)
+	assert.Equal(t, 0, db.oracle.activeTxnHeap.Len())
+}
+
+func TestDB_Begin_Dropped(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	// the goroutines watching the contexts of the txns don't keep them from being garbage collected
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		opts := DefaultTxnOptions()
+		if i%2 == 0 {
+			opts.Context = ctx
+		}
+		txn, err := db.Begin(opts)
+		assert.Nil(t, err)
+		assert.Nil(t, txn.Set([]byte("k"), []byte("v")))
+	}
+
+	// the dropped txns are rolled back by their finalizers
+	for i := 0; i < 50; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+		db.oracle.txnsMu.Lock()
+		active := db.oracle.activeTxnHeap.Len()
+		db.oracle.txnsMu.Unlock()
+		if active == 0 {
+			break
+		}
+	}
+	db.oracle.txnsMu.Lock()
+	assert.Equal(t, 0, db.oracle.activeTxnHeap.Len())
+	db.oracle.txnsMu.Unlock()
+}
+
+// failingWriter fails the number of writes after skipping some
+type failingWriter struct {
+	driver.IOManager
+	skip, failures int
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	if w.skip > 0 {
+		w.skip--
+	} else if w.failures > 0 {
+		w.failures--
+		return 0, errors.New("injected")
+	}
+	return w.IOManager.Write(b)
+}
+
+func TestDB_Commit_Failed(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	assert.Nil(t, db.Put([]byte("k"), []byte("v0")))
+	writer := &failingWriter{IOManager: db.activityFile.Writer}
+	db.activityFile.Writer = writer
+	defer func() { db.activityFile.Writer = writer.IOManager }()
+
+	txn, err := db.Begin(DefaultTxnOptions())
+	assert.Nil(t, err)
+	assert.Nil(t, txn.LockKeys([]byte("k")))
+	assert.Nil(t, txn.Set([]byte("k"), []byte("v")))
+	// the commit mark fails, the txn is rolled back
+	writer.failures = 1
+	assert.NotNil(t, txn.Commit())
+
+	// a closure txn failing to commit is rolled back too, as one which panics
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		if err := txn.Set([]byte("k"), []byte("v")); err != nil {
+			return err
+		}
+		writer.failures = 1
+		return nil
+	})
+	assert.NotNil(t, err)
+	assert.Panics(t, func() {
+		_ = db.SerialTransaction(false, func(txn *Txn) error {
+			panic("injected")
+		})
+	})
+
+	db.oracle.txnsMu.Lock()
+	assert.Equal(t, 0, db.oracle.activeTxnHeap.Len())
+	db.oracle.txnsMu.Unlock()
+	other, err := db.Begin(TxnOptions{LockTimeout: time.Second})
+	assert.Nil(t, err)
+	assert.Nil(t, other.LockKeys([]byte("k")))
+	assert.Nil(t, other.Rollback())
+}
+
+func TestDB_SerialTransaction_Snapshot(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	assert.Nil(t, db.Put([]byte("k"), []byte("v1")))
+	assert.Nil(t, db.SerialTransaction(false, func(txn *Txn) error {
+		if err := txn.HSet([]byte("hash"), []byte("f"), []byte("v1")); err != nil {
+			return err
+		}
+		if err := txn.SAdd([]byte("set"), []byte("m1")); err != nil {
+			return err
+		}
+		if err := txn.ZAdd([]byte("zset"), ZMember{Member: []byte("m1"), Score: 1}); err != nil {
+			return err
+		}
+		if _, err := txn.SetBit([]byte("bitmap"), 1, true); err != nil {
+			return err
+		}
+		return txn.RPush([]byte("list"), [][]byte{[]byte("e1")})
+	}))
+
+	reader, err := db.Begin(TxnOptions{ReadOnly: true, IsolationLevel: Serializable})
+	assert.Nil(t, err)
+	defer reader.Discard()
+
+	// the commits after the start of the reader are not seen by it
+	assert.Nil(t, db.SerialTransaction(false, func(txn *Txn) error {
+		if err := txn.Set([]byte("k"), []byte("v2")); err != nil {
+			return err
+		}
+		if err := txn.HSet([]byte("hash"), []byte("f"), []byte("v2")); err != nil {
+			return err
+		}
+		if err := txn.HSet([]byte("hash"), []byte("g"), []byte("v2")); err != nil {
+			return err
+		}
+		if err := txn.SRem([]byte("set"), []byte("m1")); err != nil {
+			return err
+		}
+		if err := txn.ZAdd([]byte("zset"), ZMember{Member: []byte("m1"), Score: 3}, ZMember{Member: []byte("m2"), Score: 2}); err != nil {
+			return err
+		}
+		if _, err := txn.SetBit([]byte("bitmap"), 1, false); err != nil {
+			return err
+		}
+		if _, err := txn.SetBit([]byte("bitmap"), 9000, true); err != nil {
+			return err
+		}
+		return txn.RPush([]byte("list"), [][]byte{[]byte("e2")})
+	}))
+
+	value, err := reader.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), value)
+	fields, values, err := reader.HGetAll([]byte("hash"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("f")}, fields)
+	assert.Equal(t, [][]byte{[]byte("v1")}, values)
+	isMember, err := reader.SIsMember([]byte("set"), []byte("m1"))
+	assert.Nil(t, err)
+	assert.True(t, isMember)
+	elements, err := reader.LRange([]byte("list"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("e1")}, elements)
+	members, err := reader.ZRange([]byte("zset"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZMember{{Member: []byte("m1"), Score: 1}}, members)
+	score, err := reader.ZScore([]byte("zset"), []byte("m1"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), score)
+	bit, err := reader.GetBit([]byte("bitmap"), 1)
+	assert.Nil(t, err)
+	assert.True(t, bit)
+	bit, err = reader.GetBit([]byte("bitmap"), 9000)
+	assert.Nil(t, err)
+	assert.False(t, bit)
+	assert.Nil(t, reader.Commit())
+
+	// a read committed txn reads the latest commits
+	assert.Nil(t, db.RWTransaction(false, func(txn *Txn) error {
+		value, err := txn.Get([]byte("k"))
+		assert.Equal(t, []byte("v2"), value)
+		return err
+	}))
+}
+
+func TestDB_SerialTransaction_OutsideWrites(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	writes := map[string]func() error{
+		"put":          func() error { return db.Put([]byte("k"), []byte("v2")) },
+		"del":          func() error { return db.Del([]byte("k")) },
+		"delete range": func() error { return db.DeleteRange([]byte("k"), []byte("l")) },
+		"write batch": func() error {
+			wb := db.NewWriteBatch(DefaultBatchOptions())
+			assert.Nil(t, wb.Put([]byte("k"), []byte("v2")))
+			return wb.Commit()
+		},
+	}
+	for name, write := range writes {
+		assert.Nil(t, db.Put([]byte("k"), []byte("v1")), name)
+		txn, err := db.Begin(TxnOptions{IsolationLevel: Serializable})
+		assert.Nil(t, err)
+		value, err := txn.Get([]byte("k"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), value)
+
+		// the writes outside the txns are not seen by the txn and conflict with it
+		assert.Nil(t, write(), name)
+		value, err = txn.Get([]byte("k"))
+		assert.Nil(t, err, name)
+		assert.Equal(t, []byte("v1"), value, name)
+		assert.Nil(t, txn.Set([]byte("k"), []byte("v3")))
+		assert.Equal(t, public.ErrTransactionConflict, txn.Commit(), name)
+	}
+
+	// a stream written after the start of the txn is not read from the snapshot
+	txn, err := db.Begin(TxnOptions{IsolationLevel: Serializable})
+	assert.Nil(t, err)
+	defer txn.Discard()
+	assert.Nil(t, db.RWTransaction(false, func(other *Txn) error {
+		_, err := other.XAdd([]byte("stream"), []byte("f"), []byte("v"))
+		return err
+	}))
+	_, err = txn.XLen([]byte("stream"))
+	assert.Equal(t, public.ErrTransactionConflict, err)
+}
+
+func TestDB_SerialTransaction_WriteSkew(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	assert.Nil(t, db.Put([]byte("alice"), []byte("on")))
+	assert.Nil(t, db.Put([]byte("bob"), []byte("on")))
+
+	// each txn goes off call if the other is still on call
+	offCall := func(me, other []byte) *Txn {
+		txn, err := db.Begin(TxnOptions{IsolationLevel: Serializable})
+		assert.Nil(t, err)
+		value, err := txn.Get(other)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("on"), value)
+		assert.Nil(t, txn.Set(me, []byte("off")))
+		return txn
+	}
+	txn1 := offCall([]byte("alice"), []byte("bob"))
+	txn2 := offCall([]byte("bob"), []byte("alice"))
+	assert.Nil(t, txn1.Commit())
+	assert.Equal(t, public.ErrTransactionConflict, txn2.Commit())
+
+	value, err := db.Get([]byte("bob"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("on"), value)
+}
+
+func TestDB_Transaction_ListConflict(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	assert.Nil(t, db.RWTransaction(false, func(txn *Txn) error {
+		return txn.RPush([]byte("list"), [][]byte{[]byte("e1")})
+	}))
+
+	txn1, err := db.Begin(DefaultTxnOptions())
+	assert.Nil(t, err)
+	txn2, err := db.Begin(DefaultTxnOptions())
+	assert.Nil(t, err)
+	assert.Nil(t, txn1.RPush([]byte("list"), [][]byte{[]byte("e2")}))
+	assert.Nil(t, txn2.RPush([]byte("list"), [][]byte{[]byte("e3")}))
+	assert.Nil(t, txn1.Commit())
+	assert.Equal(t, public.ErrTransactionConflict, txn2.Commit())
+
+	assert.Nil(t, db.RWTransaction(false, func(txn *Txn) error {
+		elements, err := txn.LRange([]byte("list"), 0, -1)
+		assert.Equal(t, [][]byte{[]byte("e1"), []byte("e2")}, elements)
+		return err
+	}))
+}
+
+func TestDB_SerialTransaction_Concurrent(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	// a serializable txn does not block the others while it is open
+	txn, err := db.Begin(TxnOptions{IsolationLevel: Serializable})
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Set([]byte("k1"), []byte("v1")))
+
+	wg := wait.NewWait()
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer wg.Done()
+			// the txns conflicting on the counter are retried until they commit
+			opts := TxnOptions{IsolationLevel: Serializable}
+			policy := RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+			err := db.RetryTransaction(opts, policy, func(txn *Txn) error {
+				_, err := txn.Incr([]byte("counter"))
+				return err
+			})
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Nil(t, txn.Commit())
+
+	value, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("10"), value)
+	value, err = db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), value)
+}
+
+func TestTxn_NewIterator(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	for _, key := range []string{"a1", "a2", "a3", "b1"} {
+		assert.Nil(t, db.Put([]byte(key), []byte(key)))
+	}
+
+	txn, err := db.Begin(TxnOptions{IsolationLevel: Serializable})
+	assert.Nil(t, err)
+	defer txn.Discard()
+	assert.Nil(t, txn.Set([]byte("a1"), []byte("new")))
+	assert.Nil(t, txn.Del([]byte("a2")))
+	assert.Nil(t, txn.Set([]byte("a4"), []byte("a4")))
+	// the txns committed after the txn began are not seen
+	assert.Nil(t, db.RWTransaction(false, func(other *Txn) error {
+		assert.Nil(t, other.Set([]byte("a5"), []byte("a5")))
+		return other.Del([]byte("a3"))
+	}))
+
+	collect := func(options IteratorOptions) ([]string, []string) {
+		iterator := txn.NewIterator(options)
+		defer iterator.Close()
+		keys, values := make([]string, 0), make([]string, 0)
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			value, err := iterator.Value()
+			assert.Nil(t, err)
+			keys = append(keys, string(iterator.Key()))
+			values = append(values, string(value))
+		}
+		return keys, values
+	}
+
+	keys, values := collect(IteratorOptions{Prefix: []byte("a")})
+	assert.Equal(t, []string{"a1", "a3", "a4"}, keys)
+	assert.Equal(t, []string{"new", "a3", "a4"}, values)
+	keys, _ = collect(IteratorOptions{Reverse: true})
+	assert.Equal(t, []string{"b1", "a4", "a3", "a1"}, keys)
+}
+
+func TestTxn_NewIterator_Seek(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	for _, key := range []string{"a", "b1", "b3", "b5", "c"} {
+		assert.Nil(t, db.Put([]byte(key), []byte(key)))
+	}
+
+	txn, err := db.Begin(TxnOptions{IsolationLevel: Serializable})
+	assert.Nil(t, err)
+	defer txn.Discard()
+	assert.Nil(t, txn.Set([]byte("b2"), []byte("b2")))
+	assert.Nil(t, txn.Set([]byte("b5"), []byte("new")))
+	assert.Nil(t, txn.Del([]byte("b3")))
+
+	forward := txn.NewIterator(IteratorOptions{Prefix: []byte("b")})
+	defer forward.Close()
+	reverse := txn.NewIterator(IteratorOptions{Prefix: []byte("b"), Reverse: true})
+	defer reverse.Close()
+
+	// a commit during the iteration is not seen by the serializable txn
+	assert.Nil(t, db.RWTransaction(false, func(other *Txn) error {
+		if err := other.Set([]byte("b1"), []byte("other")); err != nil {
+			return err
+		}
+		return other.Del([]byte("b5"))
+	}))
+
+	collect := func(iterator *Iterator) []string {
+		values := make([]string, 0)
+		for ; iterator.Valid(); iterator.Next() {
+			value, err := iterator.Value()
+			assert.Nil(t, err)
+			values = append(values, string(iterator.Key())+"="+string(value))
+		}
+		return values
+	}
+
+	forward.Rewind()
+	assert.Equal(t, []string{"b1=b1", "b2=b2", "b5=new"}, collect(forward))
+	reverse.Rewind()
+	assert.Equal(t, []string{"b5=new", "b2=b2", "b1=b1"}, collect(reverse))
+
+	forward.Seek([]byte("b3"))
+	assert.Equal(t, []string{"b5=new"}, collect(forward))
+	reverse.Seek([]byte("b3"))
+	assert.Equal(t, []string{"b2=b2", "b1=b1"}, collect(reverse))
+	forward.Seek([]byte("b6"))
+	assert.False(t, forward.Valid())
+}
+
+func TestTxn_NewIterator_Phantom(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer destroyCouloyDB(db)
+
+	assert.Nil(t, db.Put([]byte("a1"), []byte("a1")))
+	scan := func(write string) error {
+		txn, err := db.Begin(TxnOptions{IsolationLevel: Serializable})
+		assert.Nil(t, err)
+		defer txn.Discard()
+		iterator := txn.NewIterator(IteratorOptions{Prefix: []byte("a")})
+		iterator.Close()
+
+		assert.Nil(t, db.RWTransaction(false, func(other *Txn) error {
+			return other.Set([]byte(write), []byte("v"))
+		}))
+		assert.Nil(t, txn.Set([]byte("count"), []byte("1")))
+		return txn.Commit()
+	}
+
+	// a string added to the scanned range after the scan is a conflict, the others are not
+	assert.Equal(t, public.ErrTransactionConflict, scan("a2"))
+	assert.Nil(t, scan("b1"))
+}
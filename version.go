@@ -0,0 +1,96 @@
+package CouloyDB
+
+import (
+	"github.com/Kirov7/CouloyDB/data"
+	"github.com/Kirov7/CouloyDB/public"
+)
+
+// Every write of a string stores a new version in its record, the versions are taken from the txn ids,
+// so the versions of a key keep increasing even if the key is deleted and put again. If the history is
+// retained the version of a string is the commitTs kept in its history, the one GetAt and History use.
+// The strings written before the versions were added have the version zero
+
+// GetWithVersion returns the value of the key and its version
+func (db *DB) GetWithVersion(key []byte) ([]byte, int64, error) {
+	if len(key) == 0 {
+		return nil, 0, public.ErrKeyIsEmpty
+	}
+
+	db.getIndexLockByType(data.String).RLock()
+	defer db.getIndexLockByType(data.String).RUnlock()
+
+	logRecord, err := db.getStringRecord(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if logRecord == nil {
+		return nil, 0, public.ErrKeyNotFound
+	}
+	return logRecord.Value, db.latestStrVersion(key, logRecord), nil
+}
+
+// PutIfVersion puts the value if the key is still at the version and returns the new version,
+// the version zero expects the key not to exist. It returns ErrVersionMismatch otherwise.
+// The key keeps its expiration
+func (db *DB) PutIfVersion(key, value []byte, version int64) (int64, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+
+	db.lockStrWrite()
+	defer db.unlockStrWrite()
+
+	logRecord, err := db.checkVersion(key, version)
+	if err != nil {
+		return 0, err
+	}
+	var expiration int64
+	if logRecord != nil {
+		expiration = logRecord.Expiration
+	}
+	return db.putString(key, value, expiration)
+}
+
+// DeleteIfVersion deletes the key if it is still at the version, it returns ErrVersionMismatch otherwise
+func (db *DB) DeleteIfVersion(key []byte, version int64) error {
+	if len(key) == 0 {
+		return public.ErrKeyIsEmpty
+	}
+
+	db.lockStrWrite()
+	defer db.unlockStrWrite()
+
+	if _, err := db.checkVersion(key, version); err != nil {
+		return err
+	}
+	return db.delString(key)
+}
+
+// checkVersion returns the record of the key, or ErrVersionMismatch if the key is not at the version.
+// The string lock must be held
+func (db *DB) checkVersion(key []byte, version int64) (*data.LogRecord, error) {
+	logRecord, err := db.getStringRecord(key)
+	if err != nil {
+		return nil, err
+	}
+	var current int64
+	if logRecord != nil {
+		current = db.latestStrVersion(key, logRecord)
+	}
+	if current != version {
+		return nil, public.ErrVersionMismatch
+	}
+	return logRecord, nil
+}
+
+// getStringRecord returns the record of the string, or nil if the string does not exist or has expired
+func (db *DB) getStringRecord(key []byte) (*data.LogRecord, error) {
+	if db.ttl.isExpired(string(encodeExpireKey(data.String, key))) {
+		return nil, nil
+	}
+	pos := db.index.getStrIndex().Get(key)
+	if pos == nil {
+		return nil, nil
+	}
+	return db.getLogRecordByPos(pos)
+}
@@ -0,0 +1,106 @@
+package CouloyDB
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Kirov7/CouloyDB/public"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_PutIfVersion(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	key := []byte("balance")
+	_, _, err = db.GetWithVersion(key)
+	assert.Equal(t, public.ErrKeyNotFound, err)
+
+	// the version zero expects the key not to exist
+	v1, err := db.PutIfVersion(key, []byte("100"), 0)
+	assert.Nil(t, err)
+	_, err = db.PutIfVersion(key, []byte("200"), 0)
+	assert.Equal(t, public.ErrVersionMismatch, err)
+
+	value, version, err := db.GetWithVersion(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("100"), value)
+	assert.Equal(t, v1, version)
+
+	v2, err := db.PutIfVersion(key, []byte("90"), v1)
+	assert.Nil(t, err)
+	assert.True(t, v2 > v1)
+	_, err = db.PutIfVersion(key, []byte("80"), v1)
+	assert.Equal(t, public.ErrVersionMismatch, err)
+
+	// a plain put changes the version as well
+	assert.Nil(t, db.Put(key, []byte("70")))
+	assert.Equal(t, public.ErrVersionMismatch, db.DeleteIfVersion(key, v2))
+	_, v3, err := db.GetWithVersion(key)
+	assert.Nil(t, err)
+	assert.True(t, v3 > v2)
+
+	err = db.SerialTransaction(false, func(txn *Txn) error {
+		return txn.Set(key, []byte("60"))
+	})
+	assert.Nil(t, err)
+	_, v4, err := db.GetWithVersion(key)
+	assert.Nil(t, err)
+	assert.True(t, v4 > v3)
+
+	assert.Nil(t, db.DeleteIfVersion(key, v4))
+	_, _, err = db.GetWithVersion(key)
+	assert.Equal(t, public.ErrKeyNotFound, err)
+
+	// the versions are rebuilt from the log and keep increasing after a restart
+	v5, err := db.PutIfVersion(key, []byte("50"), 0)
+	assert.Nil(t, err)
+	assert.True(t, v5 > v4)
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(db.options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+
+	value, version, err = db.GetWithVersion(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("50"), value)
+	assert.Equal(t, v5, version)
+	v6, err := db.PutIfVersion(key, []byte("40"), v5)
+	assert.Nil(t, err)
+	assert.True(t, v6 > v5)
+}
+
+func TestDB_PutIfVersion_TTL(t *testing.T) {
+	db, err := NewCouloyDB(DefaultOptions())
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	key := []byte("session")
+	assert.Nil(t, db.PutWithExpiration(key, []byte("v1"), time.Hour))
+	before, err := db.TTL(key)
+	assert.Nil(t, err)
+	_, version, err := db.GetWithVersion(key)
+	assert.Nil(t, err)
+
+	// the key keeps its expiration after the update
+	_, err = db.PutIfVersion(key, []byte("v2"), version)
+	assert.Nil(t, err)
+	after, err := db.TTL(key)
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, after, before)
+	assert.Greater(t, after, 59*time.Minute)
+
+	// and after a restart
+	assert.Nil(t, db.Close())
+	db, err = NewCouloyDB(db.options)
+	assert.Nil(t, err)
+	defer destroyCouloyDB(db)
+
+	after, err = db.TTL(key)
+	assert.Nil(t, err)
+	assert.Greater(t, after, 59*time.Minute)
+	value, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), value)
+}
@@ -107,7 +107,8 @@ func (wm *watcherManager) start() {
 				continue
 			}
 
-			go watcher.sendResp(event)
+			// send in order, sendResp gives up after a short timeout
+			watcher.sendResp(event)
 		}
 		wm.lock.RUnlock()
 	}